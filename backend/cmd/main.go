@@ -2,17 +2,29 @@ package main
 
 import (
 	"log"
+	"time"
 
+	"github.com/alertsMIS/backend/internal/auth"
+	"github.com/alertsMIS/backend/internal/clustering"
 	"github.com/alertsMIS/backend/internal/config"
 	"github.com/alertsMIS/backend/internal/database"
 	"github.com/alertsMIS/backend/internal/handlers"
+	"github.com/alertsMIS/backend/internal/mail"
 	"github.com/alertsMIS/backend/internal/middleware"
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/alertsMIS/backend/internal/oidc"
+	"github.com/alertsMIS/backend/internal/risk"
+	"github.com/alertsMIS/backend/internal/storage"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/swagger"
 )
 
+// revocationCacheTTL bounds how long a token revoked through another
+// instance of the API stays usable here
+const revocationCacheTTL = 10 * time.Second
+
 // @title Alerts MIS API
 // @version 1.0
 // @description This is the API documentation for the Alerts Management Information System.
@@ -30,9 +42,48 @@ func main() {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	// Run database migrations
+	if err := database.Migrate(); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	// Initialize attachment storage
+	attachmentStore, err := storage.NewLocalStorage(cfg.AttachmentDir)
+	if err != nil {
+		log.Fatalf("Failed to initialize attachment storage: %v", err)
+	}
+
+	// Initialize mail, logging recipients and subjects when no SMTP server
+	// is configured
+	var mailSender mail.Sender = mail.LogSender{}
+	if cfg.MailSMTPHost != "" {
+		mailSender = mail.NewSMTPSender(cfg.MailSMTPHost, cfg.MailSMTPPort, cfg.MailSMTPUsername, cfg.MailSMTPPassword, cfg.MailFrom)
+	} else {
+		log.Printf("MAIL_SMTP_HOST is not set; emails will not be sent, only logged without their body")
+	}
+
+	// Schedule cluster detection
+	clusterConfig := clustering.Config{
+		Params: clustering.Params{
+			RadiusKm:  cfg.ClusterRadiusKm,
+			Window:    time.Duration(cfg.ClusterWindowDays) * 24 * time.Hour,
+			MinPoints: cfg.ClusterMinAlerts,
+		},
+		Lookback: time.Duration(cfg.ClusterLookbackDays) * 24 * time.Hour,
+	}
+	clustering.Start(database.GetDB(), clusterConfig, cfg.ClusterInterval)
+
+	// Schedule risk score refresh for alerts awaiting verification
+	risk.Start(database.GetDB(), cfg.RiskRefreshInterval)
+
 	// Create new Fiber app
 	app := fiber.New(fiber.Config{
 		AppName: "Alerts MIS API v1.0",
+		// Leave room for the multipart overhead around the largest attachment
+		BodyLimit: int(cfg.AttachmentMaxSize) + 1<<20,
+		// Behind a reverse proxy the client IP recorded for sessions comes
+		// from the header the proxy sets
+		ProxyHeader: cfg.ProxyHeader,
 	})
 
 	// Middleware
@@ -56,38 +107,217 @@ func main() {
 	api := app.Group("/api/v1")
 
 	// Initialize handlers
-	userHandler := handlers.NewUserHandler(database.GetDB(), cfg.JWTSecret)
-	alertHandler := handlers.NewAlertHandler(database.GetDB())
+	tokenIssuer := auth.NewIssuer(database.GetDB(), cfg.JWTSecret, cfg.AccessTokenTTL, cfg.RefreshTokenTTL)
+	revocations := auth.NewRevocations(database.GetDB(), revocationCacheTTL)
+	loginThrottle := auth.NewLoginThrottle(database.GetDB(), cfg.LoginMaxFailures, cfg.LoginMaxFailuresPerIP, cfg.LoginLockout)
+	twoFactor, err := auth.NewTwoFactor(database.GetDB(), cfg.TOTPEncryptionKey, cfg.TOTPIssuer, cfg.TOTPRequiredRoles, cfg.LoginChallengeTTL)
+	if err != nil {
+		log.Fatalf("Failed to initialize two-factor authentication: %v", err)
+	}
+	userHandler := handlers.NewUserHandler(database.GetDB(), tokenIssuer, revocations, loginThrottle, twoFactor)
+	var sso *auth.SSO
+	if cfg.OIDCIssuer != "" {
+		provider := oidc.NewProvider(cfg.OIDCIssuer, cfg.OIDCClientID, cfg.OIDCClientSecret, cfg.OIDCRedirectURL, cfg.OIDCScopes)
+		sso = auth.NewSSO(database.GetDB(), provider, oidc.Mapping{
+			UsernameClaim:    cfg.OIDCUsernameClaim,
+			GroupsClaim:      cfg.OIDCGroupsClaim,
+			UserTypeClaim:    cfg.OIDCUserTypeClaim,
+			AffiliationClaim: cfg.OIDCAffiliationClaim,
+			LevelClaim:       cfg.OIDCLevelClaim,
+			Groups:           cfg.OIDCGroupMap,
+		}, cfg.OIDCLinkByUsername, cfg.LoginChallengeTTL)
+	}
+	ssoHandler := handlers.NewSSOHandler(sso, userHandler, cfg.OIDCPostLoginURL, cfg.PasswordLogin)
+	passwordResets := auth.NewPasswordResets(database.GetDB(), cfg.PasswordResetTTL, cfg.PasswordResetPerAccount, cfg.PasswordResetPerIP)
+	passwordResetHandler := handlers.NewPasswordResetHandler(database.GetDB(), passwordResets, mailSender, revocations, cfg.PasswordResetURL)
+	alertHandler := handlers.NewAlertHandler(database.GetDB(), cfg.RequireAlertVillage)
 	adminUnitsHandler := handlers.NewAdminUnitsHandler(database.GetDB())
+	caseDefinitionHandler := handlers.NewCaseDefinitionHandler(database.GetDB())
+	patientHandler := handlers.NewPatientHandler(database.GetDB())
+	contactHandler := handlers.NewContactHandler(database.GetDB())
+	labHandler := handlers.NewLabHandler(database.GetDB())
+	notificationHandler := handlers.NewNotificationHandler(database.GetDB())
+	assignmentHandler := handlers.NewAssignmentHandler(database.GetDB())
+	noteHandler := handlers.NewNoteHandler(database.GetDB())
+	attachmentHandler := handlers.NewAttachmentHandler(database.GetDB(), attachmentStore, cfg.AttachmentMaxSize)
+	clusterHandler := handlers.NewClusterHandler(database.GetDB(), clusterConfig)
+	eventHandler := handlers.NewEventHandler(database.GetDB())
+
+	// Role checks
+	requireLab := middleware.RequireRoles(database.GetDB(), models.RoleLab)
+	requireSupervisor := middleware.RequireRoles(database.GetDB(), models.RoleNational, models.RoleREOC, models.RoleDistrict)
+	requireAdmin := middleware.RequireRoles(database.GetDB())
+	requirePasswordLogin := middleware.RequirePasswordLogin(cfg.PasswordLogin)
 
 	// Auth routes
-	api.Post("/users/register", userHandler.Register)
-	api.Post("/login", userHandler.Login)
-	api.Post("/users/logout", middleware.AuthMiddleware(cfg.JWTSecret), userHandler.Logout)
-	api.Get("/users/profile", middleware.AuthMiddleware(cfg.JWTSecret), userHandler.GetProfile)
-	api.Get("/users/all", middleware.AuthMiddleware(cfg.JWTSecret), userHandler.GetAllUsers)
-	api.Get("/users/:id", middleware.AuthMiddleware(cfg.JWTSecret), userHandler.GetUserById)
-	api.Get("/debug/users", userHandler.DebugUsers)  // Temporary debug endpoint
-	api.Get("/debug/bcrypt", userHandler.TestBcrypt) // Temporary bcrypt test endpoint
+	api.Post("/users/register", requirePasswordLogin, middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, userHandler.Register)
+	api.Get("/auth/methods", ssoHandler.GetLoginMethods)
+	api.Post("/login", requirePasswordLogin, userHandler.Login)
+	api.Post("/auth/refresh", userHandler.Refresh)
+	api.Get("/auth/sso/login", ssoHandler.StartSSO)
+	api.Get("/auth/sso/callback", ssoHandler.SSOCallback)
+	api.Post("/auth/sso/token", ssoHandler.ExchangeSSOCode)
+	api.Post("/auth/2fa/verify", userHandler.VerifyTwoFactor)
+	api.Post("/auth/2fa/enroll", userHandler.EnrollTwoFactorAtLogin)
+	api.Post("/auth/2fa/enroll/confirm", userHandler.ConfirmTwoFactorAtLogin)
+	api.Post("/auth/password/forgot", requirePasswordLogin, passwordResetHandler.ForgotPassword)
+	api.Post("/auth/password/reset", requirePasswordLogin, passwordResetHandler.ResetPassword)
+	api.Post("/users/logout", middleware.AuthMiddleware(cfg.JWTSecret, revocations), userHandler.Logout)
+	api.Get("/users/profile", middleware.AuthMiddleware(cfg.JWTSecret, revocations), userHandler.GetProfile)
+	api.Get("/users/all", middleware.AuthMiddleware(cfg.JWTSecret, revocations), userHandler.GetAllUsers)
+	api.Get("/users/me/sessions", middleware.AuthMiddleware(cfg.JWTSecret, revocations), userHandler.GetMySessions)
+	api.Delete("/users/me/sessions/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), userHandler.EndMySession)
+	api.Get("/users/me/2fa", middleware.AuthMiddleware(cfg.JWTSecret, revocations), userHandler.GetMyTwoFactor)
+	api.Post("/users/me/2fa/enroll", middleware.AuthMiddleware(cfg.JWTSecret, revocations), userHandler.EnrollMyTwoFactor)
+	api.Post("/users/me/2fa/confirm", middleware.AuthMiddleware(cfg.JWTSecret, revocations), userHandler.ConfirmMyTwoFactor)
+	api.Post("/users/me/2fa/recovery-codes", middleware.AuthMiddleware(cfg.JWTSecret, revocations), userHandler.RegenerateMyRecoveryCodes)
+	api.Post("/users/me/2fa/disable", middleware.AuthMiddleware(cfg.JWTSecret, revocations), userHandler.DisableMyTwoFactor)
+	api.Get("/users/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), userHandler.GetUserById)
+	api.Post("/users/:id/revoke-sessions", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, userHandler.RevokeUserSessions)
+	api.Get("/users/:id/sessions", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, userHandler.GetUserSessions)
+	api.Delete("/users/:id/sessions/:session_id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, userHandler.EndUserSession)
+	api.Delete("/users/:id/2fa", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, userHandler.ResetUserTwoFactor)
 
 	// Alert routes
-	api.Get("/alerts", middleware.AuthMiddleware(cfg.JWTSecret), alertHandler.GetAlerts)
-	api.Get("/alerts/:id", middleware.AuthMiddleware(cfg.JWTSecret), alertHandler.GetAlert)
-	api.Post("/alerts", middleware.AuthMiddleware(cfg.JWTSecret), alertHandler.CreateAlert)
-	api.Put("/alerts/:id", middleware.AuthMiddleware(cfg.JWTSecret), alertHandler.UpdateAlert)
-	api.Delete("/alerts/:id", middleware.AuthMiddleware(cfg.JWTSecret), alertHandler.DeleteAlert)
+	api.Get("/alerts", middleware.AuthMiddleware(cfg.JWTSecret, revocations), alertHandler.GetAlerts)
+	api.Get("/alerts.geojson", middleware.AuthMiddleware(cfg.JWTSecret, revocations), alertHandler.GetAlertsGeoJSON)
+	api.Get("/alerts/stats", middleware.AuthMiddleware(cfg.JWTSecret, revocations), alertHandler.GetAlertStats)
+	api.Get("/alerts/hotspots", middleware.AuthMiddleware(cfg.JWTSecret, revocations), alertHandler.GetAlertHotspots)
+	api.Get("/alerts/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), alertHandler.GetAlert)
+	api.Post("/alerts", middleware.AuthMiddleware(cfg.JWTSecret, revocations), alertHandler.CreateAlert)
+	api.Put("/alerts/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), alertHandler.UpdateAlert)
+	api.Delete("/alerts/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), alertHandler.DeleteAlert)
 	api.Post("/alerts/:id/verify", alertHandler.VerifyAlert) // No auth required for verification
-	api.Post("/alerts/:id/generate-token", middleware.AuthMiddleware(cfg.JWTSecret), alertHandler.GenerateVerificationToken)
-	api.Post("/alerts/query", middleware.AuthMiddleware(cfg.JWTSecret), alertHandler.QueryAlerts)
-	api.Get("/alerts/not-verified/count", middleware.AuthMiddleware(cfg.JWTSecret), alertHandler.GetNotVerifiedAlertsCount)
-	api.Get("/alerts/verified/count", middleware.AuthMiddleware(cfg.JWTSecret), alertHandler.GetVerifiedAlertsCount)
+	api.Post("/alerts/:id/generate-token", middleware.AuthMiddleware(cfg.JWTSecret, revocations), alertHandler.GenerateVerificationToken)
+	api.Post("/alerts/query", middleware.AuthMiddleware(cfg.JWTSecret, revocations), alertHandler.QueryAlerts)
+	api.Get("/alerts/not-verified/count", middleware.AuthMiddleware(cfg.JWTSecret, revocations), alertHandler.GetNotVerifiedAlertsCount)
+	api.Get("/alerts/verified/count", middleware.AuthMiddleware(cfg.JWTSecret, revocations), alertHandler.GetVerifiedAlertsCount)
+
+	// Assignment routes
+	api.Post("/alerts/:id/assign", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireSupervisor, assignmentHandler.AssignAlert)
+	api.Post("/alerts/:id/claim", middleware.AuthMiddleware(cfg.JWTSecret, revocations), assignmentHandler.ClaimAlert)
+	api.Post("/alerts/:id/unclaim", middleware.AuthMiddleware(cfg.JWTSecret, revocations), assignmentHandler.UnclaimAlert)
+	api.Get("/alerts/:id/assignments", middleware.AuthMiddleware(cfg.JWTSecret, revocations), assignmentHandler.GetAssignmentHistory)
+	api.Get("/me/queue", middleware.AuthMiddleware(cfg.JWTSecret, revocations), assignmentHandler.GetMyQueue)
+
+	// Note routes
+	api.Get("/alerts/:id/notes", middleware.AuthMiddleware(cfg.JWTSecret, revocations), noteHandler.GetAlertNotes)
+	api.Post("/alerts/:id/notes", middleware.AuthMiddleware(cfg.JWTSecret, revocations), noteHandler.CreateAlertNote)
+	api.Get("/alerts/:id/shared-notes", noteHandler.GetSharedAlertNotes) // Verification token instead of auth
+	api.Put("/notes/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), noteHandler.UpdateAlertNote)
+	api.Delete("/notes/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), noteHandler.DeleteAlertNote)
+	api.Get("/notes/:id/revisions", middleware.AuthMiddleware(cfg.JWTSecret, revocations), noteHandler.GetNoteRevisions)
+
+	// Attachment routes
+	api.Get("/alerts/:id/attachments", middleware.AuthMiddleware(cfg.JWTSecret, revocations), attachmentHandler.GetAttachments)
+	api.Post("/alerts/:id/attachments", middleware.AuthMiddleware(cfg.JWTSecret, revocations), attachmentHandler.UploadAttachment)
+	api.Get("/alerts/:id/attachments/:attachmentId", middleware.AuthMiddleware(cfg.JWTSecret, revocations), attachmentHandler.DownloadAttachment)
+	api.Delete("/alerts/:id/attachments/:attachmentId", middleware.AuthMiddleware(cfg.JWTSecret, revocations), attachmentHandler.DeleteAttachment)
+
+	api.Post("/alerts/:id/convert-to-case", middleware.AuthMiddleware(cfg.JWTSecret, revocations), patientHandler.ConvertAlertToCase)
+
+	// Contact tracing routes
+	api.Get("/alerts/:id/contacts", middleware.AuthMiddleware(cfg.JWTSecret, revocations), contactHandler.GetAlertContacts)
+	api.Post("/alerts/:id/contacts", middleware.AuthMiddleware(cfg.JWTSecret, revocations), contactHandler.CreateContact)
+	api.Get("/contacts/follow-up-report", middleware.AuthMiddleware(cfg.JWTSecret, revocations), contactHandler.GetFollowUpReport)
+	api.Get("/contacts/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), contactHandler.GetContact)
+	api.Put("/contacts/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), contactHandler.UpdateContact)
+	api.Delete("/contacts/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), contactHandler.DeleteContact)
+	api.Get("/contacts/:id/follow-ups", middleware.AuthMiddleware(cfg.JWTSecret, revocations), contactHandler.GetFollowUps)
+	api.Post("/contacts/:id/follow-ups", middleware.AuthMiddleware(cfg.JWTSecret, revocations), contactHandler.CreateFollowUp)
+
+	// Laboratory routes
+	api.Get("/alerts/:id/lab-samples", middleware.AuthMiddleware(cfg.JWTSecret, revocations), labHandler.GetAlertLabSamples)
+	api.Post("/alerts/:id/lab-samples", middleware.AuthMiddleware(cfg.JWTSecret, revocations), labHandler.CreateLabSample)
+	api.Get("/lab-samples", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireLab, labHandler.GetLabSamples)
+	api.Get("/lab-samples/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), labHandler.GetLabSample)
+	api.Put("/lab-samples/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), labHandler.UpdateLabSample)
+	api.Delete("/lab-samples/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireLab, labHandler.DeleteLabSample)
+	api.Post("/lab-samples/:id/receive", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireLab, labHandler.ReceiveLabSample)
+	api.Post("/lab-samples/:id/tests", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireLab, labHandler.CreateLabTest)
+	api.Post("/lab-tests/:id/result", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireLab, labHandler.PostLabResult)
+
+	// Cluster routes
+	api.Get("/clusters", middleware.AuthMiddleware(cfg.JWTSecret, revocations), clusterHandler.GetClusters)
+	api.Post("/clusters/detect", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireSupervisor, clusterHandler.DetectClusters)
+	api.Get("/clusters/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), clusterHandler.GetCluster)
+
+	// Event routes
+	api.Get("/events", middleware.AuthMiddleware(cfg.JWTSecret, revocations), eventHandler.GetEvents)
+	api.Post("/events", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireSupervisor, eventHandler.CreateEvent)
+	api.Get("/events/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), eventHandler.GetEvent)
+	api.Put("/events/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireSupervisor, eventHandler.UpdateEvent)
+	api.Delete("/events/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireSupervisor, eventHandler.DeleteEvent)
+	api.Get("/events/:id/dashboard", middleware.AuthMiddleware(cfg.JWTSecret, revocations), eventHandler.GetEventDashboard)
+	api.Post("/events/:id/alerts", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireSupervisor, eventHandler.LinkEventAlerts)
+	api.Delete("/events/:id/alerts/:alertId", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireSupervisor, eventHandler.UnlinkEventAlert)
+
+	// Notification routes
+	api.Get("/notifications", middleware.AuthMiddleware(cfg.JWTSecret, revocations), notificationHandler.GetNotifications)
+	api.Post("/notifications/read-all", middleware.AuthMiddleware(cfg.JWTSecret, revocations), notificationHandler.MarkAllNotificationsRead)
+	api.Post("/notifications/:id/read", middleware.AuthMiddleware(cfg.JWTSecret, revocations), notificationHandler.MarkNotificationRead)
+
+	// Patient routes
+	api.Get("/patients", middleware.AuthMiddleware(cfg.JWTSecret, revocations), patientHandler.GetPatients)
+	api.Get("/patients/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), patientHandler.GetPatient)
+	api.Post("/patients", middleware.AuthMiddleware(cfg.JWTSecret, revocations), patientHandler.CreatePatient)
+	api.Put("/patients/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), patientHandler.UpdatePatient)
+	api.Delete("/patients/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), patientHandler.DeletePatient)
+	api.Get("/patients/:id/hospitalizations", middleware.AuthMiddleware(cfg.JWTSecret, revocations), patientHandler.GetHospitalizations)
+	api.Post("/patients/:id/hospitalizations", middleware.AuthMiddleware(cfg.JWTSecret, revocations), patientHandler.CreateHospitalization)
+	api.Put("/hospitalizations/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), patientHandler.UpdateHospitalization)
+	api.Delete("/hospitalizations/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), patientHandler.DeleteHospitalization)
+	api.Get("/patients/:id/risk-factors", middleware.AuthMiddleware(cfg.JWTSecret, revocations), patientHandler.GetRiskFactors)
+	api.Post("/patients/:id/risk-factors", middleware.AuthMiddleware(cfg.JWTSecret, revocations), patientHandler.CreateRiskFactor)
+	api.Put("/risk-factors/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), patientHandler.UpdateRiskFactor)
+	api.Delete("/risk-factors/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), patientHandler.DeleteRiskFactor)
+
+	// Case definition routes
+	api.Get("/case-definitions", middleware.AuthMiddleware(cfg.JWTSecret, revocations), caseDefinitionHandler.GetCaseDefinitions)
+	api.Post("/case-definitions/reclassify", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, caseDefinitionHandler.ReclassifyAlerts)
+	api.Get("/case-definitions/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), caseDefinitionHandler.GetCaseDefinition)
+	api.Post("/case-definitions", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, caseDefinitionHandler.CreateCaseDefinition)
+	api.Put("/case-definitions/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, caseDefinitionHandler.UpdateCaseDefinition)
+	api.Delete("/case-definitions/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, caseDefinitionHandler.DeleteCaseDefinition)
 
 	// Admin units routes
+	api.Get("/admin-units/tree", adminUnitsHandler.GetTree)
+	api.Get("/admin-units/search", adminUnitsHandler.SearchAdminUnits)
+	api.Get("/admin-units/districts.geojson", middleware.AuthMiddleware(cfg.JWTSecret, revocations), adminUnitsHandler.GetDistrictBoundaries)
+	api.Get("/admin-units/subcounties.geojson", middleware.AuthMiddleware(cfg.JWTSecret, revocations), adminUnitsHandler.GetSubcountyBoundaries)
 	api.Get("/admin-units/regions", adminUnitsHandler.GetAllRegions)
 	api.Get("/admin-units/districts", adminUnitsHandler.GetAllDistricts)
 	api.Get("/admin-units/subcounties", adminUnitsHandler.GetAllSubcounties)
+	api.Get("/admin-units/facilities", adminUnitsHandler.GetAllFacilities)
 	api.Get("/admin-units/regions/:region_id/districts", adminUnitsHandler.GetDistrictsByRegion)
 	api.Get("/admin-units/districts/:district_id/subcounties", adminUnitsHandler.GetSubcountiesByDistrict)
+	api.Get("/admin-units/subcounties/:subcounty_id/facilities", adminUnitsHandler.GetFacilitiesBySubcounty)
+	api.Get("/admin-units/subcounties/:subcounty_id/parishes", adminUnitsHandler.GetParishesBySubcounty)
+	api.Get("/admin-units/parishes/:parish_id/villages", adminUnitsHandler.GetVillagesByParish)
+	api.Get("/admin-units/districts/:id/history", adminUnitsHandler.GetDistrictHistory)
+	api.Get("/admin-units/subcounties/:id/history", adminUnitsHandler.GetSubcountyHistory)
+	api.Post("/admin-units/regions", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.CreateRegion)
+	api.Put("/admin-units/regions/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.UpdateRegion)
+	api.Delete("/admin-units/regions/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.DeleteRegion)
+	api.Post("/admin-units/districts", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.CreateDistrict)
+	api.Put("/admin-units/districts/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.UpdateDistrict)
+	api.Delete("/admin-units/districts/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.DeleteDistrict)
+	api.Post("/admin-units/subcounties", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.CreateSubcounty)
+	api.Put("/admin-units/subcounties/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.UpdateSubcounty)
+	api.Delete("/admin-units/subcounties/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.DeleteSubcounty)
+	api.Post("/admin-units/facilities", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.CreateFacility)
+	api.Put("/admin-units/facilities/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.UpdateFacility)
+	api.Delete("/admin-units/facilities/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.DeleteFacility)
+	api.Post("/admin-units/parishes", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.CreateParish)
+	api.Put("/admin-units/parishes/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.UpdateParish)
+	api.Delete("/admin-units/parishes/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.DeleteParish)
+	api.Post("/admin-units/villages", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.CreateVillage)
+	api.Put("/admin-units/villages/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.UpdateVillage)
+	api.Delete("/admin-units/villages/:id", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.DeleteVillage)
+	api.Post("/admin-units/boundaries/import/preview", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.PreviewBoundaryImport)
+	api.Post("/admin-units/boundaries/import", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.ApplyBoundaryImport)
+	api.Post("/admin-units/import/preview", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.PreviewOrgUnitImport)
+	api.Post("/admin-units/import", middleware.AuthMiddleware(cfg.JWTSecret, revocations), requireAdmin, adminUnitsHandler.ApplyOrgUnitImport)
 
 	// Health check
 	app.Get("/health", func(c *fiber.Ctx) error {
@@ -0,0 +1,373 @@
+// Command mock-idp is an OpenID Connect identity provider for trying single
+// sign-on locally. It signs in whoever asks, as the user entered on its
+// sign-in page, and supports just what the API uses: discovery, the
+// authorization code flow with PKCE and RS256 ID tokens. Keys and codes are
+// kept in memory and lost on restart.
+//
+// Usage:
+//
+//	go run ./cmd/mock-idp -groups moh-admins
+//
+// then start the API with
+//
+//	OIDC_ISSUER=http://localhost:9400
+//	OIDC_CLIENT_ID=alerts-mis
+//	OIDC_GROUP_MAP=moh-admins=level:Admin
+//
+// and open http://localhost:8089/api/v1/auth/sso/login. With -auto the
+// sign-in page is skipped, so that scripts can follow the redirects:
+//
+//	curl -sIL -c jar -b jar http://localhost:8089/api/v1/auth/sso/login
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// keyID names the signing key in the key set
+const keyID = "mock-idp"
+
+// codeTTL is how long an authorization code can be redeemed
+const codeTTL = time.Minute
+
+// authorization is an authorization code waiting to be redeemed
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	claims        jwt.MapClaims
+	expiresAt     time.Time
+}
+
+// provider is the mock identity provider
+type provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+	auto         bool
+	user         user
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+// user is the account signed in
+type user struct {
+	Subject   string
+	Username  string
+	Email     string
+	FirstName string
+	LastName  string
+	Groups    string
+	Extra     string
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:9400", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:9400", "issuer identifier, the URL the provider is reached at")
+	clientID := flag.String("client-id", "alerts-mis", "client ID of the API")
+	clientSecret := flag.String("client-secret", "", "client secret of the API; empty for a public client")
+	auto := flag.Bool("auto", false, "sign in the default user without showing the sign-in page")
+	var u user
+	flag.StringVar(&u.Subject, "sub", "", "subject of the default user; defaults to the username")
+	flag.StringVar(&u.Username, "username", "jdoe", "preferred_username of the default user")
+	flag.StringVar(&u.Email, "email", "jdoe@health.go.ug", "verified email address of the default user")
+	flag.StringVar(&u.FirstName, "given-name", "Jane", "given name of the default user")
+	flag.StringVar(&u.LastName, "family-name", "Doe", "family name of the default user")
+	flag.StringVar(&u.Groups, "groups", "", "comma-separated groups of the default user")
+	flag.StringVar(&u.Extra, "claims", "", `further claims of the default user as a JSON object, such as {"level":"REOC"}`)
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("Failed to generate signing key: %v", err)
+	}
+	p := &provider{
+		issuer:       strings.TrimSuffix(*issuer, "/"),
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		key:          key,
+		auto:         *auto,
+		user:         u,
+		codes:        make(map[string]authorization),
+	}
+
+	http.HandleFunc("/.well-known/openid-configuration", p.configuration)
+	http.HandleFunc("/jwks", p.jwks)
+	http.HandleFunc("/authorize", p.authorize)
+	http.HandleFunc("/token", p.token)
+	log.Printf("Mock identity provider %s listening on %s", p.issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// configuration serves the provider configuration document
+func (p *provider) configuration(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"scopes_supported":                      []string{"openid", "profile", "email"},
+	})
+}
+
+// jwks serves the signing key
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	encode := func(n *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(n.Bytes())
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   encode(p.key.N),
+			"e":   encode(big.NewInt(int64(p.key.E))),
+		}},
+	})
+}
+
+// signInPage lets the tester choose who to sign in as
+var signInPage = template.Must(template.New("sign-in").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Mock identity provider</title></head>
+<body style="font-family: sans-serif; max-width: 30em; margin: 2em auto">
+<h1>Mock identity provider</h1>
+<p>Sign in to <b>{{.ClientID}}</b> as:</p>
+<form method="post">
+{{range $name, $value := .Query}}<input type="hidden" name="{{$name}}" value="{{index $value 0}}">
+{{end}}<p><label>Subject<br><input name="sub" value="{{.User.Subject}}" placeholder="defaults to the username"></label></p>
+<p><label>Username<br><input name="username" value="{{.User.Username}}"></label></p>
+<p><label>Email<br><input name="email" value="{{.User.Email}}"></label></p>
+<p><label>Given name<br><input name="given_name" value="{{.User.FirstName}}"></label></p>
+<p><label>Family name<br><input name="family_name" value="{{.User.LastName}}"></label></p>
+<p><label>Groups (comma-separated)<br><input name="groups" value="{{.User.Groups}}"></label></p>
+<p><label>Further claims (JSON object)<br><textarea name="claims" rows="3" cols="40">{{.User.Extra}}</textarea></label></p>
+<p><button name="action" value="allow">Sign in</button> <button name="action" value="deny">Deny</button></p>
+</form>
+</body>
+</html>
+`))
+
+// authorize signs a user in and redirects back to the client with a code
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	query := url.Values{}
+	for _, name := range []string{"response_type", "client_id", "redirect_uri", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+		query.Set(name, r.Form.Get(name))
+	}
+	// Errors about the client or its redirect URI are shown rather than
+	// sent back to it
+	if query.Get("client_id") != p.clientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	back := func(values url.Values) {
+		values.Set("state", query.Get("state"))
+		target := *redirectURI
+		target.RawQuery = values.Encode()
+		http.Redirect(w, r, target.String(), http.StatusFound)
+	}
+	switch {
+	case query.Get("response_type") != "code":
+		back(url.Values{"error": {"unsupported_response_type"}})
+		return
+	case !strings.Contains(" "+query.Get("scope")+" ", " openid "):
+		back(url.Values{"error": {"invalid_scope"}, "error_description": {"openid scope required"}})
+		return
+	case query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256":
+		back(url.Values{"error": {"invalid_request"}, "error_description": {"PKCE with S256 required"}})
+		return
+	}
+
+	u := p.user
+	if r.Method == http.MethodGet && !p.auto {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		signInPage.Execute(w, map[string]interface{}{"ClientID": p.clientID, "Query": query, "User": u})
+		return
+	}
+	if r.Method == http.MethodPost {
+		if r.PostForm.Get("action") == "deny" {
+			back(url.Values{"error": {"access_denied"}})
+			return
+		}
+		u = user{
+			Subject:   r.PostForm.Get("sub"),
+			Username:  r.PostForm.Get("username"),
+			Email:     r.PostForm.Get("email"),
+			FirstName: r.PostForm.Get("given_name"),
+			LastName:  r.PostForm.Get("family_name"),
+			Groups:    r.PostForm.Get("groups"),
+			Extra:     r.PostForm.Get("claims"),
+		}
+	}
+
+	claims, err := u.claims()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		clientID:      query.Get("client_id"),
+		redirectURI:   query.Get("redirect_uri"),
+		codeChallenge: query.Get("code_challenge"),
+		nonce:         query.Get("nonce"),
+		claims:        claims,
+		expiresAt:     time.Now().Add(codeTTL),
+	}
+	p.mu.Unlock()
+	log.Printf("Signed in %s (%s)", u.Username, claims["sub"])
+	back(url.Values{"code": {code}})
+}
+
+// claims returns the ID token claims describing the user
+func (u user) claims() (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	if u.Extra != "" {
+		if err := json.Unmarshal([]byte(u.Extra), &claims); err != nil {
+			return nil, err
+		}
+	}
+	claims["sub"] = u.Subject
+	if u.Subject == "" {
+		claims["sub"] = u.Username
+	}
+	claims["preferred_username"] = u.Username
+	claims["email"] = u.Email
+	claims["email_verified"] = u.Email != ""
+	claims["given_name"] = u.FirstName
+	claims["family_name"] = u.LastName
+	claims["name"] = strings.TrimSpace(u.FirstName + " " + u.LastName)
+	groups := []string{}
+	for _, group := range strings.Split(u.Groups, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	claims["groups"] = groups
+	return claims, nil
+}
+
+// tokenError answers a token request with an OAuth error
+func tokenError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+// token redeems an authorization code for an ID token
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request", "form POST required")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.clientID || secret != p.clientSecret {
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	auth, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+	if !ok || time.Now().After(auth.expiresAt) || auth.clientID != clientID {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "unknown or expired code")
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != auth.redirectURI {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri mismatch")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != auth.codeChallenge {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "code_verifier mismatch")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{}
+	for name, value := range auth.claims {
+		claims[name] = value
+	}
+	claims["iss"] = p.issuer
+	claims["aud"] = clientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(5 * time.Minute).Unix()
+	if auth.nonce != "" {
+		claims["nonce"] = auth.nonce
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = keyID
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+// randomString returns a random URL-safe string
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Failed to read random bytes: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
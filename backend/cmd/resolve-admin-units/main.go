@@ -0,0 +1,333 @@
+// Command resolve-admin-units links existing alerts to regions, districts,
+// subcounties and health facilities by ID, using the free-text names
+// recorded on them.
+//
+// Names that match a unit exactly (ignoring case, punctuation and suffixes
+// such as " District") are linked; near misses are only reported unless
+// -accept-fuzzy is given. Subcounties are looked up in the alert's district,
+// and names shared by several units are reported as ambiguous and not
+// linked. Every name looked at is written to a CSV report for
+// manual review. Nothing is changed without -apply.
+//
+// Usage:
+//
+//	go run ./cmd/resolve-admin-units -report report.csv
+//	go run ./cmd/resolve-admin-units -report report.csv -apply
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alertsMIS/backend/internal/adminunits"
+	"github.com/alertsMIS/backend/internal/config"
+	"github.com/alertsMIS/backend/internal/database"
+	"github.com/alertsMIS/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Match statuses written to the report
+const (
+	statusID        = "id"
+	statusExact     = "exact"
+	statusFuzzy     = "fuzzy"
+	statusAmbiguous = "ambiguous"
+	statusUnmatched = "unmatched"
+)
+
+// resolution is how one name on an alert was resolved
+type resolution struct {
+	level    string
+	value    string
+	status   string
+	match    adminunits.Match
+	accepted bool
+}
+
+// units holds the administrative units names are matched against
+type units struct {
+	regions               []adminunits.Candidate
+	districts             []adminunits.Candidate
+	subcounties           []adminunits.Candidate
+	facilities            []adminunits.Candidate
+	subcountiesByDistrict map[uint][]adminunits.Candidate
+	facilitiesBySubcounty map[uint][]adminunits.Candidate
+	districtRegion        map[uint]uint
+	subcountyDistrict     map[uint]uint
+	facilitySubcounty     map[uint]uint
+}
+
+func main() {
+	apply := flag.Bool("apply", false, "store the resolved IDs instead of only reporting them")
+	acceptFuzzy := flag.Bool("accept-fuzzy", false, "also store fuzzy matches")
+	threshold := flag.Float64("threshold", 0.8, "minimum similarity (0-1) for a fuzzy match")
+	reportPath := flag.String("report", "-", "CSV report file, - for standard output")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := database.InitDB(cfg.GetDSN()); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	if err := database.Migrate(); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+	db := database.GetDB().Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Warn)})
+
+	u, err := loadUnits(db)
+	if err != nil {
+		log.Fatalf("Failed to load administrative units: %v", err)
+	}
+
+	var out io.Writer = os.Stdout
+	if *reportPath != "-" {
+		f, err := os.Create(*reportPath)
+		if err != nil {
+			log.Fatalf("Failed to create report: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	report := csv.NewWriter(out)
+	report.Write([]string{"alert_id", "level", "value", "status", "matched_id", "matched_name", "similarity", "applied"})
+
+	counts := make(map[string]int)
+	var alerts []models.Alert
+	err = db.Select("id", "region", "alert_case_district", "alert_case_sub_county", "facility",
+		"region_id", "district_id", "subcounty_id", "facility_id").
+		Where("region_id IS NULL OR district_id IS NULL OR subcounty_id IS NULL OR facility_id IS NULL").
+		FindInBatches(&alerts, 500, func(_ *gorm.DB, _ int) error {
+			for _, alert := range alerts {
+				resolutions, updates := resolveAlert(alert, u, *threshold, *acceptFuzzy)
+				for _, r := range resolutions {
+					counts[r.level+" "+r.status]++
+					matchedID := ""
+					if r.match.ID != 0 {
+						matchedID = strconv.FormatUint(uint64(r.match.ID), 10)
+					}
+					report.Write([]string{
+						strconv.FormatUint(uint64(alert.ID), 10),
+						r.level,
+						r.value,
+						r.status,
+						matchedID,
+						r.match.Name,
+						fmt.Sprintf("%.2f", r.match.Similarity),
+						strconv.FormatBool(*apply && r.accepted),
+					})
+				}
+				if !*apply || len(updates) == 0 {
+					continue
+				}
+				if err := db.Model(&models.Alert{}).Where("id = ?", alert.ID).UpdateColumns(updates).Error; err != nil {
+					return err
+				}
+				counts["alerts updated"]++
+			}
+			return nil
+		}).Error
+	report.Flush()
+	if err != nil {
+		log.Fatalf("Failed to resolve alerts: %v", err)
+	}
+	if err := report.Error(); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		log.Printf("%s: %d", key, counts[key])
+	}
+	if !*apply {
+		log.Println("Dry run, no alerts were changed; rerun with -apply to store the matches")
+	}
+}
+
+// loadUnits reads the regions, districts, subcounties and facilities
+func loadUnits(db *gorm.DB) (*units, error) {
+	var regions []models.Region
+	var districts []models.District
+	var subcounties []models.Subcounty
+	var facilities []models.Facility
+	if err := db.Find(&regions).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Find(&districts).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Find(&subcounties).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Find(&facilities).Error; err != nil {
+		return nil, err
+	}
+
+	u := &units{
+		subcountiesByDistrict: make(map[uint][]adminunits.Candidate),
+		facilitiesBySubcounty: make(map[uint][]adminunits.Candidate),
+		districtRegion:        make(map[uint]uint),
+		subcountyDistrict:     make(map[uint]uint),
+		facilitySubcounty:     make(map[uint]uint),
+	}
+	for _, r := range regions {
+		u.regions = append(u.regions, adminunits.Candidate{ID: r.ID, Name: r.Region})
+	}
+	for _, d := range districts {
+		u.districts = append(u.districts, adminunits.Candidate{ID: d.ID, Name: d.District})
+		u.districtRegion[d.ID] = d.RegionID
+	}
+	for _, s := range subcounties {
+		candidate := adminunits.Candidate{ID: s.ID, Name: s.Subcounty}
+		u.subcounties = append(u.subcounties, candidate)
+		u.subcountiesByDistrict[s.DistrictID] = append(u.subcountiesByDistrict[s.DistrictID], candidate)
+		u.subcountyDistrict[s.ID] = s.DistrictID
+	}
+	for _, f := range facilities {
+		candidate := adminunits.Candidate{ID: f.ID, Name: f.Facility}
+		u.facilities = append(u.facilities, candidate)
+		if f.SubcountyID != nil {
+			u.facilitiesBySubcounty[*f.SubcountyID] = append(u.facilitiesBySubcounty[*f.SubcountyID], candidate)
+			u.facilitySubcounty[f.ID] = *f.SubcountyID
+		}
+	}
+	return u, nil
+}
+
+// resolveAlert resolves the names on an alert that are not yet linked. The
+// district is resolved first so that subcounties are looked up within it,
+// and facilities within the subcounty; the region follows from the district
+// where one was found.
+func resolveAlert(alert models.Alert, u *units, threshold float64, acceptFuzzy bool) ([]resolution, map[string]interface{}) {
+	var resolutions []resolution
+	updates := make(map[string]interface{})
+
+	var districtID uint
+	if alert.DistrictID != nil {
+		districtID = *alert.DistrictID
+	} else if value := text(alert.AlertCaseDistrict); value != "" {
+		r := resolve("district", value, u.districts, threshold, acceptFuzzy)
+		resolutions = append(resolutions, r)
+		if r.accepted {
+			districtID = r.match.ID
+			updates["district_id"] = districtID
+		}
+	}
+
+	var subcountyID uint
+	if alert.SubcountyID != nil {
+		subcountyID = *alert.SubcountyID
+	} else if value := text(alert.AlertCaseSubCounty); value != "" {
+		candidates := u.subcounties
+		if districtID != 0 {
+			candidates = u.subcountiesByDistrict[districtID]
+		}
+		r := resolve("subcounty", value, candidates, threshold, acceptFuzzy)
+		resolutions = append(resolutions, r)
+		if r.accepted {
+			subcountyID = r.match.ID
+			updates["subcounty_id"] = subcountyID
+			if districtID == 0 {
+				districtID = u.subcountyDistrict[subcountyID]
+				updates["district_id"] = districtID
+			}
+		}
+	}
+
+	if value := text(alert.Facility); alert.FacilityID == nil && value != "" {
+		candidates := u.facilities
+		if subcountyID != 0 {
+			candidates = u.facilitiesBySubcounty[subcountyID]
+		}
+		r := resolve("facility", value, candidates, threshold, acceptFuzzy)
+		resolutions = append(resolutions, r)
+		if r.accepted {
+			updates["facility_id"] = r.match.ID
+			if subcountyID == 0 {
+				if subcountyID = u.facilitySubcounty[r.match.ID]; subcountyID != 0 {
+					updates["subcounty_id"] = subcountyID
+				}
+			}
+			if districtID == 0 {
+				if districtID = u.subcountyDistrict[subcountyID]; districtID != 0 {
+					updates["district_id"] = districtID
+				}
+			}
+		}
+	}
+
+	if alert.RegionID == nil {
+		if regionID := u.districtRegion[districtID]; regionID != 0 {
+			updates["region_id"] = regionID
+		} else if value := text(alert.Region); value != "" {
+			r := resolve("region", value, u.regions, threshold, acceptFuzzy)
+			resolutions = append(resolutions, r)
+			if r.accepted {
+				updates["region_id"] = r.match.ID
+			}
+		}
+	}
+
+	return resolutions, updates
+}
+
+// resolve matches a name, or a numeric ID as some clients recorded, against
+// the candidates. Unmatched names keep their closest candidate for review.
+func resolve(level, value string, candidates []adminunits.Candidate, threshold float64, acceptFuzzy bool) resolution {
+	r := resolution{level: level, value: value, status: statusUnmatched}
+	if id, err := strconv.ParseUint(value, 10, 32); err == nil {
+		for _, c := range candidates {
+			if c.ID == uint(id) {
+				r.status, r.match, r.accepted = statusID, adminunits.Match{Candidate: c, Similarity: 1, Exact: true}, true
+				break
+			}
+		}
+		return r
+	}
+
+	match, found := adminunits.BestMatch(value, candidates, threshold)
+	r.match = match
+	switch {
+	case found && sharedName(match, candidates):
+		// Such as a subcounty name used in several districts, when the
+		// alert's district is unknown
+		r.status = statusAmbiguous
+	case found && match.Exact:
+		r.status, r.accepted = statusExact, true
+	case found:
+		r.status, r.accepted = statusFuzzy, acceptFuzzy
+	}
+	return r
+}
+
+// sharedName reports whether another candidate has the name of match
+func sharedName(match adminunits.Match, candidates []adminunits.Candidate) bool {
+	name := adminunits.Normalize(match.Name)
+	for _, c := range candidates {
+		if c.ID != match.ID && adminunits.Normalize(c.Name) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// text returns the trimmed string a pointer refers to
+func text(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return strings.TrimSpace(*s)
+}
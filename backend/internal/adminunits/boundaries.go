@@ -0,0 +1,407 @@
+package adminunits
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// BoundaryProperties names the feature properties read by a boundary import.
+// Empty names fall back to the property names commonly found in boundary
+// files.
+type BoundaryProperties struct {
+	UID        string
+	Name       string
+	Population string
+}
+
+// defaults returns the property names tried for each field at a level
+func (p BoundaryProperties) defaults(level string) (uid, name, population []string) {
+	uid = []string{"uid", "dhis2_uid", level + "uid", level + "_uid", "code"}
+	name = []string{"name", level, level + "name", level + "_name", "shapename"}
+	population = []string{"population", "pop", "total_population"}
+	if p.UID != "" {
+		uid = []string{p.UID}
+	}
+	if p.Name != "" {
+		name = []string{p.Name}
+	}
+	if p.Population != "" {
+		population = []string{p.Population}
+	}
+	return uid, name, population
+}
+
+// BoundaryFeature is a feature of a boundary import. Features with an Error
+// cannot be applied.
+type BoundaryFeature struct {
+	Index      int
+	UID        string
+	Name       string
+	Population *int64
+	Geometry   json.RawMessage
+	Latitude   float64
+	Longitude  float64
+	Error      string
+}
+
+// geoJSONFeature is a feature as read from a GeoJSON file
+type geoJSONFeature struct {
+	ID         interface{}            `json:"id"`
+	Geometry   json.RawMessage        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// ParseBoundaries reads the features of a GeoJSON FeatureCollection of unit
+// boundaries. Only Polygon and MultiPolygon geometries are accepted.
+func ParseBoundaries(data []byte, level string, props BoundaryProperties) ([]BoundaryFeature, error) {
+	var collection struct {
+		Type     string           `json:"type"`
+		Features []geoJSONFeature `json:"features"`
+	}
+	if err := json.Unmarshal(data, &collection); err != nil {
+		return nil, err
+	}
+	if collection.Type != "FeatureCollection" {
+		return nil, errors.New("expected a GeoJSON FeatureCollection")
+	}
+
+	uidKeys, nameKeys, populationKeys := props.defaults(level)
+	features := make([]BoundaryFeature, 0, len(collection.Features))
+	for i, f := range collection.Features {
+		properties := make(map[string]interface{}, len(f.Properties))
+		for key, value := range f.Properties {
+			properties[strings.ToLower(key)] = value
+		}
+		feature := BoundaryFeature{
+			Index: i,
+			UID:   propertyString(properties, uidKeys),
+			Name:  propertyString(properties, nameKeys),
+		}
+		if id, ok := f.ID.(string); ok && feature.UID == "" {
+			feature.UID = strings.TrimSpace(id)
+		}
+		var err error
+		if feature.Population, err = propertyCount(properties, populationKeys); err != nil {
+			feature.Error = err.Error()
+		} else if feature.Geometry, feature.Latitude, feature.Longitude, err = parsePolygon(f.Geometry); err != nil {
+			feature.Error = err.Error()
+		} else if feature.UID == "" && feature.Name == "" {
+			feature.Error = "Feature has no UID or name property"
+		}
+		features = append(features, feature)
+	}
+	return features, nil
+}
+
+// propertyString returns the first of the named properties that is set, as
+// text
+func propertyString(properties map[string]interface{}, keys []string) string {
+	for _, key := range keys {
+		switch v := properties[strings.ToLower(key)].(type) {
+		case string:
+			if s := strings.TrimSpace(v); s != "" {
+				return s
+			}
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	return ""
+}
+
+// propertyCount returns the first of the named properties that is set, as a
+// whole number
+func propertyCount(properties map[string]interface{}, keys []string) (*int64, error) {
+	for _, key := range keys {
+		var n float64
+		switch v := properties[strings.ToLower(key)].(type) {
+		case float64:
+			n = v
+		case string:
+			s := strings.ReplaceAll(strings.TrimSpace(v), ",", "")
+			if s == "" {
+				continue
+			}
+			var err error
+			if n, err = strconv.ParseFloat(s, 64); err != nil {
+				return nil, fmt.Errorf("Population %q is not a number", v)
+			}
+		default:
+			continue
+		}
+		if n < 0 || math.IsNaN(n) || math.IsInf(n, 0) {
+			return nil, errors.New("Population must be a positive number")
+		}
+		count := int64(math.Round(n))
+		return &count, nil
+	}
+	return nil, nil
+}
+
+// parsePolygon validates a Polygon or MultiPolygon geometry and returns it
+// compacted with its centroid
+func parsePolygon(raw json.RawMessage) (json.RawMessage, float64, float64, error) {
+	var geometry struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, 0, 0, errors.New("Feature has no geometry")
+	}
+	if err := json.Unmarshal(raw, &geometry); err != nil {
+		return nil, 0, 0, errors.New("Invalid geometry")
+	}
+
+	var polygons [][][][]float64
+	switch geometry.Type {
+	case "Polygon":
+		var polygon [][][]float64
+		if err := json.Unmarshal(geometry.Coordinates, &polygon); err != nil {
+			return nil, 0, 0, errors.New("Invalid polygon coordinates")
+		}
+		polygons = [][][][]float64{polygon}
+	case "MultiPolygon":
+		if err := json.Unmarshal(geometry.Coordinates, &polygons); err != nil {
+			return nil, 0, 0, errors.New("Invalid multipolygon coordinates")
+		}
+	default:
+		return nil, 0, 0, fmt.Errorf("Geometry must be a Polygon or MultiPolygon, not %q", geometry.Type)
+	}
+	if len(polygons) == 0 {
+		return nil, 0, 0, errors.New("Geometry has no polygons")
+	}
+	for _, polygon := range polygons {
+		if len(polygon) == 0 {
+			return nil, 0, 0, errors.New("Polygon has no rings")
+		}
+		for _, ring := range polygon {
+			if msg := validateRing(ring); msg != "" {
+				return nil, 0, 0, errors.New(msg)
+			}
+		}
+	}
+
+	compact, err := json.Marshal(geometry)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	lat, lon := centroid(polygons)
+	return compact, lat, lon, nil
+}
+
+// validateRing checks that a ring is closed and made of WGS84 positions
+func validateRing(ring [][]float64) string {
+	if len(ring) < 4 {
+		return "Polygon rings need at least four positions"
+	}
+	for _, position := range ring {
+		if len(position) < 2 {
+			return "Positions need a longitude and a latitude"
+		}
+		if position[0] < -180 || position[0] > 180 || position[1] < -90 || position[1] > 90 {
+			return "Coordinates must be WGS84 longitude and latitude"
+		}
+	}
+	first, last := ring[0], ring[len(ring)-1]
+	if first[0] != last[0] || first[1] != last[1] {
+		return "Polygon rings must be closed"
+	}
+	return ""
+}
+
+// centroid returns the area-weighted centroid of the outer rings of
+// polygons, or the mean of their positions when they have no area
+func centroid(polygons [][][][]float64) (lat, lon float64) {
+	var area, x, y, meanX, meanY float64
+	var positions int
+	for _, polygon := range polygons {
+		ring := polygon[0]
+		var ringArea, ringX, ringY float64
+		for i := 0; i < len(ring)-1; i++ {
+			x0, y0, x1, y1 := ring[i][0], ring[i][1], ring[i+1][0], ring[i+1][1]
+			cross := x0*y1 - x1*y0
+			ringArea += cross
+			ringX += (x0 + x1) * cross
+			ringY += (y0 + y1) * cross
+			meanX += x0
+			meanY += y0
+			positions++
+		}
+		if ringArea != 0 {
+			weight := math.Abs(ringArea)
+			area += weight
+			x += weight * ringX / (3 * ringArea)
+			y += weight * ringY / (3 * ringArea)
+		}
+	}
+	if area == 0 {
+		return meanY / float64(positions), meanX / float64(positions)
+	}
+	return y / area, x / area
+}
+
+// BoundaryMatch is an import feature matched to a stored unit
+type BoundaryMatch struct {
+	Index      int    `json:"index"`
+	ID         uint   `json:"id"`
+	Name       string `json:"name"`
+	MatchedBy  string `json:"matchedBy"`
+	Population *int64 `json:"population"`
+
+	feature BoundaryFeature
+}
+
+// BoundaryProblem is an import feature that cannot be applied
+type BoundaryProblem struct {
+	Index int    `json:"index"`
+	UID   string `json:"uid"`
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// BoundaryUnit is a stored unit left without a boundary by an import
+type BoundaryUnit struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+// BoundaryPlan is the preview of a boundary import
+type BoundaryPlan struct {
+	Level    string            `json:"level"`
+	Matched  []BoundaryMatch   `json:"matched"`
+	Problems []BoundaryProblem `json:"problems"`
+	Missing  []BoundaryUnit    `json:"missing"`
+}
+
+// MatchBoundaries matches boundary features with the stored units of a level,
+// on UID first and then on name. Names are compared among the units in force
+// today, ignoring case, punctuation and level suffixes.
+func (u Units) MatchBoundaries(level string, features []BoundaryFeature) BoundaryPlan {
+	type unit struct {
+		id        uint
+		uid, name string
+	}
+	var all, current []unit
+	switch level {
+	case LevelDistrict:
+		for _, d := range u.Districts {
+			all = append(all, unit{d.ID, d.DistrictUID, d.District})
+		}
+		for _, d := range u.AsOf(time.Now()).Districts {
+			current = append(current, unit{d.ID, d.DistrictUID, d.District})
+		}
+	case LevelSubcounty:
+		for _, s := range u.Subcounties {
+			all = append(all, unit{s.ID, s.SubcountyUID, s.Subcounty})
+		}
+		for _, s := range u.AsOf(time.Now()).Subcounties {
+			current = append(current, unit{s.ID, s.SubcountyUID, s.Subcounty})
+		}
+	}
+	byUID := make(map[string]unit, len(all))
+	for _, s := range all {
+		if s.uid != "" {
+			byUID[s.uid] = s
+		}
+	}
+	byName := make(map[string][]unit, len(current))
+	for _, s := range current {
+		key := Normalize(s.name)
+		byName[key] = append(byName[key], s)
+	}
+
+	plan := BoundaryPlan{Level: level, Matched: []BoundaryMatch{}, Problems: []BoundaryProblem{}, Missing: []BoundaryUnit{}}
+	problem := func(f BoundaryFeature, msg string) {
+		plan.Problems = append(plan.Problems, BoundaryProblem{Index: f.Index, UID: f.UID, Name: f.Name, Error: msg})
+	}
+	matchedBy := make(map[uint]int, len(features))
+	for _, f := range features {
+		if f.Error != "" {
+			problem(f, f.Error)
+			continue
+		}
+		var match unit
+		var by string
+		if s, ok := byUID[f.UID]; ok && f.UID != "" {
+			match, by = s, "uid"
+		} else if candidates := byName[Normalize(f.Name)]; f.Name != "" && len(candidates) == 1 {
+			match, by = candidates[0], "name"
+		} else if len(candidates) > 1 {
+			problem(f, fmt.Sprintf("Name matches %d %s units; match on UID instead", len(candidates), level))
+			continue
+		} else {
+			problem(f, "No "+level+" matches the feature's UID or name")
+			continue
+		}
+		if previous, ok := matchedBy[match.id]; ok {
+			problem(f, fmt.Sprintf("Unit %s is already matched by feature %d", match.name, previous))
+			continue
+		}
+		matchedBy[match.id] = f.Index
+		plan.Matched = append(plan.Matched, BoundaryMatch{
+			Index:      f.Index,
+			ID:         match.id,
+			Name:       match.name,
+			MatchedBy:  by,
+			Population: f.Population,
+			feature:    f,
+		})
+	}
+	for _, s := range current {
+		if _, ok := matchedBy[s.id]; !ok {
+			plan.Missing = append(plan.Missing, BoundaryUnit{ID: s.id, Name: s.name})
+		}
+	}
+	return plan
+}
+
+// ApplyBoundaries stores the matched boundaries of a plan, replacing the
+// units' previous boundaries. A population is only replaced when the feature
+// has one, and units without a centroid get the centroid of their boundary.
+// It returns the number of centroids set and should run in a transaction.
+func ApplyBoundaries(tx *gorm.DB, plan BoundaryPlan) (int64, error) {
+	var unitModel interface{}
+	switch plan.Level {
+	case LevelDistrict:
+		unitModel = &models.District{}
+	case LevelSubcounty:
+		unitModel = &models.Subcounty{}
+	default:
+		return 0, fmt.Errorf("boundaries cannot be stored for level %q", plan.Level)
+	}
+
+	var centroids int64
+	for _, m := range plan.Matched {
+		var boundary models.AdminUnitBoundary
+		err := tx.Where("level = ? AND unit_id = ?", plan.Level, m.ID).Limit(1).Find(&boundary).Error
+		if err != nil {
+			return centroids, err
+		}
+		boundary.Level = plan.Level
+		boundary.UnitID = m.ID
+		boundary.Geometry = string(m.feature.Geometry)
+		if m.feature.Population != nil {
+			boundary.Population = m.feature.Population
+		}
+		if err := tx.Save(&boundary).Error; err != nil {
+			return centroids, err
+		}
+
+		result := tx.Model(unitModel).
+			Where("id = ? AND (latitude IS NULL OR longitude IS NULL)", m.ID).
+			Updates(map[string]interface{}{"latitude": m.feature.Latitude, "longitude": m.feature.Longitude})
+		if result.Error != nil {
+			return centroids, result.Error
+		}
+		centroids += result.RowsAffected
+	}
+	return centroids, nil
+}
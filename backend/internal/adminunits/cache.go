@@ -0,0 +1,73 @@
+package adminunits
+
+import (
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Load reads all administrative units and their parent history
+func Load(db *gorm.DB) (Units, error) {
+	var u Units
+	if err := db.Find(&u.Regions).Error; err != nil {
+		return u, err
+	}
+	if err := db.Find(&u.Districts).Error; err != nil {
+		return u, err
+	}
+	if err := db.Find(&u.Subcounties).Error; err != nil {
+		return u, err
+	}
+	if err := db.Find(&u.Facilities).Error; err != nil {
+		return u, err
+	}
+	if err := db.Find(&u.DistrictHistory).Error; err != nil {
+		return u, err
+	}
+	if err := db.Find(&u.SubcountyHistory).Error; err != nil {
+		return u, err
+	}
+	return u, nil
+}
+
+// Cache keeps the administrative units in memory for a short while, as
+// autocomplete searches them on every keystroke. Units are also edited
+// through the PHP system, so the cache expires rather than relying on
+// invalidation alone.
+type Cache struct {
+	db  *gorm.DB
+	ttl time.Duration
+
+	mu     sync.Mutex
+	units  Units
+	loaded time.Time
+}
+
+// NewCache creates a Cache that reloads the units after ttl
+func NewCache(db *gorm.DB, ttl time.Duration) *Cache {
+	return &Cache{db: db, ttl: ttl}
+}
+
+// Get returns the cached units, reloading them if they have expired. The
+// returned units must not be modified.
+func (c *Cache) Get() (Units, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.loaded.IsZero() && time.Since(c.loaded) < c.ttl {
+		return c.units, nil
+	}
+	units, err := Load(c.db)
+	if err != nil {
+		return units, err
+	}
+	c.units, c.loaded = units, time.Now()
+	return units, nil
+}
+
+// Invalidate makes the next Get reload the units
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loaded = time.Time{}
+}
@@ -0,0 +1,556 @@
+package adminunits
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// Import change types
+const (
+	ChangeAdded   = "added"
+	ChangeRenamed = "renamed"
+	ChangeMoved   = "moved"
+)
+
+// OrgUnit is an organisation unit from a DHIS2 export
+type OrgUnit struct {
+	UID       string
+	Name      string
+	ParentUID string
+	Level     int
+	Path      string
+	Ownership string
+}
+
+// Levels maps DHIS2 organisation unit levels to the administrative levels.
+// Units at other levels, such as the national root, are ignored.
+type Levels struct {
+	Region    int
+	District  int
+	Subcounty int
+	Facility  int
+}
+
+// DefaultLevels is the Uganda hierarchy in DHIS2: national, region,
+// district, subcounty, health facility
+var DefaultLevels = Levels{Region: 2, District: 3, Subcounty: 4, Facility: 5}
+
+// level returns the administrative level of a DHIS2 level, or ""
+func (l Levels) level(dhis2Level int) string {
+	switch dhis2Level {
+	case l.Region:
+		return LevelRegion
+	case l.District:
+		return LevelDistrict
+	case l.Subcounty:
+		return LevelSubcounty
+	case l.Facility:
+		return LevelFacility
+	}
+	return ""
+}
+
+// ParseOrgUnits reads a DHIS2 organisationUnits export, either the JSON of
+// the metadata API (an object with an organisationUnits array, or the bare
+// array) or a CSV file with a header row
+func ParseOrgUnits(data []byte) ([]OrgUnit, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return parseOrgUnitsJSON(trimmed)
+	}
+	return parseOrgUnitsCSV(bytes.NewReader(data))
+}
+
+// dhis2OrgUnit is an organisation unit as exported by the DHIS2 API
+type dhis2OrgUnit struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	Level       int    `json:"level"`
+	Path        string `json:"path"`
+	Parent      *struct {
+		ID string `json:"id"`
+	} `json:"parent"`
+}
+
+func parseOrgUnitsJSON(data []byte) ([]OrgUnit, error) {
+	var exported []dhis2OrgUnit
+	if data[0] == '[' {
+		if err := json.Unmarshal(data, &exported); err != nil {
+			return nil, err
+		}
+	} else {
+		var export struct {
+			OrganisationUnits []dhis2OrgUnit `json:"organisationUnits"`
+		}
+		if err := json.Unmarshal(data, &export); err != nil {
+			return nil, err
+		}
+		exported = export.OrganisationUnits
+	}
+
+	units := make([]OrgUnit, 0, len(exported))
+	for _, e := range exported {
+		unit := OrgUnit{UID: e.ID, Name: e.Name, Level: e.Level, Path: e.Path}
+		if unit.Name == "" {
+			unit.Name = e.DisplayName
+		}
+		if e.Parent != nil {
+			unit.ParentUID = e.Parent.ID
+		}
+		units = append(units, unit)
+	}
+	return normaliseOrgUnits(units)
+}
+
+// csvColumns maps accepted CSV header names to OrgUnit fields
+var csvColumns = map[string]string{
+	"uid":         "uid",
+	"id":          "uid",
+	"name":        "name",
+	"displayname": "name",
+	"parent":      "parent",
+	"parentuid":   "parent",
+	"parent_uid":  "parent",
+	"parentid":    "parent",
+	"level":       "level",
+	"path":        "path",
+	"ownership":   "ownership",
+}
+
+func parseOrgUnitsCSV(r io.Reader) ([]OrgUnit, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if field, ok := csvColumns[name]; ok {
+			if _, seen := columns[field]; !seen {
+				columns[field] = i
+			}
+		}
+	}
+	if _, ok := columns["uid"]; !ok {
+		return nil, errors.New("CSV has no uid column")
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, errors.New("CSV has no name column")
+	}
+
+	var units []OrgUnit
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		unit := OrgUnit{
+			UID:       field("uid"),
+			Name:      field("name"),
+			ParentUID: field("parent"),
+			Path:      field("path"),
+			Ownership: strings.ToUpper(field("ownership")),
+		}
+		if level := field("level"); level != "" {
+			if unit.Level, err = strconv.Atoi(level); err != nil {
+				return nil, fmt.Errorf("line %d: invalid level %q", line, level)
+			}
+		}
+		units = append(units, unit)
+	}
+	return normaliseOrgUnits(units)
+}
+
+// normaliseOrgUnits checks the units have UIDs and fills in missing levels
+// and parents from the path, or failing that from the depth of the unit's
+// parent chain within the export
+func normaliseOrgUnits(units []OrgUnit) ([]OrgUnit, error) {
+	byUID := make(map[string]*OrgUnit, len(units))
+	for i := range units {
+		u := &units[i]
+		u.UID, u.Name = strings.TrimSpace(u.UID), strings.TrimSpace(u.Name)
+		if u.UID == "" {
+			return nil, fmt.Errorf("organisation unit %d has no uid", i+1)
+		}
+		if _, dup := byUID[u.UID]; dup {
+			return nil, fmt.Errorf("organisation unit %s appears more than once", u.UID)
+		}
+		byUID[u.UID] = u
+
+		if u.Path != "" {
+			parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+			if u.Level == 0 {
+				u.Level = len(parts)
+			}
+			if u.ParentUID == "" && len(parts) > 1 {
+				u.ParentUID = parts[len(parts)-2]
+			}
+		}
+	}
+
+	var depth func(u *OrgUnit, seen int) int
+	depth = func(u *OrgUnit, seen int) int {
+		if u.Level != 0 {
+			return u.Level
+		}
+		parent, ok := byUID[u.ParentUID]
+		if !ok || seen > len(units) {
+			return 1
+		}
+		return depth(parent, seen+1) + 1
+	}
+	for i := range units {
+		if units[i].Level == 0 {
+			units[i].Level = depth(&units[i], 0)
+		}
+	}
+	return units, nil
+}
+
+// Change is one difference between an import and the stored units
+type Change struct {
+	Type         string `json:"type"`
+	Level        string `json:"level"`
+	UID          string `json:"uid"`
+	ID           uint   `json:"id,omitempty"`
+	Name         string `json:"name"`
+	OldName      string `json:"oldName,omitempty"`
+	ParentUID    string `json:"parentUid,omitempty"`
+	Parent       string `json:"parent,omitempty"`
+	OldParentUID string `json:"oldParentUid,omitempty"`
+	OldParent    string `json:"oldParent,omitempty"`
+	Ownership    string `json:"ownership,omitempty"`
+}
+
+// Problem is an imported unit that cannot be applied
+type Problem struct {
+	Level string `json:"level"`
+	UID   string `json:"uid"`
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// Diff is the preview of an import. Checksum identifies the changes, so an
+// import can be applied only if nothing changed since it was previewed.
+type Diff struct {
+	Added     []Change  `json:"added"`
+	Renamed   []Change  `json:"renamed"`
+	Moved     []Change  `json:"moved"`
+	Problems  []Problem `json:"problems"`
+	Unchanged int       `json:"unchanged"`
+	Ignored   int       `json:"ignored"`
+	Checksum  string    `json:"checksum"`
+}
+
+// storedUnit is a stored unit as seen by the importer
+type storedUnit struct {
+	id        uint
+	name      string
+	parentUID string
+}
+
+// parentLevels gives the level each level's parent is at
+var parentLevels = map[string]string{
+	LevelDistrict:  LevelRegion,
+	LevelSubcounty: LevelDistrict,
+	LevelFacility:  LevelSubcounty,
+}
+
+// index returns the stored units of each level by UID
+func (u Units) index() map[string]map[string]storedUnit {
+	regionUID := make(map[uint]string, len(u.Regions))
+	districtUID := make(map[uint]string, len(u.Districts))
+	subcountyUID := make(map[uint]string, len(u.Subcounties))
+	idx := map[string]map[string]storedUnit{
+		LevelRegion:    {},
+		LevelDistrict:  {},
+		LevelSubcounty: {},
+		LevelFacility:  {},
+	}
+	for _, r := range u.Regions {
+		regionUID[r.ID] = r.RegionUID
+		idx[LevelRegion][r.RegionUID] = storedUnit{id: r.ID, name: r.Region}
+	}
+	for _, d := range u.Districts {
+		districtUID[d.ID] = d.DistrictUID
+		idx[LevelDistrict][d.DistrictUID] = storedUnit{id: d.ID, name: d.District, parentUID: regionUID[d.RegionID]}
+	}
+	for _, s := range u.Subcounties {
+		subcountyUID[s.ID] = s.SubcountyUID
+		idx[LevelSubcounty][s.SubcountyUID] = storedUnit{id: s.ID, name: s.Subcounty, parentUID: districtUID[s.DistrictID]}
+	}
+	for _, f := range u.Facilities {
+		var parent string
+		if f.SubcountyID != nil {
+			parent = subcountyUID[*f.SubcountyID]
+		}
+		idx[LevelFacility][f.FacilityUID] = storedUnit{id: f.ID, name: f.Facility, parentUID: parent}
+	}
+	return idx
+}
+
+// Diff compares imported organisation units with the stored units, matching
+// them on UID at each level
+func (u Units) Diff(imported []OrgUnit, levels Levels) Diff {
+	stored := u.index()
+	d := Diff{Added: []Change{}, Renamed: []Change{}, Moved: []Change{}, Problems: []Problem{}}
+
+	importedLevel := make(map[string]string, len(imported))
+	importedName := make(map[string]string, len(imported))
+	for _, o := range imported {
+		importedLevel[o.UID] = levels.level(o.Level)
+		importedName[o.UID] = o.Name
+	}
+	// parentName names a parent from the import, or else the stored units
+	parentName := func(level, uid string) string {
+		if name, ok := importedName[uid]; ok && importedLevel[uid] == level {
+			return name
+		}
+		return stored[level][uid].name
+	}
+
+	// Parents are checked before their children, so that children of an
+	// imported unit that cannot be applied are reported too
+	ordered := make([]OrgUnit, len(imported))
+	copy(ordered, imported)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Level < ordered[j].Level })
+	accepted := make(map[string]bool, len(imported))
+
+	for _, o := range ordered {
+		level := levels.level(o.Level)
+		if level == "" {
+			d.Ignored++
+			continue
+		}
+		problem := func(msg string) {
+			d.Problems = append(d.Problems, Problem{Level: level, UID: o.UID, Name: o.Name, Error: msg})
+		}
+		if o.Name == "" {
+			problem("Name is required")
+			continue
+		}
+		if len([]rune(o.Name)) > 50 {
+			problem("Name cannot be longer than 50 characters")
+			continue
+		}
+		uidSize := 20
+		if level == LevelFacility {
+			uidSize = 15
+		}
+		if len(o.UID) > uidSize {
+			problem(fmt.Sprintf("UID cannot be longer than %d characters", uidSize))
+			continue
+		}
+
+		parentLevel := parentLevels[level]
+		if parentLevel != "" {
+			_, parentStored := stored[parentLevel][o.ParentUID]
+			parentImported := importedLevel[o.ParentUID] == parentLevel
+			if parentImported && !accepted[o.ParentUID] {
+				problem("Parent " + parentLevel + " cannot be imported")
+				continue
+			}
+			if o.ParentUID == "" || (!parentStored && !parentImported) {
+				problem("Parent " + parentLevel + " not found")
+				continue
+			}
+		}
+
+		change := Change{
+			Level:     level,
+			UID:       o.UID,
+			Name:      o.Name,
+			ParentUID: o.ParentUID,
+			Ownership: o.Ownership,
+		}
+		if parentLevel != "" {
+			change.Parent = parentName(parentLevel, o.ParentUID)
+		} else {
+			change.ParentUID = ""
+		}
+
+		accepted[o.UID] = true
+		existing, ok := stored[level][o.UID]
+		if !ok {
+			change.Type = ChangeAdded
+			d.Added = append(d.Added, change)
+			continue
+		}
+		change.ID = existing.id
+		unchanged := true
+		if existing.name != o.Name {
+			renamed := change
+			renamed.Type, renamed.OldName = ChangeRenamed, existing.name
+			d.Renamed = append(d.Renamed, renamed)
+			unchanged = false
+		}
+		if parentLevel != "" && existing.parentUID != o.ParentUID {
+			moved := change
+			moved.Type = ChangeMoved
+			moved.OldParentUID = existing.parentUID
+			moved.OldParent = stored[parentLevel][existing.parentUID].name
+			d.Moved = append(d.Moved, moved)
+			unchanged = false
+		}
+		if unchanged {
+			d.Unchanged++
+		}
+	}
+
+	for _, changes := range [][]Change{d.Added, d.Renamed, d.Moved} {
+		sortChanges(changes)
+	}
+	d.Checksum = checksum(d)
+	return d
+}
+
+// sortChanges orders changes from the top of the hierarchy down, so parents
+// are added before their children, and then by name
+func sortChanges(changes []Change) {
+	sort.SliceStable(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if levelRank[a.Level] != levelRank[b.Level] {
+			return levelRank[a.Level] < levelRank[b.Level]
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.UID < b.UID
+	})
+}
+
+// checksum hashes the changes and problems of a diff
+func checksum(d Diff) string {
+	data, _ := json.Marshal([]interface{}{d.Added, d.Renamed, d.Moved, d.Problems})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// levelTable describes how a level is stored
+type levelTable struct {
+	table        string
+	uidColumn    string
+	nameColumn   string
+	parentColumn string
+}
+
+var levelTables = map[string]levelTable{
+	LevelRegion:    {"regions", "region_uid", "region", ""},
+	LevelDistrict:  {"districts", "district_uid", "district", "region_id"},
+	LevelSubcounty: {"subcounties", "subcounty_uid", "subcounty", "district_id"},
+	LevelFacility:  {"facilities", "uid", "facility", "subcounty_id"},
+}
+
+// Apply stores the changes of a diff. Problems are left out. It should run
+// in a transaction. Moves are recorded in the parent history from the
+// effective date, today when nil; added units take effect from that date, or
+// are taken to have always existed when it is nil.
+func Apply(tx *gorm.DB, d Diff, effective *time.Time) error {
+	moved := Day(time.Now())
+	var added *time.Time
+	if effective != nil {
+		moved = Day(*effective)
+		added = &moved
+	}
+
+	parentID := func(c Change) (uint, error) {
+		var ids []uint
+		parentLevel := parentLevels[c.Level]
+		t := levelTables[parentLevel]
+		if err := tx.Table(t.table).Where(t.uidColumn+" = ?", c.ParentUID).Pluck("id", &ids).Error; err != nil {
+			return 0, err
+		}
+		if len(ids) == 0 {
+			return 0, fmt.Errorf("%s %s: parent %s %s not found", c.Level, c.UID, parentLevel, c.ParentUID)
+		}
+		return ids[0], nil
+	}
+
+	for _, c := range d.Added {
+		var parent uint
+		var err error
+		if c.Level != LevelRegion {
+			if parent, err = parentID(c); err != nil {
+				return err
+			}
+		}
+		switch c.Level {
+		case LevelRegion:
+			err = tx.Create(&models.Region{RegionUID: c.UID, Region: c.Name, EffectiveFrom: added}).Error
+		case LevelDistrict:
+			district := &models.District{DistrictUID: c.UID, District: c.Name, RegionID: parent, EffectiveFrom: added}
+			if err = tx.Create(district).Error; err == nil {
+				err = OpenDistrictRegion(tx, district)
+			}
+		case LevelSubcounty:
+			subcounty := &models.Subcounty{SubcountyUID: c.UID, Subcounty: c.Name, DistrictID: parent, EffectiveFrom: added}
+			if err = tx.Create(subcounty).Error; err == nil {
+				err = OpenSubcountyDistrict(tx, subcounty)
+			}
+		case LevelFacility:
+			err = tx.Create(&models.Facility{FacilityUID: c.UID, Facility: c.Name, Ownership: c.Ownership, SubcountyID: &parent, EffectiveFrom: added}).Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, c := range d.Renamed {
+		t := levelTables[c.Level]
+		if err := tx.Table(t.table).Where(t.uidColumn+" = ?", c.UID).Update(t.nameColumn, c.Name).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, c := range d.Moved {
+		parent, err := parentID(c)
+		if err != nil {
+			return err
+		}
+		t := levelTables[c.Level]
+		if err := tx.Table(t.table).Where(t.uidColumn+" = ?", c.UID).Update(t.parentColumn, parent).Error; err != nil {
+			return err
+		}
+		var ids []uint
+		if err := tx.Table(t.table).Where(t.uidColumn+" = ?", c.UID).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		for _, id := range ids {
+			switch c.Level {
+			case LevelDistrict:
+				err = MoveDistrict(tx, id, parent, moved)
+			case LevelSubcounty:
+				err = MoveSubcounty(tx, id, parent, moved)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
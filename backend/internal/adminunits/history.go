@@ -0,0 +1,145 @@
+package adminunits
+
+import (
+	"time"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// DateLayout is the format of effective and as-of dates
+const DateLayout = "2006-01-02"
+
+// Day truncates t to the start of its day, the granularity of effective dates
+func Day(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// effectiveOn reports whether a period with the given bounds covers day.
+// EffectiveFrom is inclusive and EffectiveTo exclusive.
+func effectiveOn(from, to *time.Time, day time.Time) bool {
+	if from != nil && Day(*from).After(day) {
+		return false
+	}
+	if to != nil && !Day(*to).After(day) {
+		return false
+	}
+	return true
+}
+
+// AsOf returns the units as they stood on a date: units not yet created or
+// already retired are left out, and districts and subcounties are placed
+// under the parents recorded in their history for that date. Units without
+// history for the date keep their current parent.
+func (u Units) AsOf(date time.Time) Units {
+	day := Day(date)
+	out := Units{DistrictHistory: u.DistrictHistory, SubcountyHistory: u.SubcountyHistory}
+
+	districtRegion := make(map[uint]uint)
+	for _, h := range u.DistrictHistory {
+		if effectiveOn(h.EffectiveFrom, h.EffectiveTo, day) {
+			districtRegion[h.DistrictID] = h.RegionID
+		}
+	}
+	subcountyDistrict := make(map[uint]uint)
+	for _, h := range u.SubcountyHistory {
+		if effectiveOn(h.EffectiveFrom, h.EffectiveTo, day) {
+			subcountyDistrict[h.SubcountyID] = h.DistrictID
+		}
+	}
+
+	for _, r := range u.Regions {
+		if effectiveOn(r.EffectiveFrom, r.EffectiveTo, day) {
+			out.Regions = append(out.Regions, r)
+		}
+	}
+	for _, d := range u.Districts {
+		if !effectiveOn(d.EffectiveFrom, d.EffectiveTo, day) {
+			continue
+		}
+		if regionID, ok := districtRegion[d.ID]; ok {
+			d.RegionID = regionID
+		}
+		out.Districts = append(out.Districts, d)
+	}
+	for _, s := range u.Subcounties {
+		if !effectiveOn(s.EffectiveFrom, s.EffectiveTo, day) {
+			continue
+		}
+		if districtID, ok := subcountyDistrict[s.ID]; ok {
+			s.DistrictID = districtID
+		}
+		out.Subcounties = append(out.Subcounties, s)
+	}
+	for _, f := range u.Facilities {
+		if effectiveOn(f.EffectiveFrom, f.EffectiveTo, day) {
+			out.Facilities = append(out.Facilities, f)
+		}
+	}
+	return out
+}
+
+// OpenDistrictRegion starts the history of a new district under its region
+func OpenDistrictRegion(tx *gorm.DB, district *models.District) error {
+	if district.RegionID == 0 {
+		return nil
+	}
+	return tx.Create(&models.DistrictRegionHistory{
+		DistrictID:    district.ID,
+		RegionID:      district.RegionID,
+		EffectiveFrom: district.EffectiveFrom,
+	}).Error
+}
+
+// OpenSubcountyDistrict starts the history of a new subcounty under its
+// district
+func OpenSubcountyDistrict(tx *gorm.DB, subcounty *models.Subcounty) error {
+	if subcounty.DistrictID == 0 {
+		return nil
+	}
+	return tx.Create(&models.SubcountyDistrictHistory{
+		SubcountyID:   subcounty.ID,
+		DistrictID:    subcounty.DistrictID,
+		EffectiveFrom: subcounty.EffectiveFrom,
+	}).Error
+}
+
+// MoveDistrict records that a district belongs to a new region from a date
+func MoveDistrict(tx *gorm.DB, districtID, regionID uint, from time.Time) error {
+	day := Day(from)
+	if err := closeHistory(tx, &models.DistrictRegionHistory{}, "district_id", districtID, day); err != nil {
+		return err
+	}
+	return tx.Create(&models.DistrictRegionHistory{
+		DistrictID:    districtID,
+		RegionID:      regionID,
+		EffectiveFrom: &day,
+	}).Error
+}
+
+// MoveSubcounty records that a subcounty belongs to a new district from a
+// date
+func MoveSubcounty(tx *gorm.DB, subcountyID, districtID uint, from time.Time) error {
+	day := Day(from)
+	if err := closeHistory(tx, &models.SubcountyDistrictHistory{}, "subcounty_id", subcountyID, day); err != nil {
+		return err
+	}
+	return tx.Create(&models.SubcountyDistrictHistory{
+		SubcountyID:   subcountyID,
+		DistrictID:    districtID,
+		EffectiveFrom: &day,
+	}).Error
+}
+
+// closeHistory ends a unit's periods at day. Periods starting on or after day
+// are superseded by the new one and removed, and periods running past day
+// are cut short.
+func closeHistory(tx *gorm.DB, model interface{}, column string, id uint, day time.Time) error {
+	if err := tx.Where(column+" = ? AND effective_from >= ?", id, day).Delete(model).Error; err != nil {
+		return err
+	}
+	return tx.Model(model).
+		Where(column+" = ? AND (effective_to IS NULL OR effective_to > ?)", id, day).
+		Update("effective_to", day).Error
+}
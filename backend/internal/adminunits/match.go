@@ -0,0 +1,112 @@
+package adminunits
+
+import (
+	"strings"
+	"unicode"
+)
+
+// unitSuffixes are dropped when comparing names, so that "Kampala" matches
+// "Kampala District" and "Adilang" matches "Adilang Subcounty"
+var unitSuffixes = []string{
+	" district",
+	" sub county",
+	" subcounty",
+	" s/c",
+	" region",
+}
+
+// Normalize folds a name for comparison: lower case, single spaces, no
+// punctuation and no administrative-level suffix
+func Normalize(name string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '/':
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteRune(r)
+			space = false
+		default:
+			space = true
+		}
+	}
+	normalized := b.String()
+	for _, suffix := range unitSuffixes {
+		if trimmed := strings.TrimSuffix(normalized, suffix); trimmed != normalized && trimmed != "" {
+			return trimmed
+		}
+	}
+	return normalized
+}
+
+// levenshtein returns the edit distance between two strings
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// Similarity compares two names after normalisation and returns a score from
+// 0 (nothing in common) to 1 (same name)
+func Similarity(a, b string) float64 {
+	a, b = Normalize(a), Normalize(b)
+	if a == b {
+		return 1
+	}
+	longest := len([]rune(a))
+	if n := len([]rune(b)); n > longest {
+		longest = n
+	}
+	if longest == 0 {
+		return 0
+	}
+	return 1 - float64(levenshtein(a, b))/float64(longest)
+}
+
+// Candidate is a named administrative unit that can be matched
+type Candidate struct {
+	ID   uint
+	Name string
+}
+
+// Match is the best candidate found for a name
+type Match struct {
+	Candidate
+	Similarity float64
+	Exact      bool
+}
+
+// BestMatch returns the candidate whose name is most similar to name. found
+// is false when no candidate reaches the threshold.
+func BestMatch(name string, candidates []Candidate, threshold float64) (match Match, found bool) {
+	normalized := Normalize(name)
+	if normalized == "" {
+		return match, false
+	}
+	for _, c := range candidates {
+		if Normalize(c.Name) == normalized {
+			return Match{Candidate: c, Similarity: 1, Exact: true}, true
+		}
+		if s := Similarity(name, c.Name); s > match.Similarity {
+			match = Match{Candidate: c, Similarity: s}
+		}
+	}
+	return match, match.Similarity >= threshold
+}
@@ -0,0 +1,189 @@
+package adminunits
+
+import (
+	"sort"
+	"strings"
+)
+
+// PathSeparator joins the names in a search result's label
+const PathSeparator = " › "
+
+// fuzzyThreshold is the minimum similarity for a misspelt query to match,
+// and fuzzyMinLength the query length below which only prefixes match
+const (
+	fuzzyThreshold = 0.75
+	fuzzyMinLength = 3
+)
+
+// levelRank orders results of equal score from the top of the hierarchy down
+var levelRank = map[string]int{
+	LevelRegion:    0,
+	LevelDistrict:  1,
+	LevelSubcounty: 2,
+	LevelFacility:  3,
+}
+
+// PathItem is one unit on the path to a search result
+type PathItem struct {
+	Level string `json:"level"`
+	ID    uint   `json:"id"`
+	Name  string `json:"name"`
+}
+
+// SearchResult is a unit matching a search, with the path from its region
+type SearchResult struct {
+	Level string     `json:"level"`
+	ID    uint       `json:"id"`
+	Name  string     `json:"name"`
+	Path  []PathItem `json:"path"`
+	Label string     `json:"label"`
+	Score float64    `json:"score"`
+}
+
+// Search finds the units at every level whose name matches q, best first.
+// Names starting with q score highest, then names with a word starting with
+// q, then names whose start is within a few typing errors of q.
+func (u Units) Search(q string, limit int) []SearchResult {
+	query := Normalize(q)
+	if query == "" {
+		return nil
+	}
+
+	regions := make(map[uint]PathItem, len(u.Regions))
+	for _, r := range u.Regions {
+		regions[r.ID] = PathItem{Level: LevelRegion, ID: r.ID, Name: r.Region}
+	}
+	districts := make(map[uint]PathItem, len(u.Districts))
+	districtRegion := make(map[uint]uint, len(u.Districts))
+	for _, d := range u.Districts {
+		districts[d.ID] = PathItem{Level: LevelDistrict, ID: d.ID, Name: d.District}
+		districtRegion[d.ID] = d.RegionID
+	}
+	subcounties := make(map[uint]PathItem, len(u.Subcounties))
+	subcountyDistrict := make(map[uint]uint, len(u.Subcounties))
+	for _, s := range u.Subcounties {
+		subcounties[s.ID] = PathItem{Level: LevelSubcounty, ID: s.ID, Name: s.Subcounty}
+		subcountyDistrict[s.ID] = s.DistrictID
+	}
+
+	// path lists the known ancestors of a unit followed by the unit itself
+	path := func(regionID, districtID, subcountyID uint, item PathItem) []PathItem {
+		var items []PathItem
+		if r, ok := regions[regionID]; ok {
+			items = append(items, r)
+		}
+		if d, ok := districts[districtID]; ok {
+			items = append(items, d)
+		}
+		if s, ok := subcounties[subcountyID]; ok {
+			items = append(items, s)
+		}
+		return append(items, item)
+	}
+
+	var results []SearchResult
+	add := func(item PathItem, items []PathItem) {
+		score := matchScore(query, item.Name)
+		if score == 0 {
+			return
+		}
+		names := make([]string, len(items))
+		for i, p := range items {
+			names[i] = p.Name
+		}
+		results = append(results, SearchResult{
+			Level: item.Level,
+			ID:    item.ID,
+			Name:  item.Name,
+			Path:  items,
+			Label: strings.Join(names, PathSeparator),
+			Score: score,
+		})
+	}
+
+	for _, item := range regions {
+		add(item, path(0, 0, 0, item))
+	}
+	for id, item := range districts {
+		add(item, path(districtRegion[id], 0, 0, item))
+	}
+	for id, item := range subcounties {
+		districtID := subcountyDistrict[id]
+		add(item, path(districtRegion[districtID], districtID, 0, item))
+	}
+	for _, f := range u.Facilities {
+		item := PathItem{Level: LevelFacility, ID: f.ID, Name: f.Facility}
+		var subcountyID uint
+		if f.SubcountyID != nil {
+			subcountyID = *f.SubcountyID
+		}
+		districtID := subcountyDistrict[subcountyID]
+		add(item, path(districtRegion[districtID], districtID, subcountyID, item))
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if levelRank[a.Level] != levelRank[b.Level] {
+			return levelRank[a.Level] < levelRank[b.Level]
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID < b.ID
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// matchScore scores how well a normalised query matches a name, from 0 (no
+// match) to 1 (same name)
+func matchScore(query, name string) float64 {
+	normalized := Normalize(name)
+	switch {
+	case normalized == query:
+		return 1
+	case strings.HasPrefix(normalized, query):
+		return 0.95
+	case strings.Contains(" "+normalized, " "+query):
+		return 0.9
+	}
+
+	if len([]rune(query)) < fuzzyMinLength {
+		return 0
+	}
+	best := 0.0
+	words := strings.Fields(normalized)
+	for i := range words {
+		// Compare with the start of the name and of each later word, as
+		// far as the query goes
+		if s := prefixSimilarity(query, strings.Join(words[i:], " ")); s > best {
+			best = s
+		}
+	}
+	if best < fuzzyThreshold {
+		return 0
+	}
+	return 0.8 * best
+}
+
+// prefixSimilarity compares a query with the start of a name, from 0 to 1.
+// Starts one rune shorter or longer than the query are tried too, so that a
+// dropped or doubled letter costs a single error.
+func prefixSimilarity(query, name string) float64 {
+	q, n := []rune(query), []rune(name)
+	best := 0.0
+	for length := len(q) - 1; length <= len(q)+1; length++ {
+		if length > len(n) {
+			break
+		}
+		if s := 1 - float64(levenshtein(query, string(n[:length])))/float64(len(q)); s > best {
+			best = s
+		}
+	}
+	return best
+}
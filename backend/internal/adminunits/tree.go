@@ -0,0 +1,153 @@
+package adminunits
+
+import (
+	"sort"
+
+	"github.com/alertsMIS/backend/internal/models"
+)
+
+// Administrative levels, from the top of the hierarchy down
+const (
+	LevelRegion    = "region"
+	LevelDistrict  = "district"
+	LevelSubcounty = "subcounty"
+	LevelParish    = "parish"
+	LevelVillage   = "village"
+	LevelFacility  = "facility"
+)
+
+// Units is a snapshot of the administrative units and of the parents they
+// had over time
+type Units struct {
+	Regions     []models.Region
+	Districts   []models.District
+	Subcounties []models.Subcounty
+	Facilities  []models.Facility
+
+	DistrictHistory  []models.DistrictRegionHistory
+	SubcountyHistory []models.SubcountyDistrictHistory
+}
+
+// FacilityNode is a facility in the hierarchy tree
+type FacilityNode struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	Ownership string `json:"ownership"`
+}
+
+// SubcountyNode is a subcounty and its facilities
+type SubcountyNode struct {
+	ID         uint           `json:"id"`
+	Name       string         `json:"name"`
+	Facilities []FacilityNode `json:"facilities,omitempty"`
+}
+
+// DistrictNode is a district and its subcounties
+type DistrictNode struct {
+	ID          uint            `json:"id"`
+	Name        string          `json:"name"`
+	Subcounties []SubcountyNode `json:"subcounties,omitempty"`
+}
+
+// RegionNode is a region and its districts
+type RegionNode struct {
+	ID        uint           `json:"id"`
+	Name      string         `json:"name"`
+	Districts []DistrictNode `json:"districts,omitempty"`
+}
+
+// Tree nests the units under their parents, each level sorted by name.
+// Units whose parent is missing are kept under a parent with ID 0 so that
+// nothing is dropped from the tree.
+func (u Units) Tree() []RegionNode {
+	facilities := make(map[uint][]FacilityNode)
+	for _, f := range u.Facilities {
+		var subcountyID uint
+		if f.SubcountyID != nil {
+			subcountyID = *f.SubcountyID
+		}
+		facilities[subcountyID] = append(facilities[subcountyID], FacilityNode{ID: f.ID, Name: f.Facility, Ownership: f.Ownership})
+	}
+
+	subcounties := make(map[uint][]SubcountyNode)
+	subcountyIDs := make(map[uint]bool, len(u.Subcounties))
+	for _, s := range u.Subcounties {
+		subcountyIDs[s.ID] = true
+		subcounties[s.DistrictID] = append(subcounties[s.DistrictID], SubcountyNode{ID: s.ID, Name: s.Subcounty, Facilities: facilities[s.ID]})
+	}
+	var orphanFacilities []FacilityNode
+	for id, nodes := range facilities {
+		if !subcountyIDs[id] {
+			orphanFacilities = append(orphanFacilities, nodes...)
+		}
+	}
+	if len(orphanFacilities) > 0 {
+		subcounties[0] = append(subcounties[0], SubcountyNode{Facilities: orphanFacilities})
+	}
+
+	districts := make(map[uint][]DistrictNode)
+	districtIDs := make(map[uint]bool, len(u.Districts))
+	for _, d := range u.Districts {
+		districtIDs[d.ID] = true
+		districts[d.RegionID] = append(districts[d.RegionID], DistrictNode{ID: d.ID, Name: d.District, Subcounties: subcounties[d.ID]})
+	}
+	var orphanSubcounties []SubcountyNode
+	for id, nodes := range subcounties {
+		if !districtIDs[id] {
+			orphanSubcounties = append(orphanSubcounties, nodes...)
+		}
+	}
+	if len(orphanSubcounties) > 0 {
+		districts[0] = append(districts[0], DistrictNode{Subcounties: orphanSubcounties})
+	}
+
+	regions := make([]RegionNode, 0, len(u.Regions)+1)
+	regionIDs := make(map[uint]bool, len(u.Regions))
+	for _, r := range u.Regions {
+		regionIDs[r.ID] = true
+		regions = append(regions, RegionNode{ID: r.ID, Name: r.Region, Districts: districts[r.ID]})
+	}
+	var orphanDistricts []DistrictNode
+	for id, nodes := range districts {
+		if !regionIDs[id] {
+			orphanDistricts = append(orphanDistricts, nodes...)
+		}
+	}
+	if len(orphanDistricts) > 0 {
+		regions = append(regions, RegionNode{Districts: orphanDistricts})
+	}
+
+	sortTree(regions)
+	return regions
+}
+
+// sortTree orders every level of the tree by name
+func sortTree(regions []RegionNode) {
+	sort.Slice(regions, func(i, j int) bool { return less(regions[i].ID, regions[i].Name, regions[j].ID, regions[j].Name) })
+	for _, r := range regions {
+		sort.Slice(r.Districts, func(i, j int) bool {
+			return less(r.Districts[i].ID, r.Districts[i].Name, r.Districts[j].ID, r.Districts[j].Name)
+		})
+		for _, d := range r.Districts {
+			sort.Slice(d.Subcounties, func(i, j int) bool {
+				return less(d.Subcounties[i].ID, d.Subcounties[i].Name, d.Subcounties[j].ID, d.Subcounties[j].Name)
+			})
+			for _, s := range d.Subcounties {
+				sort.Slice(s.Facilities, func(i, j int) bool {
+					return less(s.Facilities[i].ID, s.Facilities[i].Name, s.Facilities[j].ID, s.Facilities[j].Name)
+				})
+			}
+		}
+	}
+}
+
+// less orders units by name, with the placeholder for missing parents last
+func less(idA uint, nameA string, idB uint, nameB string) bool {
+	if (idA == 0) != (idB == 0) {
+		return idB == 0
+	}
+	if nameA != nameB {
+		return nameA < nameB
+	}
+	return idA < idB
+}
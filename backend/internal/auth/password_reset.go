@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Errors returned when a password cannot be reset
+var (
+	ErrInvalidResetToken = errors.New("invalid password reset token")
+	ErrWeakPassword      = fmt.Errorf("password must be %d to 72 characters long", MinPasswordLength)
+)
+
+// MinPasswordLength is the shortest password accepted when one is reset
+const MinPasswordLength = 8
+
+// PasswordResets issues and redeems one-time password reset tokens. Requests
+// are limited per account and per IP address over a rolling hour.
+type PasswordResets struct {
+	db       *gorm.DB
+	ttl      time.Duration
+	accounts *Limiter
+	ips      *Limiter
+	attempts *Limiter
+}
+
+// NewPasswordResets creates a PasswordResets issuing tokens that last ttl.
+// Each account may be sent perAccount reset emails an hour, and each IP
+// address may make perIP reset requests and as many reset attempts an hour.
+func NewPasswordResets(db *gorm.DB, ttl time.Duration, perAccount, perIP int) *PasswordResets {
+	return &PasswordResets{
+		db:       db,
+		ttl:      ttl,
+		accounts: NewLimiter(db, "password_reset_account", perAccount, time.Hour),
+		ips:      NewLimiter(db, "password_reset_ip", perIP, time.Hour),
+		attempts: NewLimiter(db, "password_reset_attempt_ip", perIP, time.Hour),
+	}
+}
+
+// TTL returns how long reset tokens last
+func (p *PasswordResets) TTL() time.Duration {
+	return p.ttl
+}
+
+// AllowRequest counts a reset request from an IP address against its limit
+func (p *PasswordResets) AllowRequest(ip string) (bool, time.Duration, error) {
+	return p.ips.Allow(ip)
+}
+
+// AllowAttempt counts an attempt to redeem a token from an IP address
+// against its limit
+func (p *PasswordResets) AllowAttempt(ip string) (bool, time.Duration, error) {
+	return p.attempts.Allow(ip)
+}
+
+// Create issues a reset token for a user, replacing the user's unused ones.
+// When the account has had too many reset emails ok is false and no token is
+// issued.
+func (p *PasswordResets) Create(userID uint, ip string) (token string, ok bool, err error) {
+	allowed, _, err := p.accounts.Allow(strconv.FormatUint(uint64(userID), 10))
+	if err != nil || !allowed {
+		return "", false, err
+	}
+	token, err = randomToken(32)
+	if err != nil {
+		return "", false, err
+	}
+
+	now := time.Now()
+	err = p.db.Transaction(func(tx *gorm.DB) error {
+		// Expired tokens are of no further use, and only the latest link
+		// sent to a user works
+		err := tx.Where("expires_at < ? OR (user_id = ? AND used_at IS NULL)", now, userID).
+			Delete(&models.PasswordResetToken{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&models.PasswordResetToken{
+			UserID:    userID,
+			TokenHash: HashToken(token),
+			RequestIP: truncate(ip, 45),
+			ExpiresAt: now.Add(p.ttl),
+		}).Error
+	})
+	if err != nil {
+		return "", false, err
+	}
+	return token, true, nil
+}
+
+// Reset uses up a reset token and sets the password of its user, returning
+// the user's ID
+func (p *PasswordResets) Reset(token, password string) (uint, error) {
+	if len(password) < MinPasswordLength || len(password) > 72 {
+		return 0, ErrWeakPassword
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	var userID uint
+	err = p.db.Transaction(func(tx *gorm.DB) error {
+		var reset models.PasswordResetToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", HashToken(token)).
+			First(&reset).Error
+		if err == gorm.ErrRecordNotFound {
+			return ErrInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if reset.UsedAt != nil || !now.Before(reset.ExpiresAt) {
+			return ErrInvalidResetToken
+		}
+
+		result := tx.Model(&models.User{}).Where("id = ?", reset.UserID).Update("password", string(hashed))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidResetToken
+		}
+		userID = reset.UserID
+		return tx.Model(&reset).Update("used_at", now).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
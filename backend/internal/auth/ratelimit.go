@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// Limiter allows a number of requests per key in a sliding window. Requests
+// are counted in the database so that every instance of the API shares the
+// limit.
+type Limiter struct {
+	db     *gorm.DB
+	scope  string
+	limit  int
+	window time.Duration
+}
+
+// NewLimiter creates a Limiter allowing limit requests per key in window.
+// Scope tells the limit apart from others counted in the same table.
+func NewLimiter(db *gorm.DB, scope string, limit int, window time.Duration) *Limiter {
+	return &Limiter{db: db, scope: scope, limit: limit, window: window}
+}
+
+// Allow counts a request for key and reports whether it is within the limit.
+// Refused requests are not counted; retryAfter is then the time until the
+// oldest counted request leaves the window.
+func (l *Limiter) Allow(key string) (allowed bool, retryAfter time.Duration, err error) {
+	now := time.Now()
+	since := now.Add(-l.window)
+	// Requests that have left the window no longer count
+	err = l.db.Where("scope = ? AND created_at < ?", l.scope, since).Delete(&models.RateLimitEvent{}).Error
+	if err != nil {
+		return false, 0, err
+	}
+
+	var events []models.RateLimitEvent
+	err = l.db.Select("created_at").
+		Where("scope = ? AND limit_key = ? AND created_at >= ?", l.scope, key, since).
+		Order("created_at").
+		Limit(l.limit).
+		Find(&events).Error
+	if err != nil {
+		return false, 0, err
+	}
+	if len(events) >= l.limit {
+		return false, events[0].CreatedAt.Add(l.window).Sub(now), nil
+	}
+
+	err = l.db.Create(&models.RateLimitEvent{Scope: l.scope, Key: key, CreatedAt: now}).Error
+	if err != nil {
+		return false, 0, err
+	}
+	return true, 0, nil
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Revocations records revoked access tokens and ended sessions in the
+// database and keeps them in memory, as they are checked on every
+// authenticated request. Tokens may also be revoked by other instances of the
+// API, so the cache is reloaded after ttl.
+type Revocations struct {
+	db  *gorm.DB
+	ttl time.Duration
+
+	mu       sync.Mutex
+	tokens   map[string]time.Time
+	users    map[uint]time.Time
+	sessions map[string]time.Time
+	loaded   time.Time
+}
+
+// NewRevocations creates a Revocations store that reloads after ttl
+func NewRevocations(db *gorm.DB, ttl time.Duration) *Revocations {
+	return &Revocations{db: db, ttl: ttl}
+}
+
+// load refreshes the cache if it has expired. Callers must hold mu.
+func (r *Revocations) load() error {
+	if !r.loaded.IsZero() && time.Since(r.loaded) < r.ttl {
+		return nil
+	}
+	now := time.Now()
+	// Revocations of expired tokens are of no further use
+	if err := r.db.Where("expires_at < ?", now).Delete(&models.RevokedToken{}).Error; err != nil {
+		return err
+	}
+	var tokens []models.RevokedToken
+	if err := r.db.Find(&tokens).Error; err != nil {
+		return err
+	}
+	var users []models.UserRevocation
+	if err := r.db.Find(&users).Error; err != nil {
+		return err
+	}
+	// Access tokens of an ended session can be refreshed no longer than
+	// the session would have lasted
+	var sessions []models.Session
+	err := r.db.Select("family_id", "expires_at").
+		Where("ended_at IS NOT NULL AND expires_at >= ?", now).
+		Find(&sessions).Error
+	if err != nil {
+		return err
+	}
+
+	r.tokens = make(map[string]time.Time, len(tokens))
+	for _, t := range tokens {
+		r.tokens[t.JTI] = t.ExpiresAt
+	}
+	r.users = make(map[uint]time.Time, len(users))
+	for _, u := range users {
+		r.users[u.UserID] = u.RevokedBefore
+	}
+	r.sessions = make(map[string]time.Time, len(sessions))
+	for _, s := range sessions {
+		r.sessions[s.FamilyID] = s.ExpiresAt
+	}
+	r.loaded = now
+	return nil
+}
+
+// IsRevoked reports whether an access token has been revoked, either by
+// itself, by ending its session or with all the tokens of its user
+func (r *Revocations) IsRevoked(jti, familyID string, userID uint, issuedAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.load(); err != nil {
+		return false, err
+	}
+	if _, ok := r.tokens[jti]; ok {
+		return true, nil
+	}
+	if _, ok := r.sessions[familyID]; ok && familyID != "" {
+		return true, nil
+	}
+	if before, ok := r.users[userID]; ok && issuedAt.Before(before) {
+		return true, nil
+	}
+	return false, nil
+}
+
+// RevokeToken revokes an access token until it expires
+func (r *Revocations) RevokeToken(jti string, userID uint, expiresAt time.Time) error {
+	err := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.RevokedToken{
+		JTI:       jti,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+		RevokedAt: time.Now(),
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tokens != nil {
+		r.tokens[jti] = expiresAt
+	}
+	return nil
+}
+
+// EndSession ends the session of a refresh token family: its refresh tokens
+// are revoked and so are the access tokens issued in it
+func (r *Revocations) EndSession(familyID string) error {
+	var session models.Session
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := RevokeFamily(tx, familyID); err != nil {
+			return err
+		}
+		err := tx.Select("family_id", "expires_at").Where("family_id = ?", familyID).First(&session).Error
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sessions != nil && session.FamilyID != "" {
+		r.sessions[familyID] = session.ExpiresAt
+	}
+	return nil
+}
+
+// RevokeUser ends all sessions of a user: access tokens issued so far are
+// revoked and so are the user's refresh tokens. It returns the number of
+// refresh tokens revoked.
+func (r *Revocations) RevokeUser(userID uint) (int64, error) {
+	// Token issue times are in whole seconds, so a token issued during
+	// this second must count as issued before the revocation
+	before := time.Now().Truncate(time.Second).Add(time.Second)
+	var revoked int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&models.UserRevocation{
+			UserID:        userID,
+			RevokedBefore: before,
+		}).Error
+		if err != nil {
+			return err
+		}
+		result := tx.Model(&models.RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL AND used_at IS NULL", userID).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		revoked = result.RowsAffected
+		return endSessions(tx, "user_id = ?", userID)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.users != nil {
+		r.users[userID] = before
+	}
+	return revoked, nil
+}
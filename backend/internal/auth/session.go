@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// Client describes the device a session is used from
+type Client struct {
+	IP        string
+	UserAgent string
+	// Device is a name given by the client, such as the phone model reported
+	// by the mobile app. When empty it is guessed from the user agent.
+	Device string
+}
+
+// device returns the device name of the client
+func (c Client) device() string {
+	if name := strings.TrimSpace(c.Device); name != "" {
+		return truncate(name, 100)
+	}
+	return DescribeUserAgent(c.UserAgent)
+}
+
+// sessionUpdates returns the columns recording that a session was seen from
+// the client
+func (c Client) sessionUpdates(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"device":       c.device(),
+		"ip_address":   truncate(c.IP, 45),
+		"user_agent":   truncate(c.UserAgent, 255),
+		"last_seen_at": now,
+	}
+}
+
+// truncate cuts s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// userAgentBrowsers and userAgentSystems are matched in order, so that
+// browsers built on others and mobile systems reporting desktop ones are
+// recognised first
+var (
+	userAgentBrowsers = []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"SamsungBrowser/", "Samsung Internet"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"CriOS/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"okhttp/", "Android app"},
+		{"Dart/", "Mobile app"},
+		{"PostmanRuntime/", "Postman"},
+		{"curl/", "curl"},
+	}
+	userAgentSystems = []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// DescribeUserAgent returns a short device name such as "Chrome on Windows"
+// for a user agent
+func DescribeUserAgent(userAgent string) string {
+	var browser, system string
+	for _, b := range userAgentBrowsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, s := range userAgentSystems {
+		if strings.Contains(userAgent, s.token) {
+			system = s.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	case strings.TrimSpace(userAgent) == "":
+		return "Unknown device"
+	}
+	return truncate(userAgent, 100)
+}
+
+// createSession records the session of a new refresh token family
+func createSession(tx *gorm.DB, userID uint, familyID string, client Client, expiresAt time.Time) error {
+	now := time.Now()
+	return tx.Create(&models.Session{
+		UserID:     userID,
+		FamilyID:   familyID,
+		Device:     client.device(),
+		IPAddress:  truncate(client.IP, 45),
+		UserAgent:  truncate(client.UserAgent, 255),
+		LastSeenAt: now,
+		ExpiresAt:  expiresAt,
+	}).Error
+}
+
+// endSessions marks the sessions of the refresh token families matched by
+// query as ended
+func endSessions(tx *gorm.DB, query interface{}, args ...interface{}) error {
+	return tx.Model(&models.Session{}).
+		Where(query, args...).
+		Where("ended_at IS NULL").
+		Update("ended_at", time.Now()).Error
+}
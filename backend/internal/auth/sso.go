@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/alertsMIS/backend/internal/oidc"
+	"gorm.io/gorm"
+)
+
+// Errors returned when a single sign-on cannot be completed
+var (
+	ErrInvalidSSOState  = errors.New("invalid or expired single sign-on state")
+	ErrInvalidLoginCode = errors.New("invalid or expired login code")
+	ErrSSOUsername      = errors.New("identity provider gave no usable username")
+	ErrSSOUsernameTaken = errors.New("username belongs to another account")
+	ErrSSOUserDeleted   = errors.New("linked user has been deleted")
+)
+
+// SSOLoginTTL is the time allowed for signing in at the identity provider
+const SSOLoginTTL = 10 * time.Minute
+
+// Sizes of the users table columns of the PHP schema
+const (
+	usernameSize    = 50
+	emailSize       = 25
+	nameSize        = 25
+	affiliationSize = 50
+	roleSize        = 20
+)
+
+// SSO signs users in through an OpenID Connect identity provider. Users are
+// found by their provider account, or linked to an existing user by
+// verified email address, or created on their first sign-in. Their user
+// type, affiliation and level follow the provider at every sign-in.
+type SSO struct {
+	db             *gorm.DB
+	provider       *oidc.Provider
+	mapping        oidc.Mapping
+	linkByUsername bool
+	codeTTL        time.Duration
+}
+
+// NewSSO creates an SSO signing users in with provider. With linkByUsername,
+// provider accounts are also linked to existing users of the same username.
+// Login codes handed to the client after sign-in last codeTTL.
+func NewSSO(db *gorm.DB, provider *oidc.Provider, mapping oidc.Mapping, linkByUsername bool, codeTTL time.Duration) *SSO {
+	return &SSO{db: db, provider: provider, mapping: mapping, linkByUsername: linkByUsername, codeTTL: codeTTL}
+}
+
+// Begin starts a sign-in and returns the identity provider URL to send the
+// user to, and the state the provider will redirect back with. The state is
+// to be kept in the browser that started the sign-in, so that the callback
+// can tell that it comes from the same browser.
+func (s *SSO) Begin(ctx context.Context) (authURL, state string, err error) {
+	state, err = randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		return "", "", err
+	}
+	authURL, err = s.provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	// Sign-ins abandoned or not redeemed are of no further use
+	if err := s.db.Where("expires_at < ?", now).Delete(&models.SSOLogin{}).Error; err != nil {
+		return "", "", err
+	}
+	err = s.db.Create(&models.SSOLogin{
+		StateHash:    HashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    now.Add(SSOLoginTTL),
+	}).Error
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, state, nil
+}
+
+// Complete finishes a sign-in with the state and authorization code the
+// identity provider redirected back with. It returns a one-time login code
+// for the client to exchange for tokens.
+func (s *SSO) Complete(ctx context.Context, state, code string) (string, error) {
+	var login models.SSOLogin
+	err := s.db.Where("state_hash = ? AND user_id IS NULL AND expires_at > ?", HashToken(state), time.Now()).
+		First(&login).Error
+	if err == gorm.ErrRecordNotFound {
+		return "", ErrInvalidSSOState
+	}
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := s.provider.Exchange(ctx, code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		return "", err
+	}
+	profile := s.mapping.Profile(claims)
+
+	loginCode, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	codeHash := HashToken(loginCode)
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		userID, err := s.user(tx, profile)
+		if err != nil {
+			return err
+		}
+		// The state can only be used once
+		result := tx.Model(&login).Where("user_id IS NULL").Updates(map[string]interface{}{
+			"user_id":         userID,
+			"login_code_hash": codeHash,
+			"expires_at":      time.Now().Add(s.codeTTL),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidSSOState
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return loginCode, nil
+}
+
+// Redeem exchanges a login code for the user it signed in. Each code can be
+// used once.
+func (s *SSO) Redeem(loginCode string) (uint, error) {
+	var login models.SSOLogin
+	err := s.db.Where("login_code_hash = ? AND expires_at > ?", HashToken(loginCode), time.Now()).
+		First(&login).Error
+	if err == gorm.ErrRecordNotFound {
+		return 0, ErrInvalidLoginCode
+	}
+	if err != nil {
+		return 0, err
+	}
+	result := s.db.Delete(&login)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 || login.UserID == nil {
+		return 0, ErrInvalidLoginCode
+	}
+	return *login.UserID, nil
+}
+
+// user returns the user of a provider account, linking or creating one on
+// its first sign-in, and updates the user's roles from the provider
+func (s *SSO) user(tx *gorm.DB, profile oidc.Profile) (uint, error) {
+	identity := models.UserIdentity{Issuer: s.provider.Issuer(), Subject: profile.Subject}
+	err := tx.Where("issuer = ? AND subject = ?", identity.Issuer, identity.Subject).First(&identity).Error
+	switch err {
+	case nil:
+		var count int64
+		if err := tx.Model(&models.User{}).Where("id = ?", identity.UserID).Count(&count).Error; err != nil {
+			return 0, err
+		}
+		if count == 0 {
+			return 0, ErrSSOUserDeleted
+		}
+	case gorm.ErrRecordNotFound:
+		if identity.UserID, err = s.link(tx, profile); err != nil {
+			return 0, err
+		}
+		if identity.UserID == 0 {
+			if identity.UserID, err = s.create(tx, profile); err != nil {
+				return 0, err
+			}
+		}
+	default:
+		return 0, err
+	}
+
+	roles := make(map[string]interface{})
+	if profile.UserType != "" {
+		roles["user_type"] = truncate(profile.UserType, roleSize)
+	}
+	if profile.Affiliation != "" {
+		roles["affiliation"] = truncate(profile.Affiliation, affiliationSize)
+	}
+	if profile.Level != "" {
+		roles["level"] = truncate(profile.Level, roleSize)
+	}
+	if len(roles) > 0 {
+		if err := tx.Model(&models.User{}).Where("id = ?", identity.UserID).Updates(roles).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	identity.Email = truncate(profile.Email, 255)
+	identity.LastLoginAt = time.Now()
+	if err := tx.Save(&identity).Error; err != nil {
+		return 0, err
+	}
+	return identity.UserID, nil
+}
+
+// link returns the existing user a new provider account belongs to, or 0
+func (s *SSO) link(tx *gorm.DB, profile oidc.Profile) (uint, error) {
+	var user models.User
+	if profile.EmailVerified && profile.Email != "" {
+		err := tx.Select("id").Where("email = ?", profile.Email).First(&user).Error
+		if err != gorm.ErrRecordNotFound {
+			return user.ID, err
+		}
+	}
+	if s.linkByUsername && profile.Username != "" {
+		err := tx.Select("id").Where("username = ?", profile.Username).First(&user).Error
+		if err != gorm.ErrRecordNotFound {
+			return user.ID, err
+		}
+	}
+	return 0, nil
+}
+
+// ssoUser is a user created on sign-in as written to the users table, with
+// the name columns of the PHP system, which it requires
+type ssoUser struct {
+	ID          uint
+	Username    string
+	Password    string
+	GName       string `gorm:"column:gname"`
+	Surname     string `gorm:"column:surname"`
+	FirstName   string
+	LastName    string
+	Email       string
+	Affiliation string
+	UserType    string
+	Level       string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// create adds the user of a new provider account. The user has no password,
+// so can only sign in through the provider.
+func (s *SSO) create(tx *gorm.DB, profile oidc.Profile) (uint, error) {
+	if profile.Username == "" || len(profile.Username) > usernameSize {
+		return 0, ErrSSOUsername
+	}
+	var count int64
+	if err := tx.Unscoped().Model(&models.User{}).Where("username = ?", profile.Username).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return 0, ErrSSOUsernameTaken
+	}
+
+	// The users table holds short, unique email addresses. Others are only
+	// kept on the identity, and the user gets a placeholder.
+	email := profile.Email
+	if email != "" && len(email) <= emailSize {
+		if err := tx.Unscoped().Model(&models.User{}).Where("email = ?", email).Count(&count).Error; err != nil {
+			return 0, err
+		}
+	}
+	if email == "" || len(email) > emailSize || count > 0 {
+		email = "sso-" + HashToken(s.provider.Issuer() + " " + profile.Subject)[:emailSize-4]
+	}
+
+	firstName := truncate(profile.FirstName, nameSize)
+	lastName := truncate(profile.LastName, nameSize)
+	user := ssoUser{
+		Username:    profile.Username,
+		GName:       firstName,
+		Surname:     lastName,
+		FirstName:   firstName,
+		LastName:    lastName,
+		Email:       email,
+		Affiliation: truncate(profile.Affiliation, affiliationSize),
+		UserType:    truncate(profile.UserType, roleSize),
+		Level:       truncate(profile.Level, roleSize),
+	}
+	if err := tx.Table("users").Create(&user).Error; err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/dbtest"
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/alertsMIS/backend/internal/oidc"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newTestProvider starts an identity provider that signs in every code as
+// the same account, and returns a Provider for it
+func newTestProvider(t *testing.T) *oidc.Provider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var server *httptest.Server
+	var mu sync.Mutex
+	nonces := make(map[string]string)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key-1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	// The authorization endpoint is called directly by the test, with the
+	// code to sign in as a query parameter
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		nonces[r.URL.Query().Get("code")] = r.URL.Query().Get("nonce")
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		nonce, ok := nonces[r.PostFormValue("code")]
+		mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		now := time.Now()
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":   server.URL,
+			"sub":   "user-1",
+			"aud":   "alertsmis",
+			"iat":   now.Unix(),
+			"exp":   now.Add(5 * time.Minute).Unix(),
+			"nonce": nonce,
+		})
+		token.Header["kid"] = "key-1"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return oidc.NewProvider(server.URL, "alertsmis", "", "https://alerts.example/callback", nil)
+}
+
+// authorize signs in at the identity provider started at authURL, which
+// redirects back with code
+func authorize(t *testing.T, authURL, code string) (state string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	query.Set("code", code)
+	u.RawQuery = query.Encode()
+	resp, err := http.Get(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return query.Get("state")
+}
+
+// ssoStore holds a sign-in in place of the sso_logins table, and a user
+// already linked to the provider account
+type ssoStore struct {
+	t     *testing.T
+	login *models.SSOLogin
+	// staleReads answers the lookup of a state as if the sign-in had not
+	// been completed yet, as a concurrent callback may see it
+	staleReads bool
+}
+
+func (s *ssoStore) handle(_ *dbtest.Conn, query string, args []driver.NamedValue) (dbtest.Result, error) {
+	loginRow := func() dbtest.Result {
+		l := s.login
+		var userID, codeHash driver.Value
+		if l.UserID != nil {
+			userID = int64(*l.UserID)
+		}
+		if l.LoginCodeHash != nil {
+			codeHash = *l.LoginCodeHash
+		}
+		return dbtest.Result{
+			Columns: []string{"id", "state_hash", "nonce", "code_verifier", "user_id", "login_code_hash", "expires_at", "created_at"},
+			Rows:    [][]driver.Value{{int64(l.ID), l.StateHash, l.Nonce, l.CodeVerifier, userID, codeHash, l.ExpiresAt, l.CreatedAt}},
+		}
+	}
+	switch {
+	case strings.HasPrefix(query, "DELETE FROM `sso_logins` WHERE expires_at < ?"):
+		return dbtest.Result{}, nil
+	case strings.HasPrefix(query, "INSERT INTO `sso_logins`"):
+		values := dbtest.InsertValues(query, args)
+		s.login = &models.SSOLogin{
+			ID:           1,
+			StateHash:    values["state_hash"].(string),
+			Nonce:        values["nonce"].(string),
+			CodeVerifier: values["code_verifier"].(string),
+			ExpiresAt:    values["expires_at"].(time.Time),
+		}
+		return dbtest.Result{RowsAffected: 1, LastInsertID: 1}, nil
+	case strings.HasPrefix(query, "SELECT * FROM `sso_logins` WHERE state_hash = ? AND user_id IS NULL"):
+		if s.login == nil || args[0].Value != s.login.StateHash || (s.login.UserID != nil && !s.staleReads) {
+			return dbtest.Result{}, nil
+		}
+		return loginRow(), nil
+	case strings.HasPrefix(query, "SELECT * FROM `sso_logins` WHERE login_code_hash = ?"):
+		if s.login == nil || s.login.LoginCodeHash == nil || args[0].Value != *s.login.LoginCodeHash {
+			return dbtest.Result{}, nil
+		}
+		return loginRow(), nil
+	case strings.HasPrefix(query, "SELECT * FROM `user_identities` WHERE issuer = ? AND subject = ?"):
+		return dbtest.Result{
+			Columns: []string{"id", "user_id", "issuer", "subject", "email", "created_at", "last_login_at"},
+			Rows:    [][]driver.Value{{int64(1), int64(testUserID), args[0].Value, args[1].Value, "", time.Now(), time.Now()}},
+		}, nil
+	case strings.HasPrefix(query, "SELECT count(*) FROM `users` WHERE id = ?"):
+		return dbtest.Result{Columns: []string{"count(*)"}, Rows: [][]driver.Value{{int64(1)}}}, nil
+	case strings.HasPrefix(query, "UPDATE `user_identities` SET"):
+		return dbtest.Result{RowsAffected: 1}, nil
+	case strings.HasPrefix(query, "UPDATE `sso_logins` SET") && strings.Contains(query, "user_id IS NULL"):
+		if s.login == nil || s.login.UserID != nil {
+			return dbtest.Result{}, nil
+		}
+		values := dbtest.UpdateValues(query, args)
+		userID := uint(values["user_id"].(int64))
+		codeHash := values["login_code_hash"].(string)
+		s.login.UserID = &userID
+		s.login.LoginCodeHash = &codeHash
+		return dbtest.Result{RowsAffected: 1}, nil
+	case strings.HasPrefix(query, "DELETE FROM `sso_logins` WHERE `sso_logins`.`id` = ?"):
+		if s.login == nil {
+			return dbtest.Result{}, nil
+		}
+		s.login = nil
+		return dbtest.Result{RowsAffected: 1}, nil
+	}
+	s.t.Errorf("unexpected statement: %s", query)
+	return dbtest.Result{}, errors.New("unexpected statement")
+}
+
+func TestCompleteRejectsReusedState(t *testing.T) {
+	tests := []struct {
+		name       string
+		staleReads bool
+	}{
+		{"after the sign-in", false},
+		{"during the sign-in", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := &ssoStore{t: t}
+			sso := NewSSO(dbtest.Open(t, store.handle), newTestProvider(t), oidc.Mapping{}, false, time.Minute)
+
+			authURL, _, err := sso.Begin(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			state := authorize(t, authURL, "code-1")
+			authorize(t, authURL, "code-2")
+
+			loginCode, err := sso.Complete(ctx, state, "code-1")
+			if err != nil {
+				t.Fatalf("first callback: %v", err)
+			}
+			store.staleReads = tt.staleReads
+			if _, err := sso.Complete(ctx, state, "code-2"); err != ErrInvalidSSOState {
+				t.Errorf("second callback: err = %v, want %v", err, ErrInvalidSSOState)
+			}
+
+			userID, err := sso.Redeem(loginCode)
+			if err != nil || userID != testUserID {
+				t.Fatalf("redeem: user %d, err = %v, want user %d", userID, err, testUserID)
+			}
+			if _, err := sso.Redeem(loginCode); err != ErrInvalidLoginCode {
+				t.Errorf("redeem again: err = %v, want %v", err, ErrInvalidLoginCode)
+			}
+		})
+	}
+}
+
+func TestCompleteRejectsUnknownState(t *testing.T) {
+	ctx := context.Background()
+	store := &ssoStore{t: t}
+	sso := NewSSO(dbtest.Open(t, store.handle), newTestProvider(t), oidc.Mapping{}, false, time.Minute)
+
+	authURL, _, err := sso.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorize(t, authURL, "code-1")
+	if _, err := sso.Complete(ctx, "forged-state", "code-1"); err != ErrInvalidSSOState {
+		t.Errorf("err = %v, want %v", err, ErrInvalidSSOState)
+	}
+}
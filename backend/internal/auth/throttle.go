@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Scopes of the login failure counters
+const (
+	throttleUsername = "username"
+	throttleIP       = "ip"
+)
+
+// The first failures of a counter are free; after them each further attempt
+// must wait twice as long as the previous one, up to throttleMaxDelay
+const (
+	throttleFreeFailures = 2
+	throttleBaseDelay    = time.Second
+	throttleMaxDelay     = 30 * time.Second
+)
+
+// LoginThrottle slows down password guessing. Failed logins are counted per
+// username and per IP address; after a few failures each attempt must wait
+// longer than the one before, and reaching the limit of a counter locks it
+// out for the lockout period. Counters forget failures older than that.
+type LoginThrottle struct {
+	db          *gorm.DB
+	maxUsername int
+	maxIP       int
+	lockout     time.Duration
+}
+
+// NewLoginThrottle creates a LoginThrottle locking a username out after
+// maxUsername failures and an IP address after maxIP failures
+func NewLoginThrottle(db *gorm.DB, maxUsername, maxIP int, lockout time.Duration) *LoginThrottle {
+	return &LoginThrottle{db: db, maxUsername: maxUsername, maxIP: maxIP, lockout: lockout}
+}
+
+// usernameKey normalises a username as the database compares them
+func usernameKey(username string) string {
+	return truncate(strings.ToLower(strings.TrimSpace(username)), 150)
+}
+
+// throttleDelay returns how long to wait after a number of failures
+func throttleDelay(failures int) time.Duration {
+	if failures <= throttleFreeFailures {
+		return 0
+	}
+	delay := throttleBaseDelay
+	for i := throttleFreeFailures + 1; i < failures && delay < throttleMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > throttleMaxDelay {
+		delay = throttleMaxDelay
+	}
+	return delay
+}
+
+// wait returns how long a counter makes the next attempt wait
+func (t *LoginThrottle) wait(counter models.LoginThrottle, now time.Time) time.Duration {
+	if counter.LockedUntil != nil && now.Before(*counter.LockedUntil) {
+		return counter.LockedUntil.Sub(now)
+	}
+	if counter.LastFailedAt.Before(now.Add(-t.lockout)) {
+		return 0
+	}
+	if next := counter.LastFailedAt.Add(throttleDelay(counter.Failures)); now.Before(next) {
+		return next.Sub(now)
+	}
+	return 0
+}
+
+// Check returns how long a login for username from ip must wait, zero when
+// it may go ahead
+func (t *LoginThrottle) Check(username, ip string) (time.Duration, error) {
+	var counters []models.LoginThrottle
+	err := t.db.Where("(scope = ? AND throttle_key = ?) OR (scope = ? AND throttle_key = ?)",
+		throttleUsername, usernameKey(username), throttleIP, ip).
+		Find(&counters).Error
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	var wait time.Duration
+	for _, counter := range counters {
+		if w := t.wait(counter, now); w > wait {
+			wait = w
+		}
+	}
+	return wait, nil
+}
+
+// Failure counts a failed login for username from ip. It returns the recent
+// failures for the username and whether this one locked the username out.
+func (t *LoginThrottle) Failure(username, ip string) (failures int, locked bool, err error) {
+	now := time.Now()
+	since := now.Add(-t.lockout)
+	// Counters with nothing left to remember are of no further use
+	err = t.db.Where("last_failed_at < ? AND (locked_until IS NULL OR locked_until < ?)", since, now).
+		Delete(&models.LoginThrottle{}).Error
+	if err != nil {
+		return 0, false, err
+	}
+
+	for _, counter := range []struct {
+		scope, key string
+		max        int
+	}{
+		{throttleUsername, usernameKey(username), t.maxUsername},
+		{throttleIP, ip, t.maxIP},
+	} {
+		n, err := t.count(counter.scope, counter.key, now, since)
+		if err != nil {
+			return 0, false, err
+		}
+		reached := n >= counter.max
+		if reached {
+			err := t.db.Model(&models.LoginThrottle{}).
+				Where("scope = ? AND throttle_key = ?", counter.scope, counter.key).
+				Update("locked_until", now.Add(t.lockout)).Error
+			if err != nil {
+				return 0, false, err
+			}
+		}
+		if counter.scope == throttleUsername {
+			failures, locked = n, reached
+		}
+	}
+	return failures, locked, nil
+}
+
+// count adds a failure to a counter and returns its recent failures. A
+// counter whose failures are forgotten or whose lockout has passed starts
+// again from one.
+func (t *LoginThrottle) count(scope, key string, now, since time.Time) (int, error) {
+	// MySQL assigns in order, so failures is computed from the previous
+	// failure time and lockout
+	err := t.db.Clauses(clause.OnConflict{
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "failures"}, Value: gorm.Expr("IF(last_failed_at < ? OR locked_until IS NOT NULL, 1, failures + 1)", since)},
+			{Column: clause.Column{Name: "last_failed_at"}, Value: now},
+			{Column: clause.Column{Name: "locked_until"}, Value: nil},
+		},
+	}).Create(&models.LoginThrottle{
+		Scope:        scope,
+		Key:          key,
+		Failures:     1,
+		LastFailedAt: now,
+	}).Error
+	if err != nil {
+		return 0, err
+	}
+	var counter models.LoginThrottle
+	err = t.db.Select("failures").Where("scope = ? AND throttle_key = ?", scope, key).First(&counter).Error
+	return counter.Failures, err
+}
+
+// Success forgets the failures of a username once its user has signed in.
+// Those of the IP address are kept, so that signing in to one account does
+// not make room for guessing the passwords of others.
+func (t *LoginThrottle) Success(username string) error {
+	return t.db.Where("scope = ? AND throttle_key = ?", throttleUsername, usernameKey(username)).
+		Delete(&models.LoginThrottle{}).Error
+}
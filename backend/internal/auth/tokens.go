@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Errors returned when a refresh token cannot be used
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// Pair is a signed access token and the refresh token that renews it
+type Pair struct {
+	AccessToken  string
+	RefreshToken string
+	// ExpiresIn is the lifetime of the access token in seconds
+	ExpiresIn int64
+}
+
+// Issuer signs short-lived access tokens and rotates the refresh tokens
+// that renew them
+type Issuer struct {
+	db         *gorm.DB
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+// NewIssuer creates an Issuer signing access tokens with secret. Access
+// tokens last accessTTL and refresh tokens refreshTTL from their issue.
+func NewIssuer(db *gorm.DB, secret string, accessTTL, refreshTTL time.Duration) *Issuer {
+	return &Issuer{db: db, secret: []byte(secret), accessTTL: accessTTL, refreshTTL: refreshTTL}
+}
+
+// randomToken returns n random bytes, URL-safe encoded
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// randomID returns a random 128-bit identifier in hex
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashToken returns the hex SHA-256 of a token, as stored in the database
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Login starts a new refresh token family, and the session that follows it,
+// for a user who has just authenticated from client
+func (i *Issuer) Login(userID uint, username string, client Client) (Pair, error) {
+	family, err := randomID()
+	if err != nil {
+		return Pair{}, err
+	}
+	now := time.Now()
+	// Expired tokens are of no further use, even to detect reuse, and
+	// expired sessions can no longer be resumed
+	if err := i.db.Where("expires_at < ?", now).Delete(&models.RefreshToken{}).Error; err != nil {
+		return Pair{}, err
+	}
+	if err := i.db.Where("expires_at < ?", now).Delete(&models.Session{}).Error; err != nil {
+		return Pair{}, err
+	}
+	var refresh string
+	err = i.db.Transaction(func(tx *gorm.DB) error {
+		stored, plain, err := i.createRefreshToken(tx, userID, family)
+		if err != nil {
+			return err
+		}
+		refresh = plain
+		return createSession(tx, userID, family, client, stored.ExpiresAt)
+	})
+	if err != nil {
+		return Pair{}, err
+	}
+	return i.pair(userID, username, family, refresh)
+}
+
+// Refresh uses up a refresh token and returns a new access token with the
+// next refresh token of the family. Presenting a token that was already used
+// revokes the family, so that neither the thief nor the user can go on
+// refreshing with it, and returns ErrRefreshTokenReused. The session of the
+// family is recorded as last seen from client.
+func (i *Issuer) Refresh(token string, client Client) (Pair, error) {
+	var pair Pair
+	reused := false
+	err := i.db.Transaction(func(tx *gorm.DB) error {
+		var current models.RefreshToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", HashToken(token)).
+			First(&current).Error
+		if err == gorm.ErrRecordNotFound {
+			return ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if current.RevokedAt != nil || !now.Before(current.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+		if current.UsedAt != nil {
+			reused = true
+			return RevokeFamily(tx, current.FamilyID)
+		}
+
+		var user models.User
+		if err := tx.Select("id", "username").First(&user, current.UserID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+
+		next, refresh, err := i.createRefreshToken(tx, user.ID, current.FamilyID)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&current).Updates(map[string]interface{}{
+			"used_at":        now,
+			"replaced_by_id": next.ID,
+		}).Error
+		if err != nil {
+			return err
+		}
+		updates := client.sessionUpdates(now)
+		updates["expires_at"] = next.ExpiresAt
+		err = tx.Model(&models.Session{}).Where("family_id = ?", current.FamilyID).Updates(updates).Error
+		if err != nil {
+			return err
+		}
+		pair, err = i.pair(user.ID, user.Username, current.FamilyID, refresh)
+		return err
+	})
+	if reused {
+		return Pair{}, ErrRefreshTokenReused
+	}
+	return pair, err
+}
+
+// RevokeFamily revokes the refresh tokens of a family that are still
+// unrevoked and ends its session
+func RevokeFamily(tx *gorm.DB, familyID string) error {
+	err := tx.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	return endSessions(tx, "family_id = ?", familyID)
+}
+
+// createRefreshToken stores a new refresh token of a family and returns it
+// with its plain value
+func (i *Issuer) createRefreshToken(tx *gorm.DB, userID uint, familyID string) (models.RefreshToken, string, error) {
+	refresh, err := randomToken(32)
+	if err != nil {
+		return models.RefreshToken{}, "", err
+	}
+	stored := models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: HashToken(refresh),
+		ExpiresAt: time.Now().Add(i.refreshTTL),
+	}
+	if err := tx.Create(&stored).Error; err != nil {
+		return models.RefreshToken{}, "", err
+	}
+	return stored, refresh, nil
+}
+
+// pair signs an access token to go with a refresh token. The token carries
+// a unique jti so that it can be revoked, and the refresh token family so
+// that logging out can end the family.
+func (i *Issuer) pair(userID uint, username, familyID, refresh string) (Pair, error) {
+	jti, err := randomID()
+	if err != nil {
+		return Pair{}, err
+	}
+	now := time.Now()
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["jti"] = jti
+	claims["fid"] = familyID
+	claims["user_id"] = userID
+	claims["username"] = username
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(i.accessTTL).Unix()
+
+	access, err := token.SignedString(i.secret)
+	if err != nil {
+		return Pair{}, err
+	}
+	return Pair{AccessToken: access, RefreshToken: refresh, ExpiresIn: int64(i.accessTTL / time.Second)}, nil
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 key of the test vectors of RFC 6238
+var rfc6238Secret = []byte("12345678901234567890")
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// The RFC gives 8 digit codes; ours are their last 6 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		step := totpStep(time.Unix(tt.unix, 0))
+		if got := totpCode(rfc6238Secret, step); got != tt.code {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestTOTPMatchWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := totpStep(now)
+	tests := []struct {
+		step int64
+		ok   bool
+	}{
+		{current - 2, false},
+		{current - 1, true},
+		{current, true},
+		{current + 1, true},
+		{current + 2, false},
+	}
+	for _, tt := range tests {
+		step, ok := totpMatch(rfc6238Secret, totpCode(rfc6238Secret, tt.step), now)
+		if ok != tt.ok {
+			t.Errorf("code of step %+d: matched = %v, want %v", tt.step-current, ok, tt.ok)
+			continue
+		}
+		if ok && step != tt.step {
+			t.Errorf("code of step %+d: matched step %d, want %d", tt.step-current, step, tt.step)
+		}
+	}
+}
+
+func TestIsTOTPCode(t *testing.T) {
+	tests := map[string]bool{
+		"050471":     true,
+		"05047":      false,
+		"0504711":    false,
+		"05047a":     false,
+		"abcdefghjk": false,
+	}
+	for code, want := range tests {
+		if got := isTOTPCode(code); got != want {
+			t.Errorf("isTOTPCode(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestOTPAuthURI(t *testing.T) {
+	uri := otpauthURI("Alerts MIS", "jane doe", rfc6238Secret)
+	want := "otpauth://totp/Alerts%20MIS:jane%20doe?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&issuer=Alerts%20MIS"
+	if !strings.HasPrefix(uri, want) {
+		t.Errorf("uri = %s, want prefix %s", uri, want)
+	}
+	if !strings.HasSuffix(uri, "&algorithm=SHA1&digits=6&period=30") {
+		t.Errorf("uri = %s, want SHA1, 6 digits and 30 seconds", uri)
+	}
+}
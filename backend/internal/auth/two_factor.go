@@ -0,0 +1,370 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/alertsMIS/backend/internal/qrcode"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Errors returned by two-factor authentication
+var (
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled = errors.New("two-factor authentication is not enrolled")
+	ErrInvalidCode          = errors.New("invalid two-factor code")
+	ErrInvalidChallenge     = errors.New("invalid login challenge")
+)
+
+// Purposes of login challenges
+const (
+	ChallengeVerify = "verify"
+	ChallengeEnrol  = "enrol"
+)
+
+const (
+	// challengeMaxAttempts is the number of wrong codes after which a login
+	// challenge is dropped and the user must sign in again
+	challengeMaxAttempts = 5
+	recoveryCodeCount    = 10
+	// recoveryCodeAlphabet leaves out letters and digits easily confused
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// Enrolment is a TOTP secret waiting to be confirmed, in the forms
+// authenticator apps accept
+type Enrolment struct {
+	Secret string
+	URI    string
+	// QRCode is a PNG of the URI
+	QRCode []byte
+}
+
+// TwoFactor manages TOTP authenticators, their recovery codes and the
+// second step of logins
+type TwoFactor struct {
+	db            *gorm.DB
+	aead          cipher.AEAD
+	issuer        string
+	requiredRoles []string
+	challengeTTL  time.Duration
+}
+
+// NewTwoFactor creates a TwoFactor encrypting secrets with a key derived from
+// key. Authenticator apps list accounts under issuer, and users holding one of
+// requiredRoles must enrol before they can sign in.
+func NewTwoFactor(db *gorm.DB, key, issuer string, requiredRoles []string, challengeTTL time.Duration) (*TwoFactor, error) {
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &TwoFactor{db: db, aead: aead, issuer: issuer, requiredRoles: requiredRoles, challengeTTL: challengeTTL}, nil
+}
+
+// Required reports whether the role of a user requires two-factor
+// authentication
+func (t *TwoFactor) Required(user models.User) bool {
+	return len(t.requiredRoles) > 0 && user.HasRole(t.requiredRoles...)
+}
+
+// encrypt seals a secret for storage
+func (t *TwoFactor) encrypt(secret []byte) (string, error) {
+	nonce := make([]byte, t.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(t.aead.Seal(nonce, nonce, secret, nil)), nil
+}
+
+// decrypt opens a stored secret
+func (t *TwoFactor) decrypt(stored string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(stored)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < t.aead.NonceSize() {
+		return nil, errors.New("stored TOTP secret is too short")
+	}
+	nonce := sealed[:t.aead.NonceSize()]
+	return t.aead.Open(nil, nonce, sealed[len(nonce):], nil)
+}
+
+// authenticator returns the TOTP authenticator of a user, or nil when the
+// user has none
+func (t *TwoFactor) authenticator(tx *gorm.DB, userID uint) (*models.UserTOTP, error) {
+	var totp models.UserTOTP
+	err := tx.Where("user_id = ?", userID).First(&totp).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &totp, nil
+}
+
+// Status returns whether a user has confirmed a TOTP authenticator and how
+// many unused recovery codes the user has left
+func (t *TwoFactor) Status(userID uint) (enabled bool, recoveryCodesLeft int64, err error) {
+	totp, err := t.authenticator(t.db, userID)
+	if err != nil || totp == nil || totp.ConfirmedAt == nil {
+		return false, 0, err
+	}
+	err = t.db.Model(&models.TOTPRecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&recoveryCodesLeft).Error
+	return true, recoveryCodesLeft, err
+}
+
+// Enroll creates a new TOTP secret for a user, replacing any unconfirmed
+// one. The secret takes effect once confirmed with a code.
+func (t *TwoFactor) Enroll(userID uint, username string) (Enrolment, error) {
+	totp, err := t.authenticator(t.db, userID)
+	if err != nil {
+		return Enrolment{}, err
+	}
+	if totp != nil && totp.ConfirmedAt != nil {
+		return Enrolment{}, ErrTwoFactorEnabled
+	}
+
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return Enrolment{}, err
+	}
+	stored, err := t.encrypt(secret)
+	if err != nil {
+		return Enrolment{}, err
+	}
+	err = t.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&models.UserTOTP{
+		UserID: userID,
+		Secret: stored,
+	}).Error
+	if err != nil {
+		return Enrolment{}, err
+	}
+
+	uri := otpauthURI(t.issuer, username, secret)
+	code, err := qrcode.Encode([]byte(uri))
+	if err != nil {
+		return Enrolment{}, err
+	}
+	png, err := code.PNG(6)
+	if err != nil {
+		return Enrolment{}, err
+	}
+	return Enrolment{Secret: totpEncoding.EncodeToString(secret), URI: uri, QRCode: png}, nil
+}
+
+// Confirm checks a code of a user's unconfirmed secret and enables it,
+// returning the user's recovery codes
+func (t *TwoFactor) Confirm(userID uint, code string) ([]string, error) {
+	var codes []string
+	err := t.db.Transaction(func(tx *gorm.DB) error {
+		var totp models.UserTOTP
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&totp).Error
+		if err == gorm.ErrRecordNotFound {
+			return ErrTwoFactorNotEnrolled
+		}
+		if err != nil {
+			return err
+		}
+		if totp.ConfirmedAt != nil {
+			return ErrTwoFactorEnabled
+		}
+		secret, err := t.decrypt(totp.Secret)
+		if err != nil {
+			return err
+		}
+		step, ok := totpMatch(secret, normaliseCode(code), time.Now())
+		if !ok {
+			return ErrInvalidCode
+		}
+		err = tx.Model(&totp).Updates(map[string]interface{}{
+			"confirmed_at":   time.Now(),
+			"last_used_step": step,
+		}).Error
+		if err != nil {
+			return err
+		}
+		codes, err = createRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// Verify checks a TOTP code or an unused recovery code of a user whose
+// authenticator is confirmed. Each code is accepted once. It reports whether
+// a recovery code was used.
+func (t *TwoFactor) Verify(userID uint, code string) (recovery bool, err error) {
+	code = normaliseCode(code)
+	if !isTOTPCode(code) {
+		result := t.db.Model(&models.TOTPRecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, HashToken(code)).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return false, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return false, ErrInvalidCode
+		}
+		return true, nil
+	}
+
+	totp, err := t.authenticator(t.db, userID)
+	if err != nil {
+		return false, err
+	}
+	if totp == nil || totp.ConfirmedAt == nil {
+		return false, ErrTwoFactorNotEnrolled
+	}
+	secret, err := t.decrypt(totp.Secret)
+	if err != nil {
+		return false, err
+	}
+	step, ok := totpMatch(secret, code, time.Now())
+	if !ok {
+		return false, ErrInvalidCode
+	}
+	// A code whose step is not newer than the last accepted one has been
+	// used already
+	result := t.db.Model(&models.UserTOTP{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, ErrInvalidCode
+	}
+	return false, nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user
+func (t *TwoFactor) RegenerateRecoveryCodes(userID uint) ([]string, error) {
+	var codes []string
+	err := t.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = createRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// Disable removes the authenticator and recovery codes of a user
+func (t *TwoFactor) Disable(userID uint) error {
+	return t.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.TOTPRecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.UserTOTP{}).Error
+	})
+}
+
+// normaliseCode drops the spaces and dashes users type in codes
+func normaliseCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// createRecoveryCodes replaces the recovery codes of a user and returns the
+// new ones, formatted as xxxxx-xxxxx
+func createRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.TOTPRecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	stored := make([]models.TOTPRecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		code, err := randomCode(10)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code[:5] + "-" + code[5:]
+		stored[i] = models.TOTPRecoveryCode{UserID: userID, CodeHash: HashToken(code)}
+	}
+	if err := tx.Create(&stored).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// randomCode returns n random characters of the recovery code alphabet
+func randomCode(n int) (string, error) {
+	// Bytes beyond the last whole multiple of the alphabet are skipped, so
+	// that every character is as likely
+	limit := 256 - 256%len(recoveryCodeAlphabet)
+	code := make([]byte, 0, n)
+	b := make([]byte, 1)
+	for len(code) < n {
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		if int(b[0]) < limit {
+			code = append(code, recoveryCodeAlphabet[int(b[0])%len(recoveryCodeAlphabet)])
+		}
+	}
+	return string(code), nil
+}
+
+// NewChallenge starts the second step of a login whose password was checked
+// and returns its token
+func (t *TwoFactor) NewChallenge(userID uint, purpose string) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	// Expired challenges are of no further use
+	if err := t.db.Where("expires_at < ?", now).Delete(&models.LoginChallenge{}).Error; err != nil {
+		return "", err
+	}
+	err = t.db.Create(&models.LoginChallenge{
+		UserID:    userID,
+		TokenHash: HashToken(token),
+		Purpose:   purpose,
+		ExpiresAt: now.Add(t.challengeTTL),
+	}).Error
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ChallengeTTL returns how long a login challenge lasts
+func (t *TwoFactor) ChallengeTTL() time.Duration {
+	return t.challengeTTL
+}
+
+// Challenge returns the unexpired login challenge of a token with a purpose
+func (t *TwoFactor) Challenge(token, purpose string) (models.LoginChallenge, error) {
+	var challenge models.LoginChallenge
+	err := t.db.Where("token_hash = ? AND purpose = ? AND expires_at > ? AND attempts < ?",
+		HashToken(token), purpose, time.Now(), challengeMaxAttempts).
+		First(&challenge).Error
+	if err == gorm.ErrRecordNotFound {
+		return challenge, ErrInvalidChallenge
+	}
+	return challenge, err
+}
+
+// ChallengeFailed counts a wrong code against a login challenge
+func (t *TwoFactor) ChallengeFailed(challenge models.LoginChallenge) error {
+	return t.db.Model(&challenge).Update("attempts", gorm.Expr("attempts + 1")).Error
+}
+
+// EndChallenge removes a login challenge once the login is complete
+func (t *TwoFactor) EndChallenge(challenge models.LoginChallenge) error {
+	return t.db.Delete(&challenge).Error
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/dbtest"
+	"github.com/alertsMIS/backend/internal/models"
+)
+
+const testUserID = 7
+
+// twoFactorStore holds the authenticator and recovery codes of a user in
+// place of the user_totp and totp_recovery_codes tables
+type twoFactorStore struct {
+	t        *testing.T
+	totp     models.UserTOTP
+	recovery map[string]bool
+}
+
+func (s *twoFactorStore) handle(_ *dbtest.Conn, query string, args []driver.NamedValue) (dbtest.Result, error) {
+	arg := func(i int) driver.Value {
+		if i < 0 {
+			i += len(args)
+		}
+		return args[i].Value
+	}
+	switch {
+	case strings.HasPrefix(query, "SELECT * FROM `user_totp` WHERE user_id = ?"):
+		if arg(0) != int64(s.totp.UserID) {
+			return dbtest.Result{}, nil
+		}
+		return dbtest.Result{
+			Columns: []string{"user_id", "secret", "last_used_step", "confirmed_at", "created_at", "updated_at"},
+			Rows: [][]driver.Value{{
+				int64(s.totp.UserID), s.totp.Secret, s.totp.LastUsedStep, *s.totp.ConfirmedAt, s.totp.CreatedAt, s.totp.UpdatedAt,
+			}},
+		}, nil
+	case strings.HasPrefix(query, "UPDATE `user_totp` SET `last_used_step`=?") &&
+		strings.HasSuffix(query, "WHERE user_id = ? AND last_used_step < ?"):
+		step := arg(-1).(int64)
+		if arg(-2) != int64(s.totp.UserID) || s.totp.LastUsedStep >= step {
+			return dbtest.Result{}, nil
+		}
+		s.totp.LastUsedStep = step
+		return dbtest.Result{RowsAffected: 1}, nil
+	case strings.HasPrefix(query, "UPDATE `totp_recovery_codes` SET `used_at`=?") &&
+		strings.HasSuffix(query, "WHERE user_id = ? AND code_hash = ? AND used_at IS NULL"):
+		hash := arg(-1).(string)
+		if used, ok := s.recovery[hash]; !ok || used || arg(-2) != int64(s.totp.UserID) {
+			return dbtest.Result{}, nil
+		}
+		s.recovery[hash] = true
+		return dbtest.Result{RowsAffected: 1}, nil
+	}
+	s.t.Errorf("unexpected statement: %s", query)
+	return dbtest.Result{}, errors.New("unexpected statement")
+}
+
+// newTestTwoFactor returns a TwoFactor for a user with a confirmed
+// authenticator of secret and the given recovery codes
+func newTestTwoFactor(t *testing.T, secret []byte, recoveryCodes ...string) *TwoFactor {
+	t.Helper()
+	store := &twoFactorStore{t: t, recovery: make(map[string]bool)}
+	tf, err := NewTwoFactor(dbtest.Open(t, store.handle), "test key", "alertsMIS", nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := tf.encrypt(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirmed := time.Now()
+	store.totp = models.UserTOTP{UserID: testUserID, Secret: stored, ConfirmedAt: &confirmed}
+	for _, code := range recoveryCodes {
+		store.recovery[HashToken(normaliseCode(code))] = false
+	}
+	return tf
+}
+
+func TestVerifyRejectsReplayedCode(t *testing.T) {
+	tf := newTestTwoFactor(t, rfc6238Secret)
+	step := totpStep(time.Now())
+
+	recovery, err := tf.Verify(testUserID, totpCode(rfc6238Secret, step))
+	if err != nil || recovery {
+		t.Fatalf("first use: recovery = %v, err = %v, want a TOTP code accepted", recovery, err)
+	}
+	if _, err := tf.Verify(testUserID, totpCode(rfc6238Secret, step)); err != ErrInvalidCode {
+		t.Errorf("same code again: err = %v, want %v", err, ErrInvalidCode)
+	}
+	if _, err := tf.Verify(testUserID, totpCode(rfc6238Secret, step-1)); err != ErrInvalidCode {
+		t.Errorf("code of an earlier step: err = %v, want %v", err, ErrInvalidCode)
+	}
+	if _, err := tf.Verify(testUserID, totpCode(rfc6238Secret, step+1)); err != nil {
+		t.Errorf("code of the next step: err = %v, want it accepted", err)
+	}
+	if _, err := tf.Verify(testUserID, totpCode(rfc6238Secret, step+3)); err != ErrInvalidCode {
+		t.Errorf("code outside the window: err = %v, want %v", err, ErrInvalidCode)
+	}
+}
+
+func TestVerifyRecoveryCodeOnce(t *testing.T) {
+	tf := newTestTwoFactor(t, rfc6238Secret, "abcde-fghjk", "mnpqr-stuvw")
+
+	// Codes are accepted as typed, with spaces or in capitals
+	recovery, err := tf.Verify(testUserID, "ABCDE FGHJK")
+	if err != nil || !recovery {
+		t.Fatalf("first use: recovery = %v, err = %v, want a recovery code accepted", recovery, err)
+	}
+	if _, err := tf.Verify(testUserID, "abcde-fghjk"); err != ErrInvalidCode {
+		t.Errorf("same code again: err = %v, want %v", err, ErrInvalidCode)
+	}
+	if _, err := tf.Verify(testUserID, "xxxxx-xxxxx"); err != ErrInvalidCode {
+		t.Errorf("unknown code: err = %v, want %v", err, ErrInvalidCode)
+	}
+	if recovery, err := tf.Verify(testUserID, "mnpqr-stuvw"); err != nil || !recovery {
+		t.Errorf("other code: recovery = %v, err = %v, want it accepted", recovery, err)
+	}
+}
+
+func TestRandomCode(t *testing.T) {
+	code, err := randomCode(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 10 || strings.Trim(code, recoveryCodeAlphabet) != "" {
+		t.Errorf("code %q is not 10 characters of the alphabet", code)
+	}
+}
@@ -0,0 +1,104 @@
+package clustering
+
+import (
+	"math"
+	"time"
+)
+
+// earthRadiusKm is the mean radius of the Earth
+const earthRadiusKm = 6371.0
+
+// Point is an alert located in space and time
+type Point struct {
+	ID        uint
+	Latitude  float64
+	Longitude float64
+	Time      time.Time
+}
+
+// Params controls when points are close enough to be clustered
+type Params struct {
+	// RadiusKm is the largest distance between neighbouring points
+	RadiusKm float64
+	// Window is the largest time between neighbouring points
+	Window time.Duration
+	// MinPoints is the number of points, itself included, a point needs
+	// within reach to start or grow a cluster
+	MinPoints int
+}
+
+// DistanceKm returns the great-circle distance between two coordinates
+func DistanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// neighbours returns the indexes of the points within reach of point i in
+// both space and time, i included
+func neighbours(points []Point, i int, params Params) []int {
+	var result []int
+	for j, p := range points {
+		dt := p.Time.Sub(points[i].Time)
+		if dt < 0 {
+			dt = -dt
+		}
+		if dt > params.Window {
+			continue
+		}
+		if DistanceKm(points[i].Latitude, points[i].Longitude, p.Latitude, p.Longitude) <= params.RadiusKm {
+			result = append(result, j)
+		}
+	}
+	return result
+}
+
+// DBSCAN groups points that are dense in space and time. Points that belong
+// to no cluster are left out of the result.
+func DBSCAN(points []Point, params Params) [][]Point {
+	const (
+		unvisited = 0
+		noise     = -1
+	)
+	labels := make([]int, len(points))
+	cluster := 0
+
+	for i := range points {
+		if labels[i] != unvisited {
+			continue
+		}
+		seeds := neighbours(points, i, params)
+		if len(seeds) < params.MinPoints {
+			labels[i] = noise
+			continue
+		}
+
+		cluster++
+		labels[i] = cluster
+		for k := 0; k < len(seeds); k++ {
+			j := seeds[k]
+			if labels[j] == noise {
+				// Border point: reachable but not dense itself
+				labels[j] = cluster
+			}
+			if labels[j] != unvisited {
+				continue
+			}
+			labels[j] = cluster
+			if reach := neighbours(points, j, params); len(reach) >= params.MinPoints {
+				seeds = append(seeds, reach...)
+			}
+		}
+	}
+
+	clusters := make([][]Point, cluster)
+	for i, label := range labels {
+		if label > 0 {
+			clusters[label-1] = append(clusters[label-1], points[i])
+		}
+	}
+	return clusters
+}
@@ -0,0 +1,245 @@
+package clustering
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/alertsMIS/backend/internal/risk"
+	"gorm.io/gorm"
+)
+
+// running serialises runs so scheduled and on-demand detection cannot
+// store the same cluster twice
+var running sync.Mutex
+
+// Config controls the clustering job
+type Config struct {
+	Params
+	// Lookback is how far back alerts are considered on each run
+	Lookback time.Duration
+}
+
+// Result summarises a clustering run
+type Result struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Closed  int `json:"closed"`
+}
+
+// Start runs Detect every interval until the program exits
+func Start(db *gorm.DB, cfg Config, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if result, err := Detect(db, cfg, time.Now()); err != nil {
+				log.Printf("Cluster detection failed: %v", err)
+			} else if result.Created > 0 || result.Closed > 0 {
+				log.Printf("Cluster detection: %d created, %d updated, %d closed", result.Created, result.Updated, result.Closed)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Detect clusters the recent alerts of each syndrome and stores the result.
+// A detected cluster that shares alerts with a stored one updates it, so
+// clusters keep their IDs as they grow; stored clusters no longer detected
+// are closed. Cluster membership counts towards an alert's risk score, so
+// the scores of the alerts involved are refreshed.
+func Detect(db *gorm.DB, cfg Config, now time.Time) (Result, error) {
+	running.Lock()
+	defer running.Unlock()
+
+	var result Result
+	since := now.Add(-cfg.Lookback)
+
+	var alerts []models.Alert
+	err := db.Select("id", "latitude", "longitude", "syndromes", "date", "created_at").
+		// Alerts placed at the centroid of their village, subcounty or
+		// district would pile up on one point and form clusters of their own
+		Where("latitude IS NOT NULL AND longitude IS NOT NULL AND location_source = ?", "gps").
+		Where("syndromes IS NOT NULL AND syndromes <> ''").
+		Where("classification IS NULL OR classification <> ?", models.ClassificationNotACase).
+		Where("COALESCE(date, created_at) >= ?", since).
+		Find(&alerts).Error
+	if err != nil {
+		return result, err
+	}
+
+	points := make(map[string][]Point)
+	for _, alert := range alerts {
+		at := alert.CreatedAt
+		if alert.Date != nil {
+			at = *alert.Date
+		}
+		for _, syndrome := range strings.Split(*alert.Syndromes, ",") {
+			if syndrome = strings.TrimSpace(syndrome); syndrome != "" {
+				points[syndrome] = append(points[syndrome], Point{
+					ID:        alert.ID,
+					Latitude:  *alert.Latitude,
+					Longitude: *alert.Longitude,
+					Time:      at,
+				})
+			}
+		}
+	}
+
+	var stored []models.Cluster
+	err = db.Preload("Members").
+		Where("status = ? OR end_date >= ?", models.ClusterStatusActive, since).
+		Find(&stored).Error
+	if err != nil {
+		return result, err
+	}
+	storedBySyndrome := make(map[string][]models.Cluster)
+	for _, cluster := range stored {
+		storedBySyndrome[cluster.Syndrome] = append(storedBySyndrome[cluster.Syndrome], cluster)
+		if _, ok := points[cluster.Syndrome]; !ok {
+			points[cluster.Syndrome] = nil
+		}
+	}
+
+	var affected []uint
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for syndrome, syndromePoints := range points {
+			existing := storedBySyndrome[syndrome]
+			matched := make(map[uint]bool)
+
+			for _, group := range DBSCAN(syndromePoints, cfg.Params) {
+				cluster, found := bestMatch(existing, group, matched)
+				if found {
+					matched[cluster.ID] = true
+					result.Updated++
+				} else {
+					cluster = models.Cluster{Syndrome: syndrome}
+					result.Created++
+				}
+				summarise(&cluster, group, now, cfg.Window)
+				for _, member := range cluster.Members {
+					affected = append(affected, member.AlertID)
+				}
+				for _, p := range group {
+					affected = append(affected, p.ID)
+				}
+				if err := saveCluster(tx, &cluster, group); err != nil {
+					return err
+				}
+			}
+
+			for _, cluster := range existing {
+				if matched[cluster.ID] || cluster.Status != models.ClusterStatusActive {
+					continue
+				}
+				if err := tx.Model(&cluster).Update("status", models.ClusterStatusClosed).Error; err != nil {
+					return err
+				}
+				for _, member := range cluster.Members {
+					affected = append(affected, member.AlertID)
+				}
+				result.Closed++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	return result, risk.RefreshAlerts(db, affected, now)
+}
+
+// bestMatch returns the unmatched stored cluster sharing most alerts with group
+func bestMatch(existing []models.Cluster, group []Point, matched map[uint]bool) (models.Cluster, bool) {
+	ids := make(map[uint]bool, len(group))
+	for _, p := range group {
+		ids[p.ID] = true
+	}
+
+	var best models.Cluster
+	bestOverlap := 0
+	for _, cluster := range existing {
+		if matched[cluster.ID] {
+			continue
+		}
+		overlap := 0
+		for _, member := range cluster.Members {
+			if ids[member.AlertID] {
+				overlap++
+			}
+		}
+		if overlap > bestOverlap {
+			best, bestOverlap = cluster, overlap
+		}
+	}
+	return best, bestOverlap > 0
+}
+
+// summarise sets a cluster's extent in space and time from its points. A
+// cluster stays active while its latest alert is within the window.
+func summarise(cluster *models.Cluster, group []Point, now time.Time, window time.Duration) {
+	var lat, lon float64
+	cluster.StartDate, cluster.EndDate = group[0].Time, group[0].Time
+	for _, p := range group {
+		lat += p.Latitude
+		lon += p.Longitude
+		if p.Time.Before(cluster.StartDate) {
+			cluster.StartDate = p.Time
+		}
+		if p.Time.After(cluster.EndDate) {
+			cluster.EndDate = p.Time
+		}
+	}
+	cluster.Latitude = lat / float64(len(group))
+	cluster.Longitude = lon / float64(len(group))
+
+	cluster.RadiusKm = 0
+	for _, p := range group {
+		if d := DistanceKm(cluster.Latitude, cluster.Longitude, p.Latitude, p.Longitude); d > cluster.RadiusKm {
+			cluster.RadiusKm = d
+		}
+	}
+
+	cluster.AlertCount = len(group)
+	cluster.Status = models.ClusterStatusClosed
+	if !cluster.EndDate.Before(now.Add(-window)) {
+		cluster.Status = models.ClusterStatusActive
+	}
+}
+
+// saveCluster stores a cluster and replaces its members with the group
+func saveCluster(tx *gorm.DB, cluster *models.Cluster, group []Point) error {
+	existing := cluster.Members
+	cluster.Members = nil
+	if err := tx.Save(cluster).Error; err != nil {
+		return err
+	}
+
+	keep := make(map[uint]bool, len(group))
+	for _, p := range group {
+		keep[p.ID] = true
+	}
+	have := make(map[uint]bool, len(existing))
+	for _, member := range existing {
+		if !keep[member.AlertID] {
+			if err := tx.Delete(&member).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		have[member.AlertID] = true
+	}
+
+	var added []models.ClusterMember
+	for _, p := range group {
+		if !have[p.ID] {
+			added = append(added, models.ClusterMember{ClusterID: cluster.ID, AlertID: p.ID})
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	return tx.Create(&added).Error
+}
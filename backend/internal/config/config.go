@@ -3,7 +3,11 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/alertsMIS/backend/internal/oidc"
 	"github.com/joho/godotenv"
 )
 
@@ -18,6 +22,58 @@ type Config struct {
 	SSLEnabled  bool
 	SSLCertFile string
 	SSLKeyFile  string
+	ProxyHeader string
+
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	LoginMaxFailures      int
+	LoginMaxFailuresPerIP int
+	LoginLockout          time.Duration
+
+	TOTPIssuer        string
+	TOTPRequiredRoles []string
+	TOTPEncryptionKey string
+	LoginChallengeTTL time.Duration
+
+	PasswordLogin        bool
+	OIDCIssuer           string
+	OIDCClientID         string
+	OIDCClientSecret     string
+	OIDCRedirectURL      string
+	OIDCScopes           []string
+	OIDCPostLoginURL     string
+	OIDCUsernameClaim    string
+	OIDCGroupsClaim      string
+	OIDCUserTypeClaim    string
+	OIDCAffiliationClaim string
+	OIDCLevelClaim       string
+	OIDCGroupMap         []oidc.GroupRole
+	OIDCLinkByUsername   bool
+
+	PasswordResetURL        string
+	PasswordResetTTL        time.Duration
+	PasswordResetPerAccount int
+	PasswordResetPerIP      int
+
+	MailSMTPHost     string
+	MailSMTPPort     int
+	MailSMTPUsername string
+	MailSMTPPassword string
+	MailFrom         string
+
+	AttachmentDir     string
+	AttachmentMaxSize int64
+
+	ClusterRadiusKm     float64
+	ClusterWindowDays   int
+	ClusterMinAlerts    int
+	ClusterLookbackDays int
+	ClusterInterval     time.Duration
+
+	RiskRefreshInterval time.Duration
+
+	RequireAlertVillage bool
 }
 
 // LoadConfig loads configuration from environment variables
@@ -26,13 +82,143 @@ func LoadConfig() (*Config, error) {
 	godotenv.Load()
 
 	config := &Config{
-		DBHost:     getEnv("DB_HOST", "localhost"),
-		DBPort:     getEnv("DB_PORT", "3306"),
-		DBUser:     getEnv("DB_USER", "root"),
-		DBPassword: getEnv("DB_PASSWORD", "Felinho@123"),
-		DBName:     getEnv("DB_NAME", "alerts"),
-		ServerPort: getEnv("SERVER_PORT", "8089"),
-		JWTSecret:  getEnv("JWT_SECRET", "your-secret-key"),
+		DBHost:      getEnv("DB_HOST", "localhost"),
+		DBPort:      getEnv("DB_PORT", "3306"),
+		DBUser:      getEnv("DB_USER", "root"),
+		DBPassword:  getEnv("DB_PASSWORD", "Felinho@123"),
+		DBName:      getEnv("DB_NAME", "alerts"),
+		ServerPort:  getEnv("SERVER_PORT", "8089"),
+		JWTSecret:   getEnv("JWT_SECRET", "your-secret-key"),
+		ProxyHeader: getEnv("PROXY_HEADER", ""),
+
+		AttachmentDir: getEnv("ATTACHMENT_DIR", "./uploads"),
+
+		PasswordResetURL: getEnv("PASSWORD_RESET_URL", "https://alerts.health.go.ug/reset-password"),
+
+		MailSMTPHost:     getEnv("MAIL_SMTP_HOST", ""),
+		MailSMTPUsername: getEnv("MAIL_SMTP_USERNAME", ""),
+		MailSMTPPassword: getEnv("MAIL_SMTP_PASSWORD", ""),
+		MailFrom:         getEnv("MAIL_FROM", "no-reply@health.go.ug"),
+	}
+
+	accessMinutes, err := getEnvInt("ACCESS_TOKEN_MINUTES", 15)
+	if err != nil {
+		return nil, err
+	}
+	config.AccessTokenTTL = time.Duration(accessMinutes) * time.Minute
+	refreshDays, err := getEnvInt("REFRESH_TOKEN_DAYS", 30)
+	if err != nil {
+		return nil, err
+	}
+	config.RefreshTokenTTL = time.Duration(refreshDays) * 24 * time.Hour
+
+	if config.LoginMaxFailures, err = getEnvInt("LOGIN_MAX_FAILURES", 5); err != nil {
+		return nil, err
+	}
+	if config.LoginMaxFailuresPerIP, err = getEnvInt("LOGIN_MAX_FAILURES_PER_IP", 20); err != nil {
+		return nil, err
+	}
+	lockoutMinutes, err := getEnvInt("LOGIN_LOCKOUT_MINUTES", 15)
+	if err != nil {
+		return nil, err
+	}
+	config.LoginLockout = time.Duration(lockoutMinutes) * time.Minute
+
+	config.TOTPIssuer = getEnv("TOTP_ISSUER", "Alerts MIS")
+	// The key is not derived from JWT_SECRET, whose leak would otherwise
+	// also expose every stored TOTP secret
+	config.TOTPEncryptionKey = getEnv("TOTP_ENCRYPTION_KEY", "")
+	if config.TOTPEncryptionKey == "" {
+		return nil, fmt.Errorf("TOTP_ENCRYPTION_KEY is required")
+	}
+	if config.TOTPEncryptionKey == config.JWTSecret {
+		return nil, fmt.Errorf("TOTP_ENCRYPTION_KEY must differ from JWT_SECRET")
+	}
+	for _, role := range strings.Split(getEnv("TOTP_REQUIRED_ROLES", ""), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			config.TOTPRequiredRoles = append(config.TOTPRequiredRoles, role)
+		}
+	}
+	challengeMinutes, err := getEnvInt("LOGIN_CHALLENGE_MINUTES", 5)
+	if err != nil {
+		return nil, err
+	}
+	config.LoginChallengeTTL = time.Duration(challengeMinutes) * time.Minute
+
+	if config.PasswordLogin, err = strconv.ParseBool(getEnv("PASSWORD_LOGIN_ENABLED", "true")); err != nil {
+		return nil, fmt.Errorf("invalid PASSWORD_LOGIN_ENABLED: %q", os.Getenv("PASSWORD_LOGIN_ENABLED"))
+	}
+	config.OIDCIssuer = getEnv("OIDC_ISSUER", "")
+	config.OIDCClientID = getEnv("OIDC_CLIENT_ID", "")
+	config.OIDCClientSecret = getEnv("OIDC_CLIENT_SECRET", "")
+	config.OIDCRedirectURL = getEnv("OIDC_REDIRECT_URL", "http://localhost:8089/api/v1/auth/sso/callback")
+	config.OIDCScopes = strings.Fields(getEnv("OIDC_SCOPES", "openid profile email"))
+	config.OIDCPostLoginURL = getEnv("OIDC_POST_LOGIN_URL", "https://alerts.health.go.ug/sso-callback")
+	config.OIDCUsernameClaim = getEnv("OIDC_USERNAME_CLAIM", "preferred_username")
+	config.OIDCGroupsClaim = getEnv("OIDC_GROUPS_CLAIM", "groups")
+	config.OIDCUserTypeClaim = getEnv("OIDC_USER_TYPE_CLAIM", "")
+	config.OIDCAffiliationClaim = getEnv("OIDC_AFFILIATION_CLAIM", "")
+	config.OIDCLevelClaim = getEnv("OIDC_LEVEL_CLAIM", "")
+	if config.OIDCGroupMap, err = oidc.ParseGroupMap(getEnv("OIDC_GROUP_MAP", "")); err != nil {
+		return nil, fmt.Errorf("invalid OIDC_GROUP_MAP: %v", err)
+	}
+	if config.OIDCLinkByUsername, err = strconv.ParseBool(getEnv("OIDC_LINK_BY_USERNAME", "false")); err != nil {
+		return nil, fmt.Errorf("invalid OIDC_LINK_BY_USERNAME: %q", os.Getenv("OIDC_LINK_BY_USERNAME"))
+	}
+	if config.OIDCIssuer != "" && config.OIDCClientID == "" {
+		return nil, fmt.Errorf("OIDC_CLIENT_ID is required with OIDC_ISSUER")
+	}
+	if config.OIDCIssuer == "" && !config.PasswordLogin {
+		return nil, fmt.Errorf("password login can only be disabled when OIDC_ISSUER is set")
+	}
+
+	resetMinutes, err := getEnvInt("PASSWORD_RESET_MINUTES", 30)
+	if err != nil {
+		return nil, err
+	}
+	config.PasswordResetTTL = time.Duration(resetMinutes) * time.Minute
+	if config.PasswordResetPerAccount, err = getEnvInt("PASSWORD_RESET_PER_ACCOUNT_HOUR", 3); err != nil {
+		return nil, err
+	}
+	if config.PasswordResetPerIP, err = getEnvInt("PASSWORD_RESET_PER_IP_HOUR", 10); err != nil {
+		return nil, err
+	}
+	if config.MailSMTPPort, err = getEnvInt("MAIL_SMTP_PORT", 587); err != nil {
+		return nil, err
+	}
+
+	maxSizeMB, err := strconv.ParseInt(getEnv("ATTACHMENT_MAX_SIZE_MB", "10"), 10, 64)
+	if err != nil || maxSizeMB <= 0 {
+		return nil, fmt.Errorf("invalid ATTACHMENT_MAX_SIZE_MB: %q", os.Getenv("ATTACHMENT_MAX_SIZE_MB"))
+	}
+	config.AttachmentMaxSize = maxSizeMB << 20
+
+	if config.ClusterRadiusKm, err = strconv.ParseFloat(getEnv("CLUSTER_RADIUS_KM", "10"), 64); err != nil || config.ClusterRadiusKm <= 0 {
+		return nil, fmt.Errorf("invalid CLUSTER_RADIUS_KM: %q", os.Getenv("CLUSTER_RADIUS_KM"))
+	}
+	if config.ClusterWindowDays, err = getEnvInt("CLUSTER_WINDOW_DAYS", 7); err != nil {
+		return nil, err
+	}
+	if config.ClusterMinAlerts, err = getEnvInt("CLUSTER_MIN_ALERTS", 3); err != nil {
+		return nil, err
+	}
+	if config.ClusterLookbackDays, err = getEnvInt("CLUSTER_LOOKBACK_DAYS", 60); err != nil {
+		return nil, err
+	}
+	intervalMinutes, err := getEnvInt("CLUSTER_INTERVAL_MINUTES", 60)
+	if err != nil {
+		return nil, err
+	}
+	config.ClusterInterval = time.Duration(intervalMinutes) * time.Minute
+
+	riskMinutes, err := getEnvInt("RISK_REFRESH_MINUTES", 15)
+	if err != nil {
+		return nil, err
+	}
+	config.RiskRefreshInterval = time.Duration(riskMinutes) * time.Minute
+
+	if config.RequireAlertVillage, err = strconv.ParseBool(getEnv("REQUIRE_ALERT_VILLAGE", "false")); err != nil {
+		return nil, fmt.Errorf("invalid REQUIRE_ALERT_VILLAGE: %q", os.Getenv("REQUIRE_ALERT_VILLAGE"))
 	}
 
 	return config, nil
@@ -52,3 +238,16 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// getEnvInt gets a positive integer environment variable or returns a default value
+func getEnvInt(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s: %q", key, value)
+	}
+	return n, nil
+}
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// Migrate creates the tables owned by the Go backend and adds any missing
+// columns to the legacy tables shared with the PHP system
+func Migrate() error {
+	// Tables created and owned by the Go backend
+	if err := DB.AutoMigrate(
+		&models.CaseDefinition{},
+		&models.Contact{},
+		&models.ContactFollowUp{},
+		&models.Notification{},
+		&models.LabSample{},
+		&models.LabTest{},
+		&models.AlertAssignment{},
+		&models.AlertNote{},
+		&models.AlertNoteRevision{},
+		&models.AlertAttachment{},
+		&models.AttachmentBlob{},
+		&models.Cluster{},
+		&models.ClusterMember{},
+		&models.Event{},
+		&models.EventDistrict{},
+		&models.DistrictRegionHistory{},
+		&models.SubcountyDistrictHistory{},
+		&models.Parish{},
+		&models.Village{},
+		&models.AdminUnitBoundary{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.UserRevocation{},
+		&models.Session{},
+		&models.PasswordResetToken{},
+		&models.RateLimitEvent{},
+		&models.LoginThrottle{},
+		&models.UserTOTP{},
+		&models.TOTPRecoveryCode{},
+		&models.LoginChallenge{},
+		&models.UserIdentity{},
+		&models.SSOLogin{},
+	); err != nil {
+		return fmt.Errorf("failed to migrate tables: %v", err)
+	}
+
+	// Legacy tables only get new columns so the PHP schema is left untouched
+	if err := addMissingColumns(DB, &models.Alert{},
+		"Syndromes", "Classification", "Priority", "PatientID",
+		"Latitude", "Longitude", "LocationAccuracy", "LocationSource",
+		"EventID", "RiskScore", "RegionID", "DistrictID", "SubcountyID", "FacilityID",
+		"ParishID", "VillageID",
+	); err != nil {
+		return err
+	}
+	if err := addMissingIndexes(DB, &models.Alert{},
+		"Classification", "PatientID", "EventID", "RiskScore",
+		"RegionID", "DistrictID", "SubcountyID", "FacilityID", "ParishID", "VillageID",
+	); err != nil {
+		return err
+	}
+	if err := addMissingColumns(DB, &models.Region{}, "EffectiveFrom", "EffectiveTo"); err != nil {
+		return err
+	}
+	if err := addMissingColumns(DB, &models.District{}, "Latitude", "Longitude", "EffectiveFrom", "EffectiveTo"); err != nil {
+		return err
+	}
+	if err := addMissingColumns(DB, &models.Subcounty{}, "Latitude", "Longitude", "EffectiveFrom", "EffectiveTo"); err != nil {
+		return err
+	}
+	if err := addMissingColumns(DB, &models.Facility{}, "EffectiveFrom", "EffectiveTo"); err != nil {
+		return err
+	}
+	if err := syncAdminUnitHistory(DB); err != nil {
+		return err
+	}
+
+	if err := seedCaseDefinitions(DB); err != nil {
+		return err
+	}
+
+	log.Println("Database migration completed")
+	return nil
+}
+
+// addMissingColumns adds the given model fields as columns if they do not exist
+func addMissingColumns(db *gorm.DB, model interface{}, fields ...string) error {
+	migrator := db.Migrator()
+	for _, field := range fields {
+		if migrator.HasColumn(model, field) {
+			continue
+		}
+		if err := migrator.AddColumn(model, field); err != nil {
+			return fmt.Errorf("failed to add column %s: %v", field, err)
+		}
+	}
+	return nil
+}
+
+// addMissingIndexes creates the indexes declared on the given model fields if
+// they do not exist
+func addMissingIndexes(db *gorm.DB, model interface{}, fields ...string) error {
+	migrator := db.Migrator()
+	for _, field := range fields {
+		if migrator.HasIndex(model, field) {
+			continue
+		}
+		if err := migrator.CreateIndex(model, field); err != nil {
+			return fmt.Errorf("failed to create index on %s: %v", field, err)
+		}
+	}
+	return nil
+}
+
+// syncAdminUnitHistory brings the parent history in line with the current
+// parents of districts and subcounties. Units without history get a period
+// starting with the unit itself, and units moved outside the Go backend (by
+// the PHP system or by hand) get their open period closed and a new one
+// started today.
+func syncAdminUnitHistory(db *gorm.DB) error {
+	statements := []string{
+		`UPDATE district_region_history h JOIN districts d ON d.id = h.district_id
+		SET h.effective_to = CURDATE()
+		WHERE h.effective_to IS NULL AND h.region_id <> d.region_id`,
+		`INSERT INTO district_region_history (district_id, region_id, effective_from)
+		SELECT d.id, d.region_id,
+			CASE WHEN EXISTS (SELECT 1 FROM district_region_history h WHERE h.district_id = d.id)
+				THEN CURDATE() ELSE d.effective_from END
+		FROM districts d
+		WHERE d.region_id IS NOT NULL AND d.region_id <> 0
+			AND NOT EXISTS (SELECT 1 FROM district_region_history h WHERE h.district_id = d.id AND h.effective_to IS NULL)`,
+		`UPDATE subcounty_district_history h JOIN subcounties s ON s.id = h.subcounty_id
+		SET h.effective_to = CURDATE()
+		WHERE h.effective_to IS NULL AND h.district_id <> s.district_id`,
+		`INSERT INTO subcounty_district_history (subcounty_id, district_id, effective_from)
+		SELECT s.id, s.district_id,
+			CASE WHEN EXISTS (SELECT 1 FROM subcounty_district_history h WHERE h.subcounty_id = s.id)
+				THEN CURDATE() ELSE s.effective_from END
+		FROM subcounties s
+		WHERE s.district_id IS NOT NULL AND s.district_id <> 0
+			AND NOT EXISTS (SELECT 1 FROM subcounty_district_history h WHERE h.subcounty_id = s.id AND h.effective_to IS NULL)`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return fmt.Errorf("failed to sync admin unit history: %v", err)
+		}
+	}
+	return nil
+}
+
+// seedCaseDefinitions installs the default case definitions on an empty
+// table. Deleted definitions count, so that deleting them all does not bring
+// the defaults back, whose codes they may still hold.
+func seedCaseDefinitions(db *gorm.DB) error {
+	var count int64
+	if err := db.Unscoped().Model(&models.CaseDefinition{}).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to count case definitions: %v", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	afpMaxAge := 14
+	choleraMinAge := 2
+	definitions := []models.CaseDefinition{
+		{
+			Code:            "VHF",
+			Name:            "Suspected viral haemorrhagic fever",
+			Disease:         "Viral Haemorrhagic Fever",
+			Classification:  models.ClassificationSuspected,
+			HighConsequence: true,
+			Active:          true,
+			Rules: models.CaseDefinitionRules{
+				RequiredSymptoms: []string{"fever"},
+				AnySymptoms:      []string{"bleeding", "vomiting", "diarrhea", "abdominal_pain", "difficulty_swallowing", "hiccups"},
+				MinAnySymptoms:   2,
+			},
+		},
+		{
+			Code:            "AFP",
+			Name:            "Acute flaccid paralysis",
+			Disease:         "Polio",
+			Classification:  models.ClassificationSuspected,
+			HighConsequence: true,
+			Active:          true,
+			Rules: models.CaseDefinitionRules{
+				AnySymptoms:    []string{"paralysis", "acute_flaccid_paralysis", "sudden_weakness"},
+				MinAnySymptoms: 1,
+				MaxAge:         &afpMaxAge,
+			},
+		},
+		{
+			Code:           "CHOLERA",
+			Name:           "Suspected cholera",
+			Disease:        "Cholera",
+			Classification: models.ClassificationSuspected,
+			Active:         true,
+			Rules: models.CaseDefinitionRules{
+				RequiredSymptoms: []string{"watery_diarrhea"},
+				MinAge:           &choleraMinAge,
+			},
+		},
+		{
+			Code:           "MEASLES",
+			Name:           "Suspected measles",
+			Disease:        "Measles",
+			Classification: models.ClassificationSuspected,
+			Active:         true,
+			Rules: models.CaseDefinitionRules{
+				RequiredSymptoms: []string{"fever", "rash"},
+				AnySymptoms:      []string{"cough", "runny_nose", "conjunctivitis"},
+				MinAnySymptoms:   1,
+			},
+		},
+		{
+			Code:            "ANTHRAX",
+			Name:            "Suspected anthrax",
+			Disease:         "Anthrax",
+			Classification:  models.ClassificationSuspected,
+			HighConsequence: true,
+			Active:          true,
+			Rules: models.CaseDefinitionRules{
+				AnySymptoms:    []string{"skin_lesion", "black_eschar", "skin_ulcer"},
+				MinAnySymptoms: 1,
+				RiskFactors:    []string{"animal_contact"},
+			},
+		},
+	}
+
+	if err := db.Create(&definitions).Error; err != nil {
+		return fmt.Errorf("failed to seed case definitions: %v", err)
+	}
+	return nil
+}
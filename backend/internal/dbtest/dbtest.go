@@ -0,0 +1,198 @@
+// Package dbtest provides a fake MySQL database for tests. Its statements are
+// answered by the test, so that code relying on conditional updates and row
+// locks can be tested without a MySQL server.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Result is the answer to a statement
+type Result struct {
+	Columns      []string
+	Rows         [][]driver.Value
+	RowsAffected int64
+	LastInsertID int64
+}
+
+// Handler answers a statement run on a connection. Statements of several
+// connections may be answered at the same time.
+type Handler func(conn *Conn, query string, args []driver.NamedValue) (Result, error)
+
+// Open opens a fake database whose statements are answered by handle
+func Open(t testing.TB, handle Handler) *gorm.DB {
+	t.Helper()
+	pool := sql.OpenDB(connector{handle: handle})
+	t.Cleanup(func() { pool.Close() })
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: pool, SkipInitializeWithVersion: true}), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open fake database: %v", err)
+	}
+	return db
+}
+
+// Conn is a connection to a fake database
+type Conn struct {
+	handle Handler
+
+	mu    sync.Mutex
+	inTx  bool
+	atEnd []func()
+}
+
+// AtEnd runs f once the transaction in progress on the connection commits or
+// rolls back, as a database releases its locks. Outside a transaction f runs
+// at once.
+func (c *Conn) AtEnd(f func()) {
+	c.mu.Lock()
+	if c.inTx {
+		c.atEnd = append(c.atEnd, f)
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	f()
+}
+
+// InTx reports whether a transaction is in progress on the connection
+func (c *Conn) InTx() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.inTx
+}
+
+func (c *Conn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("fake database does not prepare statements")
+}
+
+func (c *Conn) Close() error {
+	return nil
+}
+
+func (c *Conn) Begin() (driver.Tx, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inTx = true
+	return tx{c}, nil
+}
+
+func (c *Conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result, err := c.handle(c, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return execResult{lastInsertID: result.LastInsertID, rowsAffected: result.RowsAffected}, nil
+}
+
+func (c *Conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result, err := c.handle(c, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{columns: result.Columns, rows: result.Rows}, nil
+}
+
+// end runs the functions waiting for the transaction to end
+func (c *Conn) end() {
+	c.mu.Lock()
+	atEnd := c.atEnd
+	c.inTx, c.atEnd = false, nil
+	c.mu.Unlock()
+	for _, f := range atEnd {
+		f()
+	}
+}
+
+type connector struct {
+	handle Handler
+}
+
+func (c connector) Connect(context.Context) (driver.Conn, error) {
+	return &Conn{handle: c.handle}, nil
+}
+
+func (c connector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fake database must be opened with Open")
+}
+
+type tx struct {
+	conn *Conn
+}
+
+func (t tx) Commit() error {
+	t.conn.end()
+	return nil
+}
+
+func (t tx) Rollback() error {
+	t.conn.end()
+	return nil
+}
+
+type execResult struct {
+	lastInsertID, rowsAffected int64
+}
+
+func (r execResult) LastInsertId() (int64, error) { return r.lastInsertID, nil }
+func (r execResult) RowsAffected() (int64, error) { return r.rowsAffected, nil }
+
+type rows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// InsertValues maps the columns of a single row INSERT to its arguments
+func InsertValues(query string, args []driver.NamedValue) map[string]driver.Value {
+	list := query[strings.Index(query, "(")+1 : strings.Index(query, ")")]
+	values := make(map[string]driver.Value)
+	for i, column := range strings.Split(list, ",") {
+		values[strings.Trim(column, "`")] = args[i].Value
+	}
+	return values
+}
+
+// UpdateValues maps the columns set by an UPDATE to their arguments
+func UpdateValues(query string, args []driver.NamedValue) map[string]driver.Value {
+	set := query[strings.Index(query, " SET ")+5 : strings.Index(query, " WHERE ")]
+	values := make(map[string]driver.Value)
+	for i, assignment := range strings.Split(set, ",") {
+		values[strings.Trim(strings.TrimSuffix(assignment, "=?"), "`")] = args[i].Value
+	}
+	return values
+}
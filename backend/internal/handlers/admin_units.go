@@ -1,21 +1,31 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/alertsMIS/backend/internal/adminunits"
 	"github.com/alertsMIS/backend/internal/models"
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
 )
 
+// adminUnitsCacheTTL is how long the hierarchy is served from memory
+const adminUnitsCacheTTL = time.Minute
+
 // AdminUnitsHandler handles administrative units-related HTTP requests
 type AdminUnitsHandler struct {
-	db *gorm.DB
+	db    *gorm.DB
+	units *adminunits.Cache
 }
 
 // NewAdminUnitsHandler creates a new AdminUnitsHandler
 func NewAdminUnitsHandler(db *gorm.DB) *AdminUnitsHandler {
-	return &AdminUnitsHandler{db: db}
+	return &AdminUnitsHandler{db: db, units: adminunits.NewCache(db, adminUnitsCacheTTL)}
 }
 
 // GetAllRegions fetches all regions
@@ -135,3 +145,354 @@ func (h *AdminUnitsHandler) GetAllSubcounties(c *fiber.Ctx) error {
 	}
 	return c.JSON(subcounties)
 }
+
+// facilityOwnerships are the valid facility ownership filters
+var facilityOwnerships = map[string]bool{
+	models.FacilityOwnershipGovernment:          true,
+	models.FacilityOwnershipPrivateForProfit:    true,
+	models.FacilityOwnershipPrivateNotForProfit: true,
+}
+
+// filterFacilities applies the facility list filters and pagination given as
+// query parameters. It returns a message when a filter is invalid.
+func filterFacilities(c *fiber.Ctx, query *gorm.DB) (*gorm.DB, string) {
+	// facility_type is accepted as the PHP forms name ownership that way
+	if ownership := strings.ToUpper(c.Query("ownership", c.Query("facility_type"))); ownership != "" {
+		if !facilityOwnerships[ownership] {
+			return query, "Ownership must be GOV, PFP or PNFP"
+		}
+		query = query.Where("ownership = ?", ownership)
+	}
+	if search := strings.TrimSpace(c.Query("search")); search != "" {
+		query = query.Where("facility LIKE ?", "%"+search+"%")
+	}
+
+	// Facilities are only paginated when asked, as the PHP forms load
+	// them all at once
+	if c.Query("page") != "" || c.Query("limit") != "" {
+		page, _ := strconv.Atoi(c.Query("page", "1"))
+		limit, _ := strconv.Atoi(c.Query("limit", "50"))
+		query = query.Offset((page - 1) * limit).Limit(limit)
+	}
+	return query.Order("facility"), ""
+}
+
+// GetAllFacilities fetches all health facilities
+// @Summary Get all facilities
+// @Description Get all health facilities, optionally filtered by ownership, district or name
+// @Tags admin-units
+// @Accept json
+// @Produce json
+// @Param ownership query string false "GOV, PFP or PNFP"
+// @Param district_id query int false "Filter by district"
+// @Param search query string false "Search facility names"
+// @Param page query int false "Page number"
+// @Param limit query int false "Number of records per page"
+// @Success 200 {array} models.Facility
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/facilities [get]
+func (h *AdminUnitsHandler) GetAllFacilities(c *fiber.Ctx) error {
+	query, msg := filterFacilities(c, h.db.Model(&models.Facility{}))
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+	if districtID := c.Query("district_id"); districtID != "" {
+		query = query.Where("subcounty_id IN (?)", h.db.Model(&models.Subcounty{}).Select("id").Where("district_id = ?", districtID))
+	}
+
+	var facilities []models.Facility
+	if err := query.Find(&facilities).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch facilities",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(facilities)
+}
+
+// GetFacilitiesBySubcounty fetches health facilities for a specific subcounty
+// @Summary Get facilities by subcounty
+// @Description Get the health facilities in a specific subcounty, optionally filtered by ownership or name
+// @Tags admin-units
+// @Accept json
+// @Produce json
+// @Param subcounty_id path int true "Subcounty ID"
+// @Param ownership query string false "GOV, PFP or PNFP"
+// @Param search query string false "Search facility names"
+// @Success 200 {array} models.Facility
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/subcounties/{subcounty_id}/facilities [get]
+func (h *AdminUnitsHandler) GetFacilitiesBySubcounty(c *fiber.Ctx) error {
+	subcountyID := c.Params("subcounty_id")
+	subcountyIDUint, err := strconv.ParseUint(subcountyID, 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid subcounty ID",
+		})
+	}
+
+	query, msg := filterFacilities(c, h.db.Where("subcounty_id = ?", uint(subcountyIDUint)))
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	var facilities []models.Facility
+	if err := query.Find(&facilities).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch facilities",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(facilities)
+}
+
+// GetParishesBySubcounty fetches the parishes of a subcounty
+// @Summary Get parishes by subcounty
+// @Description Get the parishes in a specific subcounty, ordered by name
+// @Tags admin-units
+// @Accept json
+// @Produce json
+// @Param subcounty_id path int true "Subcounty ID"
+// @Param search query string false "Search parish names"
+// @Success 200 {array} models.Parish
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/subcounties/{subcounty_id}/parishes [get]
+func (h *AdminUnitsHandler) GetParishesBySubcounty(c *fiber.Ctx) error {
+	subcountyID, err := strconv.ParseUint(c.Params("subcounty_id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid subcounty ID",
+		})
+	}
+	query := h.db.Where("subcounty_id = ?", uint(subcountyID)).Order("parish")
+	if search := strings.TrimSpace(c.Query("search")); search != "" {
+		query = query.Where("parish LIKE ?", "%"+search+"%")
+	}
+
+	var parishes []models.Parish
+	if err := query.Find(&parishes).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch parishes",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(parishes)
+}
+
+// GetVillagesByParish fetches the villages of a parish
+// @Summary Get villages by parish
+// @Description Get the villages in a specific parish, ordered by name
+// @Tags admin-units
+// @Accept json
+// @Produce json
+// @Param parish_id path int true "Parish ID"
+// @Param search query string false "Search village names"
+// @Success 200 {array} models.Village
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/parishes/{parish_id}/villages [get]
+func (h *AdminUnitsHandler) GetVillagesByParish(c *fiber.Ctx) error {
+	parishID, err := strconv.ParseUint(c.Params("parish_id"), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid parish ID",
+		})
+	}
+	query := h.db.Where("parish_id = ?", uint(parishID)).Order("village")
+	if search := strings.TrimSpace(c.Query("search")); search != "" {
+		query = query.Where("village LIKE ?", "%"+search+"%")
+	}
+
+	var villages []models.Village
+	if err := query.Find(&villages).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch villages",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(villages)
+}
+
+// etagMatches reports whether an If-None-Match header lists the ETag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTree fetches the whole administrative hierarchy
+// @Summary Get admin unit tree
+// @Description Get regions with their districts, subcounties and facilities nested, sorted by name. Responses carry an ETag; send it back in If-None-Match to get 304 Not Modified while the hierarchy is unchanged.
+// @Tags admin-units
+// @Produce json
+// @Param facilities query bool false "Include facilities (default true)"
+// @Param as_of query string false "Hierarchy date (YYYY-MM-DD, default today)"
+// @Success 200 {array} adminunits.RegionNode
+// @Success 304
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/tree [get]
+func (h *AdminUnitsHandler) GetTree(c *fiber.Ctx) error {
+	asOf, msg := parseAsOf(c)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+	units, err := h.units.Get()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch administrative units",
+			"details": err.Error(),
+		})
+	}
+	units = units.AsOf(asOf)
+	if includeFacilities, err := strconv.ParseBool(c.Query("facilities", "true")); err == nil && !includeFacilities {
+		units.Facilities = nil
+	}
+
+	body, err := json.Marshal(units.Tree())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to encode administrative units",
+			"details": err.Error(),
+		})
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	if etagMatches(c.Get(fiber.HeaderIfNoneMatch), etag) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(body)
+}
+
+// SearchAdminUnits searches the administrative units by name
+// @Summary Search admin units
+// @Description Search regions, districts, subcounties and facilities by name prefix, with tolerance for typing errors, for autocomplete. Each result carries its path from the region (Region › District › Subcounty › Facility).
+// @Tags admin-units
+// @Produce json
+// @Param q query string true "Search text"
+// @Param level query string false "Only return region, district, subcounty or facility"
+// @Param limit query int false "Maximum number of results (default 20, at most 100)"
+// @Success 200 {array} adminunits.SearchResult
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/search [get]
+func (h *AdminUnitsHandler) SearchAdminUnits(c *fiber.Ctx) error {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Search text is required",
+		})
+	}
+	level := strings.ToLower(c.Query("level"))
+	switch level {
+	case "", adminunits.LevelRegion, adminunits.LevelDistrict, adminunits.LevelSubcounty, adminunits.LevelFacility:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Level must be region, district, subcounty or facility",
+		})
+	}
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	units, err := h.units.Get()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch administrative units",
+			"details": err.Error(),
+		})
+	}
+
+	// Only units in use today are offered
+	results := units.AsOf(time.Now()).Search(q, 0)
+	matches := make([]adminunits.SearchResult, 0, limit)
+	for _, result := range results {
+		if len(matches) == limit {
+			break
+		}
+		if level == "" || result.Level == level {
+			matches = append(matches, result)
+		}
+	}
+	return c.JSON(matches)
+}
+
+// GetDistrictHistory fetches the boundary history of a district
+// @Summary Get district history
+// @Description Get the periods a district spent in each region and the periods each subcounty spent in the district, oldest first. Effective to dates are exclusive and open dates are unbounded.
+// @Tags admin-units
+// @Produce json
+// @Param id path int true "District ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/districts/{id}/history [get]
+func (h *AdminUnitsHandler) GetDistrictHistory(c *fiber.Ctx) error {
+	var district models.District
+	if ok, err := h.findAdminUnit(c, &district, "District"); !ok {
+		return err
+	}
+	var regions []models.DistrictRegionHistory
+	var subcounties []models.SubcountyDistrictHistory
+	err := h.db.Where("district_id = ?", district.ID).Order("effective_from").Order("id").Find(&regions).Error
+	if err == nil {
+		err = h.db.Where("district_id = ?", district.ID).Order("effective_from").Order("id").Find(&subcounties).Error
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch district history",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"district":    district,
+		"regions":     regions,
+		"subcounties": subcounties,
+	})
+}
+
+// GetSubcountyHistory fetches the boundary history of a subcounty
+// @Summary Get subcounty history
+// @Description Get the periods a subcounty spent in each district, oldest first. Effective to dates are exclusive and open dates are unbounded.
+// @Tags admin-units
+// @Produce json
+// @Param id path int true "Subcounty ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/subcounties/{id}/history [get]
+func (h *AdminUnitsHandler) GetSubcountyHistory(c *fiber.Ctx) error {
+	var subcounty models.Subcounty
+	if ok, err := h.findAdminUnit(c, &subcounty, "Subcounty"); !ok {
+		return err
+	}
+	var districts []models.SubcountyDistrictHistory
+	if err := h.db.Where("subcounty_id = ?", subcounty.ID).Order("effective_from").Order("id").Find(&districts).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch subcounty history",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"subcounty": subcounty,
+		"districts": districts,
+	})
+}
@@ -0,0 +1,942 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/adminunits"
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// findAdminUnit loads a region, district, subcounty or facility by the id
+// route parameter. When the unit cannot be loaded the error response is
+// written and ok is false.
+func (h *AdminUnitsHandler) findAdminUnit(c *fiber.Ctx, unit interface{}, label string) (bool, error) {
+	if err := h.db.First(unit, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": label + " not found",
+			})
+		}
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch " + strings.ToLower(label),
+			"details": err.Error(),
+		})
+	}
+	return true, nil
+}
+
+// countRows counts the rows of a model matching a condition
+func countRows(db *gorm.DB, model interface{}, query string, args ...interface{}) (int64, error) {
+	var count int64
+	err := db.Model(model).Where(query, args...).Count(&count).Error
+	return count, err
+}
+
+// validateUnitName checks the UID and name shared by all administrative
+// units against their column sizes, and that no other unit has the UID
+func validateUnitName(db *gorm.DB, model interface{}, uidColumn string, id uint, uid, name string, uidSize int) (string, error) {
+	if strings.TrimSpace(uid) == "" {
+		return "UID is required", nil
+	}
+	if len(uid) > uidSize {
+		return fmt.Sprintf("UID cannot be longer than %d characters", uidSize), nil
+	}
+	if strings.TrimSpace(name) == "" {
+		return "Name is required", nil
+	}
+	if len([]rune(name)) > 50 {
+		return "Name cannot be longer than 50 characters", nil
+	}
+	count, err := countRows(db, model, uidColumn+" = ? AND id <> ?", uid, id)
+	if err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return "UID is already in use", nil
+	}
+	return "", nil
+}
+
+// validateEffectiveDates checks that a unit's effective period is not empty
+func validateEffectiveDates(from, to *time.Time) string {
+	if from != nil && to != nil && !to.After(*from) {
+		return "Effective to must be after effective from"
+	}
+	return ""
+}
+
+// validateRegion returns a message describing the first problem with a
+// region, or an empty string
+func validateRegion(db *gorm.DB, region *models.Region) (string, error) {
+	region.RegionUID, region.Region = strings.TrimSpace(region.RegionUID), strings.TrimSpace(region.Region)
+	if msg, err := validateUnitName(db, &models.Region{}, "region_uid", region.ID, region.RegionUID, region.Region, 20); msg != "" || err != nil {
+		return msg, err
+	}
+	return validateEffectiveDates(region.EffectiveFrom, region.EffectiveTo), nil
+}
+
+// validateDistrict returns a message describing the first problem with a
+// district, or an empty string
+func validateDistrict(db *gorm.DB, district *models.District) (string, error) {
+	district.DistrictUID, district.District = strings.TrimSpace(district.DistrictUID), strings.TrimSpace(district.District)
+	if msg, err := validateUnitName(db, &models.District{}, "district_uid", district.ID, district.DistrictUID, district.District, 20); msg != "" || err != nil {
+		return msg, err
+	}
+	if msg := validateCentroid(district.Latitude, district.Longitude); msg != "" {
+		return msg, nil
+	}
+	if msg := validateEffectiveDates(district.EffectiveFrom, district.EffectiveTo); msg != "" {
+		return msg, nil
+	}
+	count, err := countRows(db, &models.Region{}, "id = ?", district.RegionID)
+	if err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "Region not found", nil
+	}
+	return "", nil
+}
+
+// validateSubcounty returns a message describing the first problem with a
+// subcounty, or an empty string
+func validateSubcounty(db *gorm.DB, subcounty *models.Subcounty) (string, error) {
+	subcounty.SubcountyUID, subcounty.Subcounty = strings.TrimSpace(subcounty.SubcountyUID), strings.TrimSpace(subcounty.Subcounty)
+	if msg, err := validateUnitName(db, &models.Subcounty{}, "subcounty_uid", subcounty.ID, subcounty.SubcountyUID, subcounty.Subcounty, 20); msg != "" || err != nil {
+		return msg, err
+	}
+	if msg := validateCentroid(subcounty.Latitude, subcounty.Longitude); msg != "" {
+		return msg, nil
+	}
+	if msg := validateEffectiveDates(subcounty.EffectiveFrom, subcounty.EffectiveTo); msg != "" {
+		return msg, nil
+	}
+	count, err := countRows(db, &models.District{}, "id = ?", subcounty.DistrictID)
+	if err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "District not found", nil
+	}
+	return "", nil
+}
+
+// validateFacility returns a message describing the first problem with a
+// facility, or an empty string
+func validateFacility(db *gorm.DB, facility *models.Facility) (string, error) {
+	facility.FacilityUID, facility.Facility = strings.TrimSpace(facility.FacilityUID), strings.TrimSpace(facility.Facility)
+	facility.Ownership = strings.ToUpper(strings.TrimSpace(facility.Ownership))
+	if msg, err := validateUnitName(db, &models.Facility{}, "uid", facility.ID, facility.FacilityUID, facility.Facility, 15); msg != "" || err != nil {
+		return msg, err
+	}
+	if !facilityOwnerships[facility.Ownership] {
+		return "Ownership must be GOV, PFP or PNFP", nil
+	}
+	if msg := validateEffectiveDates(facility.EffectiveFrom, facility.EffectiveTo); msg != "" {
+		return msg, nil
+	}
+	if facility.SubcountyID == nil {
+		return "", nil
+	}
+	count, err := countRows(db, &models.Subcounty{}, "id = ?", *facility.SubcountyID)
+	if err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "Subcounty not found", nil
+	}
+	return "", nil
+}
+
+// validateParish returns a message describing the first problem with a
+// parish, or an empty string
+func validateParish(db *gorm.DB, parish *models.Parish) (string, error) {
+	parish.ParishUID, parish.Parish = strings.TrimSpace(parish.ParishUID), strings.TrimSpace(parish.Parish)
+	if msg, err := validateUnitName(db, &models.Parish{}, "parish_uid", parish.ID, parish.ParishUID, parish.Parish, 20); msg != "" || err != nil {
+		return msg, err
+	}
+	if msg := validateEffectiveDates(parish.EffectiveFrom, parish.EffectiveTo); msg != "" {
+		return msg, nil
+	}
+	count, err := countRows(db, &models.Subcounty{}, "id = ?", parish.SubcountyID)
+	if err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "Subcounty not found", nil
+	}
+	return "", nil
+}
+
+// validateVillage returns a message describing the first problem with a
+// village, or an empty string
+func validateVillage(db *gorm.DB, village *models.Village) (string, error) {
+	village.VillageUID, village.Village = strings.TrimSpace(village.VillageUID), strings.TrimSpace(village.Village)
+	if msg, err := validateUnitName(db, &models.Village{}, "village_uid", village.ID, village.VillageUID, village.Village, 20); msg != "" || err != nil {
+		return msg, err
+	}
+	if msg := validateCentroid(village.Latitude, village.Longitude); msg != "" {
+		return msg, nil
+	}
+	if msg := validateEffectiveDates(village.EffectiveFrom, village.EffectiveTo); msg != "" {
+		return msg, nil
+	}
+	count, err := countRows(db, &models.Parish{}, "id = ?", village.ParishID)
+	if err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "Parish not found", nil
+	}
+	return "", nil
+}
+
+// validateCentroid checks optional centroid coordinates
+func validateCentroid(latitude, longitude *float64) string {
+	return validateLocation(&models.Alert{Latitude: latitude, Longitude: longitude})
+}
+
+// saveAdminUnit stores a validated unit and writes the response. The optional
+// history function records a change of parent in the same transaction. The
+// cached hierarchy is dropped so the tree and search see the change at once.
+func (h *AdminUnitsHandler) saveAdminUnit(c *fiber.Ctx, unit interface{}, msg string, err error, status int, label string, history func(tx *gorm.DB) error) error {
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to validate " + strings.ToLower(label),
+			"details": err.Error(),
+		})
+	}
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(unit).Error; err != nil {
+			return err
+		}
+		if history != nil {
+			return history(tx)
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to save " + strings.ToLower(label),
+			"details": err.Error(),
+		})
+	}
+	h.units.Invalidate()
+	return c.Status(status).JSON(unit)
+}
+
+// adminUnitReference counts the records of one kind referring to a unit,
+// with the message given when there are any
+type adminUnitReference struct {
+	msg   string
+	count func() (int64, error)
+}
+
+// deleteAdminUnit deletes a unit unless other records still refer to it. The
+// optional history function deletes the unit's parent history and boundary
+// with it.
+func (h *AdminUnitsHandler) deleteAdminUnit(c *fiber.Ctx, unit interface{}, label string, references []adminUnitReference, history func(tx *gorm.DB) error) error {
+	for _, ref := range references {
+		n, err := ref.count()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "Failed to check " + strings.ToLower(label) + " references",
+				"details": err.Error(),
+			})
+		}
+		if n > 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": ref.msg,
+				"count": n,
+			})
+		}
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if history != nil {
+			if err := history(tx); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(unit).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to delete " + strings.ToLower(label),
+			"details": err.Error(),
+		})
+	}
+	h.units.Invalidate()
+	return c.JSON(fiber.Map{
+		"message": label + " deleted successfully",
+	})
+}
+
+// parseMoveDate reads the date from which a change of parent takes effect,
+// today when not given. When the date is invalid the error response is
+// written and ok is false.
+func parseMoveDate(c *fiber.Ctx) (time.Time, bool, error) {
+	date, msg := queryDate(c, "effective_date")
+	if msg != "" {
+		return time.Time{}, false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+	if date == nil {
+		return adminunits.Day(time.Now()), true, nil
+	}
+	return *date, true, nil
+}
+
+// parseAdminUnit decodes the request body into a unit. When the body is
+// invalid the error response is written and ok is false.
+func parseAdminUnit(c *fiber.Ctx, unit interface{}) (bool, error) {
+	if err := c.BodyParser(unit); err != nil {
+		return false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+	return true, nil
+}
+
+// CreateRegion creates a region
+// @Summary Create region
+// @Description Create a region (Admin only)
+// @Tags admin-units
+// @Accept json
+// @Produce json
+// @Param region body models.Region true "Region"
+// @Success 201 {object} models.Region
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/regions [post]
+func (h *AdminUnitsHandler) CreateRegion(c *fiber.Ctx) error {
+	var region models.Region
+	if ok, err := parseAdminUnit(c, &region); !ok {
+		return err
+	}
+	region.ID = 0
+	msg, err := validateRegion(h.db, &region)
+	return h.saveAdminUnit(c, &region, msg, err, fiber.StatusCreated, "Region", nil)
+}
+
+// UpdateRegion updates a region
+// @Summary Update region
+// @Description Rename a region or change its UID (Admin only)
+// @Tags admin-units
+// @Accept json
+// @Produce json
+// @Param id path int true "Region ID"
+// @Param region body models.Region true "Region"
+// @Success 200 {object} models.Region
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/regions/{id} [put]
+func (h *AdminUnitsHandler) UpdateRegion(c *fiber.Ctx) error {
+	var region models.Region
+	if ok, err := h.findAdminUnit(c, &region, "Region"); !ok {
+		return err
+	}
+	id := region.ID
+	if ok, err := parseAdminUnit(c, &region); !ok {
+		return err
+	}
+	region.ID = id
+	msg, err := validateRegion(h.db, &region)
+	return h.saveAdminUnit(c, &region, msg, err, fiber.StatusOK, "Region", nil)
+}
+
+// DeleteRegion deletes a region
+// @Summary Delete region
+// @Description Delete a region that has no districts, now or in its history, and no alerts. Retire a region that had districts by setting its effective to date (Admin only)
+// @Tags admin-units
+// @Produce json
+// @Param id path int true "Region ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 409 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/regions/{id} [delete]
+func (h *AdminUnitsHandler) DeleteRegion(c *fiber.Ctx) error {
+	var region models.Region
+	if ok, err := h.findAdminUnit(c, &region, "Region"); !ok {
+		return err
+	}
+	return h.deleteAdminUnit(c, &region, "Region", []adminUnitReference{
+		{"Region has districts", func() (int64, error) {
+			return countRows(h.db, &models.District{}, "region_id = ?", region.ID)
+		}},
+		{"Region is referenced by alerts", func() (int64, error) {
+			return countRows(h.db, &models.Alert{}, "region_id = ?", region.ID)
+		}},
+		{"Region had districts in the past; set its effective to date instead", func() (int64, error) {
+			return countRows(h.db, &models.DistrictRegionHistory{}, "region_id = ?", region.ID)
+		}},
+	}, nil)
+}
+
+// CreateDistrict creates a district
+// @Summary Create district
+// @Description Create a district in a region (Admin only)
+// @Tags admin-units
+// @Accept json
+// @Produce json
+// @Param district body models.District true "District"
+// @Success 201 {object} models.District
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/districts [post]
+func (h *AdminUnitsHandler) CreateDistrict(c *fiber.Ctx) error {
+	var district models.District
+	if ok, err := parseAdminUnit(c, &district); !ok {
+		return err
+	}
+	district.ID = 0
+	msg, err := validateDistrict(h.db, &district)
+	return h.saveAdminUnit(c, &district, msg, err, fiber.StatusCreated, "District", func(tx *gorm.DB) error {
+		return adminunits.OpenDistrictRegion(tx, &district)
+	})
+}
+
+// UpdateDistrict updates a district
+// @Summary Update district
+// @Description Rename a district, change its UID, centroid or effective dates, or move it to another region. A move is recorded in the district's region history from the effective date, so statistics as of earlier dates keep the old region (Admin only).
+// @Tags admin-units
+// @Accept json
+// @Produce json
+// @Param id path int true "District ID"
+// @Param effective_date query string false "Date a move to another region takes effect (YYYY-MM-DD, default today)"
+// @Param district body models.District true "District"
+// @Success 200 {object} models.District
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/districts/{id} [put]
+func (h *AdminUnitsHandler) UpdateDistrict(c *fiber.Ctx) error {
+	moveDate, ok, err := parseMoveDate(c)
+	if !ok {
+		return err
+	}
+	var district models.District
+	if ok, err := h.findAdminUnit(c, &district, "District"); !ok {
+		return err
+	}
+	id, regionID := district.ID, district.RegionID
+	if ok, err := parseAdminUnit(c, &district); !ok {
+		return err
+	}
+	district.ID = id
+	msg, err := validateDistrict(h.db, &district)
+	return h.saveAdminUnit(c, &district, msg, err, fiber.StatusOK, "District", func(tx *gorm.DB) error {
+		if district.RegionID == regionID {
+			return nil
+		}
+		return adminunits.MoveDistrict(tx, district.ID, district.RegionID, moveDate)
+	})
+}
+
+// DeleteDistrict deletes a district
+// @Summary Delete district
+// @Description Delete a district that has no subcounties, now or in its history, and no alerts or events. Retire a district that had subcounties by setting its effective to date (Admin only)
+// @Tags admin-units
+// @Produce json
+// @Param id path int true "District ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 409 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/districts/{id} [delete]
+func (h *AdminUnitsHandler) DeleteDistrict(c *fiber.Ctx) error {
+	var district models.District
+	if ok, err := h.findAdminUnit(c, &district, "District"); !ok {
+		return err
+	}
+	return h.deleteAdminUnit(c, &district, "District", []adminUnitReference{
+		{"District has subcounties", func() (int64, error) {
+			return countRows(h.db, &models.Subcounty{}, "district_id = ?", district.ID)
+		}},
+		{"District is referenced by alerts", func() (int64, error) {
+			return countRows(h.db, &models.Alert{}, "district_id = ?", district.ID)
+		}},
+		{"District is referenced by events", func() (int64, error) {
+			return countRows(h.db, &models.EventDistrict{}, "district_id = ?", district.ID)
+		}},
+		{"District had subcounties in the past; set its effective to date instead", func() (int64, error) {
+			return countRows(h.db, &models.SubcountyDistrictHistory{}, "district_id = ?", district.ID)
+		}},
+	}, func(tx *gorm.DB) error {
+		if err := tx.Where("level = ? AND unit_id = ?", adminunits.LevelDistrict, district.ID).Delete(&models.AdminUnitBoundary{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("district_id = ?", district.ID).Delete(&models.DistrictRegionHistory{}).Error
+	})
+}
+
+// CreateSubcounty creates a subcounty
+// @Summary Create subcounty
+// @Description Create a subcounty in a district (Admin only)
+// @Tags admin-units
+// @Accept json
+// @Produce json
+// @Param subcounty body models.Subcounty true "Subcounty"
+// @Success 201 {object} models.Subcounty
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/subcounties [post]
+func (h *AdminUnitsHandler) CreateSubcounty(c *fiber.Ctx) error {
+	var subcounty models.Subcounty
+	if ok, err := parseAdminUnit(c, &subcounty); !ok {
+		return err
+	}
+	subcounty.ID = 0
+	msg, err := validateSubcounty(h.db, &subcounty)
+	return h.saveAdminUnit(c, &subcounty, msg, err, fiber.StatusCreated, "Subcounty", func(tx *gorm.DB) error {
+		return adminunits.OpenSubcountyDistrict(tx, &subcounty)
+	})
+}
+
+// UpdateSubcounty updates a subcounty
+// @Summary Update subcounty
+// @Description Rename a subcounty, change its UID, centroid or effective dates, or move it to another district, as when a district splits. A move is recorded in the subcounty's district history from the effective date, so statistics as of earlier dates keep the old district (Admin only).
+// @Tags admin-units
+// @Accept json
+// @Produce json
+// @Param id path int true "Subcounty ID"
+// @Param effective_date query string false "Date a move to another district takes effect (YYYY-MM-DD, default today)"
+// @Param subcounty body models.Subcounty true "Subcounty"
+// @Success 200 {object} models.Subcounty
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/subcounties/{id} [put]
+func (h *AdminUnitsHandler) UpdateSubcounty(c *fiber.Ctx) error {
+	moveDate, ok, err := parseMoveDate(c)
+	if !ok {
+		return err
+	}
+	var subcounty models.Subcounty
+	if ok, err := h.findAdminUnit(c, &subcounty, "Subcounty"); !ok {
+		return err
+	}
+	id, districtID := subcounty.ID, subcounty.DistrictID
+	if ok, err := parseAdminUnit(c, &subcounty); !ok {
+		return err
+	}
+	subcounty.ID = id
+	msg, err := validateSubcounty(h.db, &subcounty)
+	return h.saveAdminUnit(c, &subcounty, msg, err, fiber.StatusOK, "Subcounty", func(tx *gorm.DB) error {
+		if subcounty.DistrictID == districtID {
+			return nil
+		}
+		return adminunits.MoveSubcounty(tx, subcounty.ID, subcounty.DistrictID, moveDate)
+	})
+}
+
+// DeleteSubcounty deletes a subcounty
+// @Summary Delete subcounty
+// @Description Delete a subcounty that has no facilities, no parishes and no alerts (Admin only)
+// @Tags admin-units
+// @Produce json
+// @Param id path int true "Subcounty ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 409 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/subcounties/{id} [delete]
+func (h *AdminUnitsHandler) DeleteSubcounty(c *fiber.Ctx) error {
+	var subcounty models.Subcounty
+	if ok, err := h.findAdminUnit(c, &subcounty, "Subcounty"); !ok {
+		return err
+	}
+	return h.deleteAdminUnit(c, &subcounty, "Subcounty", []adminUnitReference{
+		{"Subcounty has facilities", func() (int64, error) {
+			return countRows(h.db, &models.Facility{}, "subcounty_id = ?", subcounty.ID)
+		}},
+		{"Subcounty has parishes", func() (int64, error) {
+			return countRows(h.db, &models.Parish{}, "subcounty_id = ?", subcounty.ID)
+		}},
+		{"Subcounty is referenced by alerts", func() (int64, error) {
+			return countRows(h.db, &models.Alert{}, "subcounty_id = ?", subcounty.ID)
+		}},
+	}, func(tx *gorm.DB) error {
+		if err := tx.Where("level = ? AND unit_id = ?", adminunits.LevelSubcounty, subcounty.ID).Delete(&models.AdminUnitBoundary{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("subcounty_id = ?", subcounty.ID).Delete(&models.SubcountyDistrictHistory{}).Error
+	})
+}
+
+// CreateFacility creates a health facility
+// @Summary Create facility
+// @Description Create a health facility (Admin only)
+// @Tags admin-units
+// @Accept json
+// @Produce json
+// @Param facility body models.Facility true "Facility"
+// @Success 201 {object} models.Facility
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/facilities [post]
+func (h *AdminUnitsHandler) CreateFacility(c *fiber.Ctx) error {
+	var facility models.Facility
+	if ok, err := parseAdminUnit(c, &facility); !ok {
+		return err
+	}
+	facility.ID = 0
+	msg, err := validateFacility(h.db, &facility)
+	return h.saveAdminUnit(c, &facility, msg, err, fiber.StatusCreated, "Facility", nil)
+}
+
+// UpdateFacility updates a health facility
+// @Summary Update facility
+// @Description Rename a health facility, change its UID or ownership, or move it to another subcounty (Admin only)
+// @Tags admin-units
+// @Accept json
+// @Produce json
+// @Param id path int true "Facility ID"
+// @Param facility body models.Facility true "Facility"
+// @Success 200 {object} models.Facility
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/facilities/{id} [put]
+func (h *AdminUnitsHandler) UpdateFacility(c *fiber.Ctx) error {
+	var facility models.Facility
+	if ok, err := h.findAdminUnit(c, &facility, "Facility"); !ok {
+		return err
+	}
+	id := facility.ID
+	if ok, err := parseAdminUnit(c, &facility); !ok {
+		return err
+	}
+	facility.ID = id
+	msg, err := validateFacility(h.db, &facility)
+	return h.saveAdminUnit(c, &facility, msg, err, fiber.StatusOK, "Facility", nil)
+}
+
+// DeleteFacility deletes a health facility
+// @Summary Delete facility
+// @Description Delete a health facility no alert refers to (Admin only)
+// @Tags admin-units
+// @Produce json
+// @Param id path int true "Facility ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 409 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/facilities/{id} [delete]
+func (h *AdminUnitsHandler) DeleteFacility(c *fiber.Ctx) error {
+	var facility models.Facility
+	if ok, err := h.findAdminUnit(c, &facility, "Facility"); !ok {
+		return err
+	}
+	return h.deleteAdminUnit(c, &facility, "Facility", []adminUnitReference{
+		{"Facility is referenced by alerts", func() (int64, error) {
+			return countRows(h.db, &models.Alert{}, "facility_id = ?", facility.ID)
+		}},
+	}, nil)
+}
+
+// CreateParish creates a parish
+// @Summary Create parish
+// @Description Create a parish in a subcounty (Admin only)
+// @Tags admin-units
+// @Accept json
+// @Produce json
+// @Param parish body models.Parish true "Parish"
+// @Success 201 {object} models.Parish
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/parishes [post]
+func (h *AdminUnitsHandler) CreateParish(c *fiber.Ctx) error {
+	var parish models.Parish
+	if ok, err := parseAdminUnit(c, &parish); !ok {
+		return err
+	}
+	parish.ID = 0
+	msg, err := validateParish(h.db, &parish)
+	return h.saveAdminUnit(c, &parish, msg, err, fiber.StatusCreated, "Parish", nil)
+}
+
+// UpdateParish updates a parish
+// @Summary Update parish
+// @Description Rename a parish, change its UID or effective dates, or move it to another subcounty (Admin only)
+// @Tags admin-units
+// @Accept json
+// @Produce json
+// @Param id path int true "Parish ID"
+// @Param parish body models.Parish true "Parish"
+// @Success 200 {object} models.Parish
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/parishes/{id} [put]
+func (h *AdminUnitsHandler) UpdateParish(c *fiber.Ctx) error {
+	var parish models.Parish
+	if ok, err := h.findAdminUnit(c, &parish, "Parish"); !ok {
+		return err
+	}
+	id := parish.ID
+	if ok, err := parseAdminUnit(c, &parish); !ok {
+		return err
+	}
+	parish.ID = id
+	msg, err := validateParish(h.db, &parish)
+	return h.saveAdminUnit(c, &parish, msg, err, fiber.StatusOK, "Parish", nil)
+}
+
+// DeleteParish deletes a parish
+// @Summary Delete parish
+// @Description Delete a parish that has no villages and no alerts (Admin only)
+// @Tags admin-units
+// @Produce json
+// @Param id path int true "Parish ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 409 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/parishes/{id} [delete]
+func (h *AdminUnitsHandler) DeleteParish(c *fiber.Ctx) error {
+	var parish models.Parish
+	if ok, err := h.findAdminUnit(c, &parish, "Parish"); !ok {
+		return err
+	}
+	return h.deleteAdminUnit(c, &parish, "Parish", []adminUnitReference{
+		{"Parish has villages", func() (int64, error) {
+			return countRows(h.db, &models.Village{}, "parish_id = ?", parish.ID)
+		}},
+		{"Parish is referenced by alerts", func() (int64, error) {
+			return countRows(h.db, &models.Alert{}, "parish_id = ?", parish.ID)
+		}},
+	}, nil)
+}
+
+// CreateVillage creates a village
+// @Summary Create village
+// @Description Create a village in a parish (Admin only)
+// @Tags admin-units
+// @Accept json
+// @Produce json
+// @Param village body models.Village true "Village"
+// @Success 201 {object} models.Village
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/villages [post]
+func (h *AdminUnitsHandler) CreateVillage(c *fiber.Ctx) error {
+	var village models.Village
+	if ok, err := parseAdminUnit(c, &village); !ok {
+		return err
+	}
+	village.ID = 0
+	msg, err := validateVillage(h.db, &village)
+	return h.saveAdminUnit(c, &village, msg, err, fiber.StatusCreated, "Village", nil)
+}
+
+// UpdateVillage updates a village
+// @Summary Update village
+// @Description Rename a village, change its UID, centroid or effective dates, or move it to another parish (Admin only)
+// @Tags admin-units
+// @Accept json
+// @Produce json
+// @Param id path int true "Village ID"
+// @Param village body models.Village true "Village"
+// @Success 200 {object} models.Village
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/villages/{id} [put]
+func (h *AdminUnitsHandler) UpdateVillage(c *fiber.Ctx) error {
+	var village models.Village
+	if ok, err := h.findAdminUnit(c, &village, "Village"); !ok {
+		return err
+	}
+	id := village.ID
+	if ok, err := parseAdminUnit(c, &village); !ok {
+		return err
+	}
+	village.ID = id
+	msg, err := validateVillage(h.db, &village)
+	return h.saveAdminUnit(c, &village, msg, err, fiber.StatusOK, "Village", nil)
+}
+
+// DeleteVillage deletes a village
+// @Summary Delete village
+// @Description Delete a village no alert refers to (Admin only)
+// @Tags admin-units
+// @Produce json
+// @Param id path int true "Village ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 409 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/villages/{id} [delete]
+func (h *AdminUnitsHandler) DeleteVillage(c *fiber.Ctx) error {
+	var village models.Village
+	if ok, err := h.findAdminUnit(c, &village, "Village"); !ok {
+		return err
+	}
+	return h.deleteAdminUnit(c, &village, "Village", []adminUnitReference{
+		{"Village is referenced by alerts", func() (int64, error) {
+			return countRows(h.db, &models.Alert{}, "village_id = ?", village.ID)
+		}},
+	}, nil)
+}
+
+// errImportChanged is returned when an import no longer matches its preview
+var errImportChanged = errors.New("import changed since preview")
+
+// readOrgUnitImport reads a DHIS2 organisationUnits export from the file
+// form field or the request body, and the level mapping from the query.
+// When the import cannot be read the error response is written and ok is
+// false.
+func readOrgUnitImport(c *fiber.Ctx) (units []adminunits.OrgUnit, levels adminunits.Levels, ok bool, err error) {
+	data := c.Body()
+	if form, formErr := c.MultipartForm(); formErr == nil && len(form.File["file"]) > 0 {
+		file, openErr := form.File["file"][0].Open()
+		if openErr == nil {
+			data, openErr = io.ReadAll(file)
+			file.Close()
+		}
+		if openErr != nil {
+			return nil, levels, false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Failed to read import file",
+				"details": openErr.Error(),
+			})
+		}
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return nil, levels, false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "An organisationUnits export is required",
+		})
+	}
+
+	levels = adminunits.DefaultLevels
+	for name, level := range map[string]*int{
+		"region_level":    &levels.Region,
+		"district_level":  &levels.District,
+		"subcounty_level": &levels.Subcounty,
+		"facility_level":  &levels.Facility,
+	} {
+		if value := c.Query(name); value != "" {
+			if *level, err = strconv.Atoi(value); err != nil || *level < 1 {
+				return nil, levels, false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid " + strings.ReplaceAll(name, "_", " "),
+				})
+			}
+		}
+	}
+
+	units, err = adminunits.ParseOrgUnits(data)
+	if err != nil {
+		return nil, levels, false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid organisationUnits export",
+			"details": err.Error(),
+		})
+	}
+	return units, levels, true, nil
+}
+
+// PreviewOrgUnitImport compares a DHIS2 export with the stored units
+// @Summary Preview DHIS2 org unit import
+// @Description Compare a DHIS2 organisationUnits export (JSON or CSV) with the stored units, matching on UID, and list the units that would be added, renamed or moved without changing anything (Admin only)
+// @Tags admin-units
+// @Accept json,text/csv,multipart/form-data
+// @Produce json
+// @Param file formData file false "organisationUnits export, or send it as the body"
+// @Param region_level query int false "DHIS2 level of regions (default 2)"
+// @Param district_level query int false "DHIS2 level of districts (default 3)"
+// @Param subcounty_level query int false "DHIS2 level of subcounties (default 4)"
+// @Param facility_level query int false "DHIS2 level of facilities (default 5)"
+// @Success 200 {object} adminunits.Diff
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/import/preview [post]
+func (h *AdminUnitsHandler) PreviewOrgUnitImport(c *fiber.Ctx) error {
+	imported, levels, ok, err := readOrgUnitImport(c)
+	if !ok {
+		return err
+	}
+	units, err := adminunits.Load(h.db)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch administrative units",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(units.Diff(imported, levels))
+}
+
+// ApplyOrgUnitImport applies a DHIS2 export to the stored units
+// @Summary Apply DHIS2 org unit import
+// @Description Add, rename and move units to match a DHIS2 organisationUnits export. Units listed as problems are skipped and nothing is deleted. Pass the checksum of the preview to apply only if the changes are still the ones previewed (Admin only).
+// @Tags admin-units
+// @Accept json,text/csv,multipart/form-data
+// @Produce json
+// @Param file formData file false "organisationUnits export, or send it as the body"
+// @Param checksum query string false "Checksum from the preview"
+// @Param effective_date query string false "Date the moves and added units take effect (YYYY-MM-DD); moves default to today and added units to having always existed"
+// @Param region_level query int false "DHIS2 level of regions (default 2)"
+// @Param district_level query int false "DHIS2 level of districts (default 3)"
+// @Param subcounty_level query int false "DHIS2 level of subcounties (default 4)"
+// @Param facility_level query int false "DHIS2 level of facilities (default 5)"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 409 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/import [post]
+func (h *AdminUnitsHandler) ApplyOrgUnitImport(c *fiber.Ctx) error {
+	effective, msg := queryDate(c, "effective_date")
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+	imported, levels, ok, err := readOrgUnitImport(c)
+	if !ok {
+		return err
+	}
+
+	var diff adminunits.Diff
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		units, err := adminunits.Load(tx)
+		if err != nil {
+			return err
+		}
+		diff = units.Diff(imported, levels)
+		if checksum := c.Query("checksum"); checksum != "" && checksum != diff.Checksum {
+			return errImportChanged
+		}
+		return adminunits.Apply(tx, diff, effective)
+	})
+	if err == errImportChanged {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "The units have changed since the preview; preview the import again",
+			"diff":  diff,
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to apply import",
+			"details": err.Error(),
+		})
+	}
+	h.units.Invalidate()
+
+	return c.JSON(fiber.Map{
+		"message": "Import applied successfully",
+		"diff":    diff,
+	})
+}
@@ -10,16 +10,19 @@ import (
 	"github.com/alertsMIS/backend/internal/models"
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // AlertHandler handles alert-related HTTP requests
 type AlertHandler struct {
-	db *gorm.DB
+	db             *gorm.DB
+	requireVillage bool
 }
 
-// NewAlertHandler creates a new AlertHandler
-func NewAlertHandler(db *gorm.DB) *AlertHandler {
-	return &AlertHandler{db: db}
+// NewAlertHandler creates a new AlertHandler. When requireVillage is set,
+// alerts are only accepted with a known village for the case.
+func NewAlertHandler(db *gorm.DB, requireVillage bool) *AlertHandler {
+	return &AlertHandler{db: db, requireVillage: requireVillage}
 }
 
 // generateToken creates a secure random token for alert verification
@@ -31,6 +34,23 @@ func (h *AlertHandler) generateToken() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
+// findAlert loads an alert by the id route parameter. When the alert cannot be
+// loaded the error response is written and ok is false.
+func findAlert(db *gorm.DB, c *fiber.Ctx, alert *models.Alert) (bool, error) {
+	if err := db.First(alert, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Alert not found",
+			})
+		}
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch alert",
+			"details": err.Error(),
+		})
+	}
+	return true, nil
+}
+
 // CreateAlert handles alert creation
 // @Summary Create a new alert
 // @Description Create a new disease alert
@@ -52,6 +72,12 @@ func (h *AlertHandler) CreateAlert(c *fiber.Ctx) error {
 		})
 	}
 
+	// Alerts are assigned through the assignment endpoints, which keep the
+	// history and notify the assignee, patients are linked through the
+	// patient endpoints and lab results come from the laboratory
+	alert.AssignedTo, alert.PatientID = nil, nil
+	alert.LabResult, alert.LabResultDate = nil, nil
+
 	// Set default values
 	now := time.Now()
 	if alert.Date == nil {
@@ -81,6 +107,49 @@ func (h *AlertHandler) CreateAlert(c *fiber.Ctx) error {
 		})
 	}
 
+	if msg := validateLocation(alert); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+	if msg, err := resolveAlertAdminUnits(h.db, alert, h.requireVillage); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to resolve administrative units",
+			"details": err.Error(),
+		})
+	} else if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+	if msg, err := validateAlertEvent(h.db, alert); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch event",
+			"details": err.Error(),
+		})
+	} else if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	// Classify against the case definitions
+	if err := classifyAlert(h.db, alert); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to classify alert",
+			"details": err.Error(),
+		})
+	}
+
+	// Coordinates given with a new alert are always its own
+	alert.LocationSource = nil
+	if err := locateAlert(h.db, alert); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to locate alert",
+			"details": err.Error(),
+		})
+	}
+
 	if err := h.db.Create(alert).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error":   "Failed to create alert",
@@ -91,6 +160,93 @@ func (h *AlertHandler) CreateAlert(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusCreated).JSON(alert)
 }
 
+// filterAlerts applies the alert list filters given as query parameters. A
+// region or district given by name or ID matches alerts linked to the unit as
+// well as older alerts that only record its name, with or without the level
+// suffix. Given a hierarchy, regions and districts are matched as bounded on
+// its date rather than by the units the alerts were linked to.
+func filterAlerts(db *gorm.DB, c *fiber.Ctx, query *gorm.DB, boundaries *hierarchy) *gorm.DB {
+	var regionColumn, districtColumn interface{} = clause.Column{Name: "region_id"}, clause.Column{Name: "district_id"}
+	if boundaries != nil {
+		regionColumn, districtColumn = boundaries.region(), boundaries.district()
+	}
+
+	if region := c.Query("region"); region != "" {
+		unit, found, err := findRegion(db, region)
+		switch {
+		case err != nil:
+			query.AddError(err)
+		case found:
+			names := []string{unit.Region, strings.TrimSuffix(unit.Region, " Region")}
+			query = query.Where("? = ? OR (region_id IS NULL AND TRIM(region) IN ?)", regionColumn, unit.ID, names)
+		default:
+			query = query.Where("TRIM(region) = ?", strings.TrimSpace(region))
+		}
+	}
+	if district := c.Query("district"); district != "" {
+		unit, found, err := findDistrict(db, district)
+		switch {
+		case err != nil:
+			query.AddError(err)
+		case found:
+			names := []string{unit.District, strings.TrimSuffix(unit.District, " District")}
+			query = query.Where("? = ? OR (district_id IS NULL AND TRIM(alert_case_district) IN ?)", districtColumn, unit.ID, names)
+		default:
+			query = query.Where("TRIM(alert_case_district) = ?", strings.TrimSpace(district))
+		}
+	}
+	if regionID := c.Query("region_id"); regionID != "" {
+		query = query.Where("? = ?", regionColumn, regionID)
+	}
+	if districtID := c.Query("district_id"); districtID != "" {
+		query = query.Where("? = ?", districtColumn, districtID)
+	}
+	if subcountyID := c.Query("subcounty_id"); subcountyID != "" {
+		query = query.Where("subcounty_id = ?", subcountyID)
+	}
+	if parishID := c.Query("parish_id"); parishID != "" {
+		query = query.Where("parish_id = ?", parishID)
+	}
+	if villageID := c.Query("village_id"); villageID != "" {
+		query = query.Where("village_id = ?", villageID)
+	}
+	if facilityID := c.Query("facility_id"); facilityID != "" {
+		query = query.Where("facility_id = ?", facilityID)
+	}
+	if fromDate := c.Query("from_date"); fromDate != "" {
+		query = query.Where("date >= ?", fromDate)
+	}
+	if toDate := c.Query("to_date"); toDate != "" {
+		query = query.Where("date <= ?", toDate)
+	}
+	if alertID := c.Query("alert_id"); alertID != "" {
+		query = query.Where("id = ?", alertID)
+	}
+	if alertCaseName := c.Query("alert_case_name"); alertCaseName != "" {
+		query = query.Where("alert_case_name LIKE ?", "%"+alertCaseName+"%")
+	}
+	if personReporting := c.Query("person_reporting"); personReporting != "" {
+		query = query.Where("person_reporting LIKE ?", "%"+personReporting+"%")
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if isVerified := c.Query("is_verified"); isVerified != "" {
+		verified, _ := strconv.ParseBool(isVerified)
+		query = query.Where("is_verified = ?", verified)
+	}
+	if classification := c.Query("classification"); classification != "" {
+		query = query.Where("classification = ?", classification)
+	}
+	if syndrome := c.Query("syndrome"); syndrome != "" {
+		query = query.Where("FIND_IN_SET(?, syndromes)", strings.ToUpper(syndrome))
+	}
+	if eventID := c.Query("event_id"); eventID != "" {
+		query = query.Where("event_id = ?", eventID)
+	}
+	return query
+}
+
 // GetAlerts handles retrieving alerts with filtering and pagination
 // @Summary Get alerts
 // @Description Get all alerts with optional filtering and pagination
@@ -99,8 +255,14 @@ func (h *AlertHandler) CreateAlert(c *fiber.Ctx) error {
 // @Produce json
 // @Param page query int false "Page number"
 // @Param limit query int false "Number of records per page"
-// @Param region query string false "Filter by region"
-// @Param district query string false "Filter by district"
+// @Param region query string false "Filter by region name or ID"
+// @Param district query string false "Filter by district name or ID"
+// @Param region_id query int false "Filter by linked region"
+// @Param district_id query int false "Filter by linked district"
+// @Param subcounty_id query int false "Filter by linked subcounty"
+// @Param parish_id query int false "Filter by linked parish"
+// @Param village_id query int false "Filter by linked village"
+// @Param facility_id query int false "Filter by linked facility"
 // @Param from_date query string false "Filter from date (YYYY-MM-DD)"
 // @Param to_date query string false "Filter to date (YYYY-MM-DD)"
 // @Param alert_id query int false "Filter by alert ID"
@@ -108,10 +270,23 @@ func (h *AlertHandler) CreateAlert(c *fiber.Ctx) error {
 // @Param person_reporting query string false "Filter by person reporting"
 // @Param status query string false "Filter by status"
 // @Param is_verified query bool false "Filter by verification status"
+// @Param classification query string false "Filter by case classification"
+// @Param syndrome query string false "Filter by matched syndrome code"
+// @Param event_id query int false "Filter by event"
+// @Param as_of query string false "Match regions and districts as bounded on this date (YYYY-MM-DD)"
+// @Param sort query string false "priority to sort by risk score, highest first"
 // @Success 200 {array} models.Alert
+// @Failure 400 {object} fiber.Map
 // @Failure 500 {object} fiber.Map
 // @Router /api/v1/alerts [get]
 func (h *AlertHandler) GetAlerts(c *fiber.Ctx) error {
+	boundaries, msg := optionalHierarchy(c)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
 	var alerts []models.Alert
 	query := h.db.Model(&models.Alert{})
 
@@ -121,37 +296,72 @@ func (h *AlertHandler) GetAlerts(c *fiber.Ctx) error {
 	offset := (page - 1) * limit
 
 	// Apply filters
-	if region := c.Query("region"); region != "" {
-		query = query.Where("region = ?", region)
-	}
-	if district := c.Query("district"); district != "" {
-		query = query.Where("alert_case_district = ?", district)
-	}
-	if fromDate := c.Query("from_date"); fromDate != "" {
-		query = query.Where("date >= ?", fromDate)
-	}
-	if toDate := c.Query("to_date"); toDate != "" {
-		query = query.Where("date <= ?", toDate)
-	}
-	if alertID := c.Query("alert_id"); alertID != "" {
-		query = query.Where("id = ?", alertID)
-	}
-	if alertCaseName := c.Query("alert_case_name"); alertCaseName != "" {
-		query = query.Where("alert_case_name LIKE ?", "%"+alertCaseName+"%")
-	}
-	if personReporting := c.Query("person_reporting"); personReporting != "" {
-		query = query.Where("person_reporting LIKE ?", "%"+personReporting+"%")
+	query = filterAlerts(h.db, c, query, boundaries)
+
+	// Apply pagination and ordering. Sorting by priority puts the riskiest
+	// alerts first and, among equals, those waiting longest.
+	switch c.Query("sort") {
+	case "priority":
+		query = query.Order("risk_score DESC").Order("created_at ASC")
+	default:
+		query = query.Order("date DESC")
 	}
-	if status := c.Query("status"); status != "" {
-		query = query.Where("status = ?", status)
+	query = query.Offset(offset).Limit(limit)
+
+	if err := query.Find(&alerts).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch alerts",
+			"details": err.Error(),
+		})
 	}
-	if isVerified := c.Query("is_verified"); isVerified != "" {
-		verified, _ := strconv.ParseBool(isVerified)
-		query = query.Where("is_verified = ?", verified)
+
+	return c.JSON(alerts)
+}
+
+// GetAlertsGeoJSON returns the located alerts as a GeoJSON FeatureCollection
+// @Summary Get alerts as GeoJSON
+// @Description Get located alerts as GeoJSON points, honouring the same filters as GET /alerts. Results are only paginated when page or limit is given.
+// @Tags alerts
+// @Produce json
+// @Param page query int false "Page number"
+// @Param limit query int false "Number of records per page"
+// @Param region query string false "Filter by region name or ID"
+// @Param district query string false "Filter by district name or ID"
+// @Param region_id query int false "Filter by linked region"
+// @Param district_id query int false "Filter by linked district"
+// @Param subcounty_id query int false "Filter by linked subcounty"
+// @Param parish_id query int false "Filter by linked parish"
+// @Param village_id query int false "Filter by linked village"
+// @Param facility_id query int false "Filter by linked facility"
+// @Param from_date query string false "Filter from date (YYYY-MM-DD)"
+// @Param to_date query string false "Filter to date (YYYY-MM-DD)"
+// @Param status query string false "Filter by status"
+// @Param is_verified query bool false "Filter by verification status"
+// @Param classification query string false "Filter by case classification"
+// @Param syndrome query string false "Filter by matched syndrome code"
+// @Param event_id query int false "Filter by event"
+// @Param as_of query string false "Match regions and districts as bounded on this date (YYYY-MM-DD)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/alerts.geojson [get]
+func (h *AlertHandler) GetAlertsGeoJSON(c *fiber.Ctx) error {
+	boundaries, msg := optionalHierarchy(c)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
 	}
+	var alerts []models.Alert
+	query := filterAlerts(h.db, c, h.db.Model(&models.Alert{}), boundaries).
+		Where("latitude IS NOT NULL AND longitude IS NOT NULL").
+		Order("date DESC")
 
-	// Apply pagination and ordering
-	query = query.Order("date DESC").Offset(offset).Limit(limit)
+	if c.Query("page") != "" || c.Query("limit") != "" {
+		page, _ := strconv.Atoi(c.Query("page", "1"))
+		limit, _ := strconv.Atoi(c.Query("limit", "50"))
+		query = query.Offset((page - 1) * limit).Limit(limit)
+	}
 
 	if err := query.Find(&alerts).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -160,7 +370,32 @@ func (h *AlertHandler) GetAlerts(c *fiber.Ctx) error {
 		})
 	}
 
-	return c.JSON(alerts)
+	features := make([]geoJSONFeature, 0, len(alerts))
+	for _, alert := range alerts {
+		features = append(features, geoJSONFeature{
+			Type:     "Feature",
+			ID:       alert.ID,
+			Geometry: pointGeometry(*alert.Latitude, *alert.Longitude),
+			Properties: map[string]interface{}{
+				"id":               alert.ID,
+				"date":             alert.Date,
+				"status":           alert.Status,
+				"region":           alert.Region,
+				"district":         alert.AlertCaseDistrict,
+				"subCounty":        alert.AlertCaseSubCounty,
+				"village":          alert.AlertCaseVillage,
+				"syndromes":        alert.Syndromes,
+				"classification":   alert.Classification,
+				"priority":         alert.Priority,
+				"labResult":        alert.LabResult,
+				"isVerified":       alert.IsVerified,
+				"locationSource":   alert.LocationSource,
+				"locationAccuracy": alert.LocationAccuracy,
+			},
+		})
+	}
+
+	return c.JSON(newFeatureCollection(features), geoJSONContentType)
 }
 
 // GetAlert handles retrieving a single alert
@@ -189,6 +424,15 @@ func (h *AlertHandler) GetAlert(c *fiber.Ctx) error {
 		})
 	}
 
+	clusters, err := alertClusters(h.db, alert.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch alert clusters",
+			"details": err.Error(),
+		})
+	}
+	alert.Clusters = clusters
+
 	return c.JSON(alert)
 }
 
@@ -221,12 +465,70 @@ func (h *AlertHandler) UpdateAlert(c *fiber.Ctx) error {
 		})
 	}
 
+	latitude, longitude := alert.Latitude, alert.Longitude
+	adminUnits := adminUnitsOf(&alert)
+	assignedTo, patientID := alert.AssignedTo, alert.PatientID
+	labResult, labResultDate := alert.LabResult, alert.LabResultDate
 	if err := c.BodyParser(&alert); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error":   "Invalid request body",
 			"details": err.Error(),
 		})
 	}
+	// The assignee is changed through the assignment endpoints, the patient
+	// by converting the alert to a case and the lab result by the laboratory
+	alert.AssignedTo, alert.PatientID = assignedTo, patientID
+	alert.LabResult, alert.LabResultDate = labResult, labResultDate
+
+	if msg := validateLocation(&alert); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	// A renamed district or subcounty is looked up again unless its ID was
+	// changed with it
+	clearChangedAdminUnits(adminUnits, &alert)
+	if msg, err := resolveAlertAdminUnits(h.db, &alert, h.requireVillage); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to resolve administrative units",
+			"details": err.Error(),
+		})
+	} else if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+	if msg, err := validateAlertEvent(h.db, &alert); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch event",
+			"details": err.Error(),
+		})
+	} else if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	// Classify against the case definitions
+	if err := classifyAlert(h.db, &alert); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to classify alert",
+			"details": err.Error(),
+		})
+	}
+
+	// New coordinates replace a centroid; otherwise the centroid follows the
+	// case's subcounty and district
+	if coordinatesChanged(latitude, alert.Latitude) || coordinatesChanged(longitude, alert.Longitude) {
+		alert.LocationSource = nil
+	}
+	if err := locateAlert(h.db, &alert); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to locate alert",
+			"details": err.Error(),
+		})
+	}
 
 	if err := h.db.Save(&alert).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -323,6 +625,9 @@ func (h *AlertHandler) VerifyAlert(c *fiber.Ctx) error {
 		Actions                    string    `json:"actions"`
 		Feedback                   string    `json:"feedback"`
 		VerifiedBy                 string    `json:"verifiedBy"`
+		Latitude                   *float64  `json:"latitude"`
+		Longitude                  *float64  `json:"longitude"`
+		LocationAccuracy           *float64  `json:"locationAccuracy"`
 	}
 
 	if err := c.BodyParser(&input); err != nil {
@@ -367,6 +672,8 @@ func (h *AlertHandler) VerifyAlert(c *fiber.Ctx) error {
 		})
 	}
 
+	adminUnits := adminUnitsOf(&alert)
+
 	// Update alert with verification data
 	alert.Status = &input.Status
 	alert.VerificationDate = &input.VerificationDate
@@ -398,6 +705,44 @@ func (h *AlertHandler) VerifyAlert(c *fiber.Ctx) error {
 	alert.Feedback = &input.Feedback
 	alert.IsVerified = true
 	alert.VerifiedBy = &input.VerifiedBy
+	if input.Latitude != nil || input.Longitude != nil {
+		alert.Latitude = input.Latitude
+		alert.Longitude = input.Longitude
+		alert.LocationAccuracy = input.LocationAccuracy
+		alert.LocationSource = nil
+	}
+
+	if msg := validateLocation(&alert); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+	clearChangedAdminUnits(adminUnits, &alert)
+	if msg, err := resolveAlertAdminUnits(h.db, &alert, h.requireVillage); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to resolve administrative units",
+			"details": err.Error(),
+		})
+	} else if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	// Classify against the case definitions
+	if err := classifyAlert(h.db, &alert); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to classify alert",
+			"details": err.Error(),
+		})
+	}
+
+	if err := locateAlert(h.db, &alert); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to locate alert",
+			"details": err.Error(),
+		})
+	}
 
 	// Start transaction
 	tx := h.db.Begin()
@@ -501,13 +846,19 @@ func (h *AlertHandler) GenerateVerificationToken(c *fiber.Ctx) error {
 // @Tags alerts
 // @Accept json
 // @Produce json
+// @Param event_id query int false "Filter by event"
 // @Success 200 {object} map[string]int
 // @Failure 500 {object} fiber.Map
 // @Router /api/v1/alerts/verified/count [get]
 func (h *AlertHandler) GetVerifiedAlertsCount(c *fiber.Ctx) error {
 	var count int64
 
-	if err := h.db.Model(&models.Alert{}).Where("is_verified = ? AND created_at >= ?", true, time.Now().Add(-1*time.Hour)).Count(&count).Error; err != nil {
+	query := h.db.Model(&models.Alert{}).Where("is_verified = ? AND created_at >= ?", true, time.Now().Add(-1*time.Hour))
+	if eventID := c.Query("event_id"); eventID != "" {
+		query = query.Where("event_id = ?", eventID)
+	}
+
+	if err := query.Count(&count).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error":   "Failed to fetch verified alerts count",
 			"details": err.Error(),
@@ -523,13 +874,19 @@ func (h *AlertHandler) GetVerifiedAlertsCount(c *fiber.Ctx) error {
 // @Tags alerts
 // @Accept json
 // @Produce json
+// @Param event_id query int false "Filter by event"
 // @Success 200 {object} map[string]int
 // @Failure 500 {object} fiber.Map
 // @Router /api/v1/alerts/not-verified/count [get]
 func (h *AlertHandler) GetNotVerifiedAlertsCount(c *fiber.Ctx) error {
 	var count int64
 
-	if err := h.db.Model(&models.Alert{}).Where("is_verified = ? AND created_at >= ?", false, time.Now().Add(-1*time.Hour)).Count(&count).Error; err != nil {
+	query := h.db.Model(&models.Alert{}).Where("is_verified = ? AND created_at >= ?", false, time.Now().Add(-1*time.Hour))
+	if eventID := c.Query("event_id"); eventID != "" {
+		query = query.Where("event_id = ?", eventID)
+	}
+
+	if err := query.Count(&count).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error":   "Failed to fetch unverified alerts count",
 			"details": err.Error(),
@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/adminunits"
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// statsIntervals maps the trend intervals to the format of their periods
+var statsIntervals = map[string]string{
+	"day":   "%Y-%m-%d",
+	"week":  "%x-W%v",
+	"month": "%Y-%m",
+}
+
+// StatsPeriod is the alert count of one period in a trend
+type StatsPeriod struct {
+	Period string `json:"period"`
+	Count  int64  `json:"count"`
+}
+
+// alertCountColumns selects the number of alerts and of deaths among them
+const alertCountColumns = "COUNT(*) AS count, COALESCE(SUM(LOWER(TRIM(status)) = 'dead'), 0) AS deaths"
+
+// alertCount is the number of alerts and deaths of a group
+type alertCount struct {
+	Count  int64
+	Deaths int64
+}
+
+// UnitStats is the alert count of an administrative unit. Alerts that are
+// not linked to a unit of the level are counted under ID 0.
+type UnitStats struct {
+	ID     uint          `json:"id"`
+	Name   string        `json:"name"`
+	Count  int64         `json:"count"`
+	Deaths int64         `json:"deaths"`
+	Series []StatsPeriod `json:"series,omitempty"`
+}
+
+// GetAlertStats counts alerts by administrative unit
+// @Summary Get alert statistics
+// @Description Count alerts and deaths by region, district or subcounty, optionally as a trend by day, week or month. Alerts are placed in the units bounded on the as_of date, so counts stay comparable when districts split or subcounties move: historical alerts are counted under the new structure, or under the old one with an earlier as_of. Accepts the alert list filters.
+// @Tags alerts
+// @Produce json
+// @Param group_by query string false "region, district (default) or subcounty"
+// @Param interval query string false "day, week or month to add a trend per unit"
+// @Param as_of query string false "Hierarchy date (YYYY-MM-DD, default today)"
+// @Param from_date query string false "Filter from date (YYYY-MM-DD)"
+// @Param to_date query string false "Filter to date (YYYY-MM-DD)"
+// @Param region_id query int false "Filter by region as of the hierarchy date"
+// @Param district_id query int false "Filter by district as of the hierarchy date"
+// @Param event_id query int false "Filter by event"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/alerts/stats [get]
+func (h *AlertHandler) GetAlertStats(c *fiber.Ctx) error {
+	asOf, msg := parseAsOf(c)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+	interval := strings.ToLower(c.Query("interval"))
+	periodFormat, ok := statsIntervals[interval]
+	if interval != "" && !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Interval must be day, week or month",
+		})
+	}
+
+	boundaries := hierarchyAsOf(asOf)
+	groupBy := strings.ToLower(c.Query("group_by", adminunits.LevelDistrict))
+	var unit interface{}
+	var table string
+	switch groupBy {
+	case adminunits.LevelRegion:
+		unit, table = boundaries.region(), models.Region{}.TableName()
+	case adminunits.LevelDistrict:
+		unit, table = boundaries.district(), models.District{}.TableName()
+	case adminunits.LevelSubcounty:
+		unit, table = clause.Column{Name: "subcounty_id"}, models.Subcounty{}.TableName()
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Group by must be region, district or subcounty",
+		})
+	}
+	fail := func(err error) error {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch alert statistics",
+			"details": err.Error(),
+		})
+	}
+
+	var rows []struct {
+		UnitID *uint
+		Count  int64
+		Deaths int64
+	}
+	err := filterAlerts(h.db, c, h.db.Model(&models.Alert{}), &boundaries).
+		Select("? AS unit_id, "+alertCountColumns, unit).
+		Group("unit_id").
+		Scan(&rows).Error
+	if err != nil {
+		return fail(err)
+	}
+
+	stats := make(map[uint]*UnitStats, len(rows))
+	ids := make([]uint, 0, len(rows))
+	var total int64
+	for _, row := range rows {
+		var id uint
+		if row.UnitID != nil {
+			id = *row.UnitID
+		}
+		if s, ok := stats[id]; ok {
+			s.Count += row.Count
+			s.Deaths += row.Deaths
+		} else {
+			stats[id] = &UnitStats{ID: id, Count: row.Count, Deaths: row.Deaths}
+			ids = append(ids, id)
+		}
+		total += row.Count
+	}
+
+	if interval != "" {
+		var periods []struct {
+			UnitID *uint
+			Period string
+			Count  int64
+		}
+		err := filterAlerts(h.db, c, h.db.Model(&models.Alert{}), &boundaries).
+			Select("? AS unit_id, DATE_FORMAT(date, ?) AS period, COUNT(*) AS count", unit, periodFormat).
+			Where("date IS NOT NULL").
+			Group("unit_id, period").
+			Order("period").
+			Scan(&periods).Error
+		if err != nil {
+			return fail(err)
+		}
+		for _, p := range periods {
+			var id uint
+			if p.UnitID != nil {
+				id = *p.UnitID
+			}
+			if s, ok := stats[id]; ok {
+				s.Series = append(s.Series, StatsPeriod{Period: p.Period, Count: p.Count})
+			}
+		}
+	}
+
+	var names []struct {
+		ID   uint
+		Name string
+	}
+	err = h.db.Table(table).Select("id, "+groupBy+" AS name").Where("id IN ?", ids).Scan(&names).Error
+	if err != nil {
+		return fail(err)
+	}
+	for _, n := range names {
+		stats[n.ID].Name = n.Name
+	}
+
+	units := make([]UnitStats, 0, len(stats))
+	for _, s := range stats {
+		units = append(units, *s)
+	}
+	sort.Slice(units, func(i, j int) bool {
+		if units[i].Count != units[j].Count {
+			return units[i].Count > units[j].Count
+		}
+		if units[i].Name != units[j].Name {
+			return units[i].Name < units[j].Name
+		}
+		return units[i].ID < units[j].ID
+	})
+
+	return c.JSON(fiber.Map{
+		"groupBy":  groupBy,
+		"interval": interval,
+		"asOf":     asOf.Format(adminunits.DateLayout),
+		"total":    total,
+		"units":    units,
+	})
+}
+
+// Hotspot is a parish or village with alerts, and its place in the hierarchy
+type Hotspot struct {
+	Level      string                `json:"level"`
+	ID         uint                  `json:"id"`
+	Name       string                `json:"name"`
+	Path       []adminunits.PathItem `json:"path"`
+	Label      string                `json:"label"`
+	Count      int64                 `json:"count"`
+	Deaths     int64                 `json:"deaths"`
+	FirstAlert *time.Time            `json:"firstAlert"`
+	LastAlert  *time.Time            `json:"lastAlert"`
+}
+
+// GetAlertHotspots ranks villages or parishes by their number of alerts
+// @Summary Get community hotspots
+// @Description Rank the villages or parishes with the most alerts, with deaths, first and last alert dates and the path from the region. Only alerts linked to a parish or village are counted. Accepts the alert list filters.
+// @Tags alerts
+// @Produce json
+// @Param level query string false "village (default) or parish"
+// @Param limit query int false "Number of hotspots (default 20, at most 100)"
+// @Param from_date query string false "Filter from date (YYYY-MM-DD)"
+// @Param to_date query string false "Filter to date (YYYY-MM-DD)"
+// @Param district_id query int false "Filter by linked district"
+// @Param subcounty_id query int false "Filter by linked subcounty"
+// @Param event_id query int false "Filter by event"
+// @Success 200 {array} Hotspot
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/alerts/hotspots [get]
+func (h *AlertHandler) GetAlertHotspots(c *fiber.Ctx) error {
+	boundaries, msg := optionalHierarchy(c)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+	level := strings.ToLower(c.Query("level", adminunits.LevelVillage))
+	var column string
+	switch level {
+	case adminunits.LevelVillage:
+		column = "village_id"
+	case adminunits.LevelParish:
+		column = "parish_id"
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Level must be village or parish",
+		})
+	}
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	fail := func(err error) error {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch hotspots",
+			"details": err.Error(),
+		})
+	}
+
+	var rows []struct {
+		UnitID     uint
+		Count      int64
+		Deaths     int64
+		FirstAlert *time.Time
+		LastAlert  *time.Time
+	}
+	err := filterAlerts(h.db, c, h.db.Model(&models.Alert{}), boundaries).
+		Select(column + ` AS unit_id, COUNT(*) AS count,
+			COALESCE(SUM(LOWER(TRIM(status)) = 'dead'), 0) AS deaths,
+			MIN(date) AS first_alert, MAX(date) AS last_alert`).
+		Where(column + " IS NOT NULL").
+		Group("unit_id").
+		Order("count DESC").Order("last_alert DESC").Order("unit_id").
+		Limit(limit).
+		Scan(&rows).Error
+	if err != nil {
+		return fail(err)
+	}
+
+	ids := make([]uint, len(rows))
+	for i, row := range rows {
+		ids[i] = row.UnitID
+	}
+	paths, err := communityPaths(h.db, level, ids)
+	if err != nil {
+		return fail(err)
+	}
+
+	hotspots := make([]Hotspot, 0, len(rows))
+	for _, row := range rows {
+		path := paths[row.UnitID]
+		names := make([]string, len(path))
+		for i, item := range path {
+			names[i] = item.Name
+		}
+		var name string
+		if len(path) > 0 {
+			name = path[len(path)-1].Name
+		}
+		hotspots = append(hotspots, Hotspot{
+			Level:      level,
+			ID:         row.UnitID,
+			Name:       name,
+			Path:       path,
+			Label:      strings.Join(names, adminunits.PathSeparator),
+			Count:      row.Count,
+			Deaths:     row.Deaths,
+			FirstAlert: row.FirstAlert,
+			LastAlert:  row.LastAlert,
+		})
+	}
+	return c.JSON(hotspots)
+}
+
+// communityPaths returns the path from the region to each of the given
+// parishes or villages, leaving out ancestors that cannot be found
+func communityPaths(db *gorm.DB, level string, ids []uint) (map[uint][]adminunits.PathItem, error) {
+	paths := make(map[uint][]adminunits.PathItem, len(ids))
+	if len(ids) == 0 {
+		return paths, nil
+	}
+
+	villageParish := make(map[uint]uint)
+	var villages []models.Village
+	parishIDs := ids
+	if level == adminunits.LevelVillage {
+		if err := db.Where("id IN ?", ids).Find(&villages).Error; err != nil {
+			return nil, err
+		}
+		parishIDs = make([]uint, 0, len(villages))
+		for _, v := range villages {
+			villageParish[v.ID] = v.ParishID
+			parishIDs = append(parishIDs, v.ParishID)
+		}
+	}
+	var parishes []models.Parish
+	if err := db.Where("id IN ?", parishIDs).Find(&parishes).Error; err != nil {
+		return nil, err
+	}
+	subcountyIDs := make([]uint, 0, len(parishes))
+	for _, p := range parishes {
+		subcountyIDs = append(subcountyIDs, p.SubcountyID)
+	}
+	var subcounties []models.Subcounty
+	if err := db.Where("id IN ?", subcountyIDs).Find(&subcounties).Error; err != nil {
+		return nil, err
+	}
+	districtIDs := make([]uint, 0, len(subcounties))
+	for _, s := range subcounties {
+		districtIDs = append(districtIDs, s.DistrictID)
+	}
+	var districts []models.District
+	if err := db.Where("id IN ?", districtIDs).Find(&districts).Error; err != nil {
+		return nil, err
+	}
+	regionIDs := make([]uint, 0, len(districts))
+	for _, d := range districts {
+		regionIDs = append(regionIDs, d.RegionID)
+	}
+	var regions []models.Region
+	if err := db.Where("id IN ?", regionIDs).Find(&regions).Error; err != nil {
+		return nil, err
+	}
+
+	regionPaths := make(map[uint][]adminunits.PathItem, len(regions))
+	for _, r := range regions {
+		regionPaths[r.ID] = []adminunits.PathItem{{Level: adminunits.LevelRegion, ID: r.ID, Name: r.Region}}
+	}
+	districtPaths := make(map[uint][]adminunits.PathItem, len(districts))
+	for _, d := range districts {
+		districtPaths[d.ID] = extendPath(regionPaths[d.RegionID], adminunits.LevelDistrict, d.ID, d.District)
+	}
+	subcountyPaths := make(map[uint][]adminunits.PathItem, len(subcounties))
+	for _, s := range subcounties {
+		subcountyPaths[s.ID] = extendPath(districtPaths[s.DistrictID], adminunits.LevelSubcounty, s.ID, s.Subcounty)
+	}
+	parishPaths := make(map[uint][]adminunits.PathItem, len(parishes))
+	for _, p := range parishes {
+		parishPaths[p.ID] = extendPath(subcountyPaths[p.SubcountyID], adminunits.LevelParish, p.ID, p.Parish)
+	}
+
+	if level == adminunits.LevelParish {
+		return parishPaths, nil
+	}
+	for _, v := range villages {
+		paths[v.ID] = extendPath(parishPaths[villageParish[v.ID]], adminunits.LevelVillage, v.ID, v.Village)
+	}
+	return paths, nil
+}
+
+// extendPath returns a copy of a path with one more unit at the end
+func extendPath(path []adminunits.PathItem, level string, id uint, name string) []adminunits.PathItem {
+	extended := make([]adminunits.PathItem, len(path), len(path)+1)
+	copy(extended, path)
+	return append(extended, adminunits.PathItem{Level: level, ID: id, Name: name})
+}
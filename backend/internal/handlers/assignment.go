@@ -0,0 +1,449 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// AssignmentHandler handles alert assignment and work queue HTTP requests
+type AssignmentHandler struct {
+	db *gorm.DB
+}
+
+// NewAssignmentHandler creates a new AssignmentHandler
+func NewAssignmentHandler(db *gorm.DB) *AssignmentHandler {
+	return &AssignmentHandler{db: db}
+}
+
+// currentUser loads the authenticated user. When the user cannot be loaded
+// the error response is written and ok is false.
+func currentUser(db *gorm.DB, c *fiber.Ctx, user *models.User) (bool, error) {
+	if u, ok := c.Locals("user").(models.User); ok {
+		*user = u
+		return true, nil
+	}
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return false, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+	if err := db.First(user, userID).Error; err != nil {
+		return false, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	return true, nil
+}
+
+// userTeams returns the assignable teams the user belongs to
+func userTeams(user models.User) []string {
+	var teams []string
+	for _, team := range models.AssignableTeams {
+		if user.HasRole(team) {
+			teams = append(teams, team)
+		}
+	}
+	return teams
+}
+
+// teamAssignees returns the AssignedTo values of the given teams
+func teamAssignees(teams []string) []string {
+	assignees := make([]string, 0, len(teams))
+	for _, team := range teams {
+		assignees = append(assignees, models.TeamAssignee(team))
+	}
+	return assignees
+}
+
+// normalizeTeam matches a team name against the assignable teams
+func normalizeTeam(team string) (string, bool) {
+	for _, t := range models.AssignableTeams {
+		if strings.EqualFold(t, strings.TrimSpace(team)) {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+// notifyAssignee notifies the user or every member of the team in assignedTo
+func notifyAssignee(db *gorm.DB, assignedTo *string, n models.Notification) error {
+	userID, team := models.ParseAssignee(assignedTo)
+	if userID != 0 {
+		return notify(db, []uint{userID}, n)
+	}
+	if team == "" {
+		return nil
+	}
+	var ids []uint
+	if err := db.Model(&models.User{}).Where("level = ? OR user_type = ?", team, team).Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	return notify(db, ids, n)
+}
+
+// recordAssignment saves an entry in the alert's assignment history
+func recordAssignment(db *gorm.DB, alertID uint, action string, from, to *string, changedBy uint, reason *string) error {
+	return db.Create(&models.AlertAssignment{
+		AlertID:      alertID,
+		Action:       action,
+		FromAssignee: from,
+		ToAssignee:   to,
+		ChangedBy:    changedBy,
+		Reason:       reason,
+	}).Error
+}
+
+// AssignAlert assigns an alert to a user or a team
+// @Summary Assign alert
+// @Description Assign an alert to a user or a team; an empty body unassigns it
+// @Tags assignments
+// @Accept json
+// @Produce json
+// @Param id path int true "Alert ID"
+// @Param assignment body map[string]interface{} true "userId or team, and an optional reason"
+// @Success 200 {object} models.Alert
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/alerts/{id}/assign [post]
+func (h *AssignmentHandler) AssignAlert(c *fiber.Ctx) error {
+	var user models.User
+	if ok, err := currentUser(h.db, c, &user); !ok {
+		return err
+	}
+
+	var alert models.Alert
+	if ok, err := findAlert(h.db, c, &alert); !ok {
+		return err
+	}
+
+	var input struct {
+		UserID *uint   `json:"userId"`
+		Team   *string `json:"team"`
+		Reason *string `json:"reason"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+	if input.UserID != nil && input.Team != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Assign to either a user or a team, not both",
+		})
+	}
+
+	var assignee *string
+	var assigneeName string
+	switch {
+	case input.UserID != nil:
+		var target models.User
+		if err := h.db.First(&target, *input.UserID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Assigned user not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "Failed to fetch assigned user",
+				"details": err.Error(),
+			})
+		}
+		value := models.UserAssignee(target.ID)
+		assignee = &value
+		assigneeName = target.Username
+	case input.Team != nil:
+		team, ok := normalizeTeam(*input.Team)
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":       "Invalid team",
+				"valid_teams": models.AssignableTeams,
+			})
+		}
+		value := models.TeamAssignee(team)
+		assignee = &value
+		assigneeName = team + " team"
+	}
+
+	previous := alert.AssignedTo
+	if previous != nil && *previous == "" {
+		previous = nil
+	}
+	if (previous == nil && assignee == nil) || (previous != nil && assignee != nil && *previous == *assignee) {
+		return c.JSON(alert)
+	}
+
+	action := models.AssignmentAssigned
+	if assignee == nil {
+		action = models.AssignmentUnassigned
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&alert).Update("assigned_to", assignee).Error; err != nil {
+			return err
+		}
+		if err := recordAssignment(tx, alert.ID, action, previous, assignee, user.ID, input.Reason); err != nil {
+			return err
+		}
+		if assignee != nil {
+			if err := notifyAssignee(tx, assignee, models.Notification{
+				AlertID: &alert.ID,
+				Type:    NotificationAssignment,
+				Title:   fmt.Sprintf("Alert #%d assigned to %s", alert.ID, assigneeName),
+				Message: fmt.Sprintf("%s assigned alert #%d to %s.", user.Username, alert.ID, assigneeName),
+			}); err != nil {
+				return err
+			}
+		}
+		if previousUser, _ := models.ParseAssignee(previous); previousUser != 0 {
+			return notify(tx, []uint{previousUser}, models.Notification{
+				AlertID: &alert.ID,
+				Type:    NotificationAssignment,
+				Title:   fmt.Sprintf("Alert #%d is no longer assigned to you", alert.ID),
+				Message: fmt.Sprintf("%s reassigned alert #%d.", user.Username, alert.ID),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to assign alert",
+			"details": err.Error(),
+		})
+	}
+
+	alert.AssignedTo = assignee
+	return c.JSON(alert)
+}
+
+// ClaimAlert assigns an alert to the caller. Only unassigned alerts and alerts
+// assigned to one of the caller's teams can be claimed, so two users cannot
+// take the same alert.
+// @Summary Claim alert
+// @Description Take an unassigned or team-assigned alert
+// @Tags assignments
+// @Produce json
+// @Param id path int true "Alert ID"
+// @Success 200 {object} models.Alert
+// @Failure 404 {object} fiber.Map
+// @Failure 409 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/alerts/{id}/claim [post]
+func (h *AssignmentHandler) ClaimAlert(c *fiber.Ctx) error {
+	var user models.User
+	if ok, err := currentUser(h.db, c, &user); !ok {
+		return err
+	}
+
+	var alert models.Alert
+	if ok, err := findAlert(h.db, c, &alert); !ok {
+		return err
+	}
+
+	claimant := models.UserAssignee(user.ID)
+	if alert.AssignedTo != nil && *alert.AssignedTo == claimant {
+		return c.JSON(alert)
+	}
+
+	var claimed bool
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// The condition is checked by the update itself so that concurrent
+		// claims cannot both succeed
+		condition := tx.Where("assigned_to IS NULL OR assigned_to = ''")
+		if teams := userTeams(user); len(teams) > 0 {
+			condition = condition.Or("assigned_to IN ?", teamAssignees(teams))
+		}
+		result := tx.Model(&models.Alert{}).Where("id = ?", alert.ID).Where(condition).Update("assigned_to", claimant)
+		if result.Error != nil {
+			return result.Error
+		}
+		if claimed = result.RowsAffected > 0; !claimed {
+			return nil
+		}
+		return recordAssignment(tx, alert.ID, models.AssignmentClaimed, alert.AssignedTo, &claimant, user.ID, nil)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to claim alert",
+			"details": err.Error(),
+		})
+	}
+
+	if err := h.db.First(&alert, alert.ID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch alert",
+			"details": err.Error(),
+		})
+	}
+	if !claimed {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":      "Alert is already assigned to someone else",
+			"assignedTo": alert.AssignedTo,
+		})
+	}
+	return c.JSON(alert)
+}
+
+// UnclaimAlert releases an alert claimed by the caller. An alert claimed from
+// a team goes back to that team; otherwise it becomes unassigned.
+// @Summary Unclaim alert
+// @Description Release an alert previously claimed by the authenticated user
+// @Tags assignments
+// @Produce json
+// @Param id path int true "Alert ID"
+// @Success 200 {object} models.Alert
+// @Failure 404 {object} fiber.Map
+// @Failure 409 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/alerts/{id}/unclaim [post]
+func (h *AssignmentHandler) UnclaimAlert(c *fiber.Ctx) error {
+	var user models.User
+	if ok, err := currentUser(h.db, c, &user); !ok {
+		return err
+	}
+
+	var alert models.Alert
+	if ok, err := findAlert(h.db, c, &alert); !ok {
+		return err
+	}
+
+	claimant := models.UserAssignee(user.ID)
+	if alert.AssignedTo == nil || *alert.AssignedTo != claimant {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Alert is not assigned to you",
+		})
+	}
+
+	// Return the alert to the team it was claimed from, if any
+	var last models.AlertAssignment
+	err := h.db.Where("alert_id = ? AND to_assignee = ?", alert.ID, claimant).Order("id DESC").First(&last).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch assignment history",
+			"details": err.Error(),
+		})
+	}
+	var restored *string
+	if _, team := models.ParseAssignee(last.FromAssignee); last.Action == models.AssignmentClaimed && team != "" {
+		restored = last.FromAssignee
+	}
+
+	var released bool
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Alert{}).Where("id = ? AND assigned_to = ?", alert.ID, claimant).Update("assigned_to", restored)
+		if result.Error != nil {
+			return result.Error
+		}
+		if released = result.RowsAffected > 0; !released {
+			return nil
+		}
+		if err := recordAssignment(tx, alert.ID, models.AssignmentUnclaimed, &claimant, restored, user.ID, nil); err != nil {
+			return err
+		}
+		if restored == nil {
+			return nil
+		}
+		return notifyAssignee(tx, restored, models.Notification{
+			AlertID: &alert.ID,
+			Type:    NotificationAssignment,
+			Title:   fmt.Sprintf("Alert #%d returned to the team queue", alert.ID),
+			Message: fmt.Sprintf("%s released alert #%d.", user.Username, alert.ID),
+		})
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to unclaim alert",
+			"details": err.Error(),
+		})
+	}
+	if !released {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Alert is not assigned to you",
+		})
+	}
+
+	alert.AssignedTo = restored
+	return c.JSON(alert)
+}
+
+// GetAssignmentHistory fetches the assignment history of an alert
+// @Summary Get alert assignment history
+// @Description Get every assignment change of an alert, oldest first
+// @Tags assignments
+// @Produce json
+// @Param id path int true "Alert ID"
+// @Success 200 {array} models.AlertAssignment
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/alerts/{id}/assignments [get]
+func (h *AssignmentHandler) GetAssignmentHistory(c *fiber.Ctx) error {
+	var alert models.Alert
+	if ok, err := findAlert(h.db, c, &alert); !ok {
+		return err
+	}
+
+	var history []models.AlertAssignment
+	if err := h.db.Where("alert_id = ?", alert.ID).Order("id").Find(&history).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch assignment history",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(history)
+}
+
+// GetMyQueue fetches the open alerts assigned to the caller or their teams,
+// highest risk score first and then oldest first
+// @Summary Get my work queue
+// @Description Get the open (unverified) alerts assigned to the authenticated user or their teams
+// @Tags assignments
+// @Produce json
+// @Param scope query string false "mine, team or all (default: all)"
+// @Success 200 {array} models.Alert
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/me/queue [get]
+func (h *AssignmentHandler) GetMyQueue(c *fiber.Ctx) error {
+	var user models.User
+	if ok, err := currentUser(h.db, c, &user); !ok {
+		return err
+	}
+
+	var assignees []string
+	switch c.Query("scope", "all") {
+	case "mine":
+		assignees = []string{models.UserAssignee(user.ID)}
+	case "team":
+		assignees = teamAssignees(userTeams(user))
+	case "all":
+		assignees = append([]string{models.UserAssignee(user.ID)}, teamAssignees(userTeams(user))...)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":        "Invalid scope",
+			"valid_scopes": []string{"mine", "team", "all"},
+		})
+	}
+
+	alerts := []models.Alert{}
+	if len(assignees) == 0 {
+		return c.JSON(alerts)
+	}
+
+	err := h.db.Where("assigned_to IN ? AND is_verified = ?", assignees, false).
+		Order("risk_score DESC").
+		Order("created_at ASC").
+		Find(&alerts).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch work queue",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(alerts)
+}
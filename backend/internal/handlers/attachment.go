@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/alertsMIS/backend/internal/storage"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// allowedAttachmentTypes are the MIME types accepted for attachments: photos,
+// scanned forms and lab slips
+var allowedAttachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+// AttachmentHandler handles alert attachment HTTP requests
+type AttachmentHandler struct {
+	db      *gorm.DB
+	store   storage.Storage
+	maxSize int64
+}
+
+// NewAttachmentHandler creates a new AttachmentHandler
+func NewAttachmentHandler(db *gorm.DB, store storage.Storage, maxSize int64) *AttachmentHandler {
+	return &AttachmentHandler{db: db, store: store, maxSize: maxSize}
+}
+
+// findAttachment loads an attachment of the alert by the attachmentId route
+// parameter. When it cannot be loaded the error response is written and ok
+// is false.
+func (h *AttachmentHandler) findAttachment(c *fiber.Ctx, alert models.Alert, attachment *models.AlertAttachment) (bool, error) {
+	if err := h.db.Where("id = ? AND alert_id = ?", c.Params("attachmentId"), alert.ID).First(attachment).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Attachment not found",
+			})
+		}
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch attachment",
+			"details": err.Error(),
+		})
+	}
+	return true, nil
+}
+
+// GetAttachments lists the attachments of an alert
+// @Summary Get alert attachments
+// @Description List the files attached to an alert
+// @Tags attachments
+// @Produce json
+// @Param id path int true "Alert ID"
+// @Success 200 {array} models.AlertAttachment
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/alerts/{id}/attachments [get]
+func (h *AttachmentHandler) GetAttachments(c *fiber.Ctx) error {
+	var alert models.Alert
+	if ok, err := findAlert(h.db, c, &alert); !ok {
+		return err
+	}
+
+	var attachments []models.AlertAttachment
+	if err := h.db.Where("alert_id = ?", alert.ID).Order("created_at").Find(&attachments).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch attachments",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(attachments)
+}
+
+// UploadAttachment attaches a file to an alert. Uploading a file that is
+// already attached to the alert returns the existing attachment.
+// @Summary Upload alert attachment
+// @Description Attach a photo, scanned form or PDF to an alert
+// @Tags attachments
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path int true "Alert ID"
+// @Param file formData file true "File to attach"
+// @Success 200 {object} models.AlertAttachment
+// @Success 201 {object} models.AlertAttachment
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 413 {object} fiber.Map
+// @Failure 415 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/alerts/{id}/attachments [post]
+func (h *AttachmentHandler) UploadAttachment(c *fiber.Ctx) error {
+	var alert models.Alert
+	if ok, err := findAlert(h.db, c, &alert); !ok {
+		return err
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "File is required",
+			"details": err.Error(),
+		})
+	}
+	if header.Size > h.maxSize {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error":   "File is too large",
+			"maxSize": h.maxSize,
+		})
+	}
+	if header.Size == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "File is empty",
+		})
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Failed to read file",
+			"details": err.Error(),
+		})
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, h.maxSize+1))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Failed to read file",
+			"details": err.Error(),
+		})
+	}
+	if int64(len(data)) > h.maxSize {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error":   "File is too large",
+			"maxSize": h.maxSize,
+		})
+	}
+
+	// The type is sniffed from the contents rather than trusted from the client
+	contentType := http.DetectContentType(data)
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	if !allowedAttachmentTypes[contentType] {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error":       "File type is not allowed",
+			"contentType": contentType,
+		})
+	}
+
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	var attachment models.AlertAttachment
+	created := false
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockBlob(tx, checksum); err != nil {
+			return err
+		}
+		err := tx.Where("alert_id = ? AND checksum = ?", alert.ID, checksum).First(&attachment).Error
+		if err != gorm.ErrRecordNotFound {
+			return err
+		}
+
+		// Contents already stored for another alert are shared, not stored twice
+		var stored int64
+		if err := tx.Model(&models.AlertAttachment{}).Where("checksum = ?", checksum).Count(&stored).Error; err != nil {
+			return err
+		}
+		if stored == 0 {
+			if err := h.store.Save(checksum, bytes.NewReader(data)); err != nil {
+				return err
+			}
+		}
+
+		attachment = models.AlertAttachment{
+			AlertID:     alert.ID,
+			FileName:    filepath.Base(header.Filename),
+			ContentType: contentType,
+			Size:        int64(len(data)),
+			Checksum:    checksum,
+			UploadedBy:  c.Locals("user_id").(uint),
+		}
+		created = true
+		return tx.Create(&attachment).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to save attachment",
+			"details": err.Error(),
+		})
+	}
+	if !created {
+		return c.JSON(attachment)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(attachment)
+}
+
+// lockBlob locks the stored file of a checksum until the end of the
+// transaction. Uploads and deletes of attachments sharing the file take the
+// lock before counting them, so that the file is saved and deleted in turn.
+func lockBlob(tx *gorm.DB, checksum string) error {
+	blob := models.AttachmentBlob{Checksum: checksum}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&blob).Error; err != nil {
+		return err
+	}
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("checksum = ?", checksum).First(&blob).Error
+}
+
+// DownloadAttachment sends the contents of an attachment
+// @Summary Download alert attachment
+// @Description Download a file attached to an alert
+// @Tags attachments
+// @Produce octet-stream
+// @Param id path int true "Alert ID"
+// @Param attachmentId path int true "Attachment ID"
+// @Success 200 {file} file
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/alerts/{id}/attachments/{attachmentId} [get]
+func (h *AttachmentHandler) DownloadAttachment(c *fiber.Ctx) error {
+	var alert models.Alert
+	if ok, err := findAlert(h.db, c, &alert); !ok {
+		return err
+	}
+
+	var attachment models.AlertAttachment
+	if ok, err := h.findAttachment(c, alert, &attachment); !ok {
+		return err
+	}
+
+	reader, err := h.store.Open(attachment.Checksum)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Attachment file is missing from storage",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to open attachment",
+			"details": err.Error(),
+		})
+	}
+
+	c.Attachment(attachment.FileName)
+	c.Set(fiber.HeaderContentType, attachment.ContentType)
+	return c.SendStream(reader, int(attachment.Size))
+}
+
+// DeleteAttachment removes an attachment from an alert. The stored file is
+// deleted once no attachment refers to it.
+// @Summary Delete alert attachment
+// @Description Remove a file attached to an alert
+// @Tags attachments
+// @Produce json
+// @Param id path int true "Alert ID"
+// @Param attachmentId path int true "Attachment ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/alerts/{id}/attachments/{attachmentId} [delete]
+func (h *AttachmentHandler) DeleteAttachment(c *fiber.Ctx) error {
+	var alert models.Alert
+	if ok, err := findAlert(h.db, c, &alert); !ok {
+		return err
+	}
+
+	var attachment models.AlertAttachment
+	if ok, err := h.findAttachment(c, alert, &attachment); !ok {
+		return err
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockBlob(tx, attachment.Checksum); err != nil {
+			return err
+		}
+		if err := tx.Delete(&attachment).Error; err != nil {
+			return err
+		}
+		var remaining int64
+		if err := tx.Model(&models.AlertAttachment{}).Where("checksum = ?", attachment.Checksum).Count(&remaining).Error; err != nil {
+			return err
+		}
+		if remaining > 0 {
+			return nil
+		}
+		if err := tx.Delete(&models.AttachmentBlob{Checksum: attachment.Checksum}).Error; err != nil {
+			return err
+		}
+		// The file goes last, so that the attachment is kept if it cannot be
+		// deleted
+		return h.store.Delete(attachment.Checksum)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to delete attachment",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Attachment deleted successfully",
+	})
+}
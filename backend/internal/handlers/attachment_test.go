@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/dbtest"
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/alertsMIS/backend/internal/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+// testPNG is sniffed as a PNG image
+var testPNG = append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{1}, 64)...)
+
+// attachmentStore holds alerts 1 and 2 and their attachments in place of the
+// alert_attachments and attachment_blobs tables, locking blob rows until the
+// end of the transaction that selected them for update
+type attachmentStore struct {
+	t *testing.T
+
+	mu          sync.Mutex
+	unlocked    *sync.Cond
+	attachments map[uint]models.AlertAttachment
+	nextID      uint
+	blobs       map[string]bool
+	locks       map[string]*dbtest.Conn
+}
+
+func newAttachmentStore(t *testing.T) *attachmentStore {
+	s := &attachmentStore{
+		t:           t,
+		attachments: make(map[uint]models.AlertAttachment),
+		nextID:      1,
+		blobs:       make(map[string]bool),
+		locks:       make(map[string]*dbtest.Conn),
+	}
+	s.unlocked = sync.NewCond(&s.mu)
+	return s
+}
+
+func attachmentRows(attachments ...models.AlertAttachment) dbtest.Result {
+	result := dbtest.Result{
+		Columns: []string{"id", "alert_id", "file_name", "content_type", "size", "checksum", "uploaded_by", "created_at"},
+	}
+	for _, a := range attachments {
+		result.Rows = append(result.Rows, []driver.Value{
+			int64(a.ID), int64(a.AlertID), a.FileName, a.ContentType, a.Size, a.Checksum, int64(a.UploadedBy), a.CreatedAt,
+		})
+	}
+	return result
+}
+
+func (s *attachmentStore) handle(conn *dbtest.Conn, query string, args []driver.NamedValue) (dbtest.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	arg := func(i int) driver.Value { return args[i].Value }
+	switch {
+	case strings.HasPrefix(query, "SELECT * FROM `alerts` WHERE `alerts`.`id` = ?"):
+		if id := fmt.Sprint(arg(0)); id != "1" && id != "2" {
+			return dbtest.Result{}, nil
+		}
+		return dbtest.Result{Columns: []string{"id"}, Rows: [][]driver.Value{{arg(0)}}}, nil
+	case strings.HasPrefix(query, "INSERT INTO `attachment_blobs`"):
+		checksum := arg(0).(string)
+		if s.blobs[checksum] {
+			return dbtest.Result{}, nil
+		}
+		s.blobs[checksum] = true
+		return dbtest.Result{RowsAffected: 1}, nil
+	case strings.HasPrefix(query, "SELECT * FROM `attachment_blobs` WHERE checksum = ?") && strings.HasSuffix(query, "FOR UPDATE"):
+		if !conn.InTx() {
+			s.t.Error("blob locked outside a transaction")
+		}
+		checksum := arg(0).(string)
+		for s.locks[checksum] != nil && s.locks[checksum] != conn {
+			s.unlocked.Wait()
+		}
+		s.locks[checksum] = conn
+		conn.AtEnd(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			delete(s.locks, checksum)
+			s.unlocked.Broadcast()
+		})
+		if !s.blobs[checksum] {
+			return dbtest.Result{}, nil
+		}
+		return dbtest.Result{Columns: []string{"checksum"}, Rows: [][]driver.Value{{checksum}}}, nil
+	case strings.HasPrefix(query, "DELETE FROM `attachment_blobs` WHERE `attachment_blobs`.`checksum` = ?"):
+		s.checkLocked(conn, arg(0).(string))
+		delete(s.blobs, arg(0).(string))
+		return dbtest.Result{RowsAffected: 1}, nil
+	case strings.HasPrefix(query, "SELECT * FROM `alert_attachments` WHERE alert_id = ? AND checksum = ?"):
+		for _, a := range s.attachments {
+			if int64(a.AlertID) == arg(0) && a.Checksum == arg(1) {
+				return attachmentRows(a), nil
+			}
+		}
+		return attachmentRows(), nil
+	case strings.HasPrefix(query, "SELECT * FROM `alert_attachments` WHERE id = ? AND alert_id = ?"):
+		for _, a := range s.attachments {
+			if fmt.Sprint(a.ID) == fmt.Sprint(arg(0)) && int64(a.AlertID) == arg(1) {
+				return attachmentRows(a), nil
+			}
+		}
+		return attachmentRows(), nil
+	case strings.HasPrefix(query, "SELECT count(*) FROM `alert_attachments` WHERE checksum = ?"):
+		s.checkLocked(conn, arg(0).(string))
+		var count int64
+		for _, a := range s.attachments {
+			if a.Checksum == arg(0) {
+				count++
+			}
+		}
+		return dbtest.Result{Columns: []string{"count(*)"}, Rows: [][]driver.Value{{count}}}, nil
+	case strings.HasPrefix(query, "INSERT INTO `alert_attachments`"):
+		values := dbtest.InsertValues(query, args)
+		a := models.AlertAttachment{
+			ID:       s.nextID,
+			AlertID:  uint(values["alert_id"].(int64)),
+			FileName: values["file_name"].(string),
+			Size:     values["size"].(int64),
+			Checksum: values["checksum"].(string),
+		}
+		s.checkLocked(conn, a.Checksum)
+		s.attachments[a.ID] = a
+		s.nextID++
+		return dbtest.Result{RowsAffected: 1, LastInsertID: int64(a.ID)}, nil
+	case strings.HasPrefix(query, "DELETE FROM `alert_attachments` WHERE `alert_attachments`.`id` = ?"):
+		id := uint(arg(0).(int64))
+		s.checkLocked(conn, s.attachments[id].Checksum)
+		delete(s.attachments, id)
+		return dbtest.Result{RowsAffected: 1}, nil
+	}
+	s.t.Errorf("unexpected statement: %s", query)
+	return dbtest.Result{}, errors.New("unexpected statement")
+}
+
+// checkLocked fails the test when a statement about the file of a checksum
+// runs without its lock
+func (s *attachmentStore) checkLocked(conn *dbtest.Conn, checksum string) {
+	if s.locks[checksum] != conn {
+		s.t.Errorf("attachments of %s changed or counted without the blob lock", checksum[:8])
+	}
+}
+
+// memStorage stores objects in memory. beforeDelete, when set, runs before
+// an object is deleted.
+type memStorage struct {
+	mu           sync.Mutex
+	objects      map[string][]byte
+	beforeDelete func()
+}
+
+func (s *memStorage) Save(key string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+	return nil
+}
+
+func (s *memStorage) Open(key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *memStorage) Delete(key string) error {
+	if s.beforeDelete != nil {
+		s.beforeDelete()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *memStorage) has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.objects[key]
+	return ok
+}
+
+// newAttachmentApp serves the attachment routes to user 1
+func newAttachmentApp(t *testing.T) (*fiber.App, *memStorage) {
+	store := newAttachmentStore(t)
+	files := &memStorage{objects: make(map[string][]byte)}
+	h := NewAttachmentHandler(dbtest.Open(t, store.handle), files, 1<<20)
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user_id", uint(1))
+		return c.Next()
+	})
+	app.Post("/alerts/:id/attachments", h.UploadAttachment)
+	app.Get("/alerts/:id/attachments/:attachmentId", h.DownloadAttachment)
+	app.Delete("/alerts/:id/attachments/:attachmentId", h.DeleteAttachment)
+	return app, files
+}
+
+func upload(t *testing.T, app *fiber.App, alertID uint, data []byte) (int, models.AlertAttachment) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "slip.png")
+	if err != nil {
+		t.Error(err)
+		return 0, models.AlertAttachment{}
+	}
+	part.Write(data)
+	form.Close()
+
+	// Errors are not fatal, as uploads also run outside the test goroutine
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/alerts/%d/attachments", alertID), &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Error(err)
+		return 0, models.AlertAttachment{}
+	}
+	defer resp.Body.Close()
+	var attachment models.AlertAttachment
+	json.NewDecoder(resp.Body).Decode(&attachment)
+	return resp.StatusCode, attachment
+}
+
+func deleteAttachment(t *testing.T, app *fiber.App, attachment models.AlertAttachment) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/alerts/%d/attachments/%d", attachment.AlertID, attachment.ID), nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAttachmentFileShared(t *testing.T) {
+	app, files := newAttachmentApp(t)
+
+	status, first := upload(t, app, 1, testPNG)
+	if status != fiber.StatusCreated {
+		t.Fatalf("upload: status %d, want %d", status, fiber.StatusCreated)
+	}
+	if status, again := upload(t, app, 1, testPNG); status != fiber.StatusOK || again.ID != first.ID {
+		t.Errorf("same file again: status %d, attachment %d, want %d and %d", status, again.ID, fiber.StatusOK, first.ID)
+	}
+	status, second := upload(t, app, 2, testPNG)
+	if status != fiber.StatusCreated || second.Checksum != first.Checksum {
+		t.Fatalf("same file on another alert: status %d, want %d with the same checksum", status, fiber.StatusCreated)
+	}
+
+	if status := deleteAttachment(t, app, first); status != fiber.StatusOK {
+		t.Fatalf("delete: status %d", status)
+	}
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/alerts/2/attachments/%d", second.ID), nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != fiber.StatusOK || !bytes.Equal(data, testPNG) {
+		t.Fatalf("download of the other attachment: status %d, want the file", resp.StatusCode)
+	}
+	if status := deleteAttachment(t, app, second); status != fiber.StatusOK {
+		t.Fatalf("delete: status %d", status)
+	}
+	if files.has(first.Checksum) {
+		t.Error("file kept after its last attachment was deleted")
+	}
+}
+
+// TestAttachmentDeleteRacesUpload uploads a file while its last attachment
+// is being deleted. The upload must either keep the file or store it again.
+func TestAttachmentDeleteRacesUpload(t *testing.T) {
+	app, files := newAttachmentApp(t)
+	status, attachment := upload(t, app, 1, testPNG)
+	if status != fiber.StatusCreated {
+		t.Fatalf("upload: status %d", status)
+	}
+
+	// The delete pauses before removing the file, giving the upload the
+	// chance to run in between unless it is held back by the lock
+	deleting := make(chan struct{})
+	uploaded := make(chan models.AlertAttachment, 1)
+	files.beforeDelete = func() {
+		close(deleting)
+		select {
+		case a := <-uploaded:
+			uploaded <- a
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	go func() {
+		<-deleting
+		status, a := upload(t, app, 2, testPNG)
+		if status != fiber.StatusCreated {
+			t.Errorf("concurrent upload: status %d", status)
+		}
+		uploaded <- a
+	}()
+
+	if status := deleteAttachment(t, app, attachment); status != fiber.StatusOK {
+		t.Fatalf("delete: status %d", status)
+	}
+	second := <-uploaded
+	if !files.has(second.Checksum) {
+		t.Error("file of the concurrent upload was deleted")
+	}
+}
@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alertsMIS/backend/internal/adminunits"
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// boundaryCollection is a FeatureCollection of unit boundaries. Stats are
+// only present when requested.
+type boundaryCollection struct {
+	geoJSONFeatureCollection
+	Stats *boundaryStats `json:"stats,omitempty"`
+}
+
+// boundaryStats describes the alerts counted in a boundary collection.
+// Unassigned alerts are not linked to any of the units.
+type boundaryStats struct {
+	From       *string `json:"from"`
+	To         *string `json:"to"`
+	AsOf       string  `json:"asOf"`
+	Total      int64   `json:"total"`
+	Unassigned int64   `json:"unassigned"`
+}
+
+// perHundredThousand returns count per 100,000 people, or nil when the
+// population is unknown
+func perHundredThousand(count int64, population *int64) *float64 {
+	if population == nil || *population <= 0 {
+		return nil
+	}
+	rate := float64(count) * 100000 / float64(*population)
+	return &rate
+}
+
+// readBoundaryImport reads a GeoJSON boundary file from the file form field
+// or the request body, and the level and property names from the query.
+// When the import cannot be read the error response is written and ok is
+// false.
+func readBoundaryImport(c *fiber.Ctx) (level string, features []adminunits.BoundaryFeature, ok bool, err error) {
+	level = strings.ToLower(c.Query("level", adminunits.LevelDistrict))
+	if level != adminunits.LevelDistrict && level != adminunits.LevelSubcounty {
+		return level, nil, false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Level must be district or subcounty",
+		})
+	}
+
+	data := c.Body()
+	if form, formErr := c.MultipartForm(); formErr == nil && len(form.File["file"]) > 0 {
+		file, openErr := form.File["file"][0].Open()
+		if openErr == nil {
+			data, openErr = io.ReadAll(file)
+			file.Close()
+		}
+		if openErr != nil {
+			return level, nil, false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Failed to read boundary file",
+				"details": openErr.Error(),
+			})
+		}
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return level, nil, false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "A GeoJSON FeatureCollection is required",
+		})
+	}
+
+	features, err = adminunits.ParseBoundaries(data, level, adminunits.BoundaryProperties{
+		UID:        c.Query("uid_property"),
+		Name:       c.Query("name_property"),
+		Population: c.Query("population_property"),
+	})
+	if err != nil {
+		return level, nil, false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid GeoJSON",
+			"details": err.Error(),
+		})
+	}
+	return level, features, true, nil
+}
+
+// PreviewBoundaryImport matches a GeoJSON boundary file with the stored units
+// @Summary Preview boundary import
+// @Description Match the features of a GeoJSON FeatureCollection of district or subcounty boundaries with the stored units, on UID and then on name, without changing anything. Lists the matched features, the features that cannot be applied and the units left without a boundary (Admin only).
+// @Tags admin-units
+// @Accept json,multipart/form-data
+// @Produce json
+// @Param file formData file false "GeoJSON file, or send it as the body"
+// @Param level query string false "district (default) or subcounty"
+// @Param uid_property query string false "Feature property holding the unit UID"
+// @Param name_property query string false "Feature property holding the unit name"
+// @Param population_property query string false "Feature property holding the population"
+// @Success 200 {object} adminunits.BoundaryPlan
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/boundaries/import/preview [post]
+func (h *AdminUnitsHandler) PreviewBoundaryImport(c *fiber.Ctx) error {
+	level, features, ok, err := readBoundaryImport(c)
+	if !ok {
+		return err
+	}
+	units, err := adminunits.Load(h.db)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch administrative units",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(units.MatchBoundaries(level, features))
+}
+
+// ApplyBoundaryImport stores the boundaries of a GeoJSON file
+// @Summary Apply boundary import
+// @Description Store the boundary polygons of a GeoJSON FeatureCollection on the matched districts or subcounties, replacing their previous boundaries. Populations found in the features are stored as the denominators of alert rates, and units without a centroid get the centroid of their boundary. Features listed as problems are skipped (Admin only).
+// @Tags admin-units
+// @Accept json,multipart/form-data
+// @Produce json
+// @Param file formData file false "GeoJSON file, or send it as the body"
+// @Param level query string false "district (default) or subcounty"
+// @Param uid_property query string false "Feature property holding the unit UID"
+// @Param name_property query string false "Feature property holding the unit name"
+// @Param population_property query string false "Feature property holding the population"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/boundaries/import [post]
+func (h *AdminUnitsHandler) ApplyBoundaryImport(c *fiber.Ctx) error {
+	level, features, ok, err := readBoundaryImport(c)
+	if !ok {
+		return err
+	}
+
+	var plan adminunits.BoundaryPlan
+	var centroids int64
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		units, err := adminunits.Load(tx)
+		if err != nil {
+			return err
+		}
+		plan = units.MatchBoundaries(level, features)
+		centroids, err = adminunits.ApplyBoundaries(tx, plan)
+		return err
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to apply boundary import",
+			"details": err.Error(),
+		})
+	}
+	h.units.Invalidate()
+
+	return c.JSON(fiber.Map{
+		"message":      "Boundaries imported successfully",
+		"plan":         plan,
+		"centroidsSet": centroids,
+	})
+}
+
+// GetDistrictBoundaries fetches district boundaries as GeoJSON
+// @Summary Get district boundaries
+// @Description Get the districts in force on the as_of date as a GeoJSON FeatureCollection of their boundary polygons. With with=stats each feature carries its alert count, deaths and rates per 100,000 people for the period, for choropleth maps; alerts are placed in districts as bounded on the as_of date and accept the alert list filters. Districts without a stored boundary have a null geometry.
+// @Tags admin-units
+// @Produce application/geo+json
+// @Param with query string false "stats to add alert counts and rates"
+// @Param from query string false "Count alerts from this date (YYYY-MM-DD)"
+// @Param to query string false "Count alerts up to this date (YYYY-MM-DD)"
+// @Param as_of query string false "Hierarchy date (YYYY-MM-DD, default today)"
+// @Param event_id query int false "Count alerts of an event"
+// @Param classification query string false "Count alerts of a case classification"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/districts.geojson [get]
+func (h *AdminUnitsHandler) GetDistrictBoundaries(c *fiber.Ctx) error {
+	return h.getBoundaries(c, adminunits.LevelDistrict)
+}
+
+// GetSubcountyBoundaries fetches subcounty boundaries as GeoJSON
+// @Summary Get subcounty boundaries
+// @Description Get the subcounties in force on the as_of date as a GeoJSON FeatureCollection of their boundary polygons, optionally with alert counts and rates, as for districts
+// @Tags admin-units
+// @Produce application/geo+json
+// @Param with query string false "stats to add alert counts and rates"
+// @Param from query string false "Count alerts from this date (YYYY-MM-DD)"
+// @Param to query string false "Count alerts up to this date (YYYY-MM-DD)"
+// @Param as_of query string false "Hierarchy date (YYYY-MM-DD, default today)"
+// @Param district_id query int false "Only subcounties of a district"
+// @Param event_id query int false "Count alerts of an event"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/admin-units/subcounties.geojson [get]
+func (h *AdminUnitsHandler) GetSubcountyBoundaries(c *fiber.Ctx) error {
+	return h.getBoundaries(c, adminunits.LevelSubcounty)
+}
+
+// getBoundaries writes the boundaries of the units of a level, joined with
+// their alert statistics when asked
+func (h *AdminUnitsHandler) getBoundaries(c *fiber.Ctx, level string) error {
+	badRequest := func(msg string) error {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+	asOf, msg := parseAsOf(c)
+	if msg != "" {
+		return badRequest(msg)
+	}
+	from, msg := queryDate(c, "from")
+	if msg != "" {
+		return badRequest(msg)
+	}
+	to, msg := queryDate(c, "to")
+	if msg != "" {
+		return badRequest(msg)
+	}
+	if from != nil && to != nil && to.Before(*from) {
+		return badRequest("to must not be before from")
+	}
+	withStats := false
+	for _, with := range strings.Split(c.Query("with"), ",") {
+		switch strings.ToLower(strings.TrimSpace(with)) {
+		case "":
+		case "stats":
+			withStats = true
+		default:
+			return badRequest("with must be stats")
+		}
+	}
+	var districtID uint64
+	if level == adminunits.LevelSubcounty && c.Query("district_id") != "" {
+		var err error
+		if districtID, err = strconv.ParseUint(c.Query("district_id"), 10, 32); err != nil {
+			return badRequest("Invalid district ID")
+		}
+	}
+	fail := func(err error) error {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch boundaries",
+			"details": err.Error(),
+		})
+	}
+
+	units, err := h.units.Get()
+	if err != nil {
+		return fail(err)
+	}
+	units = units.AsOf(asOf)
+	var mapped []boundaryUnit
+	parentKey := "regionId"
+	if level == adminunits.LevelDistrict {
+		for _, d := range units.Districts {
+			mapped = append(mapped, boundaryUnit{d.ID, d.DistrictUID, d.District, d.RegionID})
+		}
+	} else {
+		parentKey = "districtId"
+		for _, s := range units.Subcounties {
+			if districtID == 0 || uint64(s.DistrictID) == districtID {
+				mapped = append(mapped, boundaryUnit{s.ID, s.SubcountyUID, s.Subcounty, s.DistrictID})
+			}
+		}
+	}
+	sort.Slice(mapped, func(i, j int) bool {
+		if mapped[i].name != mapped[j].name {
+			return mapped[i].name < mapped[j].name
+		}
+		return mapped[i].id < mapped[j].id
+	})
+
+	shapes, err := loadBoundaries(h.db, level)
+	if err != nil {
+		return fail(err)
+	}
+
+	var counts map[uint]alertCount
+	var summary *boundaryStats
+	if withStats {
+		boundaries := hierarchyAsOf(asOf)
+		var unit interface{} = clause.Column{Name: "subcounty_id"}
+		if level == adminunits.LevelDistrict {
+			unit = boundaries.district()
+		}
+		query := filterAlerts(h.db, c, h.db.Model(&models.Alert{}), &boundaries)
+		summary = &boundaryStats{AsOf: asOf.Format(adminunits.DateLayout)}
+		if from != nil {
+			day := from.Format(adminunits.DateLayout)
+			query, summary.From = query.Where("date >= ?", day), &day
+		}
+		if to != nil {
+			day := to.Format(adminunits.DateLayout)
+			query, summary.To = query.Where("date < ?", to.AddDate(0, 0, 1).Format(adminunits.DateLayout)), &day
+		}
+		var rows []struct {
+			UnitID *uint
+			Count  int64
+			Deaths int64
+		}
+		err := query.Select("? AS unit_id, "+alertCountColumns, unit).Group("unit_id").Scan(&rows).Error
+		if err != nil {
+			return fail(err)
+		}
+		counts = make(map[uint]alertCount, len(rows))
+		for _, row := range rows {
+			summary.Total += row.Count
+			if row.UnitID != nil {
+				counts[*row.UnitID] = alertCount{Count: row.Count, Deaths: row.Deaths}
+			}
+		}
+		summary.Unassigned = summary.Total
+		for _, u := range mapped {
+			summary.Unassigned -= counts[u.id].Count
+		}
+	}
+
+	features := make([]geoJSONFeature, 0, len(mapped))
+	for _, u := range mapped {
+		shape, hasShape := shapes[u.id]
+		properties := map[string]interface{}{
+			"id":         u.id,
+			"uid":        u.uid,
+			"name":       u.name,
+			parentKey:    u.parentID,
+			"population": shape.Population,
+		}
+		if withStats {
+			count := counts[u.id]
+			properties["alerts"] = count.Count
+			properties["deaths"] = count.Deaths
+			properties["alertsPer100k"] = perHundredThousand(count.Count, shape.Population)
+			properties["deathsPer100k"] = perHundredThousand(count.Deaths, shape.Population)
+		}
+		feature := geoJSONFeature{Type: "Feature", ID: u.id, Properties: properties}
+		if hasShape {
+			feature.Geometry = json.RawMessage(shape.Geometry)
+		}
+		features = append(features, feature)
+	}
+
+	return c.JSON(boundaryCollection{newFeatureCollection(features), summary}, geoJSONContentType)
+}
+
+// boundaryUnit is a unit as placed on the map
+type boundaryUnit struct {
+	id       uint
+	uid      string
+	name     string
+	parentID uint
+}
+
+// loadBoundaries returns the stored boundaries of a level by unit
+func loadBoundaries(db *gorm.DB, level string) (map[uint]models.AdminUnitBoundary, error) {
+	var boundaries []models.AdminUnitBoundary
+	if err := db.Where("level = ?", level).Find(&boundaries).Error; err != nil {
+		return nil, err
+	}
+	byUnit := make(map[uint]models.AdminUnitBoundary, len(boundaries))
+	for _, b := range boundaries {
+		byUnit[b.UnitID] = b
+	}
+	return byUnit, nil
+}
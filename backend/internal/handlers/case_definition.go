@@ -0,0 +1,544 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/alertsMIS/backend/internal/risk"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// CaseDefinitionHandler handles case definition-related HTTP requests
+type CaseDefinitionHandler struct {
+	db *gorm.DB
+}
+
+// NewCaseDefinitionHandler creates a new CaseDefinitionHandler
+func NewCaseDefinitionHandler(db *gorm.DB) *CaseDefinitionHandler {
+	return &CaseDefinitionHandler{db: db}
+}
+
+// classificationRank orders classifications so the strongest match wins
+var classificationRank = map[string]int{
+	models.ClassificationNotACase:  0,
+	models.ClassificationSuspected: 1,
+	models.ClassificationProbable:  2,
+	models.ClassificationConfirmed: 3,
+}
+
+// symptomAliases maps alternative spellings to the names used in case definitions
+var symptomAliases = map[string]string{
+	"skin_rash":              "rash",
+	"unexplained_bleeding":   "bleeding",
+	"diarrhoea":              "diarrhea",
+	"watery_diarrhoea":       "watery_diarrhea",
+	"acute_watery_diarrhea":  "watery_diarrhea",
+	"acute_watery_diarrhoea": "watery_diarrhea",
+	"afp":                    "acute_flaccid_paralysis",
+	"red_eyes":               "conjunctivitis",
+}
+
+// riskFactorKeywords are phrases in an alert's history that indicate a risk factor
+var riskFactorKeywords = map[string][]string{
+	"contact_with_sick": {"contact with sick", "contact with a sick", "sick contact"},
+	"attended_funeral":  {"funeral", "burial"},
+	"traveled":          {"travel"},
+	"animal_contact":    {"animal", "livestock", "carcass", "cattle", "goat"},
+	"bitten_by_tick":    {"tick bite", "bitten by tick", "bitten by a tick"},
+}
+
+// normalizeTerm converts a symptom or risk factor label to snake_case
+func normalizeTerm(s string) string {
+	var b strings.Builder
+	pendingSeparator := false
+	for _, r := range strings.ToLower(strings.TrimSpace(s)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if pendingSeparator && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			pendingSeparator = false
+			b.WriteRune(r)
+		} else {
+			pendingSeparator = true
+		}
+	}
+	term := b.String()
+	if alias, ok := symptomAliases[term]; ok {
+		return alias
+	}
+	return term
+}
+
+// parseSymptoms splits the comma separated symptoms captured by the call centre
+func parseSymptoms(symptoms *string) map[string]bool {
+	set := make(map[string]bool)
+	if symptoms == nil {
+		return set
+	}
+	for _, s := range strings.FieldsFunc(*symptoms, func(r rune) bool {
+		return r == ',' || r == ';' || r == '\n'
+	}) {
+		if term := normalizeTerm(s); term != "" {
+			set[term] = true
+		}
+	}
+	return set
+}
+
+// isYes reports whether a free text answer is affirmative
+func isYes(value *string) bool {
+	if value == nil {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(*value)) {
+	case "yes", "y", "true", "1":
+		return true
+	}
+	return false
+}
+
+// alertRiskFactors derives the risk factors recorded on an alert
+func alertRiskFactors(alert *models.Alert) map[string]bool {
+	factors := make(map[string]bool)
+	if isYes(alert.HealthFacilityVisit) {
+		factors["visited_health_facility"] = true
+	}
+	if isYes(alert.TraditionalHealerVisit) {
+		factors["visited_healer"] = true
+	}
+	if alert.History != nil {
+		history := strings.ToLower(*alert.History)
+		for factor, keywords := range riskFactorKeywords {
+			for _, keyword := range keywords {
+				if strings.Contains(history, keyword) {
+					factors[factor] = true
+					break
+				}
+			}
+		}
+	}
+	return factors
+}
+
+// matchesCaseDefinition checks a rule set against an alert's symptoms, age and risk factors
+func matchesCaseDefinition(rules models.CaseDefinitionRules, symptoms map[string]bool, age *int, riskFactors map[string]bool) bool {
+	// A definition without symptom rules would match every alert
+	if len(rules.RequiredSymptoms) == 0 && len(rules.AnySymptoms) == 0 {
+		return false
+	}
+
+	for _, s := range rules.RequiredSymptoms {
+		if !symptoms[normalizeTerm(s)] {
+			return false
+		}
+	}
+
+	if len(rules.AnySymptoms) > 0 {
+		required := rules.MinAnySymptoms
+		if required < 1 {
+			required = 1
+		}
+		found := 0
+		for _, s := range rules.AnySymptoms {
+			if symptoms[normalizeTerm(s)] {
+				found++
+			}
+		}
+		if found < required {
+			return false
+		}
+	}
+
+	if rules.MinAge != nil || rules.MaxAge != nil {
+		if age == nil {
+			return false
+		}
+		if rules.MinAge != nil && *age < *rules.MinAge {
+			return false
+		}
+		if rules.MaxAge != nil && *age > *rules.MaxAge {
+			return false
+		}
+	}
+
+	if len(rules.RiskFactors) > 0 {
+		for _, f := range rules.RiskFactors {
+			if riskFactors[normalizeTerm(f)] {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// applyCaseDefinitions stores the matching syndromes, the strongest
+// classification and the resulting priority on the alert. High-consequence
+// syndromes escalate the alert; a laboratory result confirms or discards it.
+func applyCaseDefinitions(alert *models.Alert, definitions []models.CaseDefinition, patientFactors []string) {
+	symptoms := parseSymptoms(alert.Symptoms)
+	riskFactors := alertRiskFactors(alert)
+	for _, f := range patientFactors {
+		riskFactors[f] = true
+	}
+
+	wasEscalated := alert.Priority != nil && *alert.Priority == models.PriorityHigh
+
+	var codes []string
+	classification := models.ClassificationNotACase
+	priority := models.PriorityNormal
+	for _, def := range definitions {
+		if !matchesCaseDefinition(def.Rules, symptoms, alert.AlertCaseAge, riskFactors) {
+			continue
+		}
+		codes = append(codes, def.Code)
+		if classificationRank[def.Classification] > classificationRank[classification] {
+			classification = def.Classification
+		}
+		if def.HighConsequence {
+			priority = models.PriorityHigh
+		}
+	}
+
+	// Laboratory results override the clinical classification
+	if alert.LabResult != nil {
+		switch *alert.LabResult {
+		case models.LabResultPositive:
+			classification = models.ClassificationConfirmed
+		case models.LabResultNegative:
+			classification = models.ClassificationNotACase
+			priority = models.PriorityNormal
+		}
+	}
+
+	syndromes := strings.Join(codes, ",")
+	alert.Syndromes = &syndromes
+	alert.Classification = &classification
+	alert.Priority = &priority
+	// The highlight follows escalation, so it is cleared when an escalated
+	// alert stops matching; alerts highlighted by hand and never escalated
+	// keep theirs
+	if priority == models.PriorityHigh {
+		alert.IsHighlighted = true
+	} else if wasEscalated {
+		alert.IsHighlighted = false
+	}
+}
+
+// patientRiskFactors returns the risk factors recorded for an alert's patient
+func patientRiskFactors(db *gorm.DB, alert *models.Alert) ([]string, error) {
+	if alert.PatientID == nil {
+		return nil, nil
+	}
+	var records []models.RiskFactor
+	if err := db.Where("patient_id = ?", *alert.PatientID).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	var factors []string
+	for _, r := range records {
+		factors = append(factors, r.Factors()...)
+	}
+	return factors, nil
+}
+
+// activeCaseDefinitions loads the case definitions alerts are evaluated
+// against
+func activeCaseDefinitions(db *gorm.DB) ([]models.CaseDefinition, error) {
+	var definitions []models.CaseDefinition
+	err := db.Where("active = ?", true).Order("code").Find(&definitions).Error
+	return definitions, err
+}
+
+// classifyAlert evaluates the active case definitions against an alert and
+// recomputes its risk score, which depends on the classification
+func classifyAlert(db *gorm.DB, alert *models.Alert) error {
+	definitions, err := activeCaseDefinitions(db)
+	if err != nil {
+		return err
+	}
+	return classifyAlertWith(db, alert, definitions)
+}
+
+// classifyAlertWith is classifyAlert with the active case definitions
+// already loaded, for classifying many alerts in a row
+func classifyAlertWith(db *gorm.DB, alert *models.Alert, definitions []models.CaseDefinition) error {
+	patientFactors, err := patientRiskFactors(db, alert)
+	if err != nil {
+		return err
+	}
+	applyCaseDefinitions(alert, definitions, patientFactors)
+	return risk.Apply(db, alert, time.Now())
+}
+
+// validateCaseDefinition checks the required fields of a case definition
+func validateCaseDefinition(def *models.CaseDefinition) string {
+	def.Code = strings.ToUpper(strings.TrimSpace(def.Code))
+	if def.Code == "" {
+		return "Code is required"
+	}
+	if strings.ContainsRune(def.Code, ',') {
+		return "Code must not contain commas"
+	}
+	if strings.TrimSpace(def.Name) == "" {
+		return "Name is required"
+	}
+	if rank, ok := classificationRank[def.Classification]; !ok || rank == 0 {
+		return "Classification must be one of Suspected, Probable or Confirmed"
+	}
+	if len(def.Rules.RequiredSymptoms) == 0 && len(def.Rules.AnySymptoms) == 0 {
+		return "Rules must list at least one required or any-of symptom"
+	}
+	return ""
+}
+
+// codeTaken reports whether another case definition, deleted ones included,
+// has code. Alerts keep the codes of the definitions they matched, so the
+// code of a deleted definition is not given to a new one.
+func codeTaken(db *gorm.DB, code string, id uint) (bool, error) {
+	var count int64
+	err := db.Unscoped().Model(&models.CaseDefinition{}).Where("code = ? AND id <> ?", code, id).Count(&count).Error
+	return count > 0, err
+}
+
+// GetCaseDefinitions fetches all case definitions
+// @Summary Get case definitions
+// @Description Get all case definitions used to classify alerts
+// @Tags case-definitions
+// @Accept json
+// @Produce json
+// @Param active query bool false "Filter by active flag"
+// @Success 200 {array} models.CaseDefinition
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/case-definitions [get]
+func (h *CaseDefinitionHandler) GetCaseDefinitions(c *fiber.Ctx) error {
+	var definitions []models.CaseDefinition
+	query := h.db.Order("code")
+	if active := c.Query("active"); active != "" {
+		query = query.Where("active = ?", active == "true" || active == "1")
+	}
+	if err := query.Find(&definitions).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch case definitions",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(definitions)
+}
+
+// GetCaseDefinition fetches a single case definition
+// @Summary Get case definition by ID
+// @Description Get a specific case definition by its ID
+// @Tags case-definitions
+// @Accept json
+// @Produce json
+// @Param id path int true "Case definition ID"
+// @Success 200 {object} models.CaseDefinition
+// @Failure 404 {object} fiber.Map
+// @Router /api/v1/case-definitions/{id} [get]
+func (h *CaseDefinitionHandler) GetCaseDefinition(c *fiber.Ctx) error {
+	var def models.CaseDefinition
+	if err := h.db.First(&def, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Case definition not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch case definition",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(def)
+}
+
+// CreateCaseDefinition handles case definition creation
+// @Summary Create a case definition
+// @Description Create a new case definition rule set
+// @Tags case-definitions
+// @Accept json
+// @Produce json
+// @Param definition body models.CaseDefinition true "Case definition object"
+// @Success 201 {object} models.CaseDefinition
+// @Failure 400 {object} fiber.Map
+// @Failure 409 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/case-definitions [post]
+func (h *CaseDefinitionHandler) CreateCaseDefinition(c *fiber.Ctx) error {
+	def := models.CaseDefinition{Active: true}
+	if err := c.BodyParser(&def); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+	def.ID = 0
+
+	if msg := validateCaseDefinition(&def); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	if taken, err := codeTaken(h.db, def.Code, def.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to check case definition code",
+			"details": err.Error(),
+		})
+	} else if taken {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Code is taken by another case definition",
+		})
+	}
+
+	if err := h.db.Create(&def).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to create case definition",
+			"details": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(def)
+}
+
+// UpdateCaseDefinition handles updating a case definition
+// @Summary Update case definition
+// @Description Update an existing case definition
+// @Tags case-definitions
+// @Accept json
+// @Produce json
+// @Param id path int true "Case definition ID"
+// @Param definition body models.CaseDefinition true "Case definition object"
+// @Success 200 {object} models.CaseDefinition
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 409 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/case-definitions/{id} [put]
+func (h *CaseDefinitionHandler) UpdateCaseDefinition(c *fiber.Ctx) error {
+	var def models.CaseDefinition
+	if err := h.db.First(&def, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Case definition not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch case definition",
+			"details": err.Error(),
+		})
+	}
+
+	id := def.ID
+	if err := c.BodyParser(&def); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+	def.ID = id
+
+	if msg := validateCaseDefinition(&def); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	if taken, err := codeTaken(h.db, def.Code, def.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to check case definition code",
+			"details": err.Error(),
+		})
+	} else if taken {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Code is taken by another case definition",
+		})
+	}
+
+	if err := h.db.Save(&def).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to update case definition",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(def)
+}
+
+// DeleteCaseDefinition handles deleting a case definition
+// @Summary Delete case definition
+// @Description Delete a case definition by ID
+// @Tags case-definitions
+// @Accept json
+// @Produce json
+// @Param id path int true "Case definition ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/case-definitions/{id} [delete]
+func (h *CaseDefinitionHandler) DeleteCaseDefinition(c *fiber.Ctx) error {
+	result := h.db.Delete(&models.CaseDefinition{}, c.Params("id"))
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to delete case definition",
+			"details": result.Error.Error(),
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Case definition not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Case definition deleted successfully",
+	})
+}
+
+// ReclassifyAlerts re-evaluates every alert against the active case definitions
+// @Summary Reclassify alerts
+// @Description Re-run the case-definition engine over all alerts after definitions change, recomputing their risk scores (Admin only)
+// @Tags case-definitions
+// @Accept json
+// @Produce json
+// @Success 200 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/case-definitions/reclassify [post]
+func (h *CaseDefinitionHandler) ReclassifyAlerts(c *fiber.Ctx) error {
+	var alerts []models.Alert
+	updated := 0
+	result := h.db.FindInBatches(&alerts, 200, func(_ *gorm.DB, _ int) error {
+		definitions, err := activeCaseDefinitions(h.db)
+		if err != nil {
+			return err
+		}
+		for i := range alerts {
+			// The risk score depends on the classification, so both are
+			// recomputed
+			if err := classifyAlertWith(h.db, &alerts[i], definitions); err != nil {
+				return err
+			}
+			if err := h.db.Model(&alerts[i]).
+				Select("Syndromes", "Classification", "Priority", "IsHighlighted", "RiskScore").
+				Updates(&alerts[i]).Error; err != nil {
+				return err
+			}
+			updated++
+		}
+		return nil
+	})
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to reclassify alerts",
+			"details": result.Error.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Alerts reclassified successfully",
+		"count":   updated,
+	})
+}
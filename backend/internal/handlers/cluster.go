@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/clustering"
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ClusterHandler handles alert cluster HTTP requests
+type ClusterHandler struct {
+	db  *gorm.DB
+	cfg clustering.Config
+}
+
+// NewClusterHandler creates a new ClusterHandler
+func NewClusterHandler(db *gorm.DB, cfg clustering.Config) *ClusterHandler {
+	return &ClusterHandler{db: db, cfg: cfg}
+}
+
+// alertClusters returns the clusters an alert belongs to, newest first
+func alertClusters(db *gorm.DB, alertID uint) ([]models.Cluster, error) {
+	var clusters []models.Cluster
+	err := db.Joins("JOIN cluster_members ON cluster_members.cluster_id = clusters.id").
+		Where("cluster_members.alert_id = ?", alertID).
+		Order("clusters.end_date DESC").
+		Find(&clusters).Error
+	return clusters, err
+}
+
+// GetClusters fetches detected clusters
+// @Summary Get clusters
+// @Description Get clusters of alerts with the same syndrome that are close in space and time, latest first
+// @Tags clusters
+// @Produce json
+// @Param syndrome query string false "Filter by syndrome code"
+// @Param status query string false "Active or Closed"
+// @Param from_date query string false "Clusters ending on or after (YYYY-MM-DD)"
+// @Param to_date query string false "Clusters starting on or before (YYYY-MM-DD)"
+// @Param page query int false "Page number"
+// @Param limit query int false "Number of records per page"
+// @Success 200 {array} models.Cluster
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/clusters [get]
+func (h *ClusterHandler) GetClusters(c *fiber.Ctx) error {
+	var clusters []models.Cluster
+	query := h.db.Preload("Members")
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	offset := (page - 1) * limit
+
+	if syndrome := c.Query("syndrome"); syndrome != "" {
+		query = query.Where("syndrome = ?", strings.ToUpper(syndrome))
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if fromDate := c.Query("from_date"); fromDate != "" {
+		query = query.Where("end_date >= ?", fromDate)
+	}
+	if toDate := c.Query("to_date"); toDate != "" {
+		query = query.Where("start_date <= ?", toDate)
+	}
+
+	if err := query.Order("end_date DESC").Offset(offset).Limit(limit).Find(&clusters).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch clusters",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(clusters)
+}
+
+// GetCluster fetches a cluster with its member alerts
+// @Summary Get cluster by ID
+// @Description Get a cluster and its member alerts
+// @Tags clusters
+// @Produce json
+// @Param id path int true "Cluster ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/clusters/{id} [get]
+func (h *ClusterHandler) GetCluster(c *fiber.Ctx) error {
+	var cluster models.Cluster
+	if err := h.db.Preload("Members").First(&cluster, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Cluster not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch cluster",
+			"details": err.Error(),
+		})
+	}
+
+	ids := make([]uint, 0, len(cluster.Members))
+	for _, member := range cluster.Members {
+		ids = append(ids, member.AlertID)
+	}
+	alerts := []models.Alert{}
+	if len(ids) > 0 {
+		if err := h.db.Where("id IN ?", ids).Order("date").Find(&alerts).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "Failed to fetch cluster alerts",
+				"details": err.Error(),
+			})
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"cluster": cluster,
+		"alerts":  alerts,
+	})
+}
+
+// DetectClusters runs cluster detection immediately
+// @Summary Detect clusters
+// @Description Run cluster detection now instead of waiting for the scheduled run
+// @Tags clusters
+// @Produce json
+// @Success 200 {object} clustering.Result
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/clusters/detect [post]
+func (h *ClusterHandler) DetectClusters(c *fiber.Ctx) error {
+	result, err := clustering.Detect(h.db, h.cfg, time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to detect clusters",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(result)
+}
@@ -0,0 +1,553 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ContactHandler handles contact tracing HTTP requests
+type ContactHandler struct {
+	db *gorm.DB
+}
+
+// NewContactHandler creates a new ContactHandler
+func NewContactHandler(db *gorm.DB) *ContactHandler {
+	return &ContactHandler{db: db}
+}
+
+// truncateToDate strips the time of day so dates compare by calendar day
+func truncateToDate(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+}
+
+// canTraceContacts reports whether contacts may be registered for an alert.
+// Contacts are traced for confirmed and probable cases.
+func canTraceContacts(alert *models.Alert) bool {
+	if alert.Classification == nil {
+		return false
+	}
+	return *alert.Classification == models.ClassificationConfirmed ||
+		*alert.Classification == models.ClassificationProbable
+}
+
+// setFollowUpWindow derives the follow-up period from the exposure date
+func setFollowUpWindow(contact *models.Contact) {
+	contact.ExposureDate = truncateToDate(contact.ExposureDate)
+	contact.FollowUpStart = contact.ExposureDate.AddDate(0, 0, 1)
+	contact.FollowUpEnd = contact.ExposureDate.AddDate(0, 0, models.ContactFollowUpDays)
+}
+
+// validateContact checks the required fields of a contact
+func validateContact(contact *models.Contact) string {
+	if contact.Name == "" {
+		return "Contact name is required"
+	}
+	if contact.ExposureDate.IsZero() {
+		return "Exposure date is required"
+	}
+	if truncateToDate(contact.ExposureDate).After(truncateToDate(time.Now())) {
+		return "Exposure date cannot be in the future"
+	}
+	return ""
+}
+
+// findContact loads a contact by the id route parameter. When the contact
+// cannot be loaded the error response is written and ok is false.
+func (h *ContactHandler) findContact(c *fiber.Ctx, contact *models.Contact) (bool, error) {
+	if err := h.db.First(contact, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Contact not found",
+			})
+		}
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch contact",
+			"details": err.Error(),
+		})
+	}
+	return true, nil
+}
+
+// GetAlertContacts fetches the contacts listed for an alert
+// @Summary Get alert contacts
+// @Description Get all contacts listed for a confirmed or probable alert
+// @Tags contacts
+// @Accept json
+// @Produce json
+// @Param id path int true "Alert ID"
+// @Param status query string false "Filter by follow-up status"
+// @Success 200 {array} models.Contact
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/alerts/{id}/contacts [get]
+func (h *ContactHandler) GetAlertContacts(c *fiber.Ctx) error {
+	var contacts []models.Contact
+	query := h.db.Where("alert_id = ?", c.Params("id"))
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Order("name").Find(&contacts).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch contacts",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(contacts)
+}
+
+// CreateContact lists a new contact for an alert
+// @Summary Create contact
+// @Description List a contact of a confirmed or probable case for 21-day follow-up
+// @Tags contacts
+// @Accept json
+// @Produce json
+// @Param id path int true "Alert ID"
+// @Param contact body models.Contact true "Contact object"
+// @Success 201 {object} models.Contact
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/alerts/{id}/contacts [post]
+func (h *ContactHandler) CreateContact(c *fiber.Ctx) error {
+	var alert models.Alert
+	if err := h.db.First(&alert, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Alert not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch alert",
+			"details": err.Error(),
+		})
+	}
+
+	if !canTraceContacts(&alert) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Contacts can only be listed for confirmed or probable cases",
+		})
+	}
+
+	contact := new(models.Contact)
+	if err := c.BodyParser(contact); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+
+	if msg := validateContact(contact); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	contact.ID = 0
+	contact.AlertID = alert.ID
+	contact.Status = models.ContactStatusUnderFollowUp
+	contact.ConvertedAlertID = nil
+	contact.FollowUps = nil
+	setFollowUpWindow(contact)
+
+	if err := h.db.Create(contact).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to create contact",
+			"details": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(contact)
+}
+
+// GetContact fetches a contact with their follow-up entries
+// @Summary Get contact by ID
+// @Description Get a contact with their daily follow-up entries
+// @Tags contacts
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Success 200 {object} models.Contact
+// @Failure 404 {object} fiber.Map
+// @Router /api/v1/contacts/{id} [get]
+func (h *ContactHandler) GetContact(c *fiber.Ctx) error {
+	var contact models.Contact
+	if err := h.db.Preload("FollowUps", func(db *gorm.DB) *gorm.DB {
+		return db.Order("follow_up_date")
+	}).First(&contact, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Contact not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch contact",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(contact)
+}
+
+// UpdateContact handles updating a contact's details or status
+// @Summary Update contact
+// @Description Update a contact's details, exposure or follow-up status
+// @Tags contacts
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Param contact body models.Contact true "Contact object"
+// @Success 200 {object} models.Contact
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/contacts/{id} [put]
+func (h *ContactHandler) UpdateContact(c *fiber.Ctx) error {
+	var contact models.Contact
+	if ok, err := h.findContact(c, &contact); !ok {
+		return err
+	}
+
+	id, alertID, convertedAlertID := contact.ID, contact.AlertID, contact.ConvertedAlertID
+	if err := c.BodyParser(&contact); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+	contact.ID, contact.AlertID, contact.ConvertedAlertID = id, alertID, convertedAlertID
+	contact.FollowUps = nil
+
+	if msg := validateContact(&contact); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+	switch contact.Status {
+	case models.ContactStatusUnderFollowUp, models.ContactStatusCompleted, models.ContactStatusLost:
+	case models.ContactStatusBecameCase:
+		if contact.ConvertedAlertID == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "A contact becomes a case by recording a symptomatic follow-up",
+			})
+		}
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid contact status",
+		})
+	}
+	setFollowUpWindow(&contact)
+
+	if err := h.db.Save(&contact).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to update contact",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(contact)
+}
+
+// DeleteContact handles deleting a contact
+// @Summary Delete contact
+// @Description Delete a contact by ID
+// @Tags contacts
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/contacts/{id} [delete]
+func (h *ContactHandler) DeleteContact(c *fiber.Ctx) error {
+	result := h.db.Delete(&models.Contact{}, c.Params("id"))
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to delete contact",
+			"details": result.Error.Error(),
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Contact not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Contact deleted successfully",
+	})
+}
+
+// GetFollowUps fetches the daily follow-up entries of a contact
+// @Summary Get contact follow-ups
+// @Description Get the daily follow-up entries recorded for a contact
+// @Tags contacts
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Success 200 {array} models.ContactFollowUp
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/contacts/{id}/follow-ups [get]
+func (h *ContactHandler) GetFollowUps(c *fiber.Ctx) error {
+	var followUps []models.ContactFollowUp
+	if err := h.db.Where("contact_id = ?", c.Params("id")).Order("follow_up_date").Find(&followUps).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch follow-ups",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(followUps)
+}
+
+// CreateFollowUp records a daily follow-up of a contact. A symptomatic
+// contact is converted into a new alert and their follow-up ends.
+// @Summary Record contact follow-up
+// @Description Record a daily follow-up; a symptomatic contact is converted into a new alert
+// @Tags contacts
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Param followUp body models.ContactFollowUp true "Follow-up object"
+// @Success 201 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 409 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/contacts/{id}/follow-ups [post]
+func (h *ContactHandler) CreateFollowUp(c *fiber.Ctx) error {
+	var contact models.Contact
+	if ok, err := h.findContact(c, &contact); !ok {
+		return err
+	}
+
+	if contact.Status == models.ContactStatusCompleted || contact.Status == models.ContactStatusBecameCase {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Follow-up has ended for this contact",
+		})
+	}
+
+	followUp := new(models.ContactFollowUp)
+	if err := c.BodyParser(followUp); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+
+	followUp.ID = 0
+	followUp.ContactID = contact.ID
+	followUp.RecordedBy = c.Locals("username").(string)
+	if followUp.FollowUpDate.IsZero() {
+		followUp.FollowUpDate = time.Now()
+	}
+	followUp.FollowUpDate = truncateToDate(followUp.FollowUpDate)
+	if followUp.FollowUpDate.After(truncateToDate(time.Now())) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Follow-up date cannot be in the future",
+		})
+	}
+	if followUp.FollowUpDate.Before(truncateToDate(contact.FollowUpStart)) || followUp.FollowUpDate.After(truncateToDate(contact.FollowUpEnd)) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Follow-up date is outside the contact's follow-up period",
+		})
+	}
+	followUp.Day = int(followUp.FollowUpDate.Sub(truncateToDate(contact.ExposureDate)).Hours()/24 + 0.5)
+	if followUp.Symptomatic {
+		followUp.Seen = true
+	}
+
+	var existing int64
+	if err := h.db.Model(&models.ContactFollowUp{}).
+		Where("contact_id = ? AND follow_up_date = ?", contact.ID, followUp.FollowUpDate).
+		Count(&existing).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to check existing follow-up",
+			"details": err.Error(),
+		})
+	}
+	if existing > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "A follow-up has already been recorded for this date",
+		})
+	}
+
+	var newAlert *models.Alert
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(followUp).Error; err != nil {
+			return err
+		}
+
+		switch {
+		case followUp.Symptomatic:
+			var source models.Alert
+			if err := tx.First(&source, contact.AlertID).Error; err != nil {
+				return err
+			}
+			newAlert = alertFromContact(&contact, &source, followUp)
+			// Only names are copied from the contact, and names that do
+			// not resolve are left unlinked rather than rejected
+			if _, err := resolveAlertAdminUnits(tx, newAlert, false); err != nil {
+				return err
+			}
+			if err := classifyAlert(tx, newAlert); err != nil {
+				return err
+			}
+			if err := locateAlert(tx, newAlert); err != nil {
+				return err
+			}
+			if err := tx.Create(newAlert).Error; err != nil {
+				return err
+			}
+			contact.Status = models.ContactStatusBecameCase
+			contact.ConvertedAlertID = &newAlert.ID
+		case followUp.Day >= models.ContactFollowUpDays:
+			contact.Status = models.ContactStatusCompleted
+		case contact.Status == models.ContactStatusLost:
+			contact.Status = models.ContactStatusUnderFollowUp
+		default:
+			return nil
+		}
+
+		return tx.Model(&contact).Select("Status", "ConvertedAlertID").Updates(&contact).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to record follow-up",
+			"details": err.Error(),
+		})
+	}
+
+	response := fiber.Map{
+		"followUp": followUp,
+		"contact":  contact,
+	}
+	if newAlert != nil {
+		response["alert"] = newAlert
+	}
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+// alertFromContact builds a new alert for a contact who developed symptoms
+func alertFromContact(contact *models.Contact, source *models.Alert, followUp *models.ContactFollowUp) *models.Alert {
+	now := time.Now()
+	status := "Alive"
+	personReporting := followUp.RecordedBy
+	if personReporting == "" {
+		personReporting = "Contact tracing"
+	}
+	sourceOfAlert := "Contact tracing"
+	alertFrom := "Contact Tracing"
+	exposure := "an unspecified"
+	if contact.ExposureType != nil && *contact.ExposureType != "" {
+		exposure = "a " + *contact.ExposureType
+	}
+	history := fmt.Sprintf("Contact with sick case (alert #%d): %s exposure on %s. Symptomatic on follow-up day %d.",
+		source.ID, exposure, contact.ExposureDate.Format("2006-01-02"), followUp.Day)
+
+	return &models.Alert{
+		Status:                     &status,
+		Date:                       &now,
+		Time:                       &now,
+		PersonReporting:            &personReporting,
+		SourceOfAlert:              &sourceOfAlert,
+		AlertFrom:                  &alertFrom,
+		AlertCaseName:              &contact.Name,
+		AlertCaseAge:               contact.Age,
+		AlertCaseSex:               contact.Sex,
+		ContactNumber:              contact.Phone,
+		AlertCaseVillage:           contact.Village,
+		AlertCaseParish:            contact.Parish,
+		AlertCaseSubCounty:         contact.SubCounty,
+		AlertCaseDistrict:          contact.District,
+		Region:                     source.Region,
+		PointOfContactName:         source.AlertCaseName,
+		PointOfContactRelationship: contact.Relationship,
+		History:                    &history,
+		Symptoms:                   followUp.Symptoms,
+	}
+}
+
+// GetFollowUpReport reports daily follow-up completeness per district
+// @Summary Get contact follow-up completeness report
+// @Description Get, per district, the contacts due for follow-up on a date and how many were followed up
+// @Tags contacts
+// @Accept json
+// @Produce json
+// @Param date query string false "Report date (YYYY-MM-DD), defaults to today"
+// @Param district query string false "Filter by district"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Param event_id query int false "Only contacts of the event's alerts"
+// @Router /api/v1/contacts/follow-up-report [get]
+func (h *ContactHandler) GetFollowUpReport(c *fiber.Ctx) error {
+	date := truncateToDate(time.Now())
+	if d := c.Query("date"); d != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", d, time.Local)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid date, expected YYYY-MM-DD",
+			})
+		}
+		date = parsed
+	}
+
+	var rows []struct {
+		District     string  `json:"district"`
+		Expected     int64   `json:"expected"`
+		FollowedUp   int64   `json:"followedUp"`
+		Symptomatic  int64   `json:"symptomatic"`
+		Completeness float64 `gorm:"-" json:"completeness"`
+	}
+
+	// Contacts who became cases leave follow-up; lost contacts still count as due
+	query := h.db.Table("contacts").
+		Select(`COALESCE(contacts.district, '') AS district,
+			COUNT(*) AS expected,
+			SUM(CASE WHEN contact_follow_ups.seen THEN 1 ELSE 0 END) AS followed_up,
+			SUM(CASE WHEN contact_follow_ups.symptomatic THEN 1 ELSE 0 END) AS symptomatic`).
+		Joins("LEFT JOIN contact_follow_ups ON contact_follow_ups.contact_id = contacts.id AND contact_follow_ups.follow_up_date = ?", date).
+		Where("contacts.deleted_at IS NULL").
+		Where("contacts.follow_up_start <= ? AND contacts.follow_up_end >= ?", date, date).
+		Where("contacts.status <> ? OR contact_follow_ups.symptomatic", models.ContactStatusBecameCase).
+		Group("contacts.district").
+		Order("contacts.district")
+	if district := c.Query("district"); district != "" {
+		query = query.Where("contacts.district = ?", district)
+	}
+	if eventID := c.Query("event_id"); eventID != "" {
+		query = query.Where("contacts.alert_id IN (?)", h.db.Model(&models.Alert{}).Select("id").Where("event_id = ?", eventID))
+	}
+
+	if err := query.Scan(&rows).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to build follow-up report",
+			"details": err.Error(),
+		})
+	}
+
+	var expected, followedUp int64
+	for i := range rows {
+		if rows[i].Expected > 0 {
+			rows[i].Completeness = float64(rows[i].FollowedUp) * 100 / float64(rows[i].Expected)
+		}
+		expected += rows[i].Expected
+		followedUp += rows[i].FollowedUp
+	}
+	completeness := 0.0
+	if expected > 0 {
+		completeness = float64(followedUp) * 100 / float64(expected)
+	}
+
+	return c.JSON(fiber.Map{
+		"date":      date.Format("2006-01-02"),
+		"districts": rows,
+		"total": fiber.Map{
+			"expected":     expected,
+			"followedUp":   followedUp,
+			"completeness": completeness,
+		},
+	})
+}
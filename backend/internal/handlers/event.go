@@ -0,0 +1,532 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EventHandler handles outbreak event HTTP requests
+type EventHandler struct {
+	db *gorm.DB
+}
+
+// NewEventHandler creates a new EventHandler
+func NewEventHandler(db *gorm.DB) *EventHandler {
+	return &EventHandler{db: db}
+}
+
+// eventInput is the request body for creating or updating an event
+type eventInput struct {
+	Name        *string    `json:"name"`
+	Disease     *string    `json:"disease"`
+	Status      *string    `json:"status"`
+	StartDate   *time.Time `json:"startDate"`
+	EndDate     *time.Time `json:"endDate"`
+	Description *string    `json:"description"`
+	DistrictIDs *[]uint    `json:"districtIds"`
+}
+
+// validateEvent checks an event and returns a message describing the first
+// problem found, or an empty string
+func validateEvent(event *models.Event) string {
+	if strings.TrimSpace(event.Name) == "" {
+		return "Event name is required"
+	}
+	if strings.TrimSpace(event.Disease) == "" {
+		return "Disease is required"
+	}
+	if event.StartDate.IsZero() {
+		return "Start date is required"
+	}
+	if event.Status == "" {
+		event.Status = models.EventStatusActive
+	}
+	if event.Status != models.EventStatusActive && event.Status != models.EventStatusClosed {
+		return "Status must be Active or Closed"
+	}
+	if event.EndDate != nil && event.EndDate.Before(event.StartDate) {
+		return "End date cannot be before the start date"
+	}
+	return ""
+}
+
+// validateAlertEvent checks that the event an alert links to exists. It
+// returns a message describing the problem, or an empty string.
+func validateAlertEvent(db *gorm.DB, alert *models.Alert) (string, error) {
+	if alert.EventID == nil {
+		return "", nil
+	}
+	var count int64
+	if err := db.Model(&models.Event{}).Where("id = ?", *alert.EventID).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "Event not found", nil
+	}
+	return "", nil
+}
+
+// eventDistricts loads the districts with the given IDs. missing is true if
+// any of them does not exist.
+func eventDistricts(db *gorm.DB, ids []uint) (districts []models.EventDistrict, missing bool, err error) {
+	if len(ids) == 0 {
+		return nil, false, nil
+	}
+	var found []models.District
+	if err := db.Where("id IN ?", ids).Find(&found).Error; err != nil {
+		return nil, false, err
+	}
+	for _, d := range found {
+		districts = append(districts, models.EventDistrict{DistrictID: d.ID, District: d.District})
+	}
+	seen := make(map[uint]bool)
+	for _, id := range ids {
+		seen[id] = true
+	}
+	return districts, len(found) != len(seen), nil
+}
+
+// findEvent loads an event with its districts by the id route parameter.
+// When the event cannot be loaded the error response is written and ok is
+// false.
+func (h *EventHandler) findEvent(c *fiber.Ctx, event *models.Event) (bool, error) {
+	if err := h.db.Preload("Districts").First(event, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Event not found",
+			})
+		}
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch event",
+			"details": err.Error(),
+		})
+	}
+	return true, nil
+}
+
+// applyEventInput copies the given fields of the input onto the event and
+// resolves its districts. It returns a message describing the first problem
+// found, or an empty string.
+func (h *EventHandler) applyEventInput(input eventInput, event *models.Event) (string, error) {
+	if input.Name != nil {
+		event.Name = *input.Name
+	}
+	if input.Disease != nil {
+		event.Disease = *input.Disease
+	}
+	if input.Status != nil {
+		event.Status = *input.Status
+	}
+	if input.StartDate != nil {
+		event.StartDate = *input.StartDate
+	}
+	if input.EndDate != nil {
+		event.EndDate = input.EndDate
+	}
+	if input.Description != nil {
+		event.Description = input.Description
+	}
+	if msg := validateEvent(event); msg != "" {
+		return msg, nil
+	}
+
+	if input.DistrictIDs != nil {
+		districts, missing, err := eventDistricts(h.db, *input.DistrictIDs)
+		if err != nil {
+			return "", err
+		}
+		if missing {
+			return "One or more districts were not found", nil
+		}
+		event.Districts = districts
+	}
+	return "", nil
+}
+
+// GetEvents fetches events
+// @Summary Get events
+// @Description Get outbreak events, most recent first
+// @Tags events
+// @Produce json
+// @Param status query string false "Active or Closed"
+// @Param disease query string false "Filter by disease"
+// @Param district_id query int false "Filter by affected district"
+// @Success 200 {array} models.Event
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/events [get]
+func (h *EventHandler) GetEvents(c *fiber.Ctx) error {
+	var events []models.Event
+	query := h.db.Preload("Districts")
+
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if disease := c.Query("disease"); disease != "" {
+		query = query.Where("disease = ?", disease)
+	}
+	if districtID := c.Query("district_id"); districtID != "" {
+		query = query.Where("id IN (?)", h.db.Model(&models.EventDistrict{}).Select("event_id").Where("district_id = ?", districtID))
+	}
+
+	if err := query.Order("start_date DESC").Find(&events).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch events",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(events)
+}
+
+// GetEvent fetches an event
+// @Summary Get event by ID
+// @Description Get an outbreak event with its affected districts
+// @Tags events
+// @Produce json
+// @Param id path int true "Event ID"
+// @Success 200 {object} models.Event
+// @Failure 404 {object} fiber.Map
+// @Router /api/v1/events/{id} [get]
+func (h *EventHandler) GetEvent(c *fiber.Ctx) error {
+	var event models.Event
+	if ok, err := h.findEvent(c, &event); !ok {
+		return err
+	}
+	return c.JSON(event)
+}
+
+// CreateEvent creates an event
+// @Summary Create event
+// @Description Create an outbreak event
+// @Tags events
+// @Accept json
+// @Produce json
+// @Param event body map[string]interface{} true "Event with districtIds"
+// @Success 201 {object} models.Event
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/events [post]
+func (h *EventHandler) CreateEvent(c *fiber.Ctx) error {
+	var input eventInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+
+	var event models.Event
+	msg, err := h.applyEventInput(input, &event)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch districts",
+			"details": err.Error(),
+		})
+	}
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	if err := h.db.Create(&event).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to create event",
+			"details": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusCreated).JSON(event)
+}
+
+// UpdateEvent updates an event
+// @Summary Update event
+// @Description Update an outbreak event; districtIds replaces the affected districts
+// @Tags events
+// @Accept json
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param event body map[string]interface{} true "Event fields to update"
+// @Success 200 {object} models.Event
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/events/{id} [put]
+func (h *EventHandler) UpdateEvent(c *fiber.Ctx) error {
+	var event models.Event
+	if ok, err := h.findEvent(c, &event); !ok {
+		return err
+	}
+
+	var input eventInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+
+	msg, err := h.applyEventInput(input, &event)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch districts",
+			"details": err.Error(),
+		})
+	}
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Districts").Save(&event).Error; err != nil {
+			return err
+		}
+		if input.DistrictIDs == nil {
+			return nil
+		}
+		if err := tx.Where("event_id = ?", event.ID).Delete(&models.EventDistrict{}).Error; err != nil {
+			return err
+		}
+		for i := range event.Districts {
+			event.Districts[i].EventID = event.ID
+		}
+		if len(event.Districts) == 0 {
+			return nil
+		}
+		return tx.Create(&event.Districts).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to update event",
+			"details": err.Error(),
+		})
+	}
+	if event.Districts == nil {
+		event.Districts = []models.EventDistrict{}
+	}
+	return c.JSON(event)
+}
+
+// DeleteEvent deletes an event and unlinks its alerts
+// @Summary Delete event
+// @Description Delete an outbreak event; its alerts are kept but unlinked
+// @Tags events
+// @Produce json
+// @Param id path int true "Event ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/events/{id} [delete]
+func (h *EventHandler) DeleteEvent(c *fiber.Ctx) error {
+	var event models.Event
+	if ok, err := h.findEvent(c, &event); !ok {
+		return err
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Alert{}).Where("event_id = ?", event.ID).Update("event_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("event_id = ?", event.ID).Delete(&models.EventDistrict{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&event).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to delete event",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Event deleted successfully",
+	})
+}
+
+// LinkEventAlerts links alerts to an event
+// @Summary Link alerts to event
+// @Description Link alerts to an event, moving them from any other event
+// @Tags events
+// @Accept json
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param alerts body map[string][]uint true "alertIds"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/events/{id}/alerts [post]
+func (h *EventHandler) LinkEventAlerts(c *fiber.Ctx) error {
+	var event models.Event
+	if ok, err := h.findEvent(c, &event); !ok {
+		return err
+	}
+
+	var input struct {
+		AlertIDs []uint `json:"alertIds"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+	if len(input.AlertIDs) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "At least one alert ID is required",
+		})
+	}
+
+	result := h.db.Model(&models.Alert{}).Where("id IN ?", input.AlertIDs).Update("event_id", event.ID)
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to link alerts",
+			"details": result.Error.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Alerts linked to event",
+		"count":   result.RowsAffected,
+	})
+}
+
+// UnlinkEventAlert removes an alert from an event
+// @Summary Unlink alert from event
+// @Description Remove an alert from an event
+// @Tags events
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param alertId path int true "Alert ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/events/{id}/alerts/{alertId} [delete]
+func (h *EventHandler) UnlinkEventAlert(c *fiber.Ctx) error {
+	result := h.db.Model(&models.Alert{}).
+		Where("id = ? AND event_id = ?", c.Params("alertId"), c.Params("id")).
+		Update("event_id", nil)
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to unlink alert",
+			"details": result.Error.Error(),
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Alert is not linked to this event",
+		})
+	}
+	return c.JSON(fiber.Map{
+		"message": "Alert unlinked from event",
+	})
+}
+
+// GetEventDashboard aggregates the alerts, deaths, lab results and contacts
+// of an event
+// @Summary Get event dashboard
+// @Description Get aggregated statistics for an outbreak event
+// @Tags events
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param as_of query string false "Break down by the districts bounded on this date (YYYY-MM-DD)"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/events/{id}/dashboard [get]
+func (h *EventHandler) GetEventDashboard(c *fiber.Ctx) error {
+	boundaries, msg := optionalHierarchy(c)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+	var event models.Event
+	if ok, err := h.findEvent(c, &event); !ok {
+		return err
+	}
+
+	alerts := func() *gorm.DB {
+		return h.db.Model(&models.Alert{}).Where("event_id = ?", event.ID)
+	}
+	fail := func(err error) error {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to build event dashboard",
+			"details": err.Error(),
+		})
+	}
+
+	var totals struct {
+		Alerts        int64      `json:"alerts"`
+		Verified      int64      `json:"verified"`
+		Deaths        int64      `json:"deaths"`
+		HighPriority  int64      `json:"highPriority"`
+		LabPositive   int64      `json:"labPositive"`
+		LabNegative   int64      `json:"labNegative"`
+		FirstAlert    *time.Time `json:"firstAlert"`
+		LastAlert     *time.Time `json:"lastAlert"`
+		Contacts      int64      `json:"contacts" gorm:"-"`
+		UnderFollowUp int64      `json:"contactsUnderFollowUp" gorm:"-"`
+	}
+	err := alerts().Select(`COUNT(*) AS alerts,
+		COALESCE(SUM(is_verified), 0) AS verified,
+		COALESCE(SUM(LOWER(TRIM(status)) = 'dead'), 0) AS deaths,
+		COALESCE(SUM(priority = ?), 0) AS high_priority,
+		COALESCE(SUM(lab_result = ?), 0) AS lab_positive,
+		COALESCE(SUM(lab_result = ?), 0) AS lab_negative,
+		MIN(date) AS first_alert,
+		MAX(date) AS last_alert`,
+		models.PriorityHigh, models.LabResultPositive, models.LabResultNegative).
+		Scan(&totals).Error
+	if err != nil {
+		return fail(err)
+	}
+
+	contacts := h.db.Model(&models.Contact{}).Where("alert_id IN (?)", alerts().Select("id"))
+	if err := contacts.Count(&totals.Contacts).Error; err != nil {
+		return fail(err)
+	}
+	underFollowUp := h.db.Model(&models.Contact{}).
+		Where("alert_id IN (?) AND status = ?", alerts().Select("id"), models.ContactStatusUnderFollowUp)
+	if err := underFollowUp.Count(&totals.UnderFollowUp).Error; err != nil {
+		return fail(err)
+	}
+
+	type count struct {
+		Key   *string `json:"key"`
+		Count int64   `json:"count"`
+	}
+	var byClassification, byDistrict, epiCurve []count
+	if err := alerts().Select("classification AS `key`, COUNT(*) AS count").Group("classification").Scan(&byClassification).Error; err != nil {
+		return fail(err)
+	}
+	// By default alerts count under the district name they were reported
+	// with; given a date, under the district they fall in on that date
+	districtKey := clause.Expr{SQL: "alert_case_district"}
+	if boundaries != nil {
+		districtKey = gorm.Expr("COALESCE((SELECT d.district FROM districts d WHERE d.id = ?), alert_case_district)", boundaries.district())
+	}
+	if err := alerts().Select("? AS `key`, COUNT(*) AS count", districtKey).Group("`key`").Order("count DESC").Scan(&byDistrict).Error; err != nil {
+		return fail(err)
+	}
+	// Epidemic curve by ISO week of the alert date
+	if err := alerts().Select("DATE_FORMAT(date, '%x-W%v') AS `key`, COUNT(*) AS count").
+		Where("date IS NOT NULL").Group("`key`").Order("`key`").Scan(&epiCurve).Error; err != nil {
+		return fail(err)
+	}
+
+	return c.JSON(fiber.Map{
+		"event":            event,
+		"totals":           totals,
+		"byClassification": byClassification,
+		"byDistrict":       byDistrict,
+		"epiCurve":         epiCurve,
+	})
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import "encoding/json"
+
+// geoJSONContentType is the media type of GeoJSON documents (RFC 7946)
+const geoJSONContentType = "application/geo+json"
+
+// geoJSONFeatureCollection is a GeoJSON FeatureCollection
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+// geoJSONFeature is a GeoJSON Feature. Geometry is kept as raw JSON so both
+// points and stored boundaries can be written.
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	ID         uint                   `json:"id"`
+	Geometry   json.RawMessage        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// newFeatureCollection wraps features in a FeatureCollection
+func newFeatureCollection(features []geoJSONFeature) geoJSONFeatureCollection {
+	if features == nil {
+		features = []geoJSONFeature{}
+	}
+	return geoJSONFeatureCollection{Type: "FeatureCollection", Features: features}
+}
+
+// pointGeometry returns a GeoJSON Point. GeoJSON orders positions as
+// longitude, latitude.
+func pointGeometry(latitude, longitude float64) json.RawMessage {
+	geometry, _ := json.Marshal(struct {
+		Type        string     `json:"type"`
+		Coordinates [2]float64 `json:"coordinates"`
+	}{"Point", [2]float64{longitude, latitude}})
+	return geometry
+}
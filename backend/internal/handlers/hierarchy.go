@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/alertsMIS/backend/internal/adminunits"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// queryDate reads an optional date query parameter, nil when not given. A
+// non-empty message means the date is invalid.
+func queryDate(c *fiber.Ctx, key string) (*time.Time, string) {
+	value := c.Query(key)
+	if value == "" {
+		return nil, ""
+	}
+	date, err := time.Parse(adminunits.DateLayout, value)
+	if err != nil {
+		return nil, key + " must be a date (YYYY-MM-DD)"
+	}
+	return &date, ""
+}
+
+// parseAsOf reads the as_of hierarchy date, today when not given. A non-empty
+// message means the date is invalid.
+func parseAsOf(c *fiber.Ctx) (time.Time, string) {
+	asOf, msg := queryDate(c, "as_of")
+	if msg != "" {
+		return time.Time{}, msg
+	}
+	if asOf == nil {
+		return adminunits.Day(time.Now()), ""
+	}
+	return *asOf, ""
+}
+
+// optionalHierarchy returns the hierarchy of the as_of date, or nil when no
+// date is given. A non-empty message means the date is invalid.
+func optionalHierarchy(c *fiber.Ctx) (*hierarchy, string) {
+	if c.Query("as_of") == "" {
+		return nil, ""
+	}
+	asOf, msg := parseAsOf(c)
+	if msg != "" {
+		return nil, msg
+	}
+	h := hierarchyAsOf(asOf)
+	return &h, ""
+}
+
+// hierarchy places alerts in the districts and regions of a given date, so
+// that counts stay comparable when boundaries are redrawn. An alert's
+// subcounty is looked up in the subcounty history, then the district in the
+// district history; alerts without history keep the units they were linked
+// to.
+type hierarchy struct {
+	date string
+}
+
+// hierarchyAsOf returns the hierarchy in force on a date
+func hierarchyAsOf(asOf time.Time) hierarchy {
+	return hierarchy{date: asOf.Format(adminunits.DateLayout)}
+}
+
+// district is the SQL expression for an alert's district
+func (h hierarchy) district() clause.Expr {
+	return gorm.Expr(`COALESCE((SELECT sdh.district_id FROM subcounty_district_history sdh
+		WHERE sdh.subcounty_id = alerts.subcounty_id
+			AND (sdh.effective_from IS NULL OR sdh.effective_from <= ?)
+			AND (sdh.effective_to IS NULL OR sdh.effective_to > ?)
+		ORDER BY sdh.effective_from DESC LIMIT 1), alerts.district_id)`, h.date, h.date)
+}
+
+// region is the SQL expression for an alert's region
+func (h hierarchy) region() clause.Expr {
+	return gorm.Expr(`COALESCE((SELECT drh.region_id FROM district_region_history drh
+		WHERE drh.district_id = ?
+			AND (drh.effective_from IS NULL OR drh.effective_from <= ?)
+			AND (drh.effective_to IS NULL OR drh.effective_to > ?)
+		ORDER BY drh.effective_from DESC LIMIT 1), alerts.region_id)`, h.district(), h.date, h.date)
+}
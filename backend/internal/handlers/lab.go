@@ -0,0 +1,644 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// LabHandler handles laboratory sample and result HTTP requests
+type LabHandler struct {
+	db *gorm.DB
+}
+
+// NewLabHandler creates a new LabHandler
+func NewLabHandler(db *gorm.DB) *LabHandler {
+	return &LabHandler{db: db}
+}
+
+// aggregateLabResult derives an alert's overall result from all of its tests.
+// Any positive test confirms the alert; it is negative only when every test
+// is negative. Otherwise the result is still open and ok is false.
+func aggregateLabResult(tests []models.LabTest) (result string, ok bool) {
+	if len(tests) == 0 {
+		return "", false
+	}
+	allNegative := true
+	for _, t := range tests {
+		if t.Result == models.LabResultPositive {
+			return models.LabResultPositive, true
+		}
+		if t.Result != models.LabResultNegative {
+			allNegative = false
+		}
+	}
+	if allNegative {
+		return models.LabResultNegative, true
+	}
+	return "", false
+}
+
+// labResultDate returns the date an alert's overall result was reached: the
+// first positive result, or the last negative one
+func labResultDate(tests []models.LabTest, result string) *time.Time {
+	var date *time.Time
+	for _, t := range tests {
+		if t.Result != result || t.ResultDate == nil {
+			continue
+		}
+		if date == nil ||
+			(result == models.LabResultPositive && t.ResultDate.Before(*date)) ||
+			(result == models.LabResultNegative && t.ResultDate.After(*date)) {
+			date = t.ResultDate
+		}
+	}
+	return date
+}
+
+// alertLabTests fetches the tests of an alert's samples
+func alertLabTests(tx *gorm.DB, alertID uint) ([]models.LabTest, error) {
+	var tests []models.LabTest
+	err := tx.Joins("JOIN lab_samples ON lab_samples.id = lab_tests.lab_sample_id").
+		Where("lab_samples.alert_id = ? AND lab_samples.deleted_at IS NULL", alertID).
+		Find(&tests).Error
+	return tests, err
+}
+
+// sampleAtLab reports whether a sample has been received by the laboratory
+// and not rejected, so that it can be tested
+func sampleAtLab(sample models.LabSample) bool {
+	return sample.Status == models.LabSampleReceived || sample.Status == models.LabSampleTested
+}
+
+// findSample loads a sample by the id route parameter. When the sample cannot
+// be loaded the error response is written and ok is false.
+func (h *LabHandler) findSample(c *fiber.Ctx, sample *models.LabSample) (bool, error) {
+	if err := h.db.First(sample, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Lab sample not found",
+			})
+		}
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch lab sample",
+			"details": err.Error(),
+		})
+	}
+	return true, nil
+}
+
+// GetLabSamples fetches samples for the laboratory work queue
+// @Summary Get lab samples
+// @Description Get laboratory samples filtered by status, receiving lab or alert
+// @Tags lab
+// @Accept json
+// @Produce json
+// @Param status query string false "Filter by sample status"
+// @Param receiving_lab query string false "Filter by receiving laboratory"
+// @Param alert_id query int false "Filter by alert ID"
+// @Param page query int false "Page number"
+// @Param limit query int false "Number of records per page"
+// @Success 200 {array} models.LabSample
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/lab-samples [get]
+func (h *LabHandler) GetLabSamples(c *fiber.Ctx) error {
+	var samples []models.LabSample
+	query := h.db.Preload("Tests")
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	offset := (page - 1) * limit
+
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if lab := c.Query("receiving_lab"); lab != "" {
+		query = query.Where("receiving_lab = ?", lab)
+	}
+	if alertID := c.Query("alert_id"); alertID != "" {
+		query = query.Where("alert_id = ?", alertID)
+	}
+
+	if err := query.Order("collection_date DESC").Offset(offset).Limit(limit).Find(&samples).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch lab samples",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(samples)
+}
+
+// GetAlertLabSamples fetches the samples collected for an alert
+// @Summary Get alert lab samples
+// @Description Get all laboratory samples and tests for an alert
+// @Tags lab
+// @Accept json
+// @Produce json
+// @Param id path int true "Alert ID"
+// @Success 200 {array} models.LabSample
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/alerts/{id}/lab-samples [get]
+func (h *LabHandler) GetAlertLabSamples(c *fiber.Ctx) error {
+	var samples []models.LabSample
+	if err := h.db.Preload("Tests").Where("alert_id = ?", c.Params("id")).Order("collection_date").Find(&samples).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch lab samples",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(samples)
+}
+
+// GetLabSample fetches a single sample with its tests
+// @Summary Get lab sample by ID
+// @Description Get a laboratory sample with its tests
+// @Tags lab
+// @Accept json
+// @Produce json
+// @Param id path int true "Lab sample ID"
+// @Success 200 {object} models.LabSample
+// @Failure 404 {object} fiber.Map
+// @Router /api/v1/lab-samples/{id} [get]
+func (h *LabHandler) GetLabSample(c *fiber.Ctx) error {
+	var sample models.LabSample
+	if err := h.db.Preload("Tests").First(&sample, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Lab sample not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch lab sample",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(sample)
+}
+
+// CreateLabSample registers a sample collected for an alert
+// @Summary Register lab sample
+// @Description Register a sample collected for an alert
+// @Tags lab
+// @Accept json
+// @Produce json
+// @Param id path int true "Alert ID"
+// @Param sample body models.LabSample true "Lab sample object"
+// @Success 201 {object} models.LabSample
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 409 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/alerts/{id}/lab-samples [post]
+func (h *LabHandler) CreateLabSample(c *fiber.Ctx) error {
+	var alert models.Alert
+	if err := h.db.First(&alert, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Alert not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch alert",
+			"details": err.Error(),
+		})
+	}
+
+	sample := new(models.LabSample)
+	if err := c.BodyParser(sample); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+
+	if sample.SampleNumber == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Sample number is required",
+		})
+	}
+	if sample.SampleType == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Sample type is required",
+		})
+	}
+
+	// Deleted samples keep their number in the unique index
+	var existing int64
+	if err := h.db.Unscoped().Model(&models.LabSample{}).Where("sample_number = ?", sample.SampleNumber).Count(&existing).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to check sample number",
+			"details": err.Error(),
+		})
+	}
+	if existing > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Sample number is already registered",
+		})
+	}
+
+	sample.ID = 0
+	sample.AlertID = alert.ID
+	sample.Tests = nil
+	sample.ReceivedDate = nil
+	sample.ReceivedBy = nil
+	if sample.CollectionDate.IsZero() {
+		sample.CollectionDate = time.Now()
+	}
+	if sample.CollectedBy == nil || *sample.CollectedBy == "" {
+		username := c.Locals("username").(string)
+		sample.CollectedBy = &username
+	}
+	sample.Status = models.LabSampleCollected
+	if sample.ShipmentDate != nil {
+		sample.Status = models.LabSampleShipped
+	}
+
+	if err := h.db.Create(sample).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to register lab sample",
+			"details": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(sample)
+}
+
+// UpdateLabSample updates the collection and shipment details of a sample
+// @Summary Update lab sample
+// @Description Update a sample's collection or shipment details
+// @Tags lab
+// @Accept json
+// @Produce json
+// @Param id path int true "Lab sample ID"
+// @Param sample body models.LabSample true "Lab sample object"
+// @Success 200 {object} models.LabSample
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/lab-samples/{id} [put]
+func (h *LabHandler) UpdateLabSample(c *fiber.Ctx) error {
+	var sample models.LabSample
+	if ok, err := h.findSample(c, &sample); !ok {
+		return err
+	}
+
+	var input struct {
+		SampleType     *string    `json:"sampleType"`
+		CollectionDate *time.Time `json:"collectionDate"`
+		CollectedBy    *string    `json:"collectedBy"`
+		ShipmentDate   *time.Time `json:"shipmentDate"`
+		ReceivingLab   *string    `json:"receivingLab"`
+		Remarks        *string    `json:"remarks"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+
+	if input.SampleType != nil && *input.SampleType != "" {
+		sample.SampleType = *input.SampleType
+	}
+	if input.CollectionDate != nil {
+		sample.CollectionDate = *input.CollectionDate
+	}
+	if input.CollectedBy != nil {
+		sample.CollectedBy = input.CollectedBy
+	}
+	if input.ReceivingLab != nil {
+		sample.ReceivingLab = input.ReceivingLab
+	}
+	if input.Remarks != nil {
+		sample.Remarks = input.Remarks
+	}
+	if input.ShipmentDate != nil {
+		sample.ShipmentDate = input.ShipmentDate
+		if sample.Status == models.LabSampleCollected {
+			sample.Status = models.LabSampleShipped
+		}
+	}
+
+	if err := h.db.Save(&sample).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to update lab sample",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(sample)
+}
+
+// ReceiveLabSample records the arrival of a sample at the laboratory
+// @Summary Receive lab sample
+// @Description Record that the laboratory received, or rejected, a sample
+// @Tags lab
+// @Accept json
+// @Produce json
+// @Param id path int true "Lab sample ID"
+// @Param input body map[string]interface{} false "Optional receivingLab, rejected flag and remarks"
+// @Success 200 {object} models.LabSample
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/lab-samples/{id}/receive [post]
+func (h *LabHandler) ReceiveLabSample(c *fiber.Ctx) error {
+	var sample models.LabSample
+	if ok, err := h.findSample(c, &sample); !ok {
+		return err
+	}
+
+	var input struct {
+		ReceivingLab *string `json:"receivingLab"`
+		Rejected     bool    `json:"rejected"`
+		Remarks      *string `json:"remarks"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Invalid request body",
+				"details": err.Error(),
+			})
+		}
+	}
+
+	if sample.Status == models.LabSampleTested || sample.Status == models.LabSampleRejected {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Sample has already been processed",
+		})
+	}
+
+	now := time.Now()
+	username := c.Locals("username").(string)
+	sample.ReceivedDate = &now
+	sample.ReceivedBy = &username
+	sample.Status = models.LabSampleReceived
+	if input.Rejected {
+		sample.Status = models.LabSampleRejected
+	}
+	if input.ReceivingLab != nil {
+		sample.ReceivingLab = input.ReceivingLab
+	}
+	if input.Remarks != nil {
+		sample.Remarks = input.Remarks
+	}
+
+	if err := h.db.Save(&sample).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to update lab sample",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(sample)
+}
+
+// DeleteLabSample handles deleting a lab sample
+// @Summary Delete lab sample
+// @Description Delete a laboratory sample by ID; the alert's lab result and classification are worked out again without its tests
+// @Tags lab
+// @Accept json
+// @Produce json
+// @Param id path int true "Lab sample ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/lab-samples/{id} [delete]
+func (h *LabHandler) DeleteLabSample(c *fiber.Ctx) error {
+	var sample models.LabSample
+	if ok, err := h.findSample(c, &sample); !ok {
+		return err
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&sample).Error; err != nil {
+			return err
+		}
+
+		// The alert's lab result is worked out again without the sample's tests
+		var alert models.Alert
+		err := tx.First(&alert, sample.AlertID).Error
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		tests, err := alertLabTests(tx, alert.ID)
+		if err != nil {
+			return err
+		}
+		alert.LabResult, alert.LabResultDate = nil, nil
+		if result, ok := aggregateLabResult(tests); ok {
+			alert.LabResult = &result
+			alert.LabResultDate = labResultDate(tests, result)
+		}
+		if err := classifyAlert(tx, &alert); err != nil {
+			return err
+		}
+		return tx.Model(&alert).
+			Select("LabResult", "LabResultDate", "Syndromes", "Classification", "Priority", "IsHighlighted", "RiskScore").
+			Updates(&alert).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to delete lab sample",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Lab sample deleted successfully",
+	})
+}
+
+// CreateLabTest requests a test on a received sample
+// @Summary Create lab test
+// @Description Add a test to a received sample; the result starts as Pending
+// @Tags lab
+// @Accept json
+// @Produce json
+// @Param id path int true "Lab sample ID"
+// @Param test body models.LabTest true "Lab test object"
+// @Success 201 {object} models.LabTest
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/lab-samples/{id}/tests [post]
+func (h *LabHandler) CreateLabTest(c *fiber.Ctx) error {
+	var sample models.LabSample
+	if ok, err := h.findSample(c, &sample); !ok {
+		return err
+	}
+
+	if !sampleAtLab(sample) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Sample has not been received by the laboratory",
+			"status": sample.Status,
+		})
+	}
+
+	test := new(models.LabTest)
+	if err := c.BodyParser(test); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+	if test.TestName == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Test name is required",
+		})
+	}
+
+	test.ID = 0
+	test.LabSampleID = sample.ID
+	test.Result = models.LabResultPending
+	test.ResultDate = nil
+
+	if err := h.db.Create(test).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to create lab test",
+			"details": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(test)
+}
+
+// PostLabResult records a test result, updates the alert and notifies the district
+// @Summary Post lab result
+// @Description Record a test result on a sample received by the laboratory; the alert's lab result and classification are updated and the district is notified
+// @Tags lab
+// @Accept json
+// @Produce json
+// @Param id path int true "Lab test ID"
+// @Param result body map[string]interface{} true "result (Positive, Negative or Inconclusive), resultDate and remarks"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/lab-tests/{id}/result [post]
+func (h *LabHandler) PostLabResult(c *fiber.Ctx) error {
+	var input struct {
+		Result     string     `json:"result"`
+		ResultDate *time.Time `json:"resultDate"`
+		Remarks    *string    `json:"remarks"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+
+	switch input.Result {
+	case models.LabResultPositive, models.LabResultNegative, models.LabResultInconclusive:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Result must be Positive, Negative or Inconclusive",
+		})
+	}
+
+	var test models.LabTest
+	if err := h.db.First(&test, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Lab test not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch lab test",
+			"details": err.Error(),
+		})
+	}
+
+	var sample models.LabSample
+	if err := h.db.First(&sample, test.LabSampleID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch lab sample",
+			"details": err.Error(),
+		})
+	}
+
+	if !sampleAtLab(sample) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Sample has not been received by the laboratory",
+			"status": sample.Status,
+		})
+	}
+
+	var alert models.Alert
+	if err := h.db.First(&alert, sample.AlertID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch alert",
+			"details": err.Error(),
+		})
+	}
+
+	now := time.Now()
+	username := c.Locals("username").(string)
+	test.Result = input.Result
+	test.ResultDate = &now
+	if input.ResultDate != nil {
+		test.ResultDate = input.ResultDate
+	}
+	test.PerformedBy = &username
+	if input.Remarks != nil {
+		test.Remarks = input.Remarks
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&test).Error; err != nil {
+			return err
+		}
+
+		sample.Status = models.LabSampleTested
+		if err := tx.Model(&sample).Update("status", sample.Status).Error; err != nil {
+			return err
+		}
+
+		tests, err := alertLabTests(tx, alert.ID)
+		if err != nil {
+			return err
+		}
+
+		// A corrected result can take the alert's result back to pending
+		alert.LabResult, alert.LabResultDate = nil, nil
+		if result, ok := aggregateLabResult(tests); ok {
+			alert.LabResult = &result
+			alert.LabResultDate = labResultDate(tests, result)
+		}
+		if err := classifyAlert(tx, &alert); err != nil {
+			return err
+		}
+		if err := tx.Model(&alert).
+			Select("LabResult", "LabResultDate", "Syndromes", "Classification", "Priority", "IsHighlighted", "RiskScore").
+			Updates(&alert).Error; err != nil {
+			return err
+		}
+
+		return notifyDistrict(tx, alert.AlertCaseDistrict, models.Notification{
+			AlertID: &alert.ID,
+			Type:    NotificationLabResult,
+			Title:   fmt.Sprintf("Lab result for alert #%d: %s", alert.ID, test.Result),
+			Message: fmt.Sprintf("%s on sample %s (%s) is %s.", test.TestName, sample.SampleNumber, sample.SampleType, test.Result),
+		})
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to record lab result",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Lab result recorded successfully",
+		"test":    test,
+		"alert":   alert,
+	})
+}
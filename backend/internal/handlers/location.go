@@ -0,0 +1,456 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// Alert location sources
+const (
+	LocationSourceGPS       = "gps"
+	LocationSourceVillage   = "village"
+	LocationSourceSubcounty = "subcounty"
+	LocationSourceDistrict  = "district"
+)
+
+// findRegion resolves a region recorded either by its ID or by its name,
+// with or without the " Region" suffix. found is false if none matches.
+func findRegion(db *gorm.DB, value string) (region models.Region, found bool, err error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return region, false, nil
+	}
+	if id, parseErr := strconv.ParseUint(value, 10, 32); parseErr == nil {
+		err = db.First(&region, id).Error
+	} else {
+		err = db.Where("region = ? OR region = ?", value, value+" Region").First(&region).Error
+	}
+	if err == gorm.ErrRecordNotFound {
+		return region, false, nil
+	}
+	return region, err == nil, err
+}
+
+// findDistrict resolves a district recorded either by its ID or by its name,
+// with or without the " District" suffix. found is false if none matches.
+func findDistrict(db *gorm.DB, value string) (district models.District, found bool, err error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return district, false, nil
+	}
+	if id, parseErr := strconv.ParseUint(value, 10, 32); parseErr == nil {
+		err = db.First(&district, id).Error
+	} else {
+		err = db.Where("district = ? OR district = ?", value, value+" District").First(&district).Error
+	}
+	if err == gorm.ErrRecordNotFound {
+		return district, false, nil
+	}
+	return district, err == nil, err
+}
+
+// findSubcounty resolves a subcounty recorded either by its ID or by its
+// name, with or without the " Subcounty" suffix. Subcounty names are not
+// unique across the country, so a name is looked up in the district if one
+// is given, and otherwise only matches a name no other subcounty has. found
+// is false if none matches.
+func findSubcounty(db *gorm.DB, value string, districtID *uint) (subcounty models.Subcounty, found bool, err error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return subcounty, false, nil
+	}
+	if id, parseErr := strconv.ParseUint(value, 10, 32); parseErr == nil {
+		err = db.First(&subcounty, id).Error
+		if err == gorm.ErrRecordNotFound {
+			return subcounty, false, nil
+		}
+		return subcounty, err == nil, err
+	}
+
+	query := db.Where("subcounty = ? OR subcounty = ?", value, value+" Subcounty")
+	if districtID != nil {
+		query = query.Where("district_id = ?", *districtID)
+	}
+	var subcounties []models.Subcounty
+	if err := query.Limit(2).Find(&subcounties).Error; err != nil {
+		return subcounty, false, err
+	}
+	if len(subcounties) != 1 {
+		return subcounty, false, nil
+	}
+	return subcounties[0], true, nil
+}
+
+// resolveAlertAdminUnits validates the region, district, subcounty, parish,
+// village and facility IDs of an alert and fills in missing parents from
+// their children. Alerts without IDs are resolved from their free-text names
+// where these match exactly, the subcounty within the district named on the
+// alert. Empty free-text columns, still read by the PHP
+// system, are set to the names of the referenced units; names already given
+// are kept as the PHP system compares them with user affiliations. When
+// requireVillage is set the case's village must be a known village. It
+// returns a message describing the first problem found, or an empty string.
+func resolveAlertAdminUnits(db *gorm.DB, alert *models.Alert, requireVillage bool) (string, error) {
+	if alert.SubcountyID == nil && alert.AlertCaseSubCounty != nil {
+		districtID := alert.DistrictID
+		if districtID == nil && alert.AlertCaseDistrict != nil {
+			district, found, err := findDistrict(db, *alert.AlertCaseDistrict)
+			if err != nil {
+				return "", err
+			}
+			if found {
+				districtID = &district.ID
+			}
+		}
+		subcounty, found, err := findSubcounty(db, *alert.AlertCaseSubCounty, districtID)
+		if err != nil {
+			return "", err
+		}
+		if found && (districtID == nil || *districtID == subcounty.DistrictID) {
+			alert.SubcountyID = &subcounty.ID
+		}
+	}
+	if msg, err := resolveAlertFacility(db, alert); err != nil || msg != "" {
+		return msg, err
+	}
+	if msg, err := resolveAlertVillage(db, alert, requireVillage); err != nil || msg != "" {
+		return msg, err
+	}
+	if alert.DistrictID == nil && alert.SubcountyID == nil && alert.AlertCaseDistrict != nil {
+		district, found, err := findDistrict(db, *alert.AlertCaseDistrict)
+		if err != nil {
+			return "", err
+		}
+		if found {
+			alert.DistrictID = &district.ID
+		}
+	}
+
+	if alert.SubcountyID != nil {
+		var subcounty models.Subcounty
+		if err := db.First(&subcounty, *alert.SubcountyID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return "Subcounty not found", nil
+			}
+			return "", err
+		}
+		if alert.DistrictID != nil && *alert.DistrictID != subcounty.DistrictID {
+			return "Subcounty does not belong to the district", nil
+		}
+		alert.DistrictID = &subcounty.DistrictID
+		if stringValue(alert.AlertCaseSubCounty) == "" {
+			alert.AlertCaseSubCounty = &subcounty.Subcounty
+		}
+	}
+
+	if alert.DistrictID != nil {
+		var district models.District
+		if err := db.First(&district, *alert.DistrictID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return "District not found", nil
+			}
+			return "", err
+		}
+		if alert.RegionID != nil && district.RegionID != 0 && *alert.RegionID != district.RegionID {
+			return "District does not belong to the region", nil
+		}
+		if district.RegionID != 0 {
+			alert.RegionID = &district.RegionID
+		}
+		if stringValue(alert.AlertCaseDistrict) == "" {
+			alert.AlertCaseDistrict = &district.District
+		}
+	}
+
+	if alert.RegionID != nil {
+		var region models.Region
+		if err := db.First(&region, *alert.RegionID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return "Region not found", nil
+			}
+			return "", err
+		}
+		if stringValue(alert.Region) == "" {
+			alert.Region = &region.Region
+		}
+	}
+	return "", nil
+}
+
+// resolveAlertFacility validates an alert's facility ID, resolving it from
+// the facility name when missing, and takes the subcounty from the facility.
+// The facility type recorded on alerts is the facility's ownership.
+func resolveAlertFacility(db *gorm.DB, alert *models.Alert) (string, error) {
+	facilityType := strings.ToUpper(strings.TrimSpace(stringValue(alert.FacilityType)))
+
+	if name := strings.TrimSpace(stringValue(alert.Facility)); alert.FacilityID == nil && name != "" {
+		query := db.Where("facility = ?", name)
+		if alert.SubcountyID != nil {
+			query = query.Where("subcounty_id = ?", *alert.SubcountyID)
+		} else if alert.DistrictID != nil {
+			query = query.Where("subcounty_id IN (?)", db.Model(&models.Subcounty{}).Select("id").Where("district_id = ?", *alert.DistrictID))
+		}
+		if facilityType != "" {
+			query = query.Where("ownership = ?", facilityType)
+		}
+		// Facility names are not unique across the country, so a name
+		// is only linked when it identifies a single facility
+		var facilities []models.Facility
+		if err := query.Limit(2).Find(&facilities).Error; err != nil {
+			return "", err
+		}
+		if len(facilities) == 1 {
+			alert.FacilityID = &facilities[0].ID
+		}
+	}
+	if alert.FacilityID == nil {
+		return "", nil
+	}
+
+	var facility models.Facility
+	if err := db.First(&facility, *alert.FacilityID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return "Facility not found", nil
+		}
+		return "", err
+	}
+	if facilityType != "" && facilityType != facility.Ownership {
+		return "Facility type does not match the facility's ownership", nil
+	}
+	if facility.SubcountyID != nil {
+		if alert.SubcountyID != nil && *alert.SubcountyID != *facility.SubcountyID {
+			return "Facility does not belong to the subcounty", nil
+		}
+		alert.SubcountyID = facility.SubcountyID
+	}
+	if stringValue(alert.Facility) == "" {
+		alert.Facility = &facility.Facility
+	}
+	alert.FacilityType = &facility.Ownership
+	return "", nil
+}
+
+// resolveAlertVillage validates an alert's parish and village IDs, resolving
+// them from the names when missing, and takes the subcounty from the parish.
+// Village and parish names repeat across the country, so names are only
+// looked up within the alert's parish or subcounty and linked when they
+// identify a single unit.
+func resolveAlertVillage(db *gorm.DB, alert *models.Alert, requireVillage bool) (string, error) {
+	if name := strings.TrimSpace(stringValue(alert.AlertCaseParish)); alert.ParishID == nil && alert.VillageID == nil && name != "" && alert.SubcountyID != nil {
+		var parishes []models.Parish
+		if err := db.Where("subcounty_id = ? AND parish = ?", *alert.SubcountyID, name).Limit(2).Find(&parishes).Error; err != nil {
+			return "", err
+		}
+		if len(parishes) == 1 {
+			alert.ParishID = &parishes[0].ID
+		}
+	}
+	if name := strings.TrimSpace(stringValue(alert.AlertCaseVillage)); alert.VillageID == nil && name != "" {
+		query := db.Where("village = ?", name)
+		switch {
+		case alert.ParishID != nil:
+			query = query.Where("parish_id = ?", *alert.ParishID)
+		case alert.SubcountyID != nil:
+			query = query.Where("parish_id IN (?)", db.Model(&models.Parish{}).Select("id").Where("subcounty_id = ?", *alert.SubcountyID))
+		default:
+			query = nil
+		}
+		if query != nil {
+			var villages []models.Village
+			if err := query.Limit(2).Find(&villages).Error; err != nil {
+				return "", err
+			}
+			if len(villages) == 1 {
+				alert.VillageID = &villages[0].ID
+			}
+		}
+	}
+
+	if alert.VillageID != nil {
+		var village models.Village
+		if err := db.First(&village, *alert.VillageID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return "Village not found", nil
+			}
+			return "", err
+		}
+		if alert.ParishID != nil && *alert.ParishID != village.ParishID {
+			return "Village does not belong to the parish", nil
+		}
+		alert.ParishID = &village.ParishID
+		if stringValue(alert.AlertCaseVillage) == "" {
+			alert.AlertCaseVillage = &village.Village
+		}
+	} else if requireVillage {
+		return "Village must be a known village of the case's subcounty", nil
+	}
+
+	if alert.ParishID != nil {
+		var parish models.Parish
+		if err := db.First(&parish, *alert.ParishID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return "Parish not found", nil
+			}
+			return "", err
+		}
+		if alert.SubcountyID != nil && *alert.SubcountyID != parish.SubcountyID {
+			return "Parish does not belong to the subcounty", nil
+		}
+		alert.SubcountyID = &parish.SubcountyID
+		if stringValue(alert.AlertCaseParish) == "" {
+			alert.AlertCaseParish = &parish.Parish
+		}
+	}
+	return "", nil
+}
+
+// adminUnitNames records an alert's free-text location names and unit IDs
+// by value, so they survive the request body being decoded into the alert
+type adminUnitNames struct {
+	region, district, subcounty, parish, village, facility             string
+	regionID, districtID, subcountyID, parishID, villageID, facilityID uint
+}
+
+// adminUnitsOf returns a copy of an alert's location names and unit IDs
+func adminUnitsOf(alert *models.Alert) adminUnitNames {
+	return adminUnitNames{
+		region:      strings.TrimSpace(stringValue(alert.Region)),
+		district:    strings.TrimSpace(stringValue(alert.AlertCaseDistrict)),
+		subcounty:   strings.TrimSpace(stringValue(alert.AlertCaseSubCounty)),
+		parish:      strings.TrimSpace(stringValue(alert.AlertCaseParish)),
+		village:     strings.TrimSpace(stringValue(alert.AlertCaseVillage)),
+		facility:    strings.TrimSpace(stringValue(alert.Facility)),
+		regionID:    uintValue(alert.RegionID),
+		districtID:  uintValue(alert.DistrictID),
+		subcountyID: uintValue(alert.SubcountyID),
+		parishID:    uintValue(alert.ParishID),
+		villageID:   uintValue(alert.VillageID),
+		facilityID:  uintValue(alert.FacilityID),
+	}
+}
+
+// clearChangedAdminUnits drops the IDs whose free-text name was changed
+// without the ID, so they are resolved again from the new name, along with
+// the IDs of the units below
+func clearChangedAdminUnits(before adminUnitNames, alert *models.Alert) {
+	after := adminUnitsOf(alert)
+	if after.facility != before.facility && after.facilityID == before.facilityID {
+		alert.FacilityID = nil
+	}
+	if after.village != before.village && after.villageID == before.villageID {
+		alert.VillageID = nil
+	}
+	if after.parish != before.parish && after.parishID == before.parishID {
+		alert.ParishID, alert.VillageID = nil, nil
+	}
+	if after.subcounty != before.subcounty && after.subcountyID == before.subcountyID {
+		alert.SubcountyID, alert.ParishID, alert.VillageID = nil, nil, nil
+	}
+	if after.district != before.district && after.districtID == before.districtID {
+		alert.DistrictID = nil
+		alert.SubcountyID, alert.ParishID, alert.VillageID = nil, nil, nil
+	}
+	if after.region != before.region && after.regionID == before.regionID {
+		alert.RegionID = nil
+	}
+}
+
+// stringValue returns the string a pointer refers to, or an empty string
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// uintValue returns the number a pointer refers to, or zero
+func uintValue(n *uint) uint {
+	if n == nil {
+		return 0
+	}
+	return *n
+}
+
+// validateLocation checks an alert's coordinates and returns a message
+// describing the first problem found, or an empty string
+func validateLocation(alert *models.Alert) string {
+	if (alert.Latitude == nil) != (alert.Longitude == nil) {
+		return "Latitude and longitude must be given together"
+	}
+	if alert.Latitude != nil && (*alert.Latitude < -90 || *alert.Latitude > 90) {
+		return "Latitude must be between -90 and 90"
+	}
+	if alert.Longitude != nil && (*alert.Longitude < -180 || *alert.Longitude > 180) {
+		return "Longitude must be between -180 and 180"
+	}
+	if alert.LocationAccuracy != nil && *alert.LocationAccuracy < 0 {
+		return "Location accuracy cannot be negative"
+	}
+	return ""
+}
+
+// locateAlert fills in an alert's location. Coordinates captured for the
+// alert itself are kept; otherwise the centroid of the case's village,
+// subcounty or district is used, the first that is known. It must run after
+// resolveAlertAdminUnits. Callers clear LocationSource when new coordinates
+// are supplied.
+func locateAlert(db *gorm.DB, alert *models.Alert) error {
+	if alert.Latitude != nil && alert.Longitude != nil &&
+		(alert.LocationSource == nil || *alert.LocationSource == LocationSourceGPS) {
+		source := LocationSourceGPS
+		alert.LocationSource = &source
+		return nil
+	}
+
+	alert.Latitude, alert.Longitude, alert.LocationAccuracy, alert.LocationSource = nil, nil, nil, nil
+
+	if alert.VillageID != nil {
+		var village models.Village
+		err := db.First(&village, *alert.VillageID).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		if village.Latitude != nil && village.Longitude != nil {
+			source := LocationSourceVillage
+			alert.Latitude, alert.Longitude, alert.LocationSource = village.Latitude, village.Longitude, &source
+			return nil
+		}
+	}
+
+	if alert.SubcountyID != nil {
+		var subcounty models.Subcounty
+		err := db.First(&subcounty, *alert.SubcountyID).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		if subcounty.Latitude != nil && subcounty.Longitude != nil {
+			source := LocationSourceSubcounty
+			alert.Latitude, alert.Longitude, alert.LocationSource = subcounty.Latitude, subcounty.Longitude, &source
+			return nil
+		}
+	}
+
+	if alert.DistrictID != nil {
+		var district models.District
+		err := db.First(&district, *alert.DistrictID).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		if district.Latitude != nil && district.Longitude != nil {
+			source := LocationSourceDistrict
+			alert.Latitude, alert.Longitude, alert.LocationSource = district.Latitude, district.Longitude, &source
+		}
+	}
+	return nil
+}
+
+// coordinatesChanged reports whether an alert's coordinates differ from before
+func coordinatesChanged(before, after *float64) bool {
+	if before == nil || after == nil {
+		return before != after
+	}
+	return *before != *after
+}
@@ -0,0 +1,453 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// mentionPattern matches @username mentions in a note
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9_.\-]+)`)
+
+// NoteHandler handles alert note HTTP requests
+type NoteHandler struct {
+	db *gorm.DB
+}
+
+// NewNoteHandler creates a new NoteHandler
+func NewNoteHandler(db *gorm.DB) *NoteHandler {
+	return &NoteHandler{db: db}
+}
+
+// mentionedUsernames returns the distinct usernames mentioned in a note body
+func mentionedUsernames(body string) []string {
+	seen := make(map[string]bool)
+	var usernames []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		username := strings.TrimRight(match[1], ".-")
+		if username == "" || seen[strings.ToLower(username)] {
+			continue
+		}
+		seen[strings.ToLower(username)] = true
+		usernames = append(usernames, username)
+	}
+	return usernames
+}
+
+// notifyMentions notifies the users mentioned in a note, skipping the author
+// and anyone listed in skip (already notified for an earlier revision)
+func notifyMentions(db *gorm.DB, note models.AlertNote, skip []string) error {
+	usernames := mentionedUsernames(note.Body)
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	var ids []uint
+	query := db.Model(&models.User{}).Where("username IN ? AND id <> ?", usernames, note.AuthorID)
+	if len(skip) > 0 {
+		query = query.Where("username NOT IN ?", skip)
+	}
+	if err := query.Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	return notify(db, ids, models.Notification{
+		AlertID: &note.AlertID,
+		Type:    NotificationMention,
+		Title:   fmt.Sprintf("%s mentioned you on alert #%d", note.Author, note.AlertID),
+		Message: note.Body,
+	})
+}
+
+// validateNoteVisibility defaults an empty visibility to internal
+func validateNoteVisibility(visibility *string) bool {
+	if *visibility == "" {
+		*visibility = models.NoteVisibilityInternal
+	}
+	return *visibility == models.NoteVisibilityInternal || *visibility == models.NoteVisibilityShared
+}
+
+// findNote loads a note by the id route parameter. When the note cannot be
+// loaded the error response is written and ok is false.
+func (h *NoteHandler) findNote(c *fiber.Ctx, note *models.AlertNote) (bool, error) {
+	if err := h.db.First(note, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Note not found",
+			})
+		}
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch note",
+			"details": err.Error(),
+		})
+	}
+	return true, nil
+}
+
+// GetAlertNotes fetches the notes of an alert
+// @Summary Get alert notes
+// @Description Get an alert's notes, oldest first. Replies carry their parent's ID.
+// @Tags notes
+// @Produce json
+// @Param id path int true "Alert ID"
+// @Param visibility query string false "internal or shared"
+// @Success 200 {array} models.AlertNote
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/alerts/{id}/notes [get]
+func (h *NoteHandler) GetAlertNotes(c *fiber.Ctx) error {
+	var alert models.Alert
+	if ok, err := findAlert(h.db, c, &alert); !ok {
+		return err
+	}
+
+	query := h.db.Where("alert_id = ?", alert.ID)
+	if visibility := c.Query("visibility"); visibility != "" {
+		query = query.Where("visibility = ?", visibility)
+	}
+
+	var notes []models.AlertNote
+	if err := query.Order("created_at, id").Find(&notes).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch notes",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(notes)
+}
+
+// GetSharedAlertNotes fetches the notes shared with the verifier of an alert
+// @Summary Get notes shared with the verifier
+// @Description Get an alert's shared notes using its verification token
+// @Tags notes
+// @Produce json
+// @Param id path int true "Alert ID"
+// @Param token query string true "Verification token"
+// @Success 200 {array} models.AlertNote
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/alerts/{id}/shared-notes [get]
+func (h *NoteHandler) GetSharedAlertNotes(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Token is required",
+		})
+	}
+
+	var verification models.AlertVerificationToken
+	if err := h.db.Where("alert_id = ? AND token = ? AND used = ?", c.Params("id"), token, false).First(&verification).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid or already used token",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to validate token",
+			"details": err.Error(),
+		})
+	}
+
+	var notes []models.AlertNote
+	err := h.db.Where("alert_id = ? AND visibility = ?", verification.AlertID, models.NoteVisibilityShared).
+		Order("created_at, id").Find(&notes).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch notes",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(notes)
+}
+
+// CreateAlertNote adds a note or a reply to an alert
+// @Summary Create alert note
+// @Description Add a note to an alert; @username mentions notify the mentioned users
+// @Tags notes
+// @Accept json
+// @Produce json
+// @Param id path int true "Alert ID"
+// @Param note body map[string]interface{} true "body, visibility and optional parentId"
+// @Success 201 {object} models.AlertNote
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/alerts/{id}/notes [post]
+func (h *NoteHandler) CreateAlertNote(c *fiber.Ctx) error {
+	var user models.User
+	if ok, err := currentUser(h.db, c, &user); !ok {
+		return err
+	}
+
+	var alert models.Alert
+	if ok, err := findAlert(h.db, c, &alert); !ok {
+		return err
+	}
+
+	var input struct {
+		Body       string `json:"body"`
+		Visibility string `json:"visibility"`
+		ParentID   *uint  `json:"parentId"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+
+	if strings.TrimSpace(input.Body) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Note body is required",
+		})
+	}
+	if !validateNoteVisibility(&input.Visibility) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Visibility must be internal or shared",
+		})
+	}
+
+	if input.ParentID != nil {
+		var parent models.AlertNote
+		if err := h.db.Where("id = ? AND alert_id = ?", *input.ParentID, alert.ID).First(&parent).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Parent note not found on this alert",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "Failed to fetch parent note",
+				"details": err.Error(),
+			})
+		}
+		// Replies to internal notes must not leak to the verifier
+		if parent.Visibility == models.NoteVisibilityInternal {
+			input.Visibility = models.NoteVisibilityInternal
+		}
+	}
+
+	note := models.AlertNote{
+		AlertID:    alert.ID,
+		ParentID:   input.ParentID,
+		AuthorID:   user.ID,
+		Author:     user.Username,
+		Body:       input.Body,
+		Visibility: input.Visibility,
+		Revision:   1,
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&note).Error; err != nil {
+			return err
+		}
+		return notifyMentions(tx, note, nil)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to create note",
+			"details": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(note)
+}
+
+// UpdateAlertNote edits a note, keeping its previous content as a revision
+// @Summary Update alert note
+// @Description Edit one of your notes; the previous content is kept as a revision
+// @Tags notes
+// @Accept json
+// @Produce json
+// @Param id path int true "Note ID"
+// @Param note body map[string]interface{} true "body and visibility"
+// @Success 200 {object} models.AlertNote
+// @Failure 400 {object} fiber.Map
+// @Failure 403 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/notes/{id} [put]
+func (h *NoteHandler) UpdateAlertNote(c *fiber.Ctx) error {
+	var note models.AlertNote
+	if ok, err := h.findNote(c, &note); !ok {
+		return err
+	}
+
+	if note.AuthorID != c.Locals("user_id").(uint) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the author can edit a note",
+		})
+	}
+
+	var input struct {
+		Body       *string `json:"body"`
+		Visibility *string `json:"visibility"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+
+	previous := note
+	if input.Body != nil {
+		if strings.TrimSpace(*input.Body) == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Note body is required",
+			})
+		}
+		note.Body = *input.Body
+	}
+	if input.Visibility != nil {
+		if !validateNoteVisibility(input.Visibility) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Visibility must be internal or shared",
+			})
+		}
+		note.Visibility = *input.Visibility
+	}
+	if note.Body == previous.Body && note.Visibility == previous.Visibility {
+		return c.JSON(note)
+	}
+
+	if note.Visibility != previous.Visibility {
+		if ok, err := h.checkThreadVisibility(c, note); !ok {
+			return err
+		}
+	}
+	note.Revision++
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		revision := models.AlertNoteRevision{
+			NoteID:     previous.ID,
+			Revision:   previous.Revision,
+			Body:       previous.Body,
+			Visibility: previous.Visibility,
+			CreatedAt:  previous.UpdatedAt,
+		}
+		if err := tx.Create(&revision).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&note).Select("Body", "Visibility", "Revision").Updates(&note).Error; err != nil {
+			return err
+		}
+		// Only users newly mentioned by the edit are notified
+		return notifyMentions(tx, note, mentionedUsernames(previous.Body))
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to update note",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(note)
+}
+
+// checkThreadVisibility makes sure a change of a note's visibility does not
+// leak an internal thread to the verifier: a reply to an internal note must
+// stay internal, and a note with shared replies cannot be made internal
+func (h *NoteHandler) checkThreadVisibility(c *fiber.Ctx, note models.AlertNote) (bool, error) {
+	if note.Visibility == models.NoteVisibilityShared && note.ParentID != nil {
+		var parent models.AlertNote
+		if err := h.db.Select("visibility").Where("id = ?", *note.ParentID).First(&parent).Error; err != nil && err != gorm.ErrRecordNotFound {
+			return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "Failed to fetch parent note",
+				"details": err.Error(),
+			})
+		}
+		if parent.Visibility == models.NoteVisibilityInternal {
+			return false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Replies to an internal note must be internal",
+			})
+		}
+	}
+
+	if note.Visibility == models.NoteVisibilityInternal {
+		var count int64
+		err := h.db.Model(&models.AlertNote{}).
+			Where("parent_id = ? AND visibility = ?", note.ID, models.NoteVisibilityShared).
+			Count(&count).Error
+		if err != nil {
+			return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "Failed to fetch replies",
+				"details": err.Error(),
+			})
+		}
+		if count > 0 {
+			return false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "A note with shared replies cannot be made internal",
+			})
+		}
+	}
+	return true, nil
+}
+
+// GetNoteRevisions fetches the earlier revisions of a note
+// @Summary Get note revisions
+// @Description Get the earlier revisions of a note, oldest first
+// @Tags notes
+// @Produce json
+// @Param id path int true "Note ID"
+// @Success 200 {array} models.AlertNoteRevision
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/notes/{id}/revisions [get]
+func (h *NoteHandler) GetNoteRevisions(c *fiber.Ctx) error {
+	var note models.AlertNote
+	if ok, err := h.findNote(c, &note); !ok {
+		return err
+	}
+
+	var revisions []models.AlertNoteRevision
+	if err := h.db.Where("note_id = ?", note.ID).Order("revision").Find(&revisions).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch note revisions",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(revisions)
+}
+
+// DeleteAlertNote deletes a note
+// @Summary Delete alert note
+// @Description Delete a note; only its author or an admin may do so
+// @Tags notes
+// @Produce json
+// @Param id path int true "Note ID"
+// @Success 200 {object} fiber.Map
+// @Failure 403 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/notes/{id} [delete]
+func (h *NoteHandler) DeleteAlertNote(c *fiber.Ctx) error {
+	var user models.User
+	if ok, err := currentUser(h.db, c, &user); !ok {
+		return err
+	}
+
+	var note models.AlertNote
+	if ok, err := h.findNote(c, &note); !ok {
+		return err
+	}
+
+	if note.AuthorID != user.ID && !user.HasRole(models.RoleAdmin) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the author can delete a note",
+		})
+	}
+
+	if err := h.db.Delete(&note).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to delete note",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Note deleted successfully",
+	})
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Notification types
+const (
+	NotificationLabResult  = "lab_result"
+	NotificationAssignment = "assignment"
+	NotificationMention    = "mention"
+)
+
+// NotificationHandler handles notification-related HTTP requests
+type NotificationHandler struct {
+	db *gorm.DB
+}
+
+// NewNotificationHandler creates a new NotificationHandler
+func NewNotificationHandler(db *gorm.DB) *NotificationHandler {
+	return &NotificationHandler{db: db}
+}
+
+// notify creates a copy of the notification for each user
+func notify(db *gorm.DB, userIDs []uint, n models.Notification) error {
+	seen := make(map[uint]bool)
+	var notifications []models.Notification
+	for _, id := range userIDs {
+		if id == 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		notification := n
+		notification.UserID = id
+		notifications = append(notifications, notification)
+	}
+	if len(notifications) == 0 {
+		return nil
+	}
+	return db.Create(&notifications).Error
+}
+
+// districtUsers returns the users affiliated with a district. Alerts and user
+// affiliations record a district either by its ID or by its name.
+func districtUsers(db *gorm.DB, district string) ([]models.User, error) {
+	district = strings.TrimSpace(district)
+	if district == "" {
+		return nil, nil
+	}
+
+	d, found, err := findDistrict(db, district)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{district}
+	if found {
+		keys = append(keys, strconv.FormatUint(uint64(d.ID), 10), d.District, strings.TrimSuffix(d.District, " District"))
+	}
+
+	var users []models.User
+	if err := db.Where("affiliation IN ?", keys).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// notifyDistrict sends the notification to every user affiliated with a district
+func notifyDistrict(db *gorm.DB, district *string, n models.Notification) error {
+	if district == nil {
+		return nil
+	}
+	users, err := districtUsers(db, *district)
+	if err != nil {
+		return err
+	}
+	ids := make([]uint, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	return notify(db, ids, n)
+}
+
+// GetNotifications fetches the caller's notifications
+// @Summary Get notifications
+// @Description Get the authenticated user's notifications, newest first
+// @Tags notifications
+// @Produce json
+// @Security Bearer
+// @Param unread query bool false "Only unread notifications"
+// @Param limit query int false "Number of records to return"
+// @Success 200 {array} models.Notification
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/notifications [get]
+func (h *NotificationHandler) GetNotifications(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uint)
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+
+	query := h.db.Where("user_id = ?", userID)
+	if unread, _ := strconv.ParseBool(c.Query("unread")); unread {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var notifications []models.Notification
+	if err := query.Order("created_at DESC").Limit(limit).Find(&notifications).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch notifications",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(notifications)
+}
+
+// MarkNotificationRead marks one of the caller's notifications as read
+// @Summary Mark notification read
+// @Description Mark a notification as read
+// @Tags notifications
+// @Produce json
+// @Security Bearer
+// @Param id path int true "Notification ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/notifications/{id}/read [post]
+func (h *NotificationHandler) MarkNotificationRead(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uint)
+	result := h.db.Model(&models.Notification{}).
+		Where("id = ? AND user_id = ? AND read_at IS NULL", c.Params("id"), userID).
+		Update("read_at", time.Now())
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to update notification",
+			"details": result.Error.Error(),
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Notification not found",
+		})
+	}
+	return c.JSON(fiber.Map{
+		"message": "Notification marked as read",
+	})
+}
+
+// MarkAllNotificationsRead marks all of the caller's notifications as read
+// @Summary Mark all notifications read
+// @Description Mark all of the authenticated user's notifications as read
+// @Tags notifications
+// @Produce json
+// @Security Bearer
+// @Success 200 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/notifications/read-all [post]
+func (h *NotificationHandler) MarkAllNotificationsRead(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uint)
+	result := h.db.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Update("read_at", time.Now())
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to update notifications",
+			"details": result.Error.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"message": "Notifications marked as read",
+		"count":   result.RowsAffected,
+	})
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/auth"
+	"github.com/alertsMIS/backend/internal/mail"
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// forgotPasswordMessage is the answer to every reset request, so that it does
+// not tell whether an account exists
+const forgotPasswordMessage = "If an account matches, a password reset link has been sent to its email address"
+
+// PasswordResetHandler handles forgotten passwords
+type PasswordResetHandler struct {
+	db          *gorm.DB
+	resets      *auth.PasswordResets
+	sender      mail.Sender
+	revocations *auth.Revocations
+	resetURL    string
+}
+
+// NewPasswordResetHandler creates a new PasswordResetHandler. Reset links
+// point to resetURL with the token in the token query parameter.
+func NewPasswordResetHandler(db *gorm.DB, resets *auth.PasswordResets, sender mail.Sender, revocations *auth.Revocations, resetURL string) *PasswordResetHandler {
+	return &PasswordResetHandler{
+		db:          db,
+		resets:      resets,
+		sender:      sender,
+		revocations: revocations,
+		resetURL:    resetURL,
+	}
+}
+
+// tooManyRequests responds that a rate limit was reached, telling the client
+// when to retry
+func tooManyRequests(c *fiber.Ctx, retryAfter time.Duration, message string) error {
+	seconds := int((retryAfter + time.Second - 1) / time.Second)
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":      message,
+		"retryAfter": seconds,
+	})
+}
+
+// resetLink returns the link of the web app page resetting a password
+func (h *PasswordResetHandler) resetLink(token string) string {
+	link, err := url.Parse(h.resetURL)
+	if err != nil {
+		return h.resetURL + "?token=" + url.QueryEscape(token)
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+// ForgotPassword sends a password reset link
+// @Summary Request password reset
+// @Description Email a one-time password reset link to the account matching a username or email address. The response is the same whether or not an account matches. Requests are limited per IP address, and reset emails per account.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param body body map[string]string true "Username or email"
+// @Success 202 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 429 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/auth/password/forgot [post]
+func (h *PasswordResetHandler) ForgotPassword(c *fiber.Ctx) error {
+	var input struct {
+		Username string `json:"username"`
+		Email    string `json:"email"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	input.Username = strings.TrimSpace(input.Username)
+	input.Email = strings.TrimSpace(input.Email)
+	if input.Username == "" && input.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "username or email is required",
+		})
+	}
+
+	allowed, retryAfter, err := h.resets.AllowRequest(c.IP())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to request password reset",
+			"details": err.Error(),
+		})
+	}
+	if !allowed {
+		return tooManyRequests(c, retryAfter, "Too many password reset requests, please try again later")
+	}
+
+	query := h.db.Select("id", "username", "email")
+	if input.Username != "" {
+		query = query.Where("username = ?", input.Username)
+	} else {
+		query = query.Where("email = ?", input.Email)
+	}
+	var users []models.User
+	if err := query.Limit(2).Find(&users).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to request password reset",
+			"details": err.Error(),
+		})
+	}
+	// An email address shared by several accounts cannot tell which one
+	// to reset
+	if len(users) != 1 || users[0].Email == "" {
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": forgotPasswordMessage})
+	}
+	user := users[0]
+
+	token, ok, err := h.resets.Create(user.ID, c.IP())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to request password reset",
+			"details": err.Error(),
+		})
+	}
+	if ok {
+		msg := mail.Message{
+			To:      user.Email,
+			Subject: "Reset your Alerts MIS password",
+			Body: fmt.Sprintf("Hello %s,\n\n"+
+				"A password reset was requested for your Alerts MIS account. To choose a new password, open this link within %d minutes:\n\n"+
+				"%s\n\n"+
+				"The link can be used once. If you did not ask for a reset, ignore this email; your password is unchanged.\n",
+				user.Username, int(h.resets.TTL()/time.Minute), h.resetLink(token)),
+		}
+		// Sending in the background keeps the response time the same
+		// whether or not an account matched
+		go func() {
+			if err := h.sender.Send(msg); err != nil {
+				log.Printf("Failed to send password reset email to user %d: %v", user.ID, err)
+			}
+		}()
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": forgotPasswordMessage})
+}
+
+// ResetPassword sets a new password with a reset token
+// @Summary Reset password
+// @Description Set a new password with the token of a password reset link. Tokens expire and can be used once. Every session of the user is ended, so the user signs in again with the new password. Attempts are limited per IP address.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param body body map[string]string true "Reset token and new password"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 429 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/auth/password/reset [post]
+func (h *PasswordResetHandler) ResetPassword(c *fiber.Ctx) error {
+	var input struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.Token == "" || input.Password == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "token and password are required",
+		})
+	}
+
+	allowed, retryAfter, err := h.resets.AllowAttempt(c.IP())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to reset password",
+			"details": err.Error(),
+		})
+	}
+	if !allowed {
+		return tooManyRequests(c, retryAfter, "Too many password reset attempts, please try again later")
+	}
+
+	userID, err := h.resets.Reset(input.Token, input.Password)
+	switch err {
+	case nil:
+	case auth.ErrWeakPassword:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Password must be between %d and 72 characters long", auth.MinPasswordLength),
+		})
+	case auth.ErrInvalidResetToken:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid or expired reset token",
+		})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to reset password",
+			"details": err.Error(),
+		})
+	}
+
+	// Whoever knew the old password is signed out everywhere
+	if _, err := h.revocations.RevokeUser(userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Password was reset but sessions could not be ended",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Password has been reset, please sign in with the new password",
+	})
+}
@@ -0,0 +1,752 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// PatientHandler handles patient, hospitalization and risk factor HTTP requests
+type PatientHandler struct {
+	db *gorm.DB
+}
+
+// NewPatientHandler creates a new PatientHandler
+func NewPatientHandler(db *gorm.DB) *PatientHandler {
+	return &PatientHandler{db: db}
+}
+
+// validatePatient checks the enum fields of a patient
+func validatePatient(p *models.Patient) string {
+	if p.AgeUnit != nil && *p.AgeUnit != "Years" && *p.AgeUnit != "Months" {
+		return "Age unit must be Years or Months"
+	}
+	if p.Gender != nil && *p.Gender != "Male" && *p.Gender != "Female" {
+		return "Gender must be Male or Female"
+	}
+	if p.Status != nil && *p.Status != "Alive" && *p.Status != "Dead" {
+		return "Status must be Alive or Dead"
+	}
+	return ""
+}
+
+// findPatient loads a patient by the id route parameter. When the patient
+// cannot be loaded the error response is written and ok is false.
+func (h *PatientHandler) findPatient(c *fiber.Ctx, patient *models.Patient) (bool, error) {
+	if err := h.db.First(patient, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Patient not found",
+			})
+		}
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch patient",
+			"details": err.Error(),
+		})
+	}
+	return true, nil
+}
+
+// GetPatients handles retrieving patients with search and pagination
+// @Summary Get patients
+// @Description Get all patients with optional name search and pagination
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Param page query int false "Page number"
+// @Param limit query int false "Number of records per page"
+// @Param q query string false "Search by surname, other names or phone"
+// @Param district query string false "Filter by residence district"
+// @Success 200 {array} models.Patient
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/patients [get]
+func (h *PatientHandler) GetPatients(c *fiber.Ctx) error {
+	var patients []models.Patient
+	query := h.db.Model(&models.Patient{})
+
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	offset := (page - 1) * limit
+
+	if q := c.Query("q"); q != "" {
+		like := "%" + q + "%"
+		query = query.Where("surname LIKE ? OR other_names LIKE ? OR phone LIKE ?", like, like, like)
+	}
+	if district := c.Query("district"); district != "" {
+		query = query.Where("residence_district = ?", district)
+	}
+
+	if err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&patients).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch patients",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(patients)
+}
+
+// GetPatient handles retrieving a single patient with hospitalizations and risk factors
+// @Summary Get patient by ID
+// @Description Get a patient with hospitalizations, risk factors and linked alerts
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Param id path int true "Patient ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Router /api/v1/patients/{id} [get]
+func (h *PatientHandler) GetPatient(c *fiber.Ctx) error {
+	var patient models.Patient
+	if err := h.db.Preload("Hospitalizations").Preload("RiskFactors").First(&patient, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Patient not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch patient",
+			"details": err.Error(),
+		})
+	}
+
+	var alerts []models.Alert
+	if err := h.db.Where("patient_id = ?", patient.ID).Order("date DESC").Find(&alerts).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch linked alerts",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"patient": patient,
+		"alerts":  alerts,
+	})
+}
+
+// CreatePatient handles patient creation
+// @Summary Create a patient
+// @Description Create a new case-patient record
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Param patient body models.Patient true "Patient object"
+// @Success 201 {object} models.Patient
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/patients [post]
+func (h *PatientHandler) CreatePatient(c *fiber.Ctx) error {
+	patient := new(models.Patient)
+	if err := c.BodyParser(patient); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+	patient.ID = 0
+
+	if msg := validatePatient(patient); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	if err := h.db.Create(patient).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to create patient",
+			"details": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(patient)
+}
+
+// UpdatePatient handles updating a patient
+// @Summary Update patient
+// @Description Update an existing patient record
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Param id path int true "Patient ID"
+// @Param patient body models.Patient true "Patient object"
+// @Success 200 {object} models.Patient
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/patients/{id} [put]
+func (h *PatientHandler) UpdatePatient(c *fiber.Ctx) error {
+	var patient models.Patient
+	if ok, err := h.findPatient(c, &patient); !ok {
+		return err
+	}
+
+	id := patient.ID
+	if err := c.BodyParser(&patient); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+	patient.ID = id
+
+	if msg := validatePatient(&patient); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	// Hospitalizations and risk factors are managed through their own endpoints
+	if err := h.db.Omit("Hospitalizations", "RiskFactors").Save(&patient).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to update patient",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(patient)
+}
+
+// DeletePatient handles deleting a patient and unlinking their alerts
+// @Summary Delete patient
+// @Description Delete a patient; hospitalizations and risk factors are removed and linked alerts are unlinked and reclassified
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Param id path int true "Patient ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/patients/{id} [delete]
+func (h *PatientHandler) DeletePatient(c *fiber.Ctx) error {
+	var patient models.Patient
+	if ok, err := h.findPatient(c, &patient); !ok {
+		return err
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var alerts []models.Alert
+		if err := tx.Where("patient_id = ?", patient.ID).Find(&alerts).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Alert{}).Where("patient_id = ?", patient.ID).Update("patient_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("patient_id = ?", patient.ID).Delete(&models.Hospitalization{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("patient_id = ?", patient.ID).Delete(&models.RiskFactor{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&patient).Error; err != nil {
+			return err
+		}
+		// The alerts lose the patient's risk factors
+		for i := range alerts {
+			alerts[i].PatientID = nil
+		}
+		return reclassifyAlerts(tx, alerts)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to delete patient",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Patient deleted successfully",
+	})
+}
+
+// GetHospitalizations fetches the hospitalizations of a patient
+// @Summary Get patient hospitalizations
+// @Description Get all hospitalizations recorded for a patient
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Param id path int true "Patient ID"
+// @Success 200 {array} models.Hospitalization
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/patients/{id}/hospitalizations [get]
+func (h *PatientHandler) GetHospitalizations(c *fiber.Ctx) error {
+	var patient models.Patient
+	if ok, err := h.findPatient(c, &patient); !ok {
+		return err
+	}
+
+	var hospitalizations []models.Hospitalization
+	if err := h.db.Where("patient_id = ?", patient.ID).Order("admission_date DESC").Find(&hospitalizations).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch hospitalizations",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(hospitalizations)
+}
+
+// CreateHospitalization records a hospital admission for a patient
+// @Summary Create hospitalization
+// @Description Record a hospital admission for a patient
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Param id path int true "Patient ID"
+// @Param hospitalization body models.Hospitalization true "Hospitalization object"
+// @Success 201 {object} models.Hospitalization
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/patients/{id}/hospitalizations [post]
+func (h *PatientHandler) CreateHospitalization(c *fiber.Ctx) error {
+	var patient models.Patient
+	if ok, err := h.findPatient(c, &patient); !ok {
+		return err
+	}
+
+	hospitalization := new(models.Hospitalization)
+	if err := c.BodyParser(hospitalization); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+	hospitalization.ID = 0
+	hospitalization.PatientID = &patient.ID
+
+	if err := h.db.Create(hospitalization).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to create hospitalization",
+			"details": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(hospitalization)
+}
+
+// UpdateHospitalization handles updating a hospitalization
+// @Summary Update hospitalization
+// @Description Update an existing hospitalization
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Param id path int true "Hospitalization ID"
+// @Param hospitalization body models.Hospitalization true "Hospitalization object"
+// @Success 200 {object} models.Hospitalization
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/hospitalizations/{id} [put]
+func (h *PatientHandler) UpdateHospitalization(c *fiber.Ctx) error {
+	var hospitalization models.Hospitalization
+	if err := h.db.First(&hospitalization, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Hospitalization not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch hospitalization",
+			"details": err.Error(),
+		})
+	}
+
+	id, patientID := hospitalization.ID, hospitalization.PatientID
+	if err := c.BodyParser(&hospitalization); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+	hospitalization.ID, hospitalization.PatientID = id, patientID
+
+	if err := h.db.Save(&hospitalization).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to update hospitalization",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(hospitalization)
+}
+
+// DeleteHospitalization handles deleting a hospitalization
+// @Summary Delete hospitalization
+// @Description Delete a hospitalization by ID
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Param id path int true "Hospitalization ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/hospitalizations/{id} [delete]
+func (h *PatientHandler) DeleteHospitalization(c *fiber.Ctx) error {
+	result := h.db.Delete(&models.Hospitalization{}, c.Params("id"))
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to delete hospitalization",
+			"details": result.Error.Error(),
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Hospitalization not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Hospitalization deleted successfully",
+	})
+}
+
+// GetRiskFactors fetches the risk factors of a patient
+// @Summary Get patient risk factors
+// @Description Get all risk factor records for a patient
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Param id path int true "Patient ID"
+// @Success 200 {array} models.RiskFactor
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/patients/{id}/risk-factors [get]
+func (h *PatientHandler) GetRiskFactors(c *fiber.Ctx) error {
+	var patient models.Patient
+	if ok, err := h.findPatient(c, &patient); !ok {
+		return err
+	}
+
+	var riskFactors []models.RiskFactor
+	if err := h.db.Where("patient_id = ?", patient.ID).Find(&riskFactors).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch risk factors",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(riskFactors)
+}
+
+// CreateRiskFactor records risk factors for a patient and reclassifies their alerts
+// @Summary Create risk factor record
+// @Description Record the exposure history of a patient
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Param id path int true "Patient ID"
+// @Param riskFactor body models.RiskFactor true "Risk factor object"
+// @Success 201 {object} models.RiskFactor
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/patients/{id}/risk-factors [post]
+func (h *PatientHandler) CreateRiskFactor(c *fiber.Ctx) error {
+	var patient models.Patient
+	if ok, err := h.findPatient(c, &patient); !ok {
+		return err
+	}
+
+	riskFactor := new(models.RiskFactor)
+	if err := c.BodyParser(riskFactor); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+	riskFactor.ID = 0
+	riskFactor.PatientID = &patient.ID
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(riskFactor).Error; err != nil {
+			return err
+		}
+		return reclassifyPatientAlerts(tx, patient.ID)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to create risk factor",
+			"details": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(riskFactor)
+}
+
+// UpdateRiskFactor handles updating a risk factor record
+// @Summary Update risk factor record
+// @Description Update an existing risk factor record
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Param id path int true "Risk factor ID"
+// @Param riskFactor body models.RiskFactor true "Risk factor object"
+// @Success 200 {object} models.RiskFactor
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/risk-factors/{id} [put]
+func (h *PatientHandler) UpdateRiskFactor(c *fiber.Ctx) error {
+	var riskFactor models.RiskFactor
+	if err := h.db.First(&riskFactor, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Risk factor not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch risk factor",
+			"details": err.Error(),
+		})
+	}
+
+	id, patientID := riskFactor.ID, riskFactor.PatientID
+	if err := c.BodyParser(&riskFactor); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+	}
+	riskFactor.ID, riskFactor.PatientID = id, patientID
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&riskFactor).Error; err != nil {
+			return err
+		}
+		if riskFactor.PatientID == nil {
+			return nil
+		}
+		return reclassifyPatientAlerts(tx, *riskFactor.PatientID)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to update risk factor",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(riskFactor)
+}
+
+// DeleteRiskFactor handles deleting a risk factor record
+// @Summary Delete risk factor record
+// @Description Delete a risk factor record by ID and reclassify the patient's alerts
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Param id path int true "Risk factor ID"
+// @Success 200 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/risk-factors/{id} [delete]
+func (h *PatientHandler) DeleteRiskFactor(c *fiber.Ctx) error {
+	var riskFactor models.RiskFactor
+	if err := h.db.First(&riskFactor, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Risk factor not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch risk factor",
+			"details": err.Error(),
+		})
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&riskFactor).Error; err != nil {
+			return err
+		}
+		if riskFactor.PatientID == nil {
+			return nil
+		}
+		return reclassifyPatientAlerts(tx, *riskFactor.PatientID)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to delete risk factor",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Risk factor deleted successfully",
+	})
+}
+
+// reclassifyPatientAlerts re-runs the case definitions over a patient's alerts
+// so changed exposure history is reflected in their classification
+func reclassifyPatientAlerts(tx *gorm.DB, patientID uint) error {
+	var alerts []models.Alert
+	if err := tx.Where("patient_id = ?", patientID).Find(&alerts).Error; err != nil {
+		return err
+	}
+	return reclassifyAlerts(tx, alerts)
+}
+
+// reclassifyAlerts re-runs the case definitions over alerts and stores their
+// classification and risk score
+func reclassifyAlerts(tx *gorm.DB, alerts []models.Alert) error {
+	if len(alerts) == 0 {
+		return nil
+	}
+	definitions, err := activeCaseDefinitions(tx)
+	if err != nil {
+		return err
+	}
+	for i := range alerts {
+		if err := classifyAlertWith(tx, &alerts[i], definitions); err != nil {
+			return err
+		}
+		if err := tx.Model(&alerts[i]).
+			Select("Syndromes", "Classification", "Priority", "IsHighlighted", "RiskScore").
+			Updates(&alerts[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConvertAlertToCase creates or links a patient record for an alert
+// @Summary Convert alert to case
+// @Description Link an alert to an existing patient, or create a patient from the alert's case details
+// @Tags patients
+// @Accept json
+// @Produce json
+// @Param id path int true "Alert ID"
+// @Param input body map[string]interface{} false "Optional patientId of an existing patient"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 409 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/alerts/{id}/convert-to-case [post]
+func (h *PatientHandler) ConvertAlertToCase(c *fiber.Ctx) error {
+	var input struct {
+		PatientID  *uint   `json:"patientId"`
+		Surname    *string `json:"surname"`
+		OtherNames *string `json:"otherNames"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Invalid request body",
+				"details": err.Error(),
+			})
+		}
+	}
+
+	var alert models.Alert
+	if err := h.db.First(&alert, c.Params("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Alert not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch alert",
+			"details": err.Error(),
+		})
+	}
+
+	if alert.PatientID != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":     "Alert is already linked to a patient",
+			"patientId": *alert.PatientID,
+		})
+	}
+
+	var patient models.Patient
+	if input.PatientID != nil {
+		if err := h.db.First(&patient, *input.PatientID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Patient not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "Failed to fetch patient",
+				"details": err.Error(),
+			})
+		}
+	} else {
+		patient = patientFromAlert(&alert)
+		if input.Surname != nil {
+			patient.Surname = input.Surname
+		}
+		if input.OtherNames != nil {
+			patient.OtherNames = input.OtherNames
+		}
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if patient.ID == 0 {
+			if err := tx.Create(&patient).Error; err != nil {
+				return err
+			}
+		}
+		alert.PatientID = &patient.ID
+		if err := classifyAlert(tx, &alert); err != nil {
+			return err
+		}
+		return tx.Save(&alert).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to convert alert to case",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Alert converted to case successfully",
+		"alert":   alert,
+		"patient": patient,
+	})
+}
+
+// patientFromAlert copies the case details captured on an alert into a new patient
+func patientFromAlert(alert *models.Alert) models.Patient {
+	patient := models.Patient{
+		Age:                alert.AlertCaseAge,
+		Phone:              alert.ContactNumber,
+		PhoneOwner:         alert.PersonReporting,
+		ResidenceVillage:   alert.AlertCaseVillage,
+		ResidenceParish:    alert.AlertCaseParish,
+		ResidenceSubcounty: alert.AlertCaseSubCounty,
+		ResidenceDistrict:  alert.AlertCaseDistrict,
+	}
+
+	if alert.AlertCaseAge != nil {
+		years := "Years"
+		patient.AgeUnit = &years
+	}
+
+	if alert.AlertCaseSex != nil {
+		switch strings.ToLower(strings.TrimSpace(*alert.AlertCaseSex)) {
+		case "male", "m":
+			gender := "Male"
+			patient.Gender = &gender
+		case "female", "f":
+			gender := "Female"
+			patient.Gender = &gender
+		}
+	}
+
+	// The call centre captures a single name; the first word is taken as the surname
+	if alert.AlertCaseName != nil {
+		names := strings.Fields(*alert.AlertCaseName)
+		if len(names) > 0 {
+			surname := names[0]
+			patient.Surname = &surname
+		}
+		if len(names) > 1 {
+			otherNames := strings.Join(names[1:], " ")
+			patient.OtherNames = &otherNames
+		}
+	}
+
+	return patient
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/auth"
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// sessionResponse is a session as listed to its user or an admin. Current
+// marks the session of the request.
+type sessionResponse struct {
+	models.Session
+	Current bool `json:"current"`
+}
+
+// sessionClient describes the client of a login or refresh request. The
+// device name given in the body, if any, is preferred to the user agent.
+func sessionClient(c *fiber.Ctx, device string) auth.Client {
+	return auth.Client{
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		Device:    device,
+	}
+}
+
+// listSessions responds with the active sessions of a user, most recently
+// seen first
+func (h *UserHandler) listSessions(c *fiber.Ctx, userID uint) error {
+	var sessions []models.Session
+	err := h.db.Where("user_id = ? AND ended_at IS NULL AND expires_at >= ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch sessions",
+			"details": err.Error(),
+		})
+	}
+
+	family, _ := c.Locals("token_family").(string)
+	response := make([]sessionResponse, len(sessions))
+	for i, s := range sessions {
+		response[i] = sessionResponse{Session: s, Current: family != "" && s.FamilyID == family}
+	}
+	return c.JSON(response)
+}
+
+// endSession ends an active session of a user
+func (h *UserHandler) endSession(c *fiber.Ctx, userID uint, param string) error {
+	id, err := strconv.ParseUint(c.Params(param), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid session ID",
+		})
+	}
+	var session models.Session
+	err = h.db.Where("id = ? AND user_id = ? AND ended_at IS NULL", id, userID).First(&session).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Session not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch session",
+			"details": err.Error(),
+		})
+	}
+
+	if err := h.revocations.EndSession(session.FamilyID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to end session",
+			"details": err.Error(),
+		})
+	}
+
+	family, _ := c.Locals("token_family").(string)
+	return c.JSON(fiber.Map{
+		"message": "Session ended successfully",
+		"id":      session.ID,
+		"current": session.FamilyID == family,
+	})
+}
+
+// pathUser fetches the user of the id path parameter. When the user
+// cannot be fetched the error response is written and ok is false.
+func (h *UserHandler) pathUser(c *fiber.Ctx) (user models.User, ok bool, err error) {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil {
+		return user, false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+	if err := h.db.Select("id", "username").First(&user, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return user, false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		return user, false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch user",
+			"details": err.Error(),
+		})
+	}
+	return user, true, nil
+}
+
+// GetMySessions lists the sessions of the authenticated user
+// @Summary Get my sessions
+// @Description List the active sessions of the authenticated user, one per login, with the device, IP address and user agent they were last seen from. The session of the request is marked current.
+// @Tags users
+// @Produce json
+// @Security Bearer
+// @Success 200 {array} sessionResponse
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/users/me/sessions [get]
+func (h *UserHandler) GetMySessions(c *fiber.Ctx) error {
+	return h.listSessions(c, c.Locals("user_id").(uint))
+}
+
+// EndMySession ends a session of the authenticated user
+// @Summary End my session
+// @Description End a session of the authenticated user, signing out the device it belongs to. Its refresh token can no longer be used and its access tokens are refused from the next request. Ending the current session is the same as logging out.
+// @Tags users
+// @Produce json
+// @Security Bearer
+// @Param id path int true "Session ID"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/users/me/sessions/{id} [delete]
+func (h *UserHandler) EndMySession(c *fiber.Ctx) error {
+	return h.endSession(c, c.Locals("user_id").(uint), "id")
+}
+
+// GetUserSessions lists the sessions of a user
+// @Summary Get user sessions
+// @Description List the active sessions of a user with the device, IP address and user agent they were last seen from (Admin only).
+// @Tags users
+// @Produce json
+// @Security Bearer
+// @Param id path int true "User ID"
+// @Success 200 {array} sessionResponse
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/users/{id}/sessions [get]
+func (h *UserHandler) GetUserSessions(c *fiber.Ctx) error {
+	user, ok, err := h.pathUser(c)
+	if !ok {
+		return err
+	}
+	return h.listSessions(c, user.ID)
+}
+
+// EndUserSession ends a session of a user
+// @Summary End user session
+// @Description End one session of a user, signing out the device it belongs to while leaving the user's other sessions alone (Admin only).
+// @Tags users
+// @Produce json
+// @Security Bearer
+// @Param id path int true "User ID"
+// @Param session_id path int true "Session ID"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/users/{id}/sessions/{session_id} [delete]
+func (h *UserHandler) EndUserSession(c *fiber.Ctx) error {
+	user, ok, err := h.pathUser(c)
+	if !ok {
+		return err
+	}
+	return h.endSession(c, user.ID, "session_id")
+}
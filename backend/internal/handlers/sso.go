@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/auth"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SSOHandler signs users in through the OpenID Connect identity provider
+type SSOHandler struct {
+	sso           *auth.SSO
+	users         *UserHandler
+	postLoginURL  string
+	passwordLogin bool
+}
+
+// NewSSOHandler creates a new SSO handler. sso is nil when single sign-on is
+// not configured. After signing in at the identity provider users are sent
+// to postLoginURL with a login code or an error in its fragment.
+func NewSSOHandler(sso *auth.SSO, users *UserHandler, postLoginURL string, passwordLogin bool) *SSOHandler {
+	return &SSOHandler{sso: sso, users: users, postLoginURL: postLoginURL, passwordLogin: passwordLogin}
+}
+
+// ssoStateCookie holds the state of the sign-in a browser started, which the
+// callback must come back with
+const ssoStateCookie = "sso_state"
+
+// setStateCookie keeps state in the browser for maxAge, or removes it when
+// maxAge is negative. The cookie is sent along with the provider's redirect
+// to the callback, a top-level navigation allowed by SameSite=Lax.
+func setStateCookie(c *fiber.Ctx, state string, maxAge time.Duration) {
+	cookie := &fiber.Cookie{
+		Name:     ssoStateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   c.Protocol() == "https",
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	}
+	if maxAge < 0 {
+		cookie.Expires = time.Unix(0, 0)
+	}
+	c.Cookie(cookie)
+}
+
+// notConfigured answers single sign-on requests when it is not configured
+func notConfigured(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+		"error": "Single sign-on is not configured",
+	})
+}
+
+// GetLoginMethods tells which ways of signing in are available
+// @Summary Get login methods
+// @Description Tell whether users can sign in with a password, with single sign-on through the identity provider, or both, so that the login page can offer them.
+// @Tags users
+// @Produce json
+// @Success 200 {object} fiber.Map
+// @Router /api/v1/auth/methods [get]
+func (h *SSOHandler) GetLoginMethods(c *fiber.Ctx) error {
+	methods := fiber.Map{
+		"password": h.passwordLogin,
+		"sso":      h.sso != nil,
+	}
+	if h.sso != nil {
+		methods["ssoLoginUrl"] = "/api/v1/auth/sso/login"
+	}
+	return c.JSON(methods)
+}
+
+// StartSSO sends the user to the identity provider
+// @Summary Start single sign-on
+// @Description Redirect the browser to the identity provider to sign in, using the authorization code flow with PKCE. The state of the sign-in is kept in an HttpOnly cookie. The provider redirects back to /auth/sso/callback.
+// @Tags users
+// @Success 302
+// @Failure 404 {object} fiber.Map
+// @Failure 502 {object} fiber.Map
+// @Router /api/v1/auth/sso/login [get]
+func (h *SSOHandler) StartSSO(c *fiber.Ctx) error {
+	if h.sso == nil {
+		return notConfigured(c)
+	}
+	authURL, state, err := h.sso.Begin(c.UserContext())
+	if err != nil {
+		log.Printf("Failed to start single sign-on: %v", err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": "The identity provider is unavailable, please try again later",
+		})
+	}
+	setStateCookie(c, state, auth.SSOLoginTTL)
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+// SSOCallback completes a sign-in at the identity provider
+// @Summary Single sign-on callback
+// @Description Receive the user back from the identity provider, check that the state matches the cookie set when the sign-in was started in this browser, redeem the authorization code and create or update the user from the provider's claims. The browser is then redirected to the web app with a one-time login code, or an error, in the URL fragment.
+// @Tags users
+// @Param state query string true "State"
+// @Param code query string false "Authorization code"
+// @Param error query string false "Error from the identity provider"
+// @Success 302
+// @Failure 404 {object} fiber.Map
+// @Router /api/v1/auth/sso/callback [get]
+func (h *SSOHandler) SSOCallback(c *fiber.Ctx) error {
+	if h.sso == nil {
+		return notConfigured(c)
+	}
+	// The state is only good for one callback
+	started := c.Cookies(ssoStateCookie)
+	setStateCookie(c, "", -1)
+	if reason := c.Query("error"); reason != "" {
+		log.Printf("Single sign-on refused by the identity provider: %s %s", reason, c.Query("error_description"))
+		return h.redirect(c, url.Values{"error": {providerError(reason)}})
+	}
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" || code == "" {
+		return h.redirect(c, url.Values{"error": {"invalid_request"}})
+	}
+	// A callback without the state of a sign-in started in this browser may
+	// be an attacker's sign-in, sent to sign the user in as the attacker
+	if subtle.ConstantTimeCompare([]byte(started), []byte(state)) != 1 {
+		log.Printf("Single sign-on callback without the sign-in state of the browser")
+		return h.redirect(c, url.Values{"error": {"invalid_state"}})
+	}
+
+	loginCode, err := h.sso.Complete(c.UserContext(), state, code)
+	if err != nil {
+		log.Printf("Single sign-on failed: %v", err)
+		reason := "sign_in_failed"
+		switch err {
+		case auth.ErrInvalidSSOState:
+			reason = "invalid_state"
+		case auth.ErrSSOUsername:
+			reason = "invalid_username"
+		case auth.ErrSSOUsernameTaken:
+			reason = "username_taken"
+		case auth.ErrSSOUserDeleted:
+			reason = "account_disabled"
+		}
+		return h.redirect(c, url.Values{"error": {reason}})
+	}
+	return h.redirect(c, url.Values{"code": {loginCode}})
+}
+
+// redirect sends the browser to the web app with values in the URL
+// fragment, which is kept out of server logs and referrers
+func (h *SSOHandler) redirect(c *fiber.Ctx, values url.Values) error {
+	return c.Redirect(h.postLoginURL+"#"+values.Encode(), fiber.StatusFound)
+}
+
+// providerError passes on an OAuth error code of the identity provider,
+// which is made of lowercase letters and underscores
+func providerError(reason string) string {
+	if reason == "" || strings.Trim(reason, "abcdefghijklmnopqrstuvwxyz_") != "" {
+		return "sign_in_failed"
+	}
+	return reason
+}
+
+// ExchangeSSOCode exchanges a login code for tokens
+// @Summary Exchange single sign-on login code
+// @Description Exchange the one-time login code handed to the web app after single sign-on for the tokens of a normal login. As with a password login, users with two-factor authentication, or whose role requires it, get a challenge for the second step instead.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param body body map[string]string true "Login code"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 401 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/auth/sso/token [post]
+func (h *SSOHandler) ExchangeSSOCode(c *fiber.Ctx) error {
+	if h.sso == nil {
+		return notConfigured(c)
+	}
+	var input struct {
+		Code   string `json:"code"`
+		Device string `json:"device"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "code is required",
+		})
+	}
+
+	userID, err := h.sso.Redeem(input.Code)
+	if err == auth.ErrInvalidLoginCode {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired login code, please sign in again",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to sign in",
+			"details": err.Error(),
+		})
+	}
+	user, err := h.users.loginUserByID(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch user",
+			"details": err.Error(),
+		})
+	}
+	return h.users.firstFactorPassed(c, user, input.Device)
+}
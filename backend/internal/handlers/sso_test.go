@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/auth"
+	"github.com/alertsMIS/backend/internal/dbtest"
+	"github.com/alertsMIS/backend/internal/oidc"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestSSOCallbackRequiresStateCookie(t *testing.T) {
+	// The sign-in must be refused before the state is looked up
+	db := dbtest.Open(t, func(_ *dbtest.Conn, query string, _ []driver.NamedValue) (dbtest.Result, error) {
+		t.Errorf("unexpected statement: %s", query)
+		return dbtest.Result{}, errors.New("unexpected statement")
+	})
+	sso := auth.NewSSO(db, oidc.NewProvider("https://idp.example", "alertsmis", "", "https://alerts.example/callback", nil), oidc.Mapping{}, false, time.Minute)
+	h := NewSSOHandler(sso, nil, "https://alerts.example/login", true)
+	app := fiber.New()
+	app.Get("/callback", h.SSOCallback)
+
+	tests := []struct {
+		name   string
+		cookie string
+	}{
+		{"no cookie", ""},
+		{"another sign-in", "state-2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/callback?state=state-1&code=code-1", nil)
+			if tt.cookie != "" {
+				req.Header.Set("Cookie", ssoStateCookie+"="+tt.cookie)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			location, err := url.Parse(resp.Header.Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			fragment, err := url.ParseQuery(location.Fragment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != fiber.StatusFound || fragment.Get("error") != "invalid_state" {
+				t.Errorf("status %d to %s, want a redirect with error invalid_state", resp.StatusCode, location)
+			}
+			if cookie := resp.Header.Get("Set-Cookie"); !strings.HasPrefix(cookie, ssoStateCookie+"=;") {
+				t.Errorf("Set-Cookie %q, want the state cookie removed", cookie)
+			}
+		})
+	}
+}
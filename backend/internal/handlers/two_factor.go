@@ -0,0 +1,510 @@
+package handlers
+
+import (
+	"encoding/base64"
+
+	"github.com/alertsMIS/backend/internal/auth"
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// loginChallenge answers a login whose password was checked with the token
+// of its second step. Flag names what the client must do next.
+func (h *UserHandler) loginChallenge(c *fiber.Ctx, userID uint, purpose, flag string) error {
+	token, err := h.twoFactor.NewChallenge(userID, purpose)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to sign in",
+		})
+	}
+	return c.JSON(fiber.Map{
+		flag:             true,
+		"challengeToken": token,
+		"expiresIn":      int64(h.twoFactor.ChallengeTTL().Seconds()),
+	})
+}
+
+// enrolmentResponse returns a new TOTP secret as shown to the user
+func enrolmentResponse(enrolment auth.Enrolment) fiber.Map {
+	return fiber.Map{
+		"secret":     enrolment.Secret,
+		"otpauthUri": enrolment.URI,
+		"qrCode":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(enrolment.QRCode),
+	}
+}
+
+// challengeUser reads the challenge token of a login's second step and
+// fetches its user. When either cannot be fetched the error response is
+// written and ok is false.
+func (h *UserHandler) challengeUser(c *fiber.Ctx, token, purpose string) (challenge models.LoginChallenge, user loginUser, ok bool, err error) {
+	challenge, err = h.twoFactor.Challenge(token, purpose)
+	if err == nil {
+		user, err = h.loginUserByID(challenge.UserID)
+		if err == gorm.ErrRecordNotFound {
+			err = auth.ErrInvalidChallenge
+		}
+	}
+	if err == auth.ErrInvalidChallenge {
+		return challenge, user, false, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired login challenge, please sign in again",
+		})
+	}
+	if err != nil {
+		return challenge, user, false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to check login challenge",
+			"details": err.Error(),
+		})
+	}
+
+	wait, err := h.throttle.Check(user.Username, c.IP())
+	if err != nil {
+		return challenge, user, false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check login attempts",
+		})
+	}
+	if wait > 0 {
+		return challenge, user, false, tooManyRequests(c, wait, "Too many failed login attempts, please try again later")
+	}
+	return challenge, user, true, nil
+}
+
+// loginUserByID reads the user of a login past its first step
+func (h *UserHandler) loginUserByID(id uint) (loginUser, error) {
+	var user loginUser
+	err := h.db.Raw("SELECT id, username, email, affiliation, user_type, level FROM users WHERE id = ?", id).Scan(&user).Error
+	if err == nil && user.ID == 0 {
+		err = gorm.ErrRecordNotFound
+	}
+	return user, err
+}
+
+// codeFailed counts a wrong code against a login challenge and the user's
+// failed logins
+func (h *UserHandler) codeFailed(c *fiber.Ctx, challenge models.LoginChallenge, user loginUser) error {
+	if err := h.twoFactor.ChallengeFailed(challenge); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to check two-factor code",
+			"details": err.Error(),
+		})
+	}
+	return h.authFailed(c, user.Username, c.IP(), user.ID, "2FA_FAILED", "Invalid two-factor code")
+}
+
+// completeLogin ends a login challenge and signs its user in
+func (h *UserHandler) completeLogin(c *fiber.Ctx, challenge models.LoginChallenge, user loginUser, device string, extra fiber.Map) error {
+	if err := h.twoFactor.EndChallenge(challenge); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to sign in",
+		})
+	}
+	if err := h.throttle.Success(user.Username); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to sign in",
+		})
+	}
+	return h.signIn(c, user, device, extra)
+}
+
+// VerifyTwoFactor completes a login with a two-factor code
+// @Summary Verify two-factor code
+// @Description Complete a login of a user with two-factor authentication, exchanging the challenge token returned by the login and a code from the authenticator app, or an unused recovery code, for the tokens of a normal login. Each code is accepted once. After 5 wrong codes the challenge is dropped; wrong codes also count as failed logins.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param body body map[string]string true "Challenge token and code"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 401 {object} fiber.Map
+// @Failure 429 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/auth/2fa/verify [post]
+func (h *UserHandler) VerifyTwoFactor(c *fiber.Ctx) error {
+	var input struct {
+		ChallengeToken string `json:"challengeToken"`
+		Code           string `json:"code"`
+		Device         string `json:"device"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.ChallengeToken == "" || input.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "challengeToken and code are required",
+		})
+	}
+	challenge, user, ok, err := h.challengeUser(c, input.ChallengeToken, auth.ChallengeVerify)
+	if !ok {
+		return err
+	}
+
+	recovery, err := h.twoFactor.Verify(user.ID, input.Code)
+	switch err {
+	case nil:
+	case auth.ErrInvalidCode, auth.ErrTwoFactorNotEnrolled:
+		return h.codeFailed(c, challenge, user)
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to check two-factor code",
+			"details": err.Error(),
+		})
+	}
+	return h.completeLogin(c, challenge, user, input.Device, fiber.Map{"recoveryCodeUsed": recovery})
+}
+
+// EnrollTwoFactorAtLogin starts the enrolment a login requires
+// @Summary Enrol two-factor authentication at login
+// @Description Create a TOTP secret for a user whose role requires two-factor authentication and who signed in without it, using the challenge token returned by the login. Returns the secret, its otpauth URI and a QR code PNG of the URI for the authenticator app; confirm it with /auth/2fa/enroll/confirm.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param body body map[string]string true "Challenge token"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 401 {object} fiber.Map
+// @Failure 429 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/auth/2fa/enroll [post]
+func (h *UserHandler) EnrollTwoFactorAtLogin(c *fiber.Ctx) error {
+	var input struct {
+		ChallengeToken string `json:"challengeToken"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.ChallengeToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "challengeToken is required",
+		})
+	}
+	_, user, ok, err := h.challengeUser(c, input.ChallengeToken, auth.ChallengeEnrol)
+	if !ok {
+		return err
+	}
+	return h.enroll(c, user.ID, user.Username)
+}
+
+// ConfirmTwoFactorAtLogin confirms the enrolment a login requires
+// @Summary Confirm two-factor enrolment at login
+// @Description Confirm the TOTP secret created with /auth/2fa/enroll with a code from the authenticator app and complete the login. The response holds the tokens of a normal login and the user's recovery codes, shown only this once.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param body body map[string]string true "Challenge token and code"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 401 {object} fiber.Map
+// @Failure 429 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/auth/2fa/enroll/confirm [post]
+func (h *UserHandler) ConfirmTwoFactorAtLogin(c *fiber.Ctx) error {
+	var input struct {
+		ChallengeToken string `json:"challengeToken"`
+		Code           string `json:"code"`
+		Device         string `json:"device"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.ChallengeToken == "" || input.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "challengeToken and code are required",
+		})
+	}
+	challenge, user, ok, err := h.challengeUser(c, input.ChallengeToken, auth.ChallengeEnrol)
+	if !ok {
+		return err
+	}
+
+	codes, err := h.twoFactor.Confirm(user.ID, input.Code)
+	switch err {
+	case nil:
+	case auth.ErrInvalidCode:
+		return h.codeFailed(c, challenge, user)
+	case auth.ErrTwoFactorNotEnrolled:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Start the enrolment with /auth/2fa/enroll first",
+		})
+	case auth.ErrTwoFactorEnabled:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Two-factor authentication is already enabled, please sign in again",
+		})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to confirm two-factor authentication",
+			"details": err.Error(),
+		})
+	}
+	return h.completeLogin(c, challenge, user, input.Device, fiber.Map{"recoveryCodes": codes})
+}
+
+// enroll responds with a new TOTP secret for a user
+func (h *UserHandler) enroll(c *fiber.Ctx, userID uint, username string) error {
+	enrolment, err := h.twoFactor.Enroll(userID, username)
+	if err == auth.ErrTwoFactorEnabled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Two-factor authentication is already enabled",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to enrol two-factor authentication",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(enrolmentResponse(enrolment))
+}
+
+// currentUser fetches the authenticated user
+func (h *UserHandler) currentUser(c *fiber.Ctx) (models.User, error) {
+	var user models.User
+	err := h.db.First(&user, c.Locals("user_id").(uint)).Error
+	return user, err
+}
+
+// checkOwnCode checks a code of the authenticated user before a change to
+// the user's two-factor authentication. Wrong codes count as failed logins.
+// When the code is not accepted the error response is written and ok is
+// false.
+func (h *UserHandler) checkOwnCode(c *fiber.Ctx, user models.User, code string) (ok bool, err error) {
+	if code == "" {
+		return false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "code is required",
+		})
+	}
+	wait, err := h.throttle.Check(user.Username, c.IP())
+	if err != nil {
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check login attempts",
+		})
+	}
+	if wait > 0 {
+		return false, tooManyRequests(c, wait, "Too many failed login attempts, please try again later")
+	}
+
+	_, err = h.twoFactor.Verify(user.ID, code)
+	switch err {
+	case nil:
+		return true, nil
+	case auth.ErrInvalidCode:
+		return false, h.authFailed(c, user.Username, c.IP(), user.ID, "2FA_FAILED", "Invalid two-factor code")
+	case auth.ErrTwoFactorNotEnrolled:
+		return false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Two-factor authentication is not enabled",
+		})
+	}
+	return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":   "Failed to check two-factor code",
+		"details": err.Error(),
+	})
+}
+
+// GetMyTwoFactor returns the two-factor authentication status of the
+// authenticated user
+// @Summary Get my two-factor status
+// @Description Tell whether the authenticated user has two-factor authentication enabled, whether the user's role requires it and how many unused recovery codes are left.
+// @Tags users
+// @Produce json
+// @Security Bearer
+// @Success 200 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/users/me/2fa [get]
+func (h *UserHandler) GetMyTwoFactor(c *fiber.Ctx) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch user",
+			"details": err.Error(),
+		})
+	}
+	enabled, left, err := h.twoFactor.Status(user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch two-factor status",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"enabled":           enabled,
+		"required":          h.twoFactor.Required(user),
+		"recoveryCodesLeft": left,
+	})
+}
+
+// EnrollMyTwoFactor starts two-factor enrolment for the authenticated user
+// @Summary Enrol two-factor authentication
+// @Description Create a TOTP secret for the authenticated user, replacing any unconfirmed one. Returns the secret, its otpauth URI and a QR code PNG of the URI as a data URL for the authenticator app. Two-factor authentication is enabled once confirmed with a code.
+// @Tags users
+// @Produce json
+// @Security Bearer
+// @Success 200 {object} fiber.Map
+// @Failure 409 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/users/me/2fa/enroll [post]
+func (h *UserHandler) EnrollMyTwoFactor(c *fiber.Ctx) error {
+	return h.enroll(c, c.Locals("user_id").(uint), c.Locals("username").(string))
+}
+
+// ConfirmMyTwoFactor enables two-factor authentication for the
+// authenticated user
+// @Summary Confirm two-factor enrolment
+// @Description Enable the TOTP secret created by the enrolment with a code from the authenticator app. Returns the user's recovery codes, shown only this once.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param body body map[string]string true "Code"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 401 {object} fiber.Map
+// @Failure 409 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/users/me/2fa/confirm [post]
+func (h *UserHandler) ConfirmMyTwoFactor(c *fiber.Ctx) error {
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "code is required",
+		})
+	}
+
+	codes, err := h.twoFactor.Confirm(c.Locals("user_id").(uint), input.Code)
+	switch err {
+	case nil:
+	case auth.ErrInvalidCode:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid two-factor code",
+		})
+	case auth.ErrTwoFactorNotEnrolled:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Start the enrolment with /users/me/2fa/enroll first",
+		})
+	case auth.ErrTwoFactorEnabled:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Two-factor authentication is already enabled",
+		})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to confirm two-factor authentication",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"message":       "Two-factor authentication enabled",
+		"recoveryCodes": codes,
+	})
+}
+
+// RegenerateMyRecoveryCodes replaces the recovery codes of the
+// authenticated user
+// @Summary Regenerate recovery codes
+// @Description Replace the recovery codes of the authenticated user, after checking a current code from the authenticator app or a recovery code. The previous codes stop working.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param body body map[string]string true "Code"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 401 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/users/me/2fa/recovery-codes [post]
+func (h *UserHandler) RegenerateMyRecoveryCodes(c *fiber.Ctx) error {
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	user, err := h.currentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch user",
+			"details": err.Error(),
+		})
+	}
+	if ok, err := h.checkOwnCode(c, user, input.Code); !ok {
+		return err
+	}
+
+	codes, err := h.twoFactor.RegenerateRecoveryCodes(user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to create recovery codes",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{"recoveryCodes": codes})
+}
+
+// DisableMyTwoFactor disables two-factor authentication for the
+// authenticated user
+// @Summary Disable two-factor authentication
+// @Description Remove the authenticator and recovery codes of the authenticated user, after checking a current code or a recovery code. Users whose role requires two-factor authentication cannot disable it.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security Bearer
+// @Param body body map[string]string true "Code"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 401 {object} fiber.Map
+// @Failure 403 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/users/me/2fa/disable [post]
+func (h *UserHandler) DisableMyTwoFactor(c *fiber.Ctx) error {
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	user, err := h.currentUser(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to fetch user",
+			"details": err.Error(),
+		})
+	}
+	if h.twoFactor.Required(user) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Two-factor authentication is required for your role",
+		})
+	}
+	if ok, err := h.checkOwnCode(c, user, input.Code); !ok {
+		return err
+	}
+
+	if err := h.twoFactor.Disable(user.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to disable two-factor authentication",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{"message": "Two-factor authentication disabled"})
+}
+
+// ResetUserTwoFactor removes the two-factor authentication of a user
+// @Summary Reset user two-factor authentication
+// @Description Remove the authenticator and recovery codes of a user who lost them. If the user's role requires two-factor authentication, the user enrols again at the next login (Admin only).
+// @Tags users
+// @Produce json
+// @Security Bearer
+// @Param id path int true "User ID"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/users/{id}/2fa [delete]
+func (h *UserHandler) ResetUserTwoFactor(c *fiber.Ctx) error {
+	user, ok, err := h.pathUser(c)
+	if !ok {
+		return err
+	}
+	if err := h.twoFactor.Disable(user.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to reset two-factor authentication",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"message": "Two-factor authentication of " + user.Username + " has been reset",
+	})
+}
@@ -1,26 +1,37 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/alertsMIS/backend/internal/auth"
 	"github.com/alertsMIS/backend/internal/models"
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 // UserHandler handles user-related HTTP requests
 type UserHandler struct {
-	db        *gorm.DB
-	jwtSecret string
+	db          *gorm.DB
+	tokens      *auth.Issuer
+	revocations *auth.Revocations
+	throttle    *auth.LoginThrottle
+	twoFactor   *auth.TwoFactor
 }
 
 // NewUserHandler creates a new UserHandler
-func NewUserHandler(db *gorm.DB, jwtSecret string) *UserHandler {
+func NewUserHandler(db *gorm.DB, tokens *auth.Issuer, revocations *auth.Revocations, throttle *auth.LoginThrottle, twoFactor *auth.TwoFactor) *UserHandler {
 	return &UserHandler{
-		db:        db,
-		jwtSecret: jwtSecret,
+		db:          db,
+		tokens:      tokens,
+		revocations: revocations,
+		throttle:    throttle,
+		twoFactor:   twoFactor,
 	}
 }
 
@@ -31,58 +42,159 @@ func (h *UserHandler) verifyPassword(hashedPassword, plainPassword string) error
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(plainPassword))
 }
 
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyPasswordHash returns a hash of a random password, at the cost of the
+// stored ones, to check passwords of unknown users against
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		password := make([]byte, 32)
+		rand.Read(password)
+		hash, _ := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+		dummyHash = string(hash)
+	})
+	return dummyHash
+}
+
+// loginFailed counts a failed login and answers it the same way whatever
+// the reason, so that responses do not tell which usernames exist. Failures
+// for existing users are recorded in the audit log, and so is a lockout.
+func (h *UserHandler) loginFailed(c *fiber.Ctx, username, ip string, userID uint) error {
+	return h.authFailed(c, username, ip, userID, "LOGIN_FAILED", "Invalid credentials")
+}
+
+// authFailed counts a failed step of a login, records it in the audit log
+// under action and answers it with message
+func (h *UserHandler) authFailed(c *fiber.Ctx, username, ip string, userID uint, action, message string) error {
+	failures, locked, err := h.throttle.Failure(username, ip)
+	if err != nil {
+		log.Printf("Failed to count failed login for %q from %s: %v", username, ip, err)
+	}
+
+	if userID != 0 {
+		details, _ := json.Marshal(fiber.Map{
+			"ip":        ip,
+			"userAgent": c.Get(fiber.HeaderUserAgent),
+			"failures":  failures,
+		})
+		entries := []models.AuditLog{auditLoginEntry(userID, action, string(details))}
+		if locked {
+			entries = append(entries, auditLoginEntry(userID, "LOGIN_LOCKED", string(details)))
+		}
+		if err := h.db.Create(&entries).Error; err != nil {
+			log.Printf("Failed to audit %s of user %d: %v", action, userID, err)
+		}
+	} else {
+		// The audit log needs an existing user
+		log.Printf("Failed login for unknown user %q from %s", username, ip)
+	}
+
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"error": message,
+	})
+}
+
+// auditLoginEntry returns an audit log entry about a login of a user
+func auditLoginEntry(userID uint, action, details string) models.AuditLog {
+	return models.AuditLog{
+		UserID:   userID,
+		Action:   action,
+		Table:    "users",
+		RecordID: userID,
+		NewValue: &details,
+	}
+}
+
 // Register handles user registration
 // @Summary Register a new user
-// @Description Create a new user account
+// @Description Create a new user account with a password of 8 to 72 characters (Admin only). Refused while password login is disabled.
 // @Tags users
 // @Accept json
 // @Produce json
-// @Param user body models.User true "User object"
+// @Security Bearer
+// @Param user body map[string]string true "User with password"
 // @Success 201 {object} models.User
+// @Failure 400 {object} fiber.Map
+// @Failure 403 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
 // @Router /api/v1/users/register [post]
 func (h *UserHandler) Register(c *fiber.Ctx) error {
-	user := new(models.User)
-
-	if err := c.BodyParser(user); err != nil {
+	// The password is not bound to models.User, whose JSON leaves it out
+	var input struct {
+		Username    string `json:"username"`
+		Password    string `json:"password"`
+		FirstName   string `json:"firstName"`
+		LastName    string `json:"lastName"`
+		OtherName   string `json:"otherName"`
+		Email       string `json:"email"`
+		Affiliation string `json:"affiliation"`
+		UserType    string `json:"userType"`
+		Level       string `json:"level"`
+	}
+	if err := c.BodyParser(&input); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid request body",
 		})
 	}
+	if input.Username == "" || input.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "username and email are required",
+		})
+	}
+	if len(input.Password) < auth.MinPasswordLength || len(input.Password) > 72 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": auth.ErrWeakPassword.Error(),
+		})
+	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to hash password",
 		})
 	}
-	user.Password = string(hashedPassword)
 
-	if err := h.db.Create(user).Error; err != nil {
+	user := models.User{
+		Username:    input.Username,
+		Password:    string(hashedPassword),
+		FirstName:   input.FirstName,
+		LastName:    input.LastName,
+		OtherName:   input.OtherName,
+		Email:       input.Email,
+		Affiliation: input.Affiliation,
+		UserType:    input.UserType,
+		Level:       input.Level,
+	}
+	if err := h.db.Create(&user).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to create user",
 		})
 	}
 
-	// Remove password from response
-	user.Password = ""
-
 	return c.Status(fiber.StatusCreated).JSON(user)
 }
 
 // Login handles user authentication
 // @Summary Login user
-// @Description Authenticate a user and return JWT token
+// @Description Authenticate a user and return a short-lived JWT access token with a refresh token to renew it. Failed logins are counted per username and per IP address; after a few failures further attempts must wait longer each time, and too many lock logins out for a while.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param credentials body map[string]string true "Login credentials"
 // @Success 200 {object} fiber.Map
+// @Failure 401 {object} fiber.Map
+// @Failure 403 {object} fiber.Map
+// @Failure 429 {object} fiber.Map
 // @Router /api/v1/users/login [post]
 func (h *UserHandler) Login(c *fiber.Ctx) error {
 	var input struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Device   string `json:"device"`
 	}
 
 	if err := c.BodyParser(&input); err != nil {
@@ -91,84 +203,92 @@ func (h *UserHandler) Login(c *fiber.Ctx) error {
 		})
 	}
 
-	// Query using the exact field names from the PHP system
-	var user struct {
-		ID          uint   `json:"id"`
-		Username    string `json:"username"`
-		Password    string `json:"-"`
-		Email       string `json:"email"`
-		Affiliation string `json:"affiliation"`
-		UserType    string `json:"userType"`
-		Level       string `json:"level"`
+	ip := c.IP()
+	wait, err := h.throttle.Check(input.Username, ip)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check login attempts",
+		})
+	}
+	if wait > 0 {
+		return tooManyRequests(c, wait, "Too many failed login attempts, please try again later")
 	}
 
-	// Use First() to check if user exists
-	result := h.db.Raw("SELECT id, username, password, email, affiliation, user_type, level FROM users WHERE username = ?", input.Username).First(&user)
+	// Query using the exact field names from the PHP system
+	var user loginUser
+	result := h.db.Raw("SELECT id, username, password, email, affiliation, user_type, level FROM users WHERE username = ?", input.Username).Scan(&user)
 	if result.Error != nil {
-		if result.Error.Error() == "record not found" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Invalid credentials",
-				"debug": fiber.Map{
-					"message":  "User not found",
-					"username": input.Username,
-				},
-			})
-		}
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid credentials",
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to sign in",
 		})
 	}
 
-	// Check if password field is empty
-	if user.Password == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid credentials",
-			"debug": fiber.Map{
-				"message":  "User found but password field is empty",
-				"username": input.Username,
-			},
-		})
+	// Unknown users are checked against a dummy hash so that they take as
+	// long to refuse as wrong passwords
+	found := result.RowsAffected > 0 && user.Password != ""
+	hash := user.Password
+	if !found {
+		hash = dummyPasswordHash()
+	}
+	if err := h.verifyPassword(hash, input.Password); err != nil || !found {
+		return h.loginFailed(c, input.Username, ip, user.ID)
 	}
 
-	// Try to verify password with bcrypt
-	err := h.verifyPassword(user.Password, input.Password)
-	if err != nil {
-		// Temporary debug information
-		debugInfo := fiber.Map{
-			"hash_length":  len(user.Password),
-			"bcrypt_error": err.Error(),
-			"username":     input.Username,
-		}
+	if err := h.throttle.Success(input.Username); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to sign in",
+		})
+	}
 
-		// Only add hash prefix if password is not empty
-		if len(user.Password) > 10 {
-			debugInfo["hash_prefix"] = user.Password[:10] + "..."
-		} else {
-			debugInfo["hash_prefix"] = user.Password
-		}
+	return h.firstFactorPassed(c, user, input.Device)
+}
 
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid credentials",
-			"debug": debugInfo,
+// firstFactorPassed signs in a user whose password, or single sign-on, was
+// checked. Users with two-factor authentication, or whose role requires it,
+// get a challenge for the second step instead of tokens.
+func (h *UserHandler) firstFactorPassed(c *fiber.Ctx, user loginUser, device string) error {
+	enabled, _, err := h.twoFactor.Status(user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to sign in",
 		})
 	}
+	if enabled {
+		return h.loginChallenge(c, user.ID, auth.ChallengeVerify, "twoFactorRequired")
+	}
+	if h.twoFactor.Required(models.User{Level: user.Level, UserType: user.UserType}) {
+		return h.loginChallenge(c, user.ID, auth.ChallengeEnrol, "twoFactorEnrolmentRequired")
+	}
 
-	// Create JWT token
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
-	claims["user_id"] = user.ID
-	claims["username"] = user.Username
-	claims["exp"] = time.Now().Add(time.Hour * 24).Unix()
+	return h.signIn(c, user, device, nil)
+}
 
-	tokenString, err := token.SignedString([]byte(h.jwtSecret))
+// loginUser is a user as read at login, with the field names of the PHP
+// system
+type loginUser struct {
+	ID          uint   `json:"id"`
+	Username    string `json:"username"`
+	Password    string `json:"-"`
+	Email       string `json:"email"`
+	Affiliation string `json:"affiliation"`
+	UserType    string `json:"userType"`
+	Level       string `json:"level"`
+}
+
+// signIn starts a session for an authenticated user and responds with its
+// tokens and the user, adding the extra fields
+func (h *UserHandler) signIn(c *fiber.Ctx, user loginUser, device string, extra fiber.Map) error {
+	tokens, err := h.tokens.Login(user.ID, user.Username, sessionClient(c, device))
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to generate token",
 		})
 	}
 
-	return c.JSON(fiber.Map{
-		"token": tokenString,
+	response := fiber.Map{
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
 		"user": fiber.Map{
 			"id":          user.ID,
 			"username":    user.Username,
@@ -177,6 +297,58 @@ func (h *UserHandler) Login(c *fiber.Ctx) error {
 			"userType":    user.UserType,
 			"level":       user.Level,
 		},
+	}
+	for key, value := range extra {
+		response[key] = value
+	}
+	return c.JSON(response)
+}
+
+// Refresh renews an access token
+// @Summary Refresh access token
+// @Description Exchange a refresh token for a new access token and the next refresh token. Each refresh token can be used once; presenting one that was already used revokes every refresh token issued since the login, so the user must sign in again.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param body body map[string]string true "Refresh token"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 401 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/auth/refresh [post]
+func (h *UserHandler) Refresh(c *fiber.Ctx) error {
+	var input struct {
+		RefreshToken string `json:"refreshToken"`
+		Device       string `json:"device"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "refreshToken is required",
+		})
+	}
+
+	tokens, err := h.tokens.Refresh(input.RefreshToken, sessionClient(c, input.Device))
+	switch err {
+	case nil:
+	case auth.ErrInvalidRefreshToken:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired refresh token",
+		})
+	case auth.ErrRefreshTokenReused:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Refresh token was already used; all sessions from this login have been revoked, please sign in again",
+		})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to refresh token",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
 	})
 }
 
@@ -229,96 +401,83 @@ func (h *UserHandler) GetUserById(c *fiber.Ctx) error {
 	return c.JSON(user)
 }
 
-// DebugUsers is a temporary endpoint to debug user data
-func (h *UserHandler) DebugUsers(c *fiber.Ctx) error {
-	// Use raw SQL to query the users table with PHP field names
-	var users []struct {
-		ID          uint   `json:"id"`
-		Username    string `json:"username"`
-		Password    string `json:"-"`
-		Email       string `json:"email"`
-		Affiliation string `json:"affiliation"`
-		UserType    string `json:"userType"`
-		Level       string `json:"level"`
-	}
+// Logout handles user logout
+// @Summary Logout user
+// @Description Revoke the access token of the request and the refresh tokens of its login, so neither can be used again
+// @Tags users
+// @Produce json
+// @Security Bearer
+// @Success 200 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/users/logout [post]
+func (h *UserHandler) Logout(c *fiber.Ctx) error {
+	// Get user info from context (set by auth middleware)
+	userID := c.Locals("user_id").(uint)
+	username := c.Locals("username").(string)
 
-	err := h.db.Raw("SELECT id, username, password, email, affiliation, user_type, level FROM users").Scan(&users).Error
+	err := h.revocations.RevokeToken(c.Locals("token_id").(string), userID, c.Locals("token_expires").(time.Time))
+	if err == nil {
+		if family := c.Locals("token_family").(string); family != "" {
+			err = h.revocations.EndSession(family)
+		}
+	}
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to fetch users",
+			"error":   "Failed to revoke token",
+			"details": err.Error(),
 		})
 	}
 
-	// Create a safe version without passwords
-	var safeUsers []fiber.Map
-	for _, user := range users {
-		userInfo := fiber.Map{
-			"id":              user.ID,
-			"username":        user.Username,
-			"email":           user.Email,
-			"password_length": len(user.Password),
-		}
-
-		// Only add hash prefix if password is not empty
-		if len(user.Password) > 10 {
-			userInfo["password_prefix"] = user.Password[:10] + "..."
-		} else {
-			userInfo["password_prefix"] = user.Password
-		}
-
-		safeUsers = append(safeUsers, userInfo)
-	}
-
 	return c.JSON(fiber.Map{
-		"users": safeUsers,
-		"count": len(users),
+		"message":   "Logout successful",
+		"user_id":   userID,
+		"username":  username,
+		"timestamp": time.Now().Unix(),
 	})
 }
 
-// TestBcrypt is a temporary endpoint to test bcrypt functionality
-func (h *UserHandler) TestBcrypt(c *fiber.Ctx) error {
-	// Test with a known password
-	testPassword := "test123"
-
-	// Generate a hash
-	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.DefaultCost)
+// RevokeUserSessions ends all sessions of a user
+// @Summary Revoke user sessions
+// @Description Revoke every access and refresh token issued to a user, for example when a phone with a logged-in session is lost. The user must sign in again on every device (Admin only).
+// @Tags users
+// @Produce json
+// @Security Bearer
+// @Param id path int true "User ID"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 404 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/v1/users/{id}/revoke-sessions [post]
+func (h *UserHandler) RevokeUserSessions(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 32)
 	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+	var user models.User
+	if err := h.db.First(&user, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to generate hash",
+			"error":   "Failed to fetch user",
+			"details": err.Error(),
 		})
 	}
 
-	// Verify the hash
-	err = bcrypt.CompareHashAndPassword(hash, []byte(testPassword))
+	revoked, err := h.revocations.RevokeUser(user.ID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to verify hash",
+			"error":   "Failed to revoke sessions",
+			"details": err.Error(),
 		})
 	}
 
-	// Test with wrong password
-	err = bcrypt.CompareHashAndPassword(hash, []byte("wrongpassword"))
-
 	return c.JSON(fiber.Map{
-		"hash":                        string(hash),
-		"hash_length":                 len(hash),
-		"test_password":               testPassword,
-		"verification_success":        true,
-		"wrong_password_verification": err != nil,
-	})
-}
-
-// Logout handles user logout
-// @Router /api/v1/users/logout [post]
-func (h *UserHandler) Logout(c *fiber.Ctx) error {
-	// Get user info from context (set by auth middleware)
-	userID := c.Locals("user_id").(uint)
-	username := c.Locals("username").(string)
-
-	return c.JSON(fiber.Map{
-		"message":   "Logout successful",
-		"user_id":   userID,
-		"username":  username,
-		"timestamp": time.Now().Unix(),
+		"message":              "All sessions of " + user.Username + " have been revoked",
+		"refreshTokensRevoked": revoked,
 	})
 }
@@ -0,0 +1,80 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender sends email
+type Sender interface {
+	// Send delivers a message or returns why it could not be handed over
+	Send(msg Message) error
+}
+
+// SMTPSender sends email through an SMTP server, authenticating when a
+// username is set. Servers offering STARTTLS are used over TLS.
+type SMTPSender struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+}
+
+// NewSMTPSender creates an SMTPSender sending from the from address
+func NewSMTPSender(host string, port int, username, password, from string) *SMTPSender {
+	return &SMTPSender{
+		addr:     net.JoinHostPort(host, fmt.Sprint(port)),
+		host:     host,
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+// Send hands the message to the SMTP server
+func (s *SMTPSender) Send(msg Message) error {
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+	return smtp.SendMail(s.addr, auth, s.from, []string{msg.To}, s.format(msg))
+}
+
+// format builds the message with its headers. Header values are stripped of
+// line breaks so that they cannot add headers of their own.
+func (s *SMTPSender) format(msg Message) []byte {
+	header := strings.NewReplacer("\r", "", "\n", "")
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", header.Replace(s.from))
+	fmt.Fprintf(&b, "To: %s\r\n", header.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", header.Replace(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// LogSender logs messages instead of sending them, for development without
+// a mail server. Bodies may hold secrets such as reset links, so only the
+// recipient and subject are logged.
+type LogSender struct{}
+
+// Send logs the recipient and subject of the message
+func (LogSender) Send(msg Message) error {
+	log.Printf("Mail to %s: %s (body not logged)", msg.To, msg.Subject)
+	return nil
+}
@@ -3,12 +3,15 @@ package middleware
 import (
 	"strings"
 
+	"github.com/alertsMIS/backend/internal/auth"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// AuthMiddleware handles JWT authentication
-func AuthMiddleware(jwtSecret string) fiber.Handler {
+// AuthMiddleware handles JWT authentication. Tokens without a jti, issued
+// before tokens could be revoked, revoked tokens and tokens of ended sessions
+// are refused.
+func AuthMiddleware(jwtSecret string, revocations *auth.Revocations) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get Authorization header
 		authHeader := c.Get("Authorization")
@@ -42,9 +45,37 @@ func AuthMiddleware(jwtSecret string) fiber.Handler {
 
 		// Check if token is valid
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+			jti, _ := claims["jti"].(string)
+			userID, _ := claims["user_id"].(float64)
+			username, _ := claims["username"].(string)
+			family, _ := claims["fid"].(string)
+			issuedAt, _ := claims.GetIssuedAt()
+			expiresAt, _ := claims.GetExpirationTime()
+			if jti == "" || userID <= 0 || issuedAt == nil || expiresAt == nil {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error": "Invalid token",
+				})
+			}
+
+			revoked, err := revocations.IsRevoked(jti, family, uint(userID), issuedAt.Time)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error":   "Failed to check token",
+					"details": err.Error(),
+				})
+			}
+			if revoked {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error": "Token has been revoked",
+				})
+			}
+
 			// Set user ID in context
-			c.Locals("user_id", uint(claims["user_id"].(float64)))
-			c.Locals("username", claims["username"].(string))
+			c.Locals("user_id", uint(userID))
+			c.Locals("username", username)
+			c.Locals("token_id", jti)
+			c.Locals("token_family", family)
+			c.Locals("token_expires", expiresAt.Time)
 			return c.Next()
 		}
 
@@ -0,0 +1,16 @@
+package middleware
+
+import "github.com/gofiber/fiber/v2"
+
+// RequirePasswordLogin refuses the request when password login is disabled
+// in favour of single sign-on
+func RequirePasswordLogin(enabled bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !enabled {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Password login is disabled, please sign in with single sign-on",
+			})
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"github.com/alertsMIS/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// RequireRoles allows the request only if the authenticated user has one of
+// the given roles. Admins are always allowed. Must run after AuthMiddleware.
+func RequireRoles(db *gorm.DB, roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, ok := c.Locals("user_id").(uint)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Authentication required",
+			})
+		}
+
+		var user models.User
+		if err := db.First(&user, userID).Error; err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+
+		if !user.HasRole(models.RoleAdmin) && !user.HasRole(roles...) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You do not have permission to perform this action",
+			})
+		}
+
+		c.Locals("user", user)
+		return c.Next()
+	}
+}
@@ -1,27 +1,128 @@
 package models
 
+import "time"
+
 type Region struct {
 	ID        uint   `gorm:"primaryKey" json:"id"`
 	RegionUID string `gorm:"size:20;not null" json:"regionUid"`
 	Region    string `gorm:"size:50;not null" json:"region"`
+	// EffectiveFrom and EffectiveTo bound when the unit exists; nil means
+	// since always and until further notice
+	EffectiveFrom *time.Time `gorm:"type:date" json:"effectiveFrom"`
+	EffectiveTo   *time.Time `gorm:"type:date" json:"effectiveTo"`
 }
 
 func (Region) TableName() string { return "regions" }
 
 type District struct {
-	ID          uint   `gorm:"primaryKey" json:"id"`
-	DistrictUID string `gorm:"size:20;not null" json:"districtUid"`
-	District    string `gorm:"size:50;not null" json:"district"`
-	RegionID    uint   `json:"regionId"`
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	DistrictUID   string     `gorm:"size:20;not null" json:"districtUid"`
+	District      string     `gorm:"size:50;not null" json:"district"`
+	RegionID      uint       `json:"regionId"`
+	Latitude      *float64   `json:"latitude"`
+	Longitude     *float64   `json:"longitude"`
+	EffectiveFrom *time.Time `gorm:"type:date" json:"effectiveFrom"`
+	EffectiveTo   *time.Time `gorm:"type:date" json:"effectiveTo"`
 }
 
 func (District) TableName() string { return "districts" }
 
 type Subcounty struct {
-	ID           uint   `gorm:"primaryKey" json:"id"`
-	SubcountyUID string `gorm:"size:20;not null" json:"subcountyUid"`
-	Subcounty    string `gorm:"size:50;not null" json:"subcounty"`
-	DistrictID   uint   `json:"districtId"`
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	SubcountyUID  string     `gorm:"size:20;not null" json:"subcountyUid"`
+	Subcounty     string     `gorm:"size:50;not null" json:"subcounty"`
+	DistrictID    uint       `json:"districtId"`
+	Latitude      *float64   `json:"latitude"`
+	Longitude     *float64   `json:"longitude"`
+	EffectiveFrom *time.Time `gorm:"type:date" json:"effectiveFrom"`
+	EffectiveTo   *time.Time `gorm:"type:date" json:"effectiveTo"`
 }
 
 func (Subcounty) TableName() string { return "subcounties" }
+
+// Parish is a parish of a subcounty. Parishes and villages are not part of
+// the legacy schema, so their tables are owned by the Go backend.
+type Parish struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	ParishUID     string     `gorm:"size:20;not null;index" json:"parishUid"`
+	Parish        string     `gorm:"size:50;not null" json:"parish"`
+	SubcountyID   uint       `gorm:"not null;index" json:"subcountyId"`
+	EffectiveFrom *time.Time `gorm:"type:date" json:"effectiveFrom"`
+	EffectiveTo   *time.Time `gorm:"type:date" json:"effectiveTo"`
+}
+
+func (Parish) TableName() string { return "parishes" }
+
+// Village is a village, or a cell in urban areas, of a parish
+type Village struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	VillageUID    string     `gorm:"size:20;not null;index" json:"villageUid"`
+	Village       string     `gorm:"size:50;not null" json:"village"`
+	ParishID      uint       `gorm:"not null;index" json:"parishId"`
+	Latitude      *float64   `json:"latitude"`
+	Longitude     *float64   `json:"longitude"`
+	EffectiveFrom *time.Time `gorm:"type:date" json:"effectiveFrom"`
+	EffectiveTo   *time.Time `gorm:"type:date" json:"effectiveTo"`
+}
+
+func (Village) TableName() string { return "villages" }
+
+// Facility ownership, as recorded in the facilities table and used as an
+// alert's facility type
+const (
+	FacilityOwnershipGovernment          = "GOV"
+	FacilityOwnershipPrivateForProfit    = "PFP"
+	FacilityOwnershipPrivateNotForProfit = "PNFP"
+)
+
+type Facility struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	FacilityUID   string     `gorm:"column:uid;size:15;not null" json:"facilityUid"`
+	Facility      string     `gorm:"size:50;not null" json:"facility"`
+	Ownership     string     `gorm:"size:10;not null" json:"ownership"`
+	SubcountyID   *uint      `json:"subcountyId"`
+	EffectiveFrom *time.Time `gorm:"type:date" json:"effectiveFrom"`
+	EffectiveTo   *time.Time `gorm:"type:date" json:"effectiveTo"`
+}
+
+func (Facility) TableName() string { return "facilities" }
+
+// DistrictRegionHistory records which region a district belonged to over a
+// period, so alerts can be aggregated under the boundaries of any date. An
+// open EffectiveFrom means since always and an open EffectiveTo until now;
+// EffectiveTo is exclusive.
+type DistrictRegionHistory struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	DistrictID    uint       `gorm:"not null;index" json:"districtId"`
+	RegionID      uint       `gorm:"not null" json:"regionId"`
+	EffectiveFrom *time.Time `gorm:"type:date" json:"effectiveFrom"`
+	EffectiveTo   *time.Time `gorm:"type:date" json:"effectiveTo"`
+}
+
+func (DistrictRegionHistory) TableName() string { return "district_region_history" }
+
+// SubcountyDistrictHistory records which district a subcounty belonged to
+// over a period, in the same way as DistrictRegionHistory
+type SubcountyDistrictHistory struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	SubcountyID   uint       `gorm:"not null;index" json:"subcountyId"`
+	DistrictID    uint       `gorm:"not null" json:"districtId"`
+	EffectiveFrom *time.Time `gorm:"type:date" json:"effectiveFrom"`
+	EffectiveTo   *time.Time `gorm:"type:date" json:"effectiveTo"`
+}
+
+func (SubcountyDistrictHistory) TableName() string { return "subcounty_district_history" }
+
+// AdminUnitBoundary is the boundary polygon of a district or subcounty, kept
+// apart from the unit so that lists and the hierarchy tree do not carry the
+// geometry. Population is the denominator of alert rates.
+type AdminUnitBoundary struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Level      string    `gorm:"size:20;not null;uniqueIndex:idx_boundary_unit" json:"level"`
+	UnitID     uint      `gorm:"not null;uniqueIndex:idx_boundary_unit" json:"unitId"`
+	Geometry   string    `gorm:"type:longtext;not null" json:"-"`
+	Population *int64    `json:"population"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+func (AdminUnitBoundary) TableName() string { return "admin_unit_boundaries" }
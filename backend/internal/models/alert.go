@@ -57,9 +57,29 @@ type Alert struct {
 	IsVerified                 bool           `gorm:"default:false" json:"isVerified"`
 	VerifiedBy                 *string        `gorm:"type:text" json:"verifiedBy"`
 	Region                     *string        `gorm:"type:text" json:"region"`
+	Syndromes                  *string        `gorm:"size:255" json:"syndromes"`
+	Classification             *string        `gorm:"size:50;index" json:"classification"`
+	Priority                   *string        `gorm:"size:20" json:"priority"`
+	PatientID                  *uint          `gorm:"index" json:"patientId"`
+	Latitude                   *float64       `json:"latitude"`
+	Longitude                  *float64       `json:"longitude"`
+	LocationAccuracy           *float64       `json:"locationAccuracy"`
+	LocationSource             *string        `gorm:"size:20" json:"locationSource"`
+	EventID                    *uint          `gorm:"index" json:"eventId"`
+	RiskScore                  int            `gorm:"not null;default:0;index" json:"riskScore"`
+	RegionID                   *uint          `gorm:"index" json:"regionId"`
+	DistrictID                 *uint          `gorm:"index" json:"districtId"`
+	SubcountyID                *uint          `gorm:"index" json:"subcountyId"`
+	ParishID                   *uint          `gorm:"index" json:"parishId"`
+	VillageID                  *uint          `gorm:"index" json:"villageId"`
+	FacilityID                 *uint          `gorm:"index" json:"facilityId"`
 	CreatedAt                  time.Time      `json:"createdAt"`
 	UpdatedAt                  time.Time      `json:"updatedAt"`
 	DeletedAt                  gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// Clusters lists the clusters the alert belongs to; it is only filled in
+	// on the alert's detail
+	Clusters []Cluster `gorm:"-" json:"clusters,omitempty"`
 }
 
 // TableName specifies the table name for the Alert model
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Alert.AssignedTo holds either a user or a team (a role such as REOC),
+// prefixed so the two can be told apart within the column's 20 characters
+const (
+	assigneeUserPrefix = "user:"
+	assigneeTeamPrefix = "team:"
+)
+
+// Assignment actions recorded in the assignment history
+const (
+	AssignmentAssigned   = "Assigned"
+	AssignmentUnassigned = "Unassigned"
+	AssignmentClaimed    = "Claimed"
+	AssignmentUnclaimed  = "Unclaimed"
+)
+
+// AssignableTeams are the roles an alert can be assigned to as a team
+var AssignableTeams = []string{RoleNational, RoleREOC, RoleDistrict, RoleLab}
+
+// UserAssignee returns the AssignedTo value for a user
+func UserAssignee(userID uint) string {
+	return assigneeUserPrefix + strconv.FormatUint(uint64(userID), 10)
+}
+
+// TeamAssignee returns the AssignedTo value for a team
+func TeamAssignee(team string) string {
+	return assigneeTeamPrefix + team
+}
+
+// ParseAssignee splits an AssignedTo value into the assigned user or team.
+// Both are empty when the alert is unassigned.
+func ParseAssignee(assignedTo *string) (userID uint, team string) {
+	if assignedTo == nil {
+		return 0, ""
+	}
+	value := strings.TrimSpace(*assignedTo)
+	switch {
+	case strings.HasPrefix(value, assigneeUserPrefix):
+		id, _ := strconv.ParseUint(strings.TrimPrefix(value, assigneeUserPrefix), 10, 32)
+		return uint(id), ""
+	case strings.HasPrefix(value, assigneeTeamPrefix):
+		return 0, strings.TrimPrefix(value, assigneeTeamPrefix)
+	}
+	return 0, ""
+}
+
+// AlertAssignment records a change of an alert's assignee
+type AlertAssignment struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	AlertID      uint      `gorm:"not null;index" json:"alertId"`
+	Action       string    `gorm:"size:20;not null" json:"action"`
+	FromAssignee *string   `gorm:"size:20" json:"fromAssignee"`
+	ToAssignee   *string   `gorm:"size:20" json:"toAssignee"`
+	ChangedBy    uint      `gorm:"not null" json:"changedBy"`
+	Reason       *string   `gorm:"size:255" json:"reason"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// TableName specifies the table name for the AlertAssignment model
+func (AlertAssignment) TableName() string {
+	return "alert_assignments"
+}
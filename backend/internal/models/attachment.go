@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// AlertAttachment represents a file attached to an alert. The file contents
+// are stored once per checksum and shared by every attachment with that
+// checksum.
+type AlertAttachment struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	AlertID     uint      `gorm:"not null;index" json:"alertId"`
+	FileName    string    `gorm:"size:255;not null" json:"fileName"`
+	ContentType string    `gorm:"size:100;not null" json:"contentType"`
+	Size        int64     `gorm:"not null" json:"size"`
+	Checksum    string    `gorm:"size:64;not null;index" json:"checksum"`
+	UploadedBy  uint      `gorm:"not null" json:"uploadedBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// TableName specifies the table name for the AlertAttachment model
+func (AlertAttachment) TableName() string {
+	return "alert_attachments"
+}
+
+// AttachmentBlob is a stored file, shared by the attachments with its
+// checksum. Its row is locked while attachments with the checksum are added
+// or removed, so that a file is never deleted under a new attachment.
+type AttachmentBlob struct {
+	Checksum  string    `gorm:"primaryKey;size:64" json:"checksum"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// TableName specifies the table name for the AttachmentBlob model
+func (AttachmentBlob) TableName() string {
+	return "attachment_blobs"
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// AuditLog is an entry of the audit_log table shared with the PHP system.
+// Entries are about a record of a table, acted on by a user.
+type AuditLog struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	UserID    uint      `gorm:"not null" json:"userId"`
+	Action    string    `gorm:"size:50;not null" json:"action"`
+	Table     string    `gorm:"column:table_name;size:50;not null" json:"tableName"`
+	RecordID  uint      `gorm:"not null" json:"recordId"`
+	OldValue  *string   `json:"oldValue"`
+	NewValue  *string   `json:"newValue"`
+	Timestamp time.Time `gorm:"column:timestamp;autoCreateTime" json:"timestamp"`
+}
+
+// TableName specifies the table name for the AuditLog model
+func (AuditLog) TableName() string {
+	return "audit_log"
+}
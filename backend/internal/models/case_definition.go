@@ -0,0 +1,82 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Case classifications, ordered from weakest to strongest
+const (
+	ClassificationNotACase  = "Not a case"
+	ClassificationSuspected = "Suspected"
+	ClassificationProbable  = "Probable"
+	ClassificationConfirmed = "Confirmed"
+)
+
+// Alert priorities set by the case-definition engine
+const (
+	PriorityNormal = "Normal"
+	PriorityHigh   = "High"
+)
+
+// CaseDefinitionRules is the rule set a case definition applies to an alert.
+// Symptom and risk factor names are matched after normalisation to snake_case,
+// so "Abdominal Pain" and "abdominal_pain" are the same symptom.
+type CaseDefinitionRules struct {
+	RequiredSymptoms []string `json:"requiredSymptoms"`
+	AnySymptoms      []string `json:"anySymptoms"`
+	MinAnySymptoms   int      `json:"minAnySymptoms"`
+	MinAge           *int     `json:"minAge"`
+	MaxAge           *int     `json:"maxAge"`
+	RiskFactors      []string `json:"riskFactors"`
+}
+
+// Value stores the rules as JSON
+func (r CaseDefinitionRules) Value() (driver.Value, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan reads the rules from a JSON column
+func (r *CaseDefinitionRules) Scan(value interface{}) error {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		*r = CaseDefinitionRules{}
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("unsupported type %T for case definition rules", value)
+	}
+	return json.Unmarshal(b, r)
+}
+
+// CaseDefinition describes a syndrome that alerts are classified against
+type CaseDefinition struct {
+	ID              uint                `gorm:"primarykey" json:"id"`
+	Code            string              `gorm:"size:20;not null;uniqueIndex" json:"code"`
+	Name            string              `gorm:"size:100;not null" json:"name"`
+	Disease         string              `gorm:"size:100" json:"disease"`
+	Classification  string              `gorm:"size:50;not null" json:"classification"`
+	HighConsequence bool                `gorm:"default:false" json:"highConsequence"`
+	Active          bool                `gorm:"not null" json:"active"`
+	Rules           CaseDefinitionRules `gorm:"type:text" json:"rules"`
+	CreatedAt       time.Time           `json:"createdAt"`
+	UpdatedAt       time.Time           `json:"updatedAt"`
+	DeletedAt       gorm.DeletedAt      `gorm:"index" json:"-"`
+}
+
+// TableName specifies the table name for the CaseDefinition model
+func (CaseDefinition) TableName() string {
+	return "case_definitions"
+}
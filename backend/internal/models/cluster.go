@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Cluster statuses
+const (
+	ClusterStatusActive = "Active"
+	ClusterStatusClosed = "Closed"
+)
+
+// Cluster represents a group of alerts with the same syndrome that are close
+// in space and time
+type Cluster struct {
+	ID         uint            `gorm:"primarykey" json:"id"`
+	Syndrome   string          `gorm:"size:20;not null;index" json:"syndrome"`
+	Status     string          `gorm:"size:20;not null;index" json:"status"`
+	StartDate  time.Time       `gorm:"not null" json:"startDate"`
+	EndDate    time.Time       `gorm:"not null;index" json:"endDate"`
+	Latitude   float64         `gorm:"not null" json:"latitude"`
+	Longitude  float64         `gorm:"not null" json:"longitude"`
+	RadiusKm   float64         `gorm:"not null" json:"radiusKm"`
+	AlertCount int             `gorm:"not null" json:"alertCount"`
+	Members    []ClusterMember `gorm:"foreignKey:ClusterID" json:"members,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	UpdatedAt  time.Time       `json:"updatedAt"`
+}
+
+// TableName specifies the table name for the Cluster model
+func (Cluster) TableName() string {
+	return "clusters"
+}
+
+// ClusterMember links an alert to a cluster
+type ClusterMember struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	ClusterID uint      `gorm:"not null;uniqueIndex:idx_cluster_alert" json:"clusterId"`
+	AlertID   uint      `gorm:"not null;uniqueIndex:idx_cluster_alert;index" json:"alertId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// TableName specifies the table name for the ClusterMember model
+func (ClusterMember) TableName() string {
+	return "cluster_members"
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ContactFollowUpDays is the number of days a contact is followed up after exposure
+const ContactFollowUpDays = 21
+
+// Contact follow-up statuses
+const (
+	ContactStatusUnderFollowUp = "Under follow-up"
+	ContactStatusCompleted     = "Completed"
+	ContactStatusLost          = "Lost to follow-up"
+	ContactStatusBecameCase    = "Became case"
+)
+
+// Contact represents a person exposed to a confirmed or probable case
+type Contact struct {
+	ID               uint              `gorm:"primarykey" json:"id"`
+	AlertID          uint              `gorm:"not null;index" json:"alertId"`
+	Name             string            `gorm:"size:255;not null" json:"name"`
+	Sex              *string           `gorm:"size:50" json:"sex"`
+	Age              *int              `json:"age"`
+	Phone            *string           `gorm:"size:50" json:"phone"`
+	Village          *string           `gorm:"size:255" json:"village"`
+	Parish           *string           `gorm:"size:255" json:"parish"`
+	SubCounty        *string           `gorm:"size:255" json:"subCounty"`
+	District         *string           `gorm:"size:255;index" json:"district"`
+	Relationship     *string           `gorm:"size:100" json:"relationship"`
+	ExposureDate     time.Time         `gorm:"type:date;not null" json:"exposureDate"`
+	ExposureType     *string           `gorm:"size:50" json:"exposureType"`
+	FollowUpStart    time.Time         `gorm:"type:date;not null" json:"followUpStart"`
+	FollowUpEnd      time.Time         `gorm:"type:date;not null;index" json:"followUpEnd"`
+	Status           string            `gorm:"size:30;not null;index" json:"status"`
+	ConvertedAlertID *uint             `json:"convertedAlertId"`
+	FollowUps        []ContactFollowUp `gorm:"foreignKey:ContactID" json:"followUps,omitempty"`
+	CreatedAt        time.Time         `json:"createdAt"`
+	UpdatedAt        time.Time         `json:"updatedAt"`
+	DeletedAt        gorm.DeletedAt    `gorm:"index" json:"-"`
+}
+
+// TableName specifies the table name for the Contact model
+func (Contact) TableName() string {
+	return "contacts"
+}
+
+// ContactFollowUp represents a daily follow-up visit of a contact
+type ContactFollowUp struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	ContactID    uint      `gorm:"not null;uniqueIndex:idx_contact_follow_up_date" json:"contactId"`
+	FollowUpDate time.Time `gorm:"type:date;not null;uniqueIndex:idx_contact_follow_up_date" json:"followUpDate"`
+	Day          int       `gorm:"not null" json:"day"`
+	Seen         bool      `gorm:"not null" json:"seen"`
+	Symptomatic  bool      `gorm:"not null" json:"symptomatic"`
+	Symptoms     *string   `gorm:"type:text" json:"symptoms"`
+	Temperature  *float64  `json:"temperature"`
+	Notes        *string   `gorm:"type:text" json:"notes"`
+	RecordedBy   string    `gorm:"size:50" json:"recordedBy"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// TableName specifies the table name for the ContactFollowUp model
+func (ContactFollowUp) TableName() string {
+	return "contact_follow_ups"
+}
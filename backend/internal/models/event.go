@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Event statuses
+const (
+	EventStatusActive = "Active"
+	EventStatusClosed = "Closed"
+)
+
+// Event represents an outbreak or other public health event that spans many
+// alerts and districts
+type Event struct {
+	ID          uint            `gorm:"primarykey" json:"id"`
+	Name        string          `gorm:"size:255;not null" json:"name"`
+	Disease     string          `gorm:"size:100;not null;index" json:"disease"`
+	Status      string          `gorm:"size:20;not null;index" json:"status"`
+	StartDate   time.Time       `gorm:"type:date;not null" json:"startDate"`
+	EndDate     *time.Time      `gorm:"type:date" json:"endDate"`
+	Description *string         `gorm:"type:text" json:"description"`
+	Districts   []EventDistrict `gorm:"foreignKey:EventID" json:"districts"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+	DeletedAt   gorm.DeletedAt  `gorm:"index" json:"-"`
+}
+
+// TableName specifies the table name for the Event model
+func (Event) TableName() string {
+	return "events"
+}
+
+// EventDistrict records a district affected by an event
+type EventDistrict struct {
+	EventID    uint   `gorm:"primaryKey;autoIncrement:false" json:"-"`
+	DistrictID uint   `gorm:"primaryKey;autoIncrement:false" json:"districtId"`
+	District   string `gorm:"size:50;not null" json:"district"`
+}
+
+// TableName specifies the table name for the EventDistrict model
+func (EventDistrict) TableName() string {
+	return "event_districts"
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Laboratory sample statuses
+const (
+	LabSampleCollected = "Collected"
+	LabSampleShipped   = "Shipped"
+	LabSampleReceived  = "Received"
+	LabSampleTested    = "Tested"
+	LabSampleRejected  = "Rejected"
+)
+
+// Laboratory test results. The alert's legacy 10 character LabResult column
+// only ever receives Positive or Negative.
+const (
+	LabResultPending      = "Pending"
+	LabResultPositive     = "Positive"
+	LabResultNegative     = "Negative"
+	LabResultInconclusive = "Inconclusive"
+)
+
+// LabSample represents a specimen collected for an alert
+type LabSample struct {
+	ID             uint           `gorm:"primarykey" json:"id"`
+	AlertID        uint           `gorm:"not null;index" json:"alertId"`
+	SampleNumber   string         `gorm:"size:50;not null;uniqueIndex" json:"sampleNumber"`
+	SampleType     string         `gorm:"size:50;not null" json:"sampleType"`
+	CollectionDate time.Time      `json:"collectionDate"`
+	CollectedBy    *string        `gorm:"size:255" json:"collectedBy"`
+	ShipmentDate   *time.Time     `json:"shipmentDate"`
+	ReceivingLab   *string        `gorm:"size:255;index" json:"receivingLab"`
+	ReceivedDate   *time.Time     `json:"receivedDate"`
+	ReceivedBy     *string        `gorm:"size:50" json:"receivedBy"`
+	Status         string         `gorm:"size:20;not null;index" json:"status"`
+	Remarks        *string        `gorm:"type:text" json:"remarks"`
+	Tests          []LabTest      `gorm:"foreignKey:LabSampleID" json:"tests,omitempty"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	UpdatedAt      time.Time      `json:"updatedAt"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// TableName specifies the table name for the LabSample model
+func (LabSample) TableName() string {
+	return "lab_samples"
+}
+
+// LabTest represents a test run on a laboratory sample
+type LabTest struct {
+	ID          uint       `gorm:"primarykey" json:"id"`
+	LabSampleID uint       `gorm:"not null;index" json:"labSampleId"`
+	TestName    string     `gorm:"size:100;not null" json:"testName"`
+	Result      string     `gorm:"size:20;not null" json:"result"`
+	ResultDate  *time.Time `json:"resultDate"`
+	PerformedBy *string    `gorm:"size:50" json:"performedBy"`
+	Remarks     *string    `gorm:"type:text" json:"remarks"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+// TableName specifies the table name for the LabTest model
+func (LabTest) TableName() string {
+	return "lab_tests"
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// LoginThrottle counts the recent failed logins for a username or from an IP
+// address. Failures older than the lockout period are forgotten; reaching the
+// limit locks further logins out until LockedUntil.
+type LoginThrottle struct {
+	ID           uint       `gorm:"primarykey" json:"id"`
+	Scope        string     `gorm:"size:10;not null;uniqueIndex:idx_login_throttle_key,priority:1" json:"scope"`
+	Key          string     `gorm:"column:throttle_key;size:150;not null;uniqueIndex:idx_login_throttle_key,priority:2" json:"key"`
+	Failures     int        `gorm:"not null;default:0" json:"failures"`
+	LastFailedAt time.Time  `gorm:"not null;index" json:"lastFailedAt"`
+	LockedUntil  *time.Time `json:"lockedUntil"`
+}
+
+// TableName specifies the table name for the LoginThrottle model
+func (LoginThrottle) TableName() string {
+	return "login_throttles"
+}
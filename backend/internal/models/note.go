@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Note visibilities
+const (
+	NoteVisibilityInternal = "internal"
+	NoteVisibilityShared   = "shared"
+)
+
+// AlertNote represents a note in an alert's discussion thread. Shared notes
+// are also visible to the verifier holding the alert's verification token.
+type AlertNote struct {
+	ID         uint           `gorm:"primarykey" json:"id"`
+	AlertID    uint           `gorm:"not null;index" json:"alertId"`
+	ParentID   *uint          `gorm:"index" json:"parentId"`
+	AuthorID   uint           `gorm:"not null" json:"authorId"`
+	Author     string         `gorm:"size:50;not null" json:"author"`
+	Body       string         `gorm:"type:text;not null" json:"body"`
+	Visibility string         `gorm:"size:10;not null" json:"visibility"`
+	Revision   int            `gorm:"not null" json:"revision"`
+	CreatedAt  time.Time      `json:"createdAt"`
+	UpdatedAt  time.Time      `json:"updatedAt"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// TableName specifies the table name for the AlertNote model
+func (AlertNote) TableName() string {
+	return "alert_notes"
+}
+
+// AlertNoteRevision keeps the content a note had before an edit
+type AlertNoteRevision struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	NoteID     uint      `gorm:"not null;uniqueIndex:idx_note_revision" json:"noteId"`
+	Revision   int       `gorm:"not null;uniqueIndex:idx_note_revision" json:"revision"`
+	Body       string    `gorm:"type:text;not null" json:"body"`
+	Visibility string    `gorm:"size:10;not null" json:"visibility"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// TableName specifies the table name for the AlertNoteRevision model
+func (AlertNoteRevision) TableName() string {
+	return "alert_note_revisions"
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Notification represents an in-app message for a user
+type Notification struct {
+	ID        uint       `gorm:"primarykey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"userId"`
+	AlertID   *uint      `gorm:"index" json:"alertId"`
+	Type      string     `gorm:"size:50;not null" json:"type"`
+	Title     string     `gorm:"size:255;not null" json:"title"`
+	Message   string     `gorm:"type:text" json:"message"`
+	ReadAt    *time.Time `json:"readAt"`
+	CreatedAt time.Time  `gorm:"index" json:"createdAt"`
+}
+
+// TableName specifies the table name for the Notification model
+func (Notification) TableName() string {
+	return "notifications"
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// PasswordResetToken is a one-time password reset link sent by email. Only
+// the SHA-256 hash of the token is stored; a user has at most one unused
+// token, the one of the latest request.
+type PasswordResetToken struct {
+	ID        uint       `gorm:"primarykey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"userId"`
+	TokenHash string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	RequestIP string     `gorm:"column:request_ip;size:45" json:"requestIp"`
+	ExpiresAt time.Time  `gorm:"not null;index" json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// TableName specifies the table name for the PasswordResetToken model
+func (PasswordResetToken) TableName() string {
+	return "password_reset_tokens"
+}
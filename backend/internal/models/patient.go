@@ -0,0 +1,95 @@
+package models
+
+import "time"
+
+// Patient represents a case-patient under investigation
+type Patient struct {
+	ID                 uint              `gorm:"primarykey" json:"id"`
+	Surname            *string           `gorm:"size:100" json:"surname"`
+	OtherNames         *string           `gorm:"size:100" json:"otherNames"`
+	Age                *int              `json:"age"`
+	AgeUnit            *string           `gorm:"type:enum('Years','Months')" json:"ageUnit"`
+	Gender             *string           `gorm:"type:enum('Male','Female')" json:"gender"`
+	Phone              *string           `gorm:"size:20" json:"phone"`
+	PhoneOwner         *string           `gorm:"size:100" json:"phoneOwner"`
+	Status             *string           `gorm:"type:enum('Alive','Dead')" json:"status"`
+	DateOfDeath        *time.Time        `gorm:"type:date" json:"dateOfDeath"`
+	ResidenceVillage   *string           `gorm:"size:100" json:"residenceVillage"`
+	ResidenceParish    *string           `gorm:"size:100" json:"residenceParish"`
+	ResidenceDistrict  *string           `gorm:"size:100" json:"residenceDistrict"`
+	ResidenceSubcounty *string           `gorm:"size:100" json:"residenceSubcounty"`
+	Occupation         *string           `gorm:"size:100" json:"occupation"`
+	LocationVillage    *string           `gorm:"size:100" json:"locationVillage"`
+	LocationDistrict   *string           `gorm:"size:100" json:"locationDistrict"`
+	LocationSubcounty  *string           `gorm:"size:100" json:"locationSubcounty"`
+	GPSLatitude        *float64          `gorm:"column:gps_latitude;type:decimal(10,8)" json:"gpsLatitude"`
+	GPSLongitude       *float64          `gorm:"column:gps_longitude;type:decimal(11,8)" json:"gpsLongitude"`
+	LocationStartDate  *time.Time        `gorm:"type:date" json:"locationStartDate"`
+	LocationEndDate    *time.Time        `gorm:"type:date" json:"locationEndDate"`
+	Hospitalizations   []Hospitalization `gorm:"foreignKey:PatientID" json:"hospitalizations,omitempty"`
+	RiskFactors        []RiskFactor      `gorm:"foreignKey:PatientID" json:"riskFactors,omitempty"`
+}
+
+// TableName specifies the table name for the Patient model
+func (Patient) TableName() string {
+	return "patients"
+}
+
+// Hospitalization represents a hospital admission of a patient
+type Hospitalization struct {
+	ID            uint       `gorm:"primarykey" json:"id"`
+	PatientID     *uint      `gorm:"index" json:"patientId"`
+	HospitalName  *string    `gorm:"size:255" json:"hospitalName"`
+	AdmissionDate *time.Time `gorm:"type:date" json:"admissionDate"`
+	Village       *string    `gorm:"size:100" json:"village"`
+	District      *string    `gorm:"size:100" json:"district"`
+	Subcounty     *string    `gorm:"size:100" json:"subcounty"`
+	Isolation     *bool      `json:"isolation"`
+	IsolationDate *time.Time `gorm:"type:date" json:"isolationDate"`
+}
+
+// TableName specifies the table name for the Hospitalization model
+func (Hospitalization) TableName() string {
+	return "hospitalizations"
+}
+
+// RiskFactor represents the exposure history recorded for a patient
+type RiskFactor struct {
+	ID                    uint  `gorm:"primarykey" json:"id"`
+	PatientID             *uint `gorm:"index" json:"patientId"`
+	ContactWithSick       *bool `json:"contactWithSick"`
+	AttendedFuneral       *bool `json:"attendedFuneral"`
+	Traveled              *bool `json:"traveled"`
+	VisitedHealthFacility *bool `json:"visitedHealthFacility"`
+	VisitedHealer         *bool `json:"visitedHealer"`
+	AnimalContact         *bool `json:"animalContact"`
+	BittenByTick          *bool `json:"bittenByTick"`
+}
+
+// TableName specifies the table name for the RiskFactor model
+func (RiskFactor) TableName() string {
+	return "risk_factors"
+}
+
+// Factors returns the names of the risk factors that are present
+func (r RiskFactor) Factors() []string {
+	flags := []struct {
+		name  string
+		value *bool
+	}{
+		{"contact_with_sick", r.ContactWithSick},
+		{"attended_funeral", r.AttendedFuneral},
+		{"traveled", r.Traveled},
+		{"visited_health_facility", r.VisitedHealthFacility},
+		{"visited_healer", r.VisitedHealer},
+		{"animal_contact", r.AnimalContact},
+		{"bitten_by_tick", r.BittenByTick},
+	}
+	var factors []string
+	for _, f := range flags {
+		if f.value != nil && *f.value {
+			factors = append(factors, f.name)
+		}
+	}
+	return factors
+}
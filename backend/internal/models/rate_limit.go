@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// RateLimitEvent is a request counted against a rate limit. Scope names the
+// limit and Key what it is counted for, such as a user or an IP address.
+type RateLimitEvent struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	Scope     string    `gorm:"size:50;not null;index:idx_rate_limit_key,priority:1" json:"scope"`
+	Key       string    `gorm:"column:limit_key;size:150;not null;index:idx_rate_limit_key,priority:2" json:"key"`
+	CreatedAt time.Time `gorm:"not null;index:idx_rate_limit_key,priority:3;index" json:"createdAt"`
+}
+
+// TableName specifies the table name for the RateLimitEvent model
+func (RateLimitEvent) TableName() string {
+	return "rate_limit_events"
+}
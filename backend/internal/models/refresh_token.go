@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// RefreshToken is a refresh token handed out at login. Only the SHA-256 hash
+// of the token is stored. Each refresh uses up the token and issues the next
+// one of the same family; a family starts at login, so reusing a spent token
+// reveals a stolen one and revokes the whole family.
+type RefreshToken struct {
+	ID           uint       `gorm:"primarykey" json:"id"`
+	UserID       uint       `gorm:"not null;index" json:"userId"`
+	FamilyID     string     `gorm:"size:32;not null;index" json:"familyId"`
+	TokenHash    string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	ExpiresAt    time.Time  `gorm:"not null;index" json:"expiresAt"`
+	UsedAt       *time.Time `json:"usedAt"`
+	ReplacedByID *uint      `json:"replacedById"`
+	RevokedAt    *time.Time `json:"revokedAt"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+// TableName specifies the table name for the RefreshToken model
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// RevokedToken is an access token revoked before it expires, as at logout.
+// Rows are only needed until the token would have expired anyway.
+type RevokedToken struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	JTI       string    `gorm:"column:jti;size:32;not null;uniqueIndex" json:"jti"`
+	UserID    uint      `gorm:"not null;index" json:"userId"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expiresAt"`
+	RevokedAt time.Time `gorm:"not null" json:"revokedAt"`
+}
+
+// TableName specifies the table name for the RevokedToken model
+func (RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
+
+// UserRevocation revokes every access token issued to a user before a time,
+// for when all of a user's sessions are ended at once
+type UserRevocation struct {
+	UserID        uint      `gorm:"primaryKey;autoIncrement:false" json:"userId"`
+	RevokedBefore time.Time `gorm:"not null" json:"revokedBefore"`
+}
+
+// TableName specifies the table name for the UserRevocation model
+func (UserRevocation) TableName() string {
+	return "user_revocations"
+}
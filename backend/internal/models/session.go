@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Session is a login of a user on a device. It follows the refresh token
+// family started at login: refreshing updates where and when the session was
+// last seen, and ending the session revokes the family and every access token
+// issued in it.
+type Session struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"userId"`
+	FamilyID   string     `gorm:"size:32;not null;uniqueIndex" json:"-"`
+	Device     string     `gorm:"size:100" json:"device"`
+	IPAddress  string     `gorm:"column:ip_address;size:45" json:"ipAddress"`
+	UserAgent  string     `gorm:"size:255" json:"userAgent"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastSeenAt time.Time  `gorm:"not null" json:"lastSeenAt"`
+	ExpiresAt  time.Time  `gorm:"not null;index" json:"expiresAt"`
+	EndedAt    *time.Time `gorm:"index" json:"endedAt,omitempty"`
+}
+
+// TableName specifies the table name for the Session model
+func (Session) TableName() string {
+	return "sessions"
+}
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// UserIdentity links a user to an account of an OpenID Connect identity
+// provider, identified by its issuer and subject
+type UserIdentity struct {
+	ID      uint   `gorm:"primarykey" json:"id"`
+	UserID  uint   `gorm:"not null;index" json:"userId"`
+	Issuer  string `gorm:"size:255;not null;uniqueIndex:idx_user_identities_subject" json:"issuer"`
+	Subject string `gorm:"size:255;not null;uniqueIndex:idx_user_identities_subject" json:"subject"`
+	// Email is the address given by the provider, which may not fit the
+	// users table
+	Email       string    `gorm:"size:255" json:"email"`
+	CreatedAt   time.Time `json:"createdAt"`
+	LastLoginAt time.Time `json:"lastLoginAt"`
+}
+
+// TableName specifies the table name for the UserIdentity model
+func (UserIdentity) TableName() string {
+	return "user_identities"
+}
+
+// SSOLogin is a single sign-on in progress. It holds the state, nonce and
+// PKCE verifier of the redirect to the identity provider, then once the
+// provider has signed the user in, the user and the hash of the one-time
+// code the client exchanges for tokens.
+type SSOLogin struct {
+	ID            uint      `gorm:"primarykey"`
+	StateHash     string    `gorm:"size:64;not null;uniqueIndex"`
+	Nonce         string    `gorm:"size:64;not null"`
+	CodeVerifier  string    `gorm:"size:64;not null"`
+	UserID        *uint     `gorm:"index"`
+	LoginCodeHash *string   `gorm:"size:64;uniqueIndex"`
+	ExpiresAt     time.Time `gorm:"not null;index"`
+	CreatedAt     time.Time
+}
+
+// TableName specifies the table name for the SSOLogin model
+func (SSOLogin) TableName() string {
+	return "sso_logins"
+}
@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// UserTOTP is the TOTP authenticator of a user. The secret is stored
+// encrypted. Until ConfirmedAt is set the user is enrolling and logins are
+// not asked for a code.
+type UserTOTP struct {
+	UserID uint   `gorm:"primaryKey;autoIncrement:false" json:"userId"`
+	Secret string `gorm:"size:255;not null" json:"-"`
+	// LastUsedStep is the time step of the last code accepted, so that a
+	// code cannot be used twice
+	LastUsedStep int64      `gorm:"not null;default:0" json:"-"`
+	ConfirmedAt  *time.Time `json:"confirmedAt"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+// TableName specifies the table name for the UserTOTP model
+func (UserTOTP) TableName() string {
+	return "user_totp"
+}
+
+// TOTPRecoveryCode is a single-use code standing in for a TOTP code when the
+// authenticator is lost. Only the SHA-256 hash of the code is stored.
+type TOTPRecoveryCode struct {
+	ID        uint       `gorm:"primarykey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"userId"`
+	CodeHash  string     `gorm:"size:64;not null" json:"-"`
+	UsedAt    *time.Time `json:"usedAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// TableName specifies the table name for the TOTPRecoveryCode model
+func (TOTPRecoveryCode) TableName() string {
+	return "totp_recovery_codes"
+}
+
+// LoginChallenge is a login waiting for its second step after the password
+// was checked, either a TOTP code or, for users whose role requires two-factor
+// authentication, enrolment. Only the SHA-256 hash of its token is stored.
+type LoginChallenge struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	UserID    uint      `gorm:"not null;index" json:"userId"`
+	TokenHash string    `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Purpose   string    `gorm:"size:10;not null" json:"purpose"`
+	Attempts  int       `gorm:"not null;default:0" json:"attempts"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expiresAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// TableName specifies the table name for the LoginChallenge model
+func (LoginChallenge) TableName() string {
+	return "login_challenges"
+}
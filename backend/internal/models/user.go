@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -27,3 +28,22 @@ type User struct {
 func (User) TableName() string {
 	return "users"
 }
+
+// Roles are held in a user's Level or UserType
+const (
+	RoleAdmin    = "Admin"
+	RoleNational = "National"
+	RoleREOC     = "REOC"
+	RoleDistrict = "District"
+	RoleLab      = "Lab"
+)
+
+// HasRole reports whether the user's level or type matches one of the roles
+func (u User) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if strings.EqualFold(u.Level, role) || strings.EqualFold(u.UserType, role) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package oidc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Claims are the claims of a verified ID token
+type Claims map[string]interface{}
+
+// lookup returns the claim at a dot-separated path, such as
+// realm_access.roles
+func (c Claims) lookup(path string) interface{} {
+	var value interface{} = map[string]interface{}(c)
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	return value
+}
+
+// String returns a string claim, or "" if it is missing or not a string
+func (c Claims) String(path string) string {
+	if path == "" {
+		return ""
+	}
+	s, _ := c.lookup(path).(string)
+	return strings.TrimSpace(s)
+}
+
+// Strings returns a claim holding a list of strings or a single string
+func (c Claims) Strings(path string) []string {
+	if path == "" {
+		return nil
+	}
+	switch v := c.lookup(path).(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Bool returns a boolean claim. Some providers send booleans as strings.
+func (c Claims) Bool(path string) bool {
+	switch v := c.lookup(path).(type) {
+	case bool:
+		return v
+	case string:
+		return strings.EqualFold(v, "true")
+	}
+	return false
+}
+
+// GroupRole gives the members of an identity provider group a user type,
+// affiliation or level. Empty fields are left alone.
+type GroupRole struct {
+	Group       string
+	UserType    string
+	Affiliation string
+	Level       string
+}
+
+// ParseGroupMap parses group roles written as
+//
+//	group=field:value,field:value;group=field:value
+//
+// where field is userType, affiliation or level, for example
+//
+//	moh-admins=level:Admin;reoc-west=level:REOC,affiliation:Western Region
+func ParseGroupMap(s string) ([]GroupRole, error) {
+	var roles []GroupRole
+	for _, entry := range strings.Split(s, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		group, fields, ok := strings.Cut(entry, "=")
+		role := GroupRole{Group: strings.TrimSpace(group)}
+		if !ok || role.Group == "" {
+			return nil, fmt.Errorf("invalid group mapping %q", entry)
+		}
+		for _, field := range strings.Split(fields, ",") {
+			name, value, ok := strings.Cut(field, ":")
+			value = strings.TrimSpace(value)
+			if !ok || value == "" {
+				return nil, fmt.Errorf("invalid group mapping %q", entry)
+			}
+			switch strings.TrimSpace(name) {
+			case "userType":
+				role.UserType = value
+			case "affiliation":
+				role.Affiliation = value
+			case "level":
+				role.Level = value
+			default:
+				return nil, fmt.Errorf("unknown field %q in group mapping %q", name, entry)
+			}
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// Mapping tells which claims describe a user. Claim names may be
+// dot-separated paths into nested claims.
+type Mapping struct {
+	UsernameClaim string
+	GroupsClaim   string
+	// Claims copied as they are to the user; empty names are not used
+	UserTypeClaim    string
+	AffiliationClaim string
+	LevelClaim       string
+	// Groups give roles to the members of groups. For each field the first
+	// matching group wins.
+	Groups []GroupRole
+}
+
+// Profile is a user as described by the identity provider
+type Profile struct {
+	Subject       string
+	Username      string
+	Email         string
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+	// Empty when nothing maps to them
+	UserType    string
+	Affiliation string
+	Level       string
+}
+
+// Profile maps the claims of an ID token to a user. Claims copied as they
+// are take precedence over groups.
+func (m Mapping) Profile(claims Claims) Profile {
+	profile := Profile{
+		Subject:       claims.String("sub"),
+		Username:      claims.String(m.UsernameClaim),
+		Email:         claims.String("email"),
+		EmailVerified: claims.Bool("email_verified"),
+		FirstName:     claims.String("given_name"),
+		LastName:      claims.String("family_name"),
+		UserType:      claims.String(m.UserTypeClaim),
+		Affiliation:   claims.String(m.AffiliationClaim),
+		Level:         claims.String(m.LevelClaim),
+	}
+	if profile.Username == "" {
+		profile.Username = profile.Email
+	}
+
+	groups := make(map[string]bool)
+	for _, group := range claims.Strings(m.GroupsClaim) {
+		groups[group] = true
+	}
+	for _, role := range m.Groups {
+		if !groups[role.Group] {
+			continue
+		}
+		if profile.UserType == "" {
+			profile.UserType = role.UserType
+		}
+		if profile.Affiliation == "" {
+			profile.Affiliation = role.Affiliation
+		}
+		if profile.Level == "" {
+			profile.Level = role.Level
+		}
+	}
+	return profile
+}
@@ -0,0 +1,287 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID    = "alertsmis"
+	testRedirectURL = "https://alerts.example/api/v1/auth/sso/callback"
+	testKeyID       = "key-1"
+)
+
+// testIdP is an identity provider whose token endpoint hands out the ID
+// token a test has prepared for a code, once it has checked the PKCE
+// verifier against the challenge of the sign-in
+type testIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu         sync.Mutex
+	challenges map[string]string
+	tokens     map[string]string
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{t: t, key: key, challenges: make(map[string]string), tokens: make(map[string]string)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                           idp.server.URL,
+			"authorization_endpoint":           idp.server.URL + "/authorize",
+			"token_endpoint":                   idp.server.URL + "/token",
+			"jwks_uri":                         idp.server.URL + "/jwks",
+			"code_challenge_methods_supported": []string{"S256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": testKeyID,
+				"use": "sig",
+				"n":   encode(key.N.Bytes()),
+				"e":   encode(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		code := r.PostFormValue("code")
+		token, ok := idp.tokens[code]
+		delete(idp.tokens, code)
+		if !ok || r.PostFormValue("client_id") != testClientID || r.PostFormValue("redirect_uri") != testRedirectURL ||
+			codeChallenge(r.PostFormValue("code_verifier")) != idp.challenges[code] {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "token_type": "Bearer", "id_token": token})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// issue prepares a code for the sign-in started at authURL, redeemed for
+// token
+func (idp *testIdP) issue(authURL, code, token string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.challenges[code] = u.Query().Get("code_challenge")
+	idp.tokens[code] = token
+}
+
+// claims returns valid ID token claims for a sign-in with nonce
+func (idp *testIdP) claims(nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":   idp.server.URL,
+		"sub":   "user-1",
+		"aud":   testClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": nonce,
+	}
+}
+
+// sign signs claims with key as the provider's key
+func (idp *testIdP) sign(claims jwt.MapClaims, key *rsa.PrivateKey) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testKeyID
+	signed, err := token.SignedString(key)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	return signed
+}
+
+func TestExchange(t *testing.T) {
+	idp := newTestIdP(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const nonce = "nonce-1"
+
+	tests := []struct {
+		name  string
+		token func() string
+		ok    bool
+	}{
+		{"valid", func() string {
+			return idp.sign(idp.claims(nonce), idp.key)
+		}, true},
+		{"bad signature", func() string {
+			return idp.sign(idp.claims(nonce), otherKey)
+		}, false},
+		{"tampered payload", func() string {
+			token := idp.sign(idp.claims(nonce), idp.key)
+			forged := idp.claims(nonce)
+			forged["sub"] = "admin"
+			payload, err := json.Marshal(forged)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parts := strings.Split(token, ".")
+			return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+		}, false},
+		{"unsigned", func() string {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodNone, idp.claims(nonce)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return token
+		}, false},
+		{"unknown key", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, idp.claims(nonce))
+			token.Header["kid"] = "key-2"
+			signed, err := token.SignedString(idp.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return signed
+		}, false},
+		{"wrong audience", func() string {
+			claims := idp.claims(nonce)
+			claims["aud"] = "another-client"
+			return idp.sign(claims, idp.key)
+		}, false},
+		{"several audiences without authorized party", func() string {
+			claims := idp.claims(nonce)
+			claims["aud"] = []string{testClientID, "another-client"}
+			return idp.sign(claims, idp.key)
+		}, false},
+		{"several audiences with authorized party", func() string {
+			claims := idp.claims(nonce)
+			claims["aud"] = []string{testClientID, "another-client"}
+			claims["azp"] = testClientID
+			return idp.sign(claims, idp.key)
+		}, true},
+		{"wrong issuer", func() string {
+			claims := idp.claims(nonce)
+			claims["iss"] = "https://evil.example"
+			return idp.sign(claims, idp.key)
+		}, false},
+		{"expired", func() string {
+			claims := idp.claims(nonce)
+			claims["iat"] = time.Now().Add(-time.Hour).Unix()
+			claims["exp"] = time.Now().Add(-10 * time.Minute).Unix()
+			return idp.sign(claims, idp.key)
+		}, false},
+		{"no expiry", func() string {
+			claims := idp.claims(nonce)
+			delete(claims, "exp")
+			return idp.sign(claims, idp.key)
+		}, false},
+		{"issued in the future", func() string {
+			claims := idp.claims(nonce)
+			claims["iat"] = time.Now().Add(10 * time.Minute).Unix()
+			claims["exp"] = time.Now().Add(15 * time.Minute).Unix()
+			return idp.sign(claims, idp.key)
+		}, false},
+		{"nonce mismatch", func() string {
+			return idp.sign(idp.claims("nonce-2"), idp.key)
+		}, false},
+		{"no nonce", func() string {
+			claims := idp.claims(nonce)
+			delete(claims, "nonce")
+			return idp.sign(claims, idp.key)
+		}, false},
+		{"no subject", func() string {
+			claims := idp.claims(nonce)
+			delete(claims, "sub")
+			return idp.sign(claims, idp.key)
+		}, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			p := NewProvider(idp.server.URL, testClientID, "", testRedirectURL, []string{"profile"})
+			verifier, err := NewVerifier()
+			if err != nil {
+				t.Fatal(err)
+			}
+			authURL, err := p.AuthCodeURL(ctx, "state", nonce, verifier)
+			if err != nil {
+				t.Fatal(err)
+			}
+			code := fmt.Sprintf("code-%d", i)
+			idp.issue(authURL, code, tt.token())
+
+			claims, err := p.Exchange(ctx, code, verifier, nonce)
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("err = %v, want the token accepted", err)
+				}
+				if claims.String("sub") != "user-1" {
+					t.Errorf("subject %q, want user-1", claims.String("sub"))
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidIDToken) {
+				t.Errorf("err = %v, want %v", err, ErrInvalidIDToken)
+			}
+		})
+	}
+}
+
+func TestExchangeChecksVerifier(t *testing.T) {
+	idp := newTestIdP(t)
+	ctx := context.Background()
+	p := NewProvider(idp.server.URL, testClientID, "", testRedirectURL, nil)
+	verifier, err := NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := p.AuthCodeURL(ctx, "state", "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.issue(authURL, "code", idp.sign(idp.claims("nonce"), idp.key))
+
+	other, err := NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Exchange(ctx, "code", other, "nonce"); err == nil {
+		t.Error("code redeemed with another verifier")
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	idp := newTestIdP(t)
+	p := NewProvider(idp.server.URL+"/realms/other", testClientID, "", testRedirectURL, nil)
+	if _, err := p.AuthCodeURL(context.Background(), "state", "nonce", "verifier"); err == nil {
+		t.Error("provider configuration of another issuer accepted")
+	}
+}
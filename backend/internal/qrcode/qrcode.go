@@ -0,0 +1,493 @@
+// Package qrcode encodes short texts, such as otpauth URIs, as QR codes. It
+// implements the byte mode of QR code model 2 at error correction level M,
+// for versions 1 to 10, which holds up to 213 bytes.
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// ErrTooLong is returned for data that does not fit a version 10 code
+var ErrTooLong = errors.New("data too long for a QR code")
+
+// blockGroup is a number of error correction blocks with the same number of
+// data codewords
+type blockGroup struct {
+	blocks, data int
+}
+
+// version holds the error correction level M layout of a QR code version
+type version struct {
+	ecPerBlock int
+	groups     []blockGroup
+	alignment  []int
+	remainder  int
+}
+
+// versions lists versions 1 to 10 at error correction level M
+var versions = []version{
+	{10, []blockGroup{{1, 16}}, nil, 0},
+	{16, []blockGroup{{1, 28}}, []int{6, 18}, 7},
+	{26, []blockGroup{{1, 44}}, []int{6, 22}, 7},
+	{18, []blockGroup{{2, 32}}, []int{6, 26}, 7},
+	{24, []blockGroup{{2, 43}}, []int{6, 30}, 7},
+	{16, []blockGroup{{4, 27}}, []int{6, 34}, 7},
+	{18, []blockGroup{{4, 31}}, []int{6, 22, 38}, 0},
+	{22, []blockGroup{{2, 38}, {2, 39}}, []int{6, 24, 42}, 0},
+	{22, []blockGroup{{3, 36}, {2, 37}}, []int{6, 26, 46}, 0},
+	{26, []blockGroup{{4, 43}, {1, 44}}, []int{6, 28, 50}, 0},
+}
+
+// dataCodewords returns the number of data codewords of the version
+func (v version) dataCodewords() int {
+	n := 0
+	for _, g := range v.groups {
+		n += g.blocks * g.data
+	}
+	return n
+}
+
+// Code is an encoded QR code. Modules are true when dark.
+type Code struct {
+	Size    int
+	modules [][]bool
+}
+
+// Dark reports whether the module at column x and row y is dark
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y][x]
+}
+
+// Encode encodes data in the smallest version that holds it
+func Encode(data []byte) (*Code, error) {
+	for i, v := range versions {
+		number := i + 1
+		countBits := 8
+		if number >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) > 8*v.dataCodewords() {
+			continue
+		}
+		codewords := v.interleave(v.encodeData(data, countBits))
+		return newMatrix(number, v).draw(codewords), nil
+	}
+	return nil, ErrTooLong
+}
+
+// bitWriter appends bits most significant first
+type bitWriter struct {
+	bytes []byte
+	n     int
+}
+
+func (w *bitWriter) write(value, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.bytes = append(w.bytes, 0)
+		}
+		if value>>i&1 == 1 {
+			w.bytes[w.n/8] |= 0x80 >> (w.n % 8)
+		}
+		w.n++
+	}
+}
+
+// encodeData returns the data codewords of data in byte mode, padded to the
+// capacity of the version
+func (v version) encodeData(data []byte, countBits int) []byte {
+	capacity := v.dataCodewords()
+	w := &bitWriter{}
+	w.write(0x4, 4)
+	w.write(len(data), countBits)
+	for _, b := range data {
+		w.write(int(b), 8)
+	}
+	// Terminator, then zero bits up to a whole codeword
+	terminator := 8*capacity - w.n
+	if terminator > 4 {
+		terminator = 4
+	}
+	w.write(0, terminator)
+	w.write(0, (8-w.n%8)%8)
+	for pad := 0; len(w.bytes) < capacity; pad++ {
+		if pad%2 == 0 {
+			w.bytes = append(w.bytes, 0xEC)
+		} else {
+			w.bytes = append(w.bytes, 0x11)
+		}
+	}
+	return w.bytes
+}
+
+// interleave splits data codewords into blocks, adds their error correction
+// codewords and interleaves them
+func (v version) interleave(data []byte) []byte {
+	divisor := rsDivisor(v.ecPerBlock)
+	var blocks, ecBlocks [][]byte
+	for _, g := range v.groups {
+		for i := 0; i < g.blocks; i++ {
+			block := data[:g.data]
+			data = data[g.data:]
+			blocks = append(blocks, block)
+			ecBlocks = append(ecBlocks, rsRemainder(block, divisor))
+		}
+	}
+
+	var result []byte
+	longest := len(blocks[len(blocks)-1])
+	for i := 0; i < longest; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < v.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of a degree,
+// without its leading term, highest power first
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords of a block
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMultiply(d, factor)
+		}
+	}
+	return result
+}
+
+// matrix is a QR code being drawn
+type matrix struct {
+	number   int
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+// newMatrix draws the function patterns of a version
+func newMatrix(number int, v version) *matrix {
+	size := 17 + 4*number
+	m := &matrix{number: number, size: size}
+	m.modules = make([][]bool, size)
+	m.function = make([][]bool, size)
+	for y := range m.modules {
+		m.modules[y] = make([]bool, size)
+		m.function[y] = make([]bool, size)
+	}
+
+	// Timing patterns, partly covered by the finder patterns
+	for i := 0; i < size; i++ {
+		m.setFunction(6, i, i%2 == 0)
+		m.setFunction(i, 6, i%2 == 0)
+	}
+	m.drawFinder(3, 3)
+	m.drawFinder(size-4, 3)
+	m.drawFinder(3, size-4)
+
+	last := len(v.alignment) - 1
+	for i, x := range v.alignment {
+		for j, y := range v.alignment {
+			// Skip the corners taken by finder patterns
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			m.drawAlignment(x, y)
+		}
+	}
+
+	// Reserve the format areas until the mask is chosen
+	m.drawFormat(0)
+	m.drawVersion()
+	return m
+}
+
+func (m *matrix) setFunction(x, y int, dark bool) {
+	m.modules[y][x] = dark
+	m.function[y][x] = true
+}
+
+// drawFinder draws a finder pattern and its separator around a center
+func (m *matrix) drawFinder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || x >= m.size || y < 0 || y >= m.size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			m.setFunction(x, y, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// drawAlignment draws an alignment pattern around a center
+func (m *matrix) drawAlignment(cx, cy int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			m.setFunction(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// drawFormat draws both copies of the format information for level M with a
+// mask, and the dark module
+func (m *matrix) drawFormat(mask int) {
+	// Level M is 00
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+	for i := 0; i <= 5; i++ {
+		m.setFunction(8, i, bit(i))
+	}
+	m.setFunction(8, 7, bit(6))
+	m.setFunction(8, 8, bit(7))
+	m.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		m.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		m.setFunction(m.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		m.setFunction(8, m.size-15+i, bit(i))
+	}
+	m.setFunction(8, m.size-8, true)
+}
+
+// drawVersion draws both copies of the version information, from version 7
+func (m *matrix) drawVersion() {
+	if m.number < 7 {
+		return
+	}
+	rem := m.number
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := m.number<<12 | rem
+	for i := 0; i < 18; i++ {
+		dark := bits>>i&1 == 1
+		a, b := m.size-11+i%3, i/3
+		m.setFunction(a, b, dark)
+		m.setFunction(b, a, dark)
+	}
+}
+
+// drawCodewords places the codewords in the zigzag order of the standard,
+// two columns at a time from the bottom right, skipping function modules
+func (m *matrix) drawCodewords(codewords []byte) {
+	i := 0
+	for right := m.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < m.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if upward {
+					y = m.size - 1 - vert
+				}
+				if m.function[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+				m.modules[y][x] = codewords[i/8]>>(7-i%8)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// masked reports whether a mask inverts the module at x, y
+func masked(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// applyMask inverts the data modules selected by a mask. Applying it twice
+// undoes it.
+func (m *matrix) applyMask(mask int) {
+	for y := 0; y < m.size; y++ {
+		for x := 0; x < m.size; x++ {
+			if !m.function[y][x] && masked(mask, x, y) {
+				m.modules[y][x] = !m.modules[y][x]
+			}
+		}
+	}
+}
+
+// draw places the codewords and applies the mask with the lowest penalty
+func (m *matrix) draw(codewords []byte) *Code {
+	m.drawCodewords(codewords)
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		m.applyMask(mask)
+		m.drawFormat(mask)
+		if p := m.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		m.applyMask(mask)
+	}
+	m.applyMask(best)
+	m.drawFormat(best)
+	return &Code{Size: m.size, modules: m.modules}
+}
+
+// finderLike is the 1:1:3:1:1 pattern with four light modules on one side
+// that the penalty rules look for
+var finderLike = [][]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+// penalty scores how hard the code is to read, by the four rules of the
+// standard
+func (m *matrix) penalty() int {
+	score := 0
+	line := func(get func(i int) bool) {
+		run := 1
+		for i := 1; i <= m.size; i++ {
+			if i < m.size && get(i) == get(i-1) {
+				run++
+				continue
+			}
+			if run >= 5 {
+				score += 3 + run - 5
+			}
+			run = 1
+		}
+		for i := 0; i+11 <= m.size; i++ {
+			for _, pattern := range finderLike {
+				match := true
+				for k, dark := range pattern {
+					if get(i+k) != dark {
+						match = false
+						break
+					}
+				}
+				if match {
+					score += 40
+				}
+			}
+		}
+	}
+	for y := 0; y < m.size; y++ {
+		line(func(i int) bool { return m.modules[y][i] })
+	}
+	for x := 0; x < m.size; x++ {
+		line(func(i int) bool { return m.modules[i][x] })
+	}
+
+	dark := 0
+	for y := 0; y < m.size; y++ {
+		for x := 0; x < m.size; x++ {
+			if m.modules[y][x] {
+				dark++
+			}
+			if x+1 < m.size && y+1 < m.size {
+				c := m.modules[y][x]
+				if m.modules[y][x+1] == c && m.modules[y+1][x] == c && m.modules[y+1][x+1] == c {
+					score += 3
+				}
+			}
+		}
+	}
+	total := m.size * m.size
+	score += abs(dark*100/total-50) / 5 * 10
+	return score
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// PNG renders the code with scale pixels per module and the four module
+// quiet zone the standard requires
+func (c *Code) PNG(scale int) ([]byte, error) {
+	const quiet = 4
+	side := (c.Size + 2*quiet) * scale
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.modules[y][x] {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex((x+quiet)*scale+dx, (y+quiet)*scale+dy, 1)
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
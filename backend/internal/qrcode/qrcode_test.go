@@ -0,0 +1,230 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// decode reads the data back from a code, checking its format information
+// and the Reed-Solomon codewords of every block
+func decode(t *testing.T, c *Code) []byte {
+	t.Helper()
+	number := (c.Size - 17) / 4
+	if number < 1 || number > len(versions) || c.Size != 17+4*number {
+		t.Fatalf("size %d is not that of a supported version", c.Size)
+	}
+	v := versions[number-1]
+
+	// Format information, both copies
+	var first, second int
+	for i := 0; i <= 5; i++ {
+		first |= bit(c.Dark(8, i)) << i
+	}
+	first |= bit(c.Dark(8, 7))<<6 | bit(c.Dark(8, 8))<<7 | bit(c.Dark(7, 8))<<8
+	for i := 9; i < 15; i++ {
+		first |= bit(c.Dark(14-i, 8)) << i
+	}
+	for i := 0; i < 8; i++ {
+		second |= bit(c.Dark(c.Size-1-i, 8)) << i
+	}
+	for i := 8; i < 15; i++ {
+		second |= bit(c.Dark(8, c.Size-15+i)) << i
+	}
+	if first != second {
+		t.Fatalf("format copies differ: %015b and %015b", first, second)
+	}
+	format := first ^ 0x5412
+	rem := format >> 10
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	if format&0x3FF != rem {
+		t.Fatalf("format %015b has a wrong BCH code", first)
+	}
+	if level := format >> 13; level != 0 {
+		t.Fatalf("error correction level bits %02b, want M (00)", level)
+	}
+	mask := format >> 10 & 7
+	if !c.Dark(8, c.Size-8) {
+		t.Error("dark module is light")
+	}
+
+	// Codewords, read in the zigzag order from the unmasked data modules
+	function := newMatrix(number, v).function
+	total := v.dataCodewords()
+	for _, g := range v.groups {
+		total += g.blocks * v.ecPerBlock
+	}
+	codewords := make([]byte, total)
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if function[y][x] || i >= total*8 {
+					continue
+				}
+				if c.Dark(x, y) != masked(mask, x, y) {
+					codewords[i/8] |= 0x80 >> (i % 8)
+				}
+				i++
+			}
+		}
+	}
+
+	// Blocks, interleaved data codewords first then error correction
+	var blocks [][]byte
+	var sizes []int
+	for _, g := range v.groups {
+		for b := 0; b < g.blocks; b++ {
+			blocks = append(blocks, make([]byte, 0, g.data+v.ecPerBlock))
+			sizes = append(sizes, g.data)
+		}
+	}
+	next := 0
+	for k := 0; k < sizes[len(sizes)-1]; k++ {
+		for b := range blocks {
+			if k < sizes[b] {
+				blocks[b] = append(blocks[b], codewords[next])
+				next++
+			}
+		}
+	}
+	for k := 0; k < v.ecPerBlock; k++ {
+		for b := range blocks {
+			blocks[b] = append(blocks[b], codewords[next])
+			next++
+		}
+	}
+
+	// Every block must be a multiple of the generator, whose roots are
+	// 2^0 to 2^(ecPerBlock-1)
+	var data []byte
+	for b, block := range blocks {
+		root := byte(1)
+		for k := 0; k < v.ecPerBlock; k++ {
+			var syndrome byte
+			for _, cw := range block {
+				syndrome = gfMultiply(syndrome, root) ^ cw
+			}
+			if syndrome != 0 {
+				t.Fatalf("block %d has syndrome %d for root 2^%d", b, syndrome, k)
+			}
+			root = gfMultiply(root, 0x02)
+		}
+		data = append(data, block[:sizes[b]]...)
+	}
+
+	// Byte mode segment
+	r := bitReader{data: data}
+	if mode := r.read(4); mode != 0x4 {
+		t.Fatalf("mode %04b, want byte mode", mode)
+	}
+	countBits := 8
+	if number >= 10 {
+		countBits = 16
+	}
+	length := r.read(countBits)
+	out := make([]byte, length)
+	for k := range out {
+		out[k] = byte(r.read(8))
+	}
+	return out
+}
+
+func bit(dark bool) int {
+	if dark {
+		return 1
+	}
+	return 0
+}
+
+// bitReader reads bits most significant first
+type bitReader struct {
+	data []byte
+	n    int
+}
+
+func (r *bitReader) read(bits int) int {
+	value := 0
+	for i := 0; i < bits; i++ {
+		value = value<<1 | int(r.data[r.n/8]>>(7-r.n%8)&1)
+		r.n++
+	}
+	return value
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		version int
+	}{
+		{"empty", "", 1},
+		{"version 1 full", strings.Repeat("a", 14), 1},
+		{"version 2", strings.Repeat("a", 15), 2},
+		{"otpauth URI", "otpauth://totp/alertsMIS:jane?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&issuer=alertsMIS&algorithm=SHA1&digits=6&period=30", 7},
+		{"version 7", strings.Repeat("b", 122), 7},
+		{"version 9 full", strings.Repeat("c", 180), 9},
+		{"version 10 full", strings.Repeat("d", 213), 10},
+		{"binary", "\x00\xff\x10 é", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Encode([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := (code.Size - 17) / 4; got != tt.version {
+				t.Errorf("version %d, want %d", got, tt.version)
+			}
+			if got := decode(t, code); string(got) != tt.data {
+				t.Errorf("decoded %q, want %q", got, tt.data)
+			}
+		})
+	}
+}
+
+func TestEncodeTooLong(t *testing.T) {
+	if _, err := Encode(bytes.Repeat([]byte("x"), 214)); err != ErrTooLong {
+		t.Errorf("err = %v, want %v", err, ErrTooLong)
+	}
+}
+
+func TestPNG(t *testing.T) {
+	const scale, quiet = 3, 4
+	code, err := Encode([]byte("otpauth://totp/alertsMIS:jane"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := code.PNG(scale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	side := (code.Size + 2*quiet) * scale
+	if b := img.Bounds(); b.Dx() != side || b.Dy() != side {
+		t.Fatalf("image is %dx%d, want %dx%d", b.Dx(), b.Dy(), side, side)
+	}
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			mx, my := x/scale-quiet, y/scale-quiet
+			want := mx >= 0 && my >= 0 && mx < code.Size && my < code.Size && code.Dark(mx, my)
+			r, _, _, _ := img.At(x, y).RGBA()
+			if dark := r == 0; dark != want {
+				t.Fatalf("pixel %d,%d dark = %v, want %v", x, y, dark, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package risk
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/alertsMIS/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// MaxScore is the highest risk score an alert can have
+const MaxScore = 100
+
+// Score weights
+const (
+	weightConfirmed       = 25
+	weightProbable        = 20
+	weightSuspected       = 15
+	weightHighConsequence = 15
+	weightVulnerableAge   = 10
+	weightPregnant        = 10
+	weightDeath           = 15
+	weightHealthWorker    = 15
+	weightCluster         = 15
+)
+
+// unverifiedSteps raise the score of an alert the longer it waits for
+// verification; the largest step reached applies
+var unverifiedSteps = []struct {
+	after  time.Duration
+	weight int
+}{
+	{24 * time.Hour, 15},
+	{6 * time.Hour, 10},
+	{time.Hour, 5},
+}
+
+// healthWorkerPattern matches mentions of healthcare workers in free text
+var healthWorkerPattern = regexp.MustCompile(`\b(hcws?|health ?care workers?|health workers?|nurses?|doctors?|clinicians?|midwi(fe|ves)|medical officers?|lab(oratory)? technicians?)\b`)
+
+// Factors are the facts about an alert that are not held on the alert itself
+type Factors struct {
+	HealthWorker bool
+	InCluster    bool
+}
+
+// Score computes an alert's risk score from 0 to MaxScore
+func Score(alert models.Alert, factors Factors, now time.Time) int {
+	score := 0
+
+	if alert.Classification != nil {
+		switch *alert.Classification {
+		case models.ClassificationConfirmed:
+			score += weightConfirmed
+		case models.ClassificationProbable:
+			score += weightProbable
+		case models.ClassificationSuspected:
+			score += weightSuspected
+		}
+	}
+	if alert.Priority != nil && *alert.Priority == models.PriorityHigh {
+		score += weightHighConsequence
+	}
+	if alert.AlertCaseAge != nil && *alert.AlertCaseAge > 0 && (*alert.AlertCaseAge < 5 || *alert.AlertCaseAge >= 60) {
+		score += weightVulnerableAge
+	}
+	if alert.AlertCasePregnantDuration != nil && *alert.AlertCasePregnantDuration > 0 {
+		score += weightPregnant
+	}
+	if alert.Status != nil && strings.EqualFold(strings.TrimSpace(*alert.Status), "dead") {
+		score += weightDeath
+	}
+	if factors.HealthWorker || mentionsHealthWorker(alert.History, alert.Narrative, alert.CaseVerificationDesk, alert.FieldVerification) {
+		score += weightHealthWorker
+	}
+	if factors.InCluster {
+		score += weightCluster
+	}
+	if !alert.IsVerified && !alert.CreatedAt.IsZero() {
+		waiting := now.Sub(alert.CreatedAt)
+		for _, step := range unverifiedSteps {
+			if waiting >= step.after {
+				score += step.weight
+				break
+			}
+		}
+	}
+
+	if score > MaxScore {
+		score = MaxScore
+	}
+	return score
+}
+
+// mentionsHealthWorker reports whether any of the texts mention a healthcare worker
+func mentionsHealthWorker(texts ...*string) bool {
+	for _, text := range texts {
+		if text != nil && healthWorkerPattern.MatchString(strings.ToLower(*text)) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadFactors looks up the facts about an alert held in other tables
+func loadFactors(db *gorm.DB, alert models.Alert) (Factors, error) {
+	var factors Factors
+
+	if alert.PatientID != nil {
+		var patient models.Patient
+		err := db.Select("id", "occupation").First(&patient, *alert.PatientID).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return factors, err
+		}
+		factors.HealthWorker = mentionsHealthWorker(patient.Occupation)
+	}
+
+	if alert.ID != 0 {
+		var count int64
+		err := db.Model(&models.ClusterMember{}).
+			Joins("JOIN clusters ON clusters.id = cluster_members.cluster_id").
+			Where("cluster_members.alert_id = ? AND clusters.status = ?", alert.ID, models.ClusterStatusActive).
+			Count(&count).Error
+		if err != nil {
+			return factors, err
+		}
+		factors.InCluster = count > 0
+	}
+	return factors, nil
+}
+
+// Apply recomputes an alert's risk score in memory
+func Apply(db *gorm.DB, alert *models.Alert, now time.Time) error {
+	factors, err := loadFactors(db, *alert)
+	if err != nil {
+		return err
+	}
+	alert.RiskScore = Score(*alert, factors, now)
+	return nil
+}
+
+// RefreshAlerts recomputes and stores the risk scores of the given alerts
+func RefreshAlerts(db *gorm.DB, ids []uint, now time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var alerts []models.Alert
+	if err := db.Where("id IN ?", ids).Find(&alerts).Error; err != nil {
+		return err
+	}
+	return refresh(db, alerts, now)
+}
+
+// refresh stores the recomputed scores of the alerts whose score changed
+func refresh(db *gorm.DB, alerts []models.Alert, now time.Time) error {
+	for _, alert := range alerts {
+		previous := alert.RiskScore
+		if err := Apply(db, &alert, now); err != nil {
+			return err
+		}
+		if alert.RiskScore == previous {
+			continue
+		}
+		if err := db.Model(&alert).UpdateColumn("risk_score", alert.RiskScore).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start refreshes the scores of all alerts once, which fills them in for
+// alerts recorded before scores were computed, and then periodically those of
+// recent unverified alerts, whose score rises the longer they wait
+func Start(db *gorm.DB, interval time.Duration) {
+	// Alerts waiting longer than the last step no longer change with time
+	horizon := unverifiedSteps[0].after + interval
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		all := true
+		for {
+			now := time.Now()
+			query := db
+			if !all {
+				query = db.Where("is_verified = ? AND created_at >= ?", false, now.Add(-horizon))
+			}
+			var alerts []models.Alert
+			err := query.FindInBatches(&alerts, 200, func(tx *gorm.DB, batch int) error {
+				return refresh(db, alerts, now)
+			}).Error
+			if err != nil {
+				log.Printf("Risk score refresh failed: %v", err)
+			} else {
+				all = false
+			}
+			<-ticker.C
+		}
+	}()
+}